@@ -0,0 +1,137 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"library"
+
+	"github.com/xandout/gorpl/action"
+)
+
+// readPayouts -- reads the payouts csv of 'address,amount' lines, the header line and the '#' comments are skipped.
+func readPayouts(file string) ([]library.BatchOutput, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var outputs []library.BatchOutput
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("payouts.line[%v].fields[%v].invalid", line, len(record))
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(record[1]), 10, 64)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("payouts.line[%v].amount[%v].invalid", line, record[1])
+		}
+		outputs = append(outputs, library.BatchOutput{Address: strings.TrimSpace(record[0]), Value: value})
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("payouts[%v].empty", file)
+	}
+	return outputs, nil
+}
+
+func walletBatchSendAction(cli *Client) *action.Action {
+	return action.New("batchsend", func(args ...interface{}) (interface{}, error) {
+		var fees uint64
+		var rows [][]string
+		columns := []string{
+			"toaddress",
+			"value(sat)",
+		}
+		usage := "batchsend <payouts.csv> [fees]"
+
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", usage)
+			return nil, nil
+		}
+		payouts, err := readPayouts(args[0].(string))
+		if err != nil {
+			pprintError(err.Error(), usage)
+			return nil, nil
+		}
+		datas, err := json.Marshal(payouts)
+		if err != nil {
+			pprintError(err.Error(), "")
+			return nil, nil
+		}
+		outputs := string(datas)
+
+		// Fees.
+		if len(args) > 1 {
+			fees, err = strconv.ParseUint(args[1].(string), 10, 64)
+			if err != nil {
+				pprintError("fees.invalid", usage)
+				return nil, nil
+			}
+		} else {
+			rsp := &library.WalletSendFeesResponse{}
+			body := library.APIWalletBatchSendFees(cli.apiurl, cli.token, outputs, cli.coinSelect)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			fees = rsp.Fees
+		}
+
+		{
+			rsp := &library.WalletSendResponse{}
+			body := library.APIWalletBatchSend(cli.apiurl, cli.token, cli.net, cli.masterPrvKey, outputs, fees, "", cli.coinSelect)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+
+			for _, payout := range payouts {
+				rows = append(rows, []string{payout.Address, fmt.Sprintf("%v", payout.Value)})
+			}
+			PrintQueryOutput(columns, rows)
+			PrintQueryOutput([]string{"outputs", "fees(sat)", "txid"}, [][]string{{fmt.Sprintf("%v", len(payouts)), fmt.Sprintf("%v", fees), rsp.TxID}})
+		}
+		return nil, nil
+	})
+}
@@ -22,6 +22,7 @@ type Client struct {
 	rsaPrvKey    string
 	rsaPubKey    string
 	masterPrvKey string
+	coinSelect   string
 }
 
 func NewClient(apiurl string, uid string, chainnet string, masterPrvKey string) *Client {
@@ -73,5 +74,19 @@ func (cli *Client) Start() {
 	f.AddAction(*walletSendFeesAction(cli))
 	f.AddAction(*walletSendToAddressAction(cli))
 	f.AddAction(*walletSendAllToAddressAction(cli))
+	f.AddAction(*walletBatchSendAction(cli))
+	f.AddAction(*walletBumpFeeAction(cli))
+	f.AddAction(*walletCPFPAction(cli))
+	f.AddAction(*walletCoinSelectAction(cli))
+	f.AddAction(*walletUTXOsAction(cli))
+	f.AddAction(*walletFreezeUTXOAction(cli, "freezeutxo", true))
+	f.AddAction(*walletFreezeUTXOAction(cli, "unfreezeutxo", false))
+	f.AddAction(*walletLabelUTXOAction(cli))
+	f.AddAction(*whitelistAddAction(cli))
+	f.AddAction(*whitelistRemoveAction(cli))
+	f.AddAction(*whitelistAction(cli))
+	f.AddAction(*whitelistModeAction(cli))
+	f.AddAction(*walletPolicyAction(cli))
+	f.AddAction(*walletSetPolicyAction(cli))
 	f.Start()
 }
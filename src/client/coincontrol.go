@@ -0,0 +1,150 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"library"
+
+	"github.com/xandout/gorpl/action"
+)
+
+// isOutpoints -- returns true if the arg is the outpoints 'txid:vout' separated by comma.
+func isOutpoints(arg string) bool {
+	for _, op := range strings.Split(arg, ",") {
+		parts := strings.Split(op, ":")
+		if len(parts) != 2 || len(parts[0]) != 64 || parts[1] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func walletUTXOsAction(cli *Client) *action.Action {
+	return action.New("getutxos", func(args ...interface{}) (interface{}, error) {
+		var rows [][]string
+		columns := []string{
+			"outpoint",
+			"address",
+			"value(sat)",
+			"confirmations",
+			"label",
+			"frozen",
+		}
+
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		{
+			rsp := &library.WalletUTXOsResponse{}
+			body := library.APIWalletUTXOs(cli.apiurl, cli.token)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+
+			for _, utxo := range rsp.UTXOs {
+				rows = append(rows, []string{
+					fmt.Sprintf("%v:%v", utxo.Txid, utxo.Vout),
+					utxo.Address,
+					fmt.Sprintf("%v", utxo.Value),
+					fmt.Sprintf("%v", utxo.Confirmations),
+					utxo.Label,
+					fmt.Sprintf("%v", utxo.Frozen),
+				})
+			}
+			PrintQueryOutput(columns, rows)
+		}
+		return nil, nil
+	})
+}
+
+func walletFreezeUTXOAction(cli *Client, name string, frozen bool) *action.Action {
+	return action.New(name, func(args ...interface{}) (interface{}, error) {
+		usage := fmt.Sprintf("%v <txid:vout>[,<txid:vout>...]", name)
+
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", usage)
+			return nil, nil
+		}
+		outpoints := args[0].(string)
+
+		{
+			var body string
+			rsp := &library.WalletFreezeUTXOsResponse{}
+			if frozen {
+				body = library.APIWalletFreezeUTXOs(cli.apiurl, cli.token, outpoints)
+			} else {
+				body = library.APIWalletUnfreezeUTXOs(cli.apiurl, cli.token, outpoints)
+			}
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			PrintQueryOutput([]string{"outpoints", "frozen"}, [][]string{{outpoints, fmt.Sprintf("%v", frozen)}})
+		}
+		return nil, nil
+	})
+}
+
+func walletLabelUTXOAction(cli *Client) *action.Action {
+	return action.New("labelutxo", func(args ...interface{}) (interface{}, error) {
+		var label string
+
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", "labelutxo <txid:vout> [label]")
+			return nil, nil
+		}
+		outpoint := args[0].(string)
+		if len(args) > 1 {
+			label = args[1].(string)
+		}
+
+		{
+			rsp := &library.WalletLabelUTXOResponse{}
+			body := library.APIWalletLabelUTXO(cli.apiurl, cli.token, outpoint, label)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			PrintQueryOutput([]string{"outpoint", "label"}, [][]string{{outpoint, label}})
+		}
+		return nil, nil
+	})
+}
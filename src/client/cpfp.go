@@ -0,0 +1,65 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"library"
+
+	"github.com/xandout/gorpl/action"
+)
+
+func walletCPFPAction(cli *Client) *action.Action {
+	return action.New("cpfp", func(args ...interface{}) (interface{}, error) {
+		var feesPerKB int
+		columns := []string{
+			"parent_txid",
+			"fees(sat)",
+			"package_feesperkb",
+			"txid",
+		}
+		usage := "cpfp <txid> [feesperkb]"
+
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", usage)
+			return nil, nil
+		}
+		txid := args[0].(string)
+		if len(args) > 1 {
+			rate, err := strconv.Atoi(args[1].(string))
+			if err != nil || rate <= 0 {
+				pprintError("feesperkb.invalid", usage)
+				return nil, nil
+			}
+			feesPerKB = rate
+		}
+
+		{
+			rsp := &library.WalletCPFPResponse{}
+			body := library.APIWalletCPFP(cli.apiurl, cli.token, cli.net, cli.masterPrvKey, txid, feesPerKB)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			PrintQueryOutput(columns, [][]string{{txid, fmt.Sprintf("%v", rsp.Fees), fmt.Sprintf("%v", rsp.PackageFeesPerKB), rsp.TxID}})
+		}
+		return nil, nil
+	})
+}
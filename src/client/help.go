@@ -30,8 +30,22 @@ func helpAction(cli *Client) *action.Action {
 		rows = append(rows, []string{"getaddresses", "getaddresses", "getaddresses"})
 		rows = append(rows, []string{"getnewaddress", "getnewaddress", "getnewaddress"})
 		rows = append(rows, []string{"getsendfees", "getsendfees <address> <value>", "getsendfees tb1qsdp08c4uua6ya865mmxvsqeqlv3gzp2lv5jtsw 10000"})
-		rows = append(rows, []string{"sendtoaddress", "sendtoaddress <address> <value> <fees>", "sendtoaddress tb1qsdp08c4uua6ya865mmxvsqeqlv3gzp2lv5jtsw 10000 1000"})
+		rows = append(rows, []string{"sendtoaddress", "sendtoaddress <address> <value> <fees> [message] [txid:vout,...]", "sendtoaddress tb1qsdp08c4uua6ya865mmxvsqeqlv3gzp2lv5jtsw 10000 1000"})
 		rows = append(rows, []string{"sendalltoaddress", "sendalltoaddress <address>", "sendalltoaddress tb1qsdp08c4uua6ya865mmxvsqeqlv3gzp2lv5jtsw"})
+		rows = append(rows, []string{"getutxos", "getutxos", "getutxos"})
+		rows = append(rows, []string{"freezeutxo", "freezeutxo <txid:vout>[,<txid:vout>...]", "freezeutxo 2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a:1"})
+		rows = append(rows, []string{"unfreezeutxo", "unfreezeutxo <txid:vout>[,<txid:vout>...]", "unfreezeutxo 2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a:1"})
+		rows = append(rows, []string{"labelutxo", "labelutxo <txid:vout> [label]", "labelutxo 2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a:1 kyc"})
+		rows = append(rows, []string{"batchsend", "batchsend <payouts.csv> [fees]", "batchsend payouts.csv"})
+		rows = append(rows, []string{"bumpfee", "bumpfee <txid> [feesperkb]", "bumpfee 2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a 5000"})
+		rows = append(rows, []string{"cpfp", "cpfp <txid> [feesperkb]", "cpfp 0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df 5000"})
+		rows = append(rows, []string{"setcoinselect", "setcoinselect <legacy|largest|bnb|smallest|privacy|confirmed>", "setcoinselect bnb"})
+		rows = append(rows, []string{"addwhitelist", "addwhitelist <address> [label]", "addwhitelist tb1qsdp08c4uua6ya865mmxvsqeqlv3gzp2lv5jtsw cold"})
+		rows = append(rows, []string{"removewhitelist", "removewhitelist <address>", "removewhitelist tb1qsdp08c4uua6ya865mmxvsqeqlv3gzp2lv5jtsw"})
+		rows = append(rows, []string{"getwhitelist", "getwhitelist", "getwhitelist"})
+		rows = append(rows, []string{"setwhitelistmode", "setwhitelistmode <on|off>", "setwhitelistmode on"})
+		rows = append(rows, []string{"getpolicy", "getpolicy", "getpolicy"})
+		rows = append(rows, []string{"setpolicy", "setpolicy <rule>=<value> ...", "setpolicy daily_limit=100000 max_tx_value=50000"})
 		PrintQueryOutput(columns, rows)
 		return nil, nil
 	})
@@ -0,0 +1,168 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"library"
+	"proto"
+
+	"github.com/xandout/gorpl/action"
+)
+
+const setPolicyUsage = "setpolicy <daily_limit|weekly_limit|max_tx_value|min_send_interval|max_fees_per_kb|allow_resign>=<value> ..."
+
+func policyRow(state string, policy *proto.WalletPolicy, at int64) []string {
+	return []string{
+		state,
+		fmt.Sprintf("%v", policy.DailyLimit),
+		fmt.Sprintf("%v", policy.WeeklyLimit),
+		fmt.Sprintf("%v", policy.MaxTxValue),
+		fmt.Sprintf("%v", policy.MinSendInterval),
+		fmt.Sprintf("%v", policy.MaxFeesPerKB),
+		fmt.Sprintf("%v", policy.AllowResign),
+		whitelistTime(at),
+	}
+}
+
+func printPolicy(rsp *library.WalletPolicyResponse) {
+	var rows [][]string
+	columns := []string{
+		"state",
+		"daily_limit",
+		"weekly_limit",
+		"max_tx_value",
+		"min_send_interval",
+		"max_fees_per_kb",
+		"allow_resign",
+		"effective_at",
+	}
+	rows = append(rows, policyRow("effective", &rsp.Policy, 0))
+	if rsp.Pending != nil {
+		rows = append(rows, policyRow("pending", rsp.Pending, rsp.PendingAt))
+	}
+	PrintQueryOutput(columns, rows)
+}
+
+// setPolicyRule -- sets the rule of the policy by the 'rule=value' arg.
+func setPolicyRule(policy *proto.WalletPolicy, arg string) error {
+	kv := strings.SplitN(arg, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("policy.rule[%v].invalid", arg)
+	}
+	if kv[0] == "allow_resign" {
+		v, err := strconv.ParseBool(kv[1])
+		if err != nil {
+			return err
+		}
+		policy.AllowResign = v
+		return nil
+	}
+
+	v, err := strconv.ParseUint(kv[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	switch kv[0] {
+	case "daily_limit":
+		policy.DailyLimit = v
+	case "weekly_limit":
+		policy.WeeklyLimit = v
+	case "max_tx_value":
+		policy.MaxTxValue = v
+	case "min_send_interval":
+		policy.MinSendInterval = int64(v)
+	case "max_fees_per_kb":
+		policy.MaxFeesPerKB = v
+	default:
+		return fmt.Errorf("policy.rule[%v].unknown", kv[0])
+	}
+	return nil
+}
+
+func walletPolicyAction(cli *Client) *action.Action {
+	return action.New("getpolicy", func(args ...interface{}) (interface{}, error) {
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		{
+			rsp := &library.WalletPolicyResponse{}
+			body := library.APIWalletPolicy(cli.apiurl, cli.token)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			printPolicy(rsp)
+		}
+		return nil, nil
+	})
+}
+
+func walletSetPolicyAction(cli *Client) *action.Action {
+	return action.New("setpolicy", func(args ...interface{}) (interface{}, error) {
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", setPolicyUsage)
+			return nil, nil
+		}
+
+		// The rules not in the args are kept as the effective policy.
+		var policy proto.WalletPolicy
+		{
+			rsp := &library.WalletPolicyResponse{}
+			body := library.APIWalletPolicy(cli.apiurl, cli.token)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			policy = rsp.Policy
+		}
+		for _, arg := range args {
+			if err := setPolicyRule(&policy, arg.(string)); err != nil {
+				pprintError(err.Error(), setPolicyUsage)
+				return nil, nil
+			}
+		}
+
+		{
+			rsp := &library.WalletPolicyResponse{}
+			body := library.APIWalletSetPolicy(cli.apiurl, cli.token, policy.DailyLimit, policy.WeeklyLimit, policy.MaxTxValue, policy.MinSendInterval, policy.MaxFeesPerKB, policy.AllowResign)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			printPolicy(rsp)
+		}
+		return nil, nil
+	})
+}
@@ -0,0 +1,65 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"library"
+
+	"github.com/xandout/gorpl/action"
+)
+
+func walletBumpFeeAction(cli *Client) *action.Action {
+	return action.New("bumpfee", func(args ...interface{}) (interface{}, error) {
+		var feesPerKB int
+		columns := []string{
+			"replaced_txid",
+			"fees(sat)",
+			"additional_fees(sat)",
+			"txid",
+		}
+		usage := "bumpfee <txid> [feesperkb]"
+
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", usage)
+			return nil, nil
+		}
+		txid := args[0].(string)
+		if len(args) > 1 {
+			rate, err := strconv.Atoi(args[1].(string))
+			if err != nil || rate <= 0 {
+				pprintError("feesperkb.invalid", usage)
+				return nil, nil
+			}
+			feesPerKB = rate
+		}
+
+		{
+			rsp := &library.WalletBumpFeeResponse{}
+			body := library.APIWalletBumpFee(cli.apiurl, cli.token, cli.masterPrvKey, txid, feesPerKB)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			PrintQueryOutput(columns, [][]string{{txid, fmt.Sprintf("%v", rsp.Fees), fmt.Sprintf("%v", rsp.AdditionalFees), rsp.TxID}})
+		}
+		return nil, nil
+	})
+}
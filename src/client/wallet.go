@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"library"
+	"proto"
 
 	"github.com/xandout/gorpl/action"
 )
@@ -297,11 +298,18 @@ func walletTxsAction(cli *Client) *action.Action {
 			}
 
 			for _, tx := range rsp.Txs {
+				// The change back to the wallet.
+				if tx.Change {
+					continue
+				}
 				txid := tx.Txid
 				direction := "received"
 				if tx.Value < 0 {
 					direction = "sent"
 				}
+				if tx.Superseded {
+					direction = "superseded"
+				}
 				value := tx.Value
 				confirmed := tx.Confirmed
 				ts := time.Unix(tx.BlockTime, 0)
@@ -428,7 +436,7 @@ func walletSendFeesAction(cli *Client) *action.Action {
 
 		{
 			rsp := &library.WalletSendFeesResponse{}
-			body := library.APIWalletSendFees(cli.apiurl, cli.token, value)
+			body := library.APIWalletSendFeesByStrategy(cli.apiurl, cli.token, value, cli.coinSelect)
 			if err := unmarshal(body, rsp); err != nil {
 				pprintError(err.Error(), "")
 				return nil, nil
@@ -454,6 +462,8 @@ func walletSendFeesAction(cli *Client) *action.Action {
 func walletSendToAddressAction(cli *Client) *action.Action {
 	return action.New("sendtoaddress", func(args ...interface{}) (interface{}, error) {
 		var msg string
+		var outpoints string
+		usage := "sendtoaddress <address> <amount> <fees> [message] [txid:vout,...]"
 		var rows [][]string
 		columns := []string{
 			"toaddress",
@@ -469,30 +479,42 @@ func walletSendToAddressAction(cli *Client) *action.Action {
 		}
 
 		if len(args) < 3 {
-			pprintError("args.invalid", "sendtoaddress <address> <amount> <fees>")
+			pprintError("args.invalid", usage)
 			return nil, nil
 		}
 
 		address := args[0].(string)
 		value, err := strconv.ParseUint(args[1].(string), 10, 64)
 		if err != nil {
-			pprintError("amount.invalid", "sendtoaddress <address> <amount> <fees>")
+			pprintError("amount.invalid", usage)
 			return nil, nil
 		}
 
 		fees, err := strconv.ParseUint(args[2].(string), 10, 64)
 		if err != nil {
-			pprintError("fees.invalid", "sendtoaddress <address> <amount> <fees>")
+			pprintError("fees.invalid", usage)
 			return nil, nil
 		}
 
-		if len(args) == 4 {
+		// The message, or the outpoints of the coin control if no message.
+		switch {
+		case len(args) == 4 && isOutpoints(args[3].(string)):
+			outpoints = args[3].(string)
+		case len(args) >= 4:
 			msg = args[3].(string)
+			if len(args) == 5 {
+				outpoints = args[4].(string)
+			}
 		}
 
 		{
+			var body string
 			rsp := &library.WalletSendResponse{}
-			body := library.APIWalletSend(cli.apiurl, cli.token, cli.net, cli.masterPrvKey, address, value, fees, msg)
+			if outpoints != "" {
+				body = library.APIWalletSendFromOutpoints(cli.apiurl, cli.token, cli.net, cli.masterPrvKey, address, value, fees, msg, outpoints)
+			} else {
+				body = library.APIWalletSendByStrategy(cli.apiurl, cli.token, cli.net, cli.masterPrvKey, address, value, fees, msg, cli.coinSelect)
+			}
 			if err := unmarshal(body, rsp); err != nil {
 				pprintError(err.Error(), "")
 				return nil, nil
@@ -559,7 +581,7 @@ func walletSendAllToAddressAction(cli *Client) *action.Action {
 
 		{
 			rsp := &library.WalletSendFeesResponse{}
-			body := library.APIWalletSendFees(cli.apiurl, cli.token, balance)
+			body := library.APIWalletSendFeesByStrategy(cli.apiurl, cli.token, balance, cli.coinSelect)
 			if err := unmarshal(body, rsp); err != nil {
 				pprintError(err.Error(), "")
 				return nil, nil
@@ -575,7 +597,7 @@ func walletSendAllToAddressAction(cli *Client) *action.Action {
 
 		{
 			rsp := &library.WalletSendResponse{}
-			body := library.APIWalletSend(cli.apiurl, cli.token, cli.net, cli.masterPrvKey, address, sendable, fees, msg)
+			body := library.APIWalletSendByStrategy(cli.apiurl, cli.token, cli.net, cli.masterPrvKey, address, sendable, fees, msg, cli.coinSelect)
 			if err := unmarshal(body, rsp); err != nil {
 				pprintError(err.Error(), "")
 				return nil, nil
@@ -592,3 +614,26 @@ func walletSendAllToAddressAction(cli *Client) *action.Action {
 		return nil, nil
 	})
 }
+
+func walletCoinSelectAction(cli *Client) *action.Action {
+	return action.New("setcoinselect", func(args ...interface{}) (interface{}, error) {
+		usage := "setcoinselect <legacy|largest|bnb|smallest|privacy|confirmed>"
+		if len(args) < 1 {
+			pprintError("args.invalid", usage)
+			return nil, nil
+		}
+
+		strategy := args[0].(string)
+		switch strategy {
+		case "legacy":
+			strategy = ""
+		case proto.CoinSelectLargest, proto.CoinSelectBnB, proto.CoinSelectSmallest, proto.CoinSelectPrivacy, proto.CoinSelectConfirmed:
+		default:
+			pprintError("strategy.invalid", usage)
+			return nil, nil
+		}
+		cli.coinSelect = strategy
+		PrintQueryOutput([]string{"coinselect"}, [][]string{{args[0].(string)}})
+		return nil, nil
+	})
+}
@@ -0,0 +1,195 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"library"
+
+	"github.com/xandout/gorpl/action"
+)
+
+func whitelistTime(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+}
+
+func printWhitelist(rsp *library.WalletWhitelistResponse) {
+	var rows [][]string
+	columns := []string{
+		"enabled",
+		"disable_at",
+	}
+	rows = append(rows, []string{fmt.Sprintf("%v", rsp.Enabled), whitelistTime(rsp.DisableAt)})
+	PrintQueryOutput(columns, rows)
+
+	rows = rows[:0]
+	columns = []string{
+		"address",
+		"label",
+		"active_at",
+		"active",
+	}
+	for _, addr := range rsp.Addresses {
+		rows = append(rows, []string{
+			addr.Address,
+			addr.Label,
+			whitelistTime(addr.ActiveAt),
+			fmt.Sprintf("%v", addr.Active),
+		})
+	}
+	PrintQueryOutput(columns, rows)
+}
+
+func whitelistAddAction(cli *Client) *action.Action {
+	return action.New("addwhitelist", func(args ...interface{}) (interface{}, error) {
+		var label string
+		var rows [][]string
+		columns := []string{
+			"address",
+			"label",
+			"active_at",
+		}
+
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", "addwhitelist <address> [label]")
+			return nil, nil
+		}
+		address := args[0].(string)
+		if len(args) > 1 {
+			label = args[1].(string)
+		}
+
+		{
+			rsp := &library.WalletWhitelistAddResponse{}
+			body := library.APIWalletWhitelistAdd(cli.apiurl, cli.token, address, label)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+
+			rows = append(rows, []string{rsp.Address, rsp.Label, whitelistTime(rsp.ActiveAt)})
+			PrintQueryOutput(columns, rows)
+		}
+		return nil, nil
+	})
+}
+
+func whitelistRemoveAction(cli *Client) *action.Action {
+	return action.New("removewhitelist", func(args ...interface{}) (interface{}, error) {
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", "removewhitelist <address>")
+			return nil, nil
+		}
+		address := args[0].(string)
+
+		{
+			rsp := &library.WalletWhitelistRemoveResponse{}
+			body := library.APIWalletWhitelistRemove(cli.apiurl, cli.token, address)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			PrintQueryOutput([]string{"address", "removed"}, [][]string{{address, "true"}})
+		}
+		return nil, nil
+	})
+}
+
+func whitelistAction(cli *Client) *action.Action {
+	return action.New("getwhitelist", func(args ...interface{}) (interface{}, error) {
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		{
+			rsp := &library.WalletWhitelistResponse{}
+			body := library.APIWalletWhitelist(cli.apiurl, cli.token)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			printWhitelist(rsp)
+		}
+		return nil, nil
+	})
+}
+
+func whitelistModeAction(cli *Client) *action.Action {
+	return action.New("setwhitelistmode", func(args ...interface{}) (interface{}, error) {
+		// Check.
+		if cli.token == "" {
+			pprintError("token.is.null", "gettoken [vcode]")
+			return nil, nil
+		}
+
+		if len(args) < 1 {
+			pprintError("args.invalid", "setwhitelistmode <on|off>")
+			return nil, nil
+		}
+
+		var enable bool
+		switch args[0].(string) {
+		case "on":
+			enable = true
+		case "off":
+			enable = false
+		default:
+			pprintError("mode.invalid", "setwhitelistmode <on|off>")
+			return nil, nil
+		}
+
+		{
+			rsp := &library.WalletWhitelistResponse{}
+			body := library.APIWalletWhitelistMode(cli.apiurl, cli.token, enable)
+			if err := unmarshal(body, rsp); err != nil {
+				pprintError(err.Error(), "")
+				return nil, nil
+			}
+
+			if rsp.Code != 200 {
+				pprintError(rsp.Message, "")
+				return nil, nil
+			}
+			printWhitelist(rsp)
+		}
+		return nil, nil
+	})
+}
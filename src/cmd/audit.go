@@ -0,0 +1,77 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"server"
+	"xlog"
+)
+
+var (
+	flagAuditConf   string
+	flagAuditFile   string
+	flagAuditAnchor string
+)
+
+func init() {
+	flag.StringVar(&flagAuditConf, "c", "", "config file, verifies the audit log of the config")
+	flag.StringVar(&flagAuditFile, "f", "", "audit log file")
+	flag.StringVar(&flagAuditAnchor, "anchor", "", "the head recorded before, <seq>:<hash>")
+}
+
+func usage() {
+	fmt.Println("Usage: " + os.Args[0] + " [-c <config-file> | -f <audit-log>] [-anchor <seq>:<hash>]")
+	fmt.Println("Verifies the hash chain of the audit log and its head file, prints the head.")
+	fmt.Println("With -anchor, the entry at the seq must have the hash, which detects the rewritten or truncated log.")
+}
+
+func main() {
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+
+	flag.Usage = func() { usage() }
+	flag.Parse()
+
+	path := flagAuditFile
+	if flagAuditConf != "" {
+		conf, err := server.LoadConfig(flagAuditConf)
+		if err != nil {
+			log.Panic("audit.load.config.error[%+v]", err)
+		}
+		path = server.AuditFile(conf)
+	}
+	if path == "" {
+		usage()
+		os.Exit(0)
+	}
+
+	var anchor *server.AuditHead
+	if flagAuditAnchor != "" {
+		parts := strings.SplitN(flagAuditAnchor, ":", 2)
+		if len(parts) != 2 {
+			log.Panic("audit.anchor[%v].invalid", flagAuditAnchor)
+		}
+		seq, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			log.Panic("audit.anchor[%v].seq.error[%+v]", flagAuditAnchor, err)
+		}
+		anchor = &server.AuditHead{Seq: seq, Hash: strings.ToLower(parts[1])}
+	}
+
+	head, err := server.VerifyAuditLog(path, anchor)
+	if err != nil {
+		log.Error("audit.verify[%v].failed[%+v]", path, err)
+		os.Exit(1)
+	}
+	log.Info("audit.verify[%v].ok", path)
+	fmt.Printf("%d:%s\n", head.Seq, head.Hash)
+}
@@ -0,0 +1,116 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"server"
+	"xlog"
+)
+
+var (
+	flagBackupKeygen bool
+	flagBackupKey    string
+	flagBackupIn     string
+	flagBackupOut    string
+	flagBackupConf   string
+)
+
+func init() {
+	flag.BoolVar(&flagBackupKeygen, "keygen", false, "generate the operator key pair")
+	flag.StringVar(&flagBackupKey, "k", "", "operator private key file, one hex key per line")
+	flag.StringVar(&flagBackupIn, "in", "", "encrypted backup attachment")
+	flag.StringVar(&flagBackupOut, "o", "", "write the decrypted wallet json to the file")
+	flag.StringVar(&flagBackupConf, "c", "", "config file, import the wallet to the datadir")
+}
+
+func usage() {
+	fmt.Println("Usage: " + os.Args[0] + " -keygen")
+	fmt.Println("       " + os.Args[0] + " -k <key-file> -in <attachment> [-o <wallet-json>] [-c <config-file>]")
+	fmt.Println("Generates the operator key pair for the smtp backup_recipients, or decrypts the backup attachment and imports the wallet.")
+	fmt.Println("The wallet server key is restored only if it's in the wallet, with the same master_key if it's encrypted.")
+}
+
+// readKeys -- reads the hex keys from the file, the empty and '#' lines are skipped.
+func readKeys(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, scanner.Err()
+}
+
+func main() {
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+
+	flag.Usage = func() { usage() }
+	flag.Parse()
+
+	if flagBackupKeygen {
+		prvkey, pubkey, err := server.GenerateBackupKey()
+		if err != nil {
+			log.Panic("backup.keygen.error[%+v]", err)
+		}
+		fmt.Printf("# public key: %s\n%s\n", pubkey, prvkey)
+		return
+	}
+	if flagBackupKey == "" || flagBackupIn == "" {
+		usage()
+		os.Exit(0)
+	}
+
+	keys, err := readKeys(flagBackupKey)
+	if err != nil {
+		log.Panic("backup.read.keys.error[%+v]", err)
+	}
+	datas, err := ioutil.ReadFile(flagBackupIn)
+	if err != nil {
+		log.Panic("backup.read.attachment.error[%+v]", err)
+	}
+	wallet, err := server.DecryptBackup(keys, datas)
+	if err != nil {
+		log.Panic("backup.decrypt.error[%+v]", err)
+	}
+
+	if flagBackupConf != "" {
+		conf, err := server.LoadConfig(flagBackupConf)
+		if err != nil {
+			log.Panic("backup.load.config.error[%+v]", err)
+		}
+		uid, err := server.ImportBackup(log, conf, wallet)
+		if err != nil {
+			log.Panic("backup.import.error[%+v]", err)
+		}
+		log.Info("backup.import.wallet[%v].to[%v].done", uid, conf.DataDir)
+	}
+
+	switch {
+	case flagBackupOut != "":
+		if err := ioutil.WriteFile(flagBackupOut, wallet, 0600); err != nil {
+			log.Panic("backup.write.error[%+v]", err)
+		}
+	case flagBackupConf == "":
+		os.Stdout.Write(wallet)
+	}
+}
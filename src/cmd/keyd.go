@@ -0,0 +1,65 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"server"
+	"xlog"
+)
+
+var (
+	flagKeydConf string
+)
+
+func init() {
+	flag.StringVar(&flagKeydConf, "c", "", "config file")
+}
+
+func usage() {
+	fmt.Println("Usage: " + os.Args[0] + " [-c] <config-file>")
+	fmt.Println("Serves the key files of the key_manager key_dir on the key_manager socket, the keys are wrapped by the master_key.")
+}
+
+func main() {
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+
+	// Load config.
+	flag.Usage = func() { usage() }
+	flag.Parse()
+	if flagKeydConf == "" {
+		usage()
+		os.Exit(0)
+	}
+	conf, err := server.LoadConfig(flagKeydConf)
+	if err != nil {
+		log.Panic("keyd.load.config.error[%+v]", err)
+	}
+	if conf.KeyManager == nil {
+		log.Panic("keyd.key_manager.config.required")
+	}
+
+	keyServer, err := server.NewLocalKeyServer(log, conf)
+	if err != nil {
+		log.Panic("keyd.keyserver.error[%+v]", err)
+	}
+	if err := keyServer.Serve(conf.KeyManager.Socket); err != nil {
+		log.Panic("keyd.serve.error[%+v]", err)
+	}
+	defer keyServer.Close()
+
+	// Handle SIGINT and SIGTERM signals.
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	log.Info("keyd.got.signal:%+v", <-ch)
+	log.Info("keyd.exit.done")
+}
@@ -0,0 +1,64 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"server"
+	"xlog"
+)
+
+var (
+	flagMigrateConf  string
+	flagMigrateLocal bool
+)
+
+func init() {
+	flag.StringVar(&flagMigrateConf, "c", "", "config file")
+	flag.BoolVar(&flagMigrateLocal, "local", false, "move the keys to the key_dir of the key_manager")
+}
+
+func usage() {
+	fmt.Println("Usage: " + os.Args[0] + " [-c] <config-file> [-local]")
+	fmt.Println("Encrypts the server master private keys of the wallets in the datadir with the master_key of the config.")
+	fmt.Println("With -local, moves them out of the wallets to the key files of the key_manager key_dir.")
+	fmt.Println("Please stop the server and backup the datadir first.")
+}
+
+func main() {
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+
+	// Load config.
+	flag.Usage = func() { usage() }
+	flag.Parse()
+	if flagMigrateConf == "" {
+		usage()
+		os.Exit(0)
+	}
+	conf, err := server.LoadConfig(flagMigrateConf)
+	if err != nil {
+		log.Panic("migrate.load.config.error[%+v]", err)
+	}
+
+	if flagMigrateLocal {
+		n, err := server.MoveWalletKeys(log, conf)
+		if err != nil {
+			log.Panic("migrate.wallet.keys.moved[%v].error[%+v]", n, err)
+		}
+		log.Info("migrate.wallet.keys.moved[%v].done", n)
+		return
+	}
+
+	n, err := server.MigrateWalletKeys(log, conf)
+	if err != nil {
+		log.Panic("migrate.wallet.keys.encrypted[%v].error[%+v]", n, err)
+	}
+	log.Info("migrate.wallet.keys.encrypted[%v].done", n)
+}
@@ -0,0 +1,77 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"proto"
+)
+
+// BatchOutput -- the recipient of the batch send.
+type BatchOutput struct {
+	Address string `json:"address"`
+	Value   uint64 `json:"value"`
+}
+
+// parseBatchOutputs -- parses the outputs json '[{"address":"...","value":1000}]', the values must not be the dust.
+func parseBatchOutputs(outputs string) ([]BatchOutput, uint64, error) {
+	var sum uint64
+	var outs []BatchOutput
+	if err := json.Unmarshal([]byte(outputs), &outs); err != nil {
+		return nil, 0, err
+	}
+	if len(outs) == 0 {
+		return nil, 0, fmt.Errorf("library.batch.outputs.empty")
+	}
+	for i, out := range outs {
+		if out.Value < proto.DustLimit {
+			return nil, 0, fmt.Errorf("library.batch.output[%v].value[%v].less.than.dust[%v]", i, out.Value, proto.DustLimit)
+		}
+		sum += out.Value
+	}
+	return outs, sum, nil
+}
+
+// APIWalletBatchSendFees -- used to prepare the fees of the batch send, the outputs are the json of the BatchOutput list.
+// The strategy is the coin selection(proto.CoinSelect*), the empty is the legacy largest first.
+// The batch isn't the send all, it fails if the balance isn't enough for all the outputs and fees.
+func APIWalletBatchSendFees(url string, token string, outputs string, strategy string) string {
+	outs, sum, err := parseBatchOutputs(outputs)
+	if err != nil {
+		rsp := &WalletSendFeesResponse{}
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	body := walletSendFees(url, token, sum, len(outs), strategy)
+	rsp := &WalletSendFeesResponse{}
+	if err := unmarshal(body, rsp); err != nil || rsp.Code != http.StatusOK {
+		return body
+	}
+	if rsp.SendableValue < sum {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = fmt.Sprintf("library.batch.send.value[%v].sendable[%v].insufficient", sum, rsp.SendableValue)
+	}
+	return marshal(rsp)
+}
+
+// APIWalletBatchSend -- used to pay all the outputs in one tx, the outputs are the json of the BatchOutput list.
+// The fees should be from the APIWalletBatchSendFees of the same outputs and strategy.
+func APIWalletBatchSend(url string, token string, chainnet string, masterPrvKey string, outputs string, fees uint64, msg string, strategy string) string {
+	outs, _, err := parseBatchOutputs(outputs)
+	if err != nil {
+		rsp := &WalletSendResponse{}
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	return walletSend(url, token, chainnet, masterPrvKey, outs, fees, msg, strategy, nil)
+}
@@ -0,0 +1,75 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"testing"
+
+	"proto"
+	"server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIWalletBatchSend(t *testing.T) {
+	var token string
+	outputs := `[{"address":"mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq","value":10000},
+	{"address":"mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw","value":20000},
+	{"address":"mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq","value":30000}]`
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	// Invalid outputs.
+	{
+		rsp := &WalletSendFeesResponse{}
+		unmarshal(APIWalletBatchSendFees(ts.URL, token, `[]`, ""), rsp)
+		assert.Equal(t, 500, rsp.Code)
+
+		unmarshal(APIWalletBatchSendFees(ts.URL, token, `[{"address":"mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq","value":100}]`, ""), rsp)
+		assert.Equal(t, 500, rsp.Code)
+
+		srsp := &WalletSendResponse{}
+		unmarshal(APIWalletBatchSend(ts.URL, token, "testnet", mockMasterPrvKey, `[{"address":"xx","value":1000}]`, 1000, "", ""), srsp)
+		assert.Equal(t, 500, srsp.Code)
+	}
+
+	// Fees and send.
+	{
+		body := APIWalletBatchSendFees(ts.URL, token, outputs, proto.CoinSelectLargest)
+		fees := &WalletSendFeesResponse{}
+		unmarshal(body, fees)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, fees.Code)
+		assert.Equal(t, uint64(60000), fees.SendableValue)
+
+		body = APIWalletBatchSend(ts.URL, token, "testnet", mockMasterPrvKey, outputs, fees.Fees, "", proto.CoinSelectLargest)
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+	}
+
+	// Insufficient, not the send all.
+	{
+		body := APIWalletBatchSendFees(ts.URL, token, `[{"address":"mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq","value":100000},{"address":"mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw","value":3266}]`, "")
+		rsp := &WalletSendFeesResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 500, rsp.Code)
+	}
+}
@@ -0,0 +1,125 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"proto"
+)
+
+// splitOutpoints -- splits the outpoints 'txid:vout' separated by comma, the empty ones are skipped.
+func splitOutpoints(outpoints string) []string {
+	var ops []string
+	for _, op := range strings.Split(outpoints, ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// WalletUTXOsResponse --
+type WalletUTXOsResponse struct {
+	Status
+	UTXOs []proto.WalletUTXOsResponse `json:"utxos"`
+}
+
+// APIWalletUTXOs -- returns the unspents with the address, confirmations, label and frozen.
+func APIWalletUTXOs(url string, token string) string {
+	rsp := &WalletUTXOsResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/utxos", url)
+
+	req := &proto.WalletUTXOsRequest{}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	if err := httpRsp.Json(&rsp.UTXOs); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	return marshal(rsp)
+}
+
+// WalletFreezeUTXOsResponse --
+type WalletFreezeUTXOsResponse struct {
+	Status
+}
+
+// APIWalletFreezeUTXOs -- freezes the outpoints 'txid:vout' separated by comma, the automatic selection never spends them.
+func APIWalletFreezeUTXOs(url string, token string, outpoints string) string {
+	return walletFreezeUTXOs(url, token, outpoints, true)
+}
+
+// APIWalletUnfreezeUTXOs -- unfreezes the outpoints 'txid:vout' separated by comma.
+func APIWalletUnfreezeUTXOs(url string, token string, outpoints string) string {
+	return walletFreezeUTXOs(url, token, outpoints, false)
+}
+
+func walletFreezeUTXOs(url string, token string, outpoints string, frozen bool) string {
+	rsp := &WalletFreezeUTXOsResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/utxos/freeze", url)
+
+	req := &proto.WalletUTXOFreezeRequest{
+		Outpoints: splitOutpoints(outpoints),
+		Frozen:    frozen,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	ret := &proto.WalletUTXOFreezeResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	return marshal(rsp)
+}
+
+// WalletLabelUTXOResponse --
+type WalletLabelUTXOResponse struct {
+	Status
+}
+
+// APIWalletLabelUTXO -- labels the outpoint 'txid:vout', the empty label removes it.
+func APIWalletLabelUTXO(url string, token string, outpoint string, label string) string {
+	rsp := &WalletLabelUTXOResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/utxos/label", url)
+
+	req := &proto.WalletUTXOLabelRequest{
+		Outpoint: outpoint,
+		Label:    label,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	ret := &proto.WalletUTXOLabelResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	return marshal(rsp)
+}
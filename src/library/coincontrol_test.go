@@ -0,0 +1,85 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"testing"
+
+	"server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIWalletCoinControl(t *testing.T) {
+	var token string
+	big := "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df:0"
+	small := "2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a:1"
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	// Freeze and label.
+	{
+		body := APIWalletFreezeUTXOs(ts.URL, token, big)
+		rsp := &WalletFreezeUTXOsResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+
+		body = APIWalletLabelUTXO(ts.URL, token, small, "kyc")
+		lrsp := &WalletLabelUTXOResponse{}
+		unmarshal(body, lrsp)
+		assert.Equal(t, 200, lrsp.Code)
+	}
+
+	// List.
+	{
+		body := APIWalletUTXOs(ts.URL, token)
+		rsp := &WalletUTXOsResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+		assert.Equal(t, 2, len(rsp.UTXOs))
+		assert.True(t, rsp.UTXOs[0].Frozen)
+		assert.Equal(t, "kyc", rsp.UTXOs[1].Label)
+	}
+
+	// The frozen is spent by the explicit outpoints only.
+	{
+		body := APIWalletSendFromOutpoints(ts.URL, token, "testnet", mockMasterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 50000, 1000, "", big)
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+
+		body = APIWalletSendFromOutpoints(ts.URL, token, "testnet", mockMasterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 50000, 1000, "", " , ")
+		unmarshal(body, rsp)
+		assert.Equal(t, 500, rsp.Code)
+
+		body = APIWalletSend(ts.URL, token, "testnet", mockMasterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 50000, 1000, "")
+		unmarshal(body, rsp)
+		assert.Equal(t, 500, rsp.Code)
+	}
+
+	// Unfreeze.
+	{
+		body := APIWalletUnfreezeUTXOs(ts.URL, token, big)
+		rsp := &WalletFreezeUTXOsResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+	}
+}
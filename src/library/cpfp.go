@@ -0,0 +1,141 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"fmt"
+	"net/http"
+
+	"proto"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcore"
+)
+
+// WalletCPFPResponse --
+// The TxID is the child, the Fees are the child fees and the PackageFeesPerKB is the rate of the parent and child.
+type WalletCPFPResponse struct {
+	WalletSendResponse
+	Fees             uint64 `json:"fees"`
+	PackageFeesPerKB int64  `json:"package_feesperkb"`
+}
+
+// APIWalletCPFP -- used to accelerate the unconfirmed parent tx by the child which spends its outputs of the wallet to a fresh
+// wallet address, the child fees make the package to the feesPerKB, 0 is of the send fee mode.
+func APIWalletCPFP(url string, token string, chainnet string, masterPrvKey string, txid string, feesPerKB int) string {
+	var to xcore.Address
+	var masterkey *proto.KeyShare
+	cpfp := &proto.WalletCPFPResponse{}
+
+	rsp := &WalletCPFPResponse{}
+	rsp.Code = http.StatusOK
+
+	// Net.
+	net := network.TestNet
+	switch chainnet {
+	case MainNet:
+		net = network.MainNet
+	}
+
+	// Master pravite key, or the refreshed key share.
+	{
+		key, err := proto.ParseKeyShare(masterPrvKey)
+		if err != nil {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+		masterkey = key
+	}
+
+	// The parent outputs to spend.
+	{
+		path := fmt.Sprintf("%s/api/wallet/cpfp", url)
+		req := &proto.WalletCPFPRequest{
+			Txid:      txid,
+			Priority:  sendFeeMode,
+			FeesPerKB: feesPerKB,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+		if err != nil {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+
+		if err := httpRsp.Json(cpfp); err != nil {
+			rsp.Code = httpRsp.StatusCode()
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+	}
+
+	// The fresh address of the change branch.
+	{
+		path := fmt.Sprintf("%s/api/wallet/changeaddress", url)
+		req := &proto.WalletChangeAddressRequest{}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+		if err != nil {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+
+		changeRsp := &proto.WalletChangeAddressResponse{}
+		if err := httpRsp.Json(changeRsp); err != nil {
+			rsp.Code = httpRsp.StatusCode()
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+		to, err = checkChangeAddress(masterkey, changeRsp, net)
+		if err != nil {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+	}
+
+	// Transaction build.
+	{
+		var totalValue uint64
+		var svrPubKeys []string
+
+		sendtx := &proto.Tx{Version: 1}
+		for _, in := range cpfp.Inputs {
+			sendtx.Inputs = append(sendtx.Inputs, proto.TxIn{
+				Pos:          in.Pos,
+				Txid:         in.Txid,
+				Vout:         in.Vout,
+				Value:        in.Value,
+				Sequence:     proto.RBFSequence,
+				Scriptpubkey: in.Scriptpubkey,
+			})
+			svrPubKeys = append(svrPubKeys, in.SvrPubKey)
+			totalValue += in.Value
+		}
+		if totalValue < cpfp.ChildFees+proto.DustLimit {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = fmt.Sprintf("library.cpfp.tx[%v].inputs[%v].not.enough.for.fees[%v]", txid, totalValue, cpfp.ChildFees)
+			return marshal(rsp)
+		}
+
+		toScript, err := to.LockingScript()
+		if err != nil {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+		sendtx.Outputs = append(sendtx.Outputs, proto.TxOut{Value: totalValue - cpfp.ChildFees, Script: fmt.Sprintf("%x", toScript)})
+
+		rsp.Fees = cpfp.ChildFees
+		rsp.PackageFeesPerKB = int64(cpfp.Fees+cpfp.ChildFees) * 1000 / (cpfp.VSize + cpfp.ChildVSize)
+		if err := cosignAndPush(url, token, masterkey, sendtx, svrPubKeys, &rsp.WalletSendResponse); err != nil {
+			return marshal(rsp)
+		}
+	}
+	return marshal(rsp)
+}
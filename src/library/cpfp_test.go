@@ -0,0 +1,57 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"testing"
+
+	"server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIWalletCPFP(t *testing.T) {
+	var token string
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	// Invalid key.
+	{
+		body := APIWalletCPFP(ts.URL, token, "testnet", "xx", "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df", 0)
+		rsp := &WalletCPFPResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 500, rsp.Code)
+	}
+
+	// Unknown tx.
+	{
+		body := APIWalletCPFP(ts.URL, token, "testnet", mockMasterPrvKey, "xx", 0)
+		rsp := &WalletCPFPResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 400, rsp.Code)
+	}
+
+	// The outputs of the wallet are confirmed.
+	{
+		body := APIWalletCPFP(ts.URL, token, "testnet", mockMasterPrvKey, "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df", 5000)
+		rsp := &WalletCPFPResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 400, rsp.Code)
+	}
+}
@@ -0,0 +1,254 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"proto"
+
+	"github.com/keyfuse/tokucore/xcore"
+	"github.com/keyfuse/tokucore/xcore/bip32"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/paillier"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+var (
+	ringPedersenMu sync.Mutex
+	ringPedersen   *proto.RingPedersen
+)
+
+// ringPedersenID -- the id of the ring-pedersen parameters verified last, empty if none.
+func ringPedersenID() string {
+	ringPedersenMu.Lock()
+	defer ringPedersenMu.Unlock()
+	if ringPedersen == nil {
+		return ""
+	}
+	return ringPedersen.ID()
+}
+
+// verifyRingPedersen -- verifies the ring-pedersen parameters of the R2 response and keeps them,
+// the nil is the same as the verified last.
+func verifyRingPedersen(rp *proto.RingPedersen) (*proto.RingPedersen, error) {
+	ringPedersenMu.Lock()
+	defer ringPedersenMu.Unlock()
+
+	if rp == nil {
+		if ringPedersen == nil {
+			return nil, fmt.Errorf("library.ecdsa.ring.pedersen.is.nil")
+		}
+		return ringPedersen, nil
+	}
+	if err := rp.Verify(); err != nil {
+		return nil, err
+	}
+	ringPedersen = rp
+	return rp, nil
+}
+
+// ecdsaInput -- the two party signing state of one input.
+type ecdsaInput struct {
+	idx       int
+	pos       uint32
+	sighash   []byte
+	cliPrvKey *bip32.HDKey
+	svrPubKey *bip32.HDKey
+
+	party    *proto.EcdsaParty
+	sharepub *xcrypto.PubKey
+	encpk1   *big.Int
+	encpub1  *paillier.PubKey
+	proof    *proto.EcdsaProof
+	r1       *secp256k1.Scalar
+	r1proof  *proto.DlogProof
+	shareR   *secp256k1.Scalar
+	session  string
+	sig      []byte
+}
+
+// parallel -- calls the fn on [0, n) by the workers of the cpu number, returns the first error.
+func parallel(n int, fn func(i int) error) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	ch := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				if err := fn(i); err != nil {
+					once.Do(func() { first = err })
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+	return first
+}
+
+// signECDSABatch -- co-signs all the inputs of the tx by one batch R2 and one batch S2 request,
+// the party work of the inputs is in parallel and the paillier key pairs are from the pool.
+// The signatures are embedded into the tx.
+func signECDSABatch(url string, token string, sendtx *proto.Tx, tx *xcore.Transaction, inputs []*ecdsaInput) error {
+	defer func() {
+		for _, in := range inputs {
+			if in.party != nil {
+				in.party.Close()
+			}
+		}
+	}()
+
+	// Phase1 and Phase2.
+	if err := parallel(len(inputs), func(i int) error {
+		in := inputs[i]
+		key, err := defaultPaillierPool.get()
+		if err != nil {
+			return err
+		}
+		in.party = proto.NewEcdsaParty(in.cliPrvKey.PrivateKey(), key)
+		in.sharepub = in.party.Phase1(in.svrPubKey.PublicKey())
+		in.r1, in.r1proof, err = in.party.Phase2(in.sighash, in.pos)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Get R2.
+	{
+		r2req := &proto.EcdsaBatchR2Request{Tx: sendtx, RingPedersen: ringPedersenID()}
+		for _, in := range inputs {
+			r2req.Inputs = append(r2req.Inputs, proto.EcdsaR2Input{
+				Idx:     in.idx,
+				Pos:     in.pos,
+				Hash:    in.sighash,
+				R1:      in.r1,
+				R1Proof: in.r1proof,
+			})
+		}
+
+		path := fmt.Sprintf("%s/api/ecdsa/r2/batch", url)
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, r2req)
+		if err != nil {
+			return err
+		}
+		if httpRsp.StatusCode() == http.StatusForbidden {
+			return newPolicyError(httpRsp)
+		}
+		r2rsp := &proto.EcdsaBatchR2Response{}
+		if err := httpRsp.Json(r2rsp); err != nil {
+			return err
+		}
+		if len(r2rsp.Inputs) != len(inputs) {
+			return fmt.Errorf("library.ecdsa.r2.inputs[%v].want[%v]", len(r2rsp.Inputs), len(inputs))
+		}
+
+		// Check two party Share R is same or not.
+		for i, in := range inputs {
+			r2 := r2rsp.Inputs[i]
+			if r2.R2 == nil || r2.ShareR == nil {
+				return fmt.Errorf("library.ecdsa.input[%v].r2.is.nil", in.idx)
+			}
+			in.shareR = in.party.Phase3(r2.R2)
+			if r2.ShareR.X.Cmp(in.shareR.X) != 0 || r2.ShareR.Y.Cmp(in.shareR.Y) != 0 {
+				return fmt.Errorf("shareR.not.equal")
+			}
+			in.session = r2.Session
+		}
+
+		// Phase4, the proofs by the ring-pedersen parameters of the server.
+		rp, err := verifyRingPedersen(r2rsp.RingPedersen)
+		if err != nil {
+			return err
+		}
+		if err := parallel(len(inputs), func(i int) error {
+			var err error
+			in := inputs[i]
+			in.encpk1, in.encpub1, in.proof, err = in.party.Phase4(rp)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Get S2.
+	{
+		s2req := &proto.EcdsaBatchS2Request{Tx: sendtx}
+		for _, in := range inputs {
+			s2req.Inputs = append(s2req.Inputs, proto.EcdsaS2Input{
+				Session:   in.session,
+				Idx:       in.idx,
+				Pos:       in.pos,
+				Hash:      in.sighash,
+				R1:        in.r1,
+				EncPK1:    in.encpk1,
+				EncPub1:   in.encpub1,
+				ShareR:    in.shareR,
+				CliPubKey: in.cliPrvKey.PublicKey().Serialize(),
+				Proof:     in.proof,
+			})
+		}
+
+		path := fmt.Sprintf("%s/api/ecdsa/s2/batch", url)
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, s2req)
+		if err != nil {
+			return err
+		}
+		if httpRsp.StatusCode() == http.StatusForbidden {
+			return newPolicyError(httpRsp)
+		}
+		s2rsp := &proto.EcdsaBatchS2Response{}
+		if err := httpRsp.Json(s2rsp); err != nil {
+			return err
+		}
+		if len(s2rsp.Inputs) != len(inputs) {
+			return fmt.Errorf("library.ecdsa.s2.inputs[%v].want[%v]", len(s2rsp.Inputs), len(inputs))
+		}
+
+		// Phase5 and verify.
+		if err := parallel(len(inputs), func(i int) error {
+			in := inputs[i]
+			if s2rsp.Inputs[i].S2 == nil {
+				return fmt.Errorf("library.ecdsa.input[%v].s2.is.nil", in.idx)
+			}
+			sharesig, err := in.party.Phase5(in.shareR, s2rsp.Inputs[i].S2)
+			if err != nil {
+				return err
+			}
+			if err := xcrypto.EcdsaVerify(in.sharepub, in.sighash, sharesig); err != nil {
+				return err
+			}
+			in.sig = sharesig
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Embed IdxSignature.
+	for _, in := range inputs {
+		if err := tx.EmbedIdxEcdsaSignature(in.idx, in.sharepub, in.sig, xcore.SigHashAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"net/http"
+	"sync"
+
+	"proto"
+)
+
+// newPaillierKey -- generates the paillier key pair and its proof, it's the slow part of the signing.
+func newPaillierKey() (*proto.PaillierKey, error) {
+	key, err := proto.GeneratePaillierKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := key.Proof(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// paillierPool -- the paillier key pairs generated ahead, the signing draws one per input.
+// The key pairs(with the proofs) are kept in memory only, never written out, and every key pair is handed out once.
+// The size zero disables the pool, the signing generates the key pairs itself.
+type paillierPool struct {
+	mu       sync.Mutex
+	size     int
+	lowWater int
+	auto     bool
+	filling  bool
+	keys     []*proto.PaillierKey
+	generate func() (*proto.PaillierKey, error)
+}
+
+func newPaillierPool(generate func() (*proto.PaillierKey, error)) *paillierPool {
+	return &paillierPool{
+		generate: generate,
+	}
+}
+
+// setup -- sets the size and the refill policy, the keys over the size are dropped.
+func (p *paillierPool) setup(size int, lowWater int, auto bool) {
+	if size < 0 {
+		size = 0
+	}
+	if lowWater < 0 || lowWater > size {
+		lowWater = size
+	}
+
+	p.mu.Lock()
+	p.size = size
+	p.lowWater = lowWater
+	p.auto = auto
+	if len(p.keys) > size {
+		for _, key := range p.keys[size:] {
+			key.Close()
+		}
+		p.keys = p.keys[:size]
+	}
+	p.mu.Unlock()
+	p.maybeRefill()
+}
+
+// get -- draws one key pair from the pool, generates it if the pool is empty.
+// The pool is refilled in the background if it's under the low water and the auto refill is on.
+func (p *paillierPool) get() (*proto.PaillierKey, error) {
+	p.mu.Lock()
+	var key *proto.PaillierKey
+	if n := len(p.keys); n > 0 {
+		key = p.keys[n-1]
+		p.keys[n-1] = nil
+		p.keys = p.keys[:n-1]
+	}
+	p.mu.Unlock()
+	p.maybeRefill()
+
+	if key != nil {
+		return key, nil
+	}
+	return p.generate()
+}
+
+// maybeRefill -- starts the background refill by the policy.
+func (p *paillierPool) maybeRefill() {
+	p.mu.Lock()
+	start := p.auto && !p.filling && p.size > 0 && len(p.keys) <= p.lowWater
+	if start {
+		p.filling = true
+	}
+	p.mu.Unlock()
+
+	if start {
+		go func() {
+			p.fill()
+			p.mu.Lock()
+			p.filling = false
+			p.mu.Unlock()
+		}()
+	}
+}
+
+// fill -- generates the key pairs up to the size, blocks until done.
+func (p *paillierPool) fill() error {
+	for {
+		p.mu.Lock()
+		full := len(p.keys) >= p.size
+		p.mu.Unlock()
+		if full {
+			return nil
+		}
+
+		key, err := p.generate()
+		if err != nil {
+			return err
+		}
+		p.mu.Lock()
+		if len(p.keys) < p.size {
+			p.keys = append(p.keys, key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// clear -- drops all the key pairs.
+func (p *paillierPool) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.keys {
+		p.keys[i].Close()
+		p.keys[i] = nil
+	}
+	p.keys = nil
+}
+
+// stat -- the size, low water, auto refill and the key pairs available.
+func (p *paillierPool) stat() (int, int, bool, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size, p.lowWater, p.auto, len(p.keys)
+}
+
+var (
+	defaultPaillierPool = newPaillierPool(newPaillierKey)
+)
+
+// PaillierPoolResponse --
+type PaillierPoolResponse struct {
+	Status
+	Size       int  `json:"size"`
+	LowWater   int  `json:"low_water"`
+	AutoRefill bool `json:"auto_refill"`
+	Available  int  `json:"available"`
+}
+
+func paillierPoolStatus(pool *paillierPool) *PaillierPoolResponse {
+	rsp := &PaillierPoolResponse{}
+	rsp.Code = http.StatusOK
+	rsp.Size, rsp.LowWater, rsp.AutoRefill, rsp.Available = pool.stat()
+	return rsp
+}
+
+// PaillierPoolSetup -- sets the paillier key pool of the signing, one key pair per tx input.
+// The pool is refilled in the background when the available key pairs are not more than the lowWater if autoRefill,
+// otherwise by the PaillierPoolRefill. The size zero disables the pool.
+func PaillierPoolSetup(size int, lowWater int, autoRefill bool) string {
+	defaultPaillierPool.setup(size, lowWater, autoRefill)
+	return marshal(paillierPoolStatus(defaultPaillierPool))
+}
+
+// PaillierPoolRefill -- generates the key pairs up to the size, it blocks and should be called from the background,
+// such as the app goes to the background or the charging.
+func PaillierPoolRefill() string {
+	if err := defaultPaillierPool.fill(); err != nil {
+		rsp := paillierPoolStatus(defaultPaillierPool)
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	return marshal(paillierPoolStatus(defaultPaillierPool))
+}
+
+// PaillierPoolStatus -- returns the pool status.
+func PaillierPoolStatus() string {
+	return marshal(paillierPoolStatus(defaultPaillierPool))
+}
+
+// PaillierPoolClear -- drops all the key pairs of the pool, such as the app logs out.
+func PaillierPoolClear() string {
+	defaultPaillierPool.clear()
+	return marshal(paillierPoolStatus(defaultPaillierPool))
+}
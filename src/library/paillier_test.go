@@ -0,0 +1,233 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"proto"
+
+	"github.com/keyfuse/tokucore/xcrypto"
+	xecdsa "github.com/keyfuse/tokucore/xcrypto/ecdsa"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPaillierKey -- the empty key pair to test the pool.
+func mockPaillierKey() (*proto.PaillierKey, error) {
+	return &proto.PaillierKey{}, nil
+}
+
+// mockCoSign -- two party signing of the hash, the server half is the same as the R2/S2 of the key manager.
+func mockCoSign(pool *paillierPool, rp *proto.RingPedersen, cliprv *xcrypto.PrvKey, svrprv *xcrypto.PrvKey, hash []byte) ([]byte, *xcrypto.PubKey, error) {
+	curve := secp256k1.SECP256K1()
+	N := curve.Params().N
+
+	key, err := pool.get()
+	if err != nil {
+		return nil, nil, err
+	}
+	party := proto.NewEcdsaParty(cliprv, key)
+	defer party.Close()
+	sharepub := party.Phase1(svrprv.PubKey())
+	r1, r1proof, err := party.Phase2(hash, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r1proof.Verify(r1, hash, 0); err != nil {
+		return nil, nil, err
+	}
+
+	// Server R2.
+	k2, err := rand.Int(rand.Reader, new(big.Int).Sub(N, big.NewInt(1)))
+	if err != nil {
+		return nil, nil, err
+	}
+	k2.Add(k2, big.NewInt(1))
+	rx, ry := curve.ScalarBaseMult(k2.Bytes())
+	sx, _ := curve.ScalarMult(r1.X, r1.Y, k2.Bytes())
+	shareR := party.Phase3(secp256k1.NewScalar(rx, ry))
+	if shareR.X.Cmp(sx) != 0 {
+		return nil, nil, fmt.Errorf("mock.cosign.shareR.not.equal")
+	}
+
+	// Server S2.
+	encpk1, encpub1, proof, err := party.Phase4(rp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := proof.Verify(rp, encpub1, encpk1, cliprv.PubKey(), hash, 0); err != nil {
+		return nil, nil, err
+	}
+	kinv := new(big.Int).ModInverse(k2, N)
+	rho, err := rand.Int(rand.Reader, new(big.Int).Exp(N, big.NewInt(5), nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	m := new(big.Int).Mul(xecdsa.HashToInt(curve, hash), kinv)
+	m.Mod(m, N)
+	m.Add(m, rho.Mul(rho, N))
+	c1, err := encpub1.Encrypt(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := new(big.Int).Mul(kinv, shareR.X)
+	v.Mul(v, svrprv.D)
+	v.Mod(v, N)
+	c2, err := encpub1.MultPlaintext(encpk1, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	s2, err := encpub1.Add(c1, c2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sig, err := party.Phase5(shareR, s2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sig, sharepub, nil
+}
+
+func TestPaillierPool(t *testing.T) {
+	pool := newPaillierPool(mockPaillierKey)
+
+	// Disabled.
+	{
+		key, err := pool.get()
+		assert.Nil(t, err)
+		assert.NotNil(t, key)
+		size, _, _, available := pool.stat()
+		assert.Equal(t, 0, size)
+		assert.Equal(t, 0, available)
+	}
+
+	// Manual refill.
+	{
+		pool.setup(3, 1, false)
+		assert.Nil(t, pool.fill())
+		_, _, _, available := pool.stat()
+		assert.Equal(t, 3, available)
+
+		keys := make(map[*proto.PaillierKey]bool)
+		for i := 0; i < 3; i++ {
+			key, err := pool.get()
+			assert.Nil(t, err)
+			assert.False(t, keys[key])
+			keys[key] = true
+		}
+		_, _, _, available = pool.stat()
+		assert.Equal(t, 0, available)
+	}
+
+	// Auto refill.
+	{
+		pool.setup(4, 2, true)
+		for i := 0; i < 100; i++ {
+			if _, _, _, available := pool.stat(); available == 4 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, _, _, available := pool.stat()
+		assert.Equal(t, 4, available)
+	}
+
+	// Shrink and clear.
+	{
+		pool.setup(2, 5, false)
+		size, lowWater, auto, available := pool.stat()
+		assert.Equal(t, 2, size)
+		assert.Equal(t, 2, lowWater)
+		assert.False(t, auto)
+		assert.Equal(t, 2, available)
+
+		pool.clear()
+		_, _, _, available = pool.stat()
+		assert.Equal(t, 0, available)
+	}
+}
+
+func TestPaillierPoolAPI(t *testing.T) {
+	defer defaultPaillierPool.setup(0, 0, false)
+
+	rsp := &PaillierPoolResponse{}
+	unmarshal(PaillierPoolSetup(1, 0, false), rsp)
+	assert.Equal(t, 200, rsp.Code)
+	assert.Equal(t, 1, rsp.Size)
+	assert.Equal(t, 0, rsp.Available)
+
+	rsp = &PaillierPoolResponse{}
+	unmarshal(PaillierPoolRefill(), rsp)
+	assert.Equal(t, 200, rsp.Code)
+	assert.Equal(t, 1, rsp.Available)
+
+	rsp = &PaillierPoolResponse{}
+	unmarshal(PaillierPoolStatus(), rsp)
+	assert.Equal(t, 1, rsp.Available)
+	assert.False(t, rsp.AutoRefill)
+
+	rsp = &PaillierPoolResponse{}
+	unmarshal(PaillierPoolClear(), rsp)
+	assert.Equal(t, 0, rsp.Available)
+}
+
+func TestEcdsaPartyPooled(t *testing.T) {
+	pool := newPaillierPool(newPaillierKey)
+	pool.setup(1, 0, false)
+	assert.Nil(t, pool.fill())
+	rp, err := proto.GenerateRingPedersen()
+	assert.Nil(t, err)
+
+	cliprv := xcrypto.PrvKeyFromBytes([]byte("client.private.key.of.the.party"))
+	svrprv := xcrypto.PrvKeyFromBytes([]byte("server.private.key.of.the.party"))
+	hash := sha256.Sum256([]byte("thresh-wallet"))
+
+	sig, sharepub, err := mockCoSign(pool, rp, cliprv, svrprv, hash[:])
+	assert.Nil(t, err)
+	assert.Nil(t, xcrypto.EcdsaVerify(sharepub, hash[:], sig))
+	_, _, _, available := pool.stat()
+	assert.Equal(t, 0, available)
+}
+
+func benchmarkSign(b *testing.B, pooled bool) {
+	pool := newPaillierPool(newPaillierKey)
+	cliprv := xcrypto.PrvKeyFromBytes([]byte("client.private.key.of.the.party"))
+	svrprv := xcrypto.PrvKeyFromBytes([]byte("server.private.key.of.the.party"))
+	hash := sha256.Sum256([]byte("thresh-wallet"))
+	rp, err := proto.GenerateRingPedersen()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	if pooled {
+		b.StopTimer()
+		pool.setup(b.N, 0, false)
+		if err := pool.fill(); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+	for i := 0; i < b.N; i++ {
+		if _, _, err := mockCoSign(pool, rp, cliprv, svrprv, hash[:]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSignCold -- the signing latency of one input, the paillier key pair is generated on signing.
+func BenchmarkSignCold(b *testing.B) { benchmarkSign(b, false) }
+
+// BenchmarkSignPooled -- the signing latency of one input, the paillier key pair is from the pool.
+func BenchmarkSignPooled(b *testing.B) { benchmarkSign(b, true) }
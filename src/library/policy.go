@@ -0,0 +1,64 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"fmt"
+	"net/http"
+
+	"proto"
+)
+
+// WalletPolicyResponse --
+type WalletPolicyResponse struct {
+	Status
+	proto.WalletPolicyResponse
+}
+
+// APIWalletPolicy -- get the spending policy in effect and the pending loosening one of the wallet.
+func APIWalletPolicy(url string, token string) string {
+	path := fmt.Sprintf("%s/api/wallet/policy", url)
+	return policyPost(path, token, &proto.WalletPolicyRequest{})
+}
+
+// APIWalletSetPolicy -- sets the spending policy of the wallet, 0 of the rule means no limit.
+// The tightening takes effect immediately, the loosening after the server delay.
+func APIWalletSetPolicy(url string, token string, dailyLimit uint64, weeklyLimit uint64, maxTxValue uint64, minSendInterval int64, maxFeesPerKB uint64, allowResign bool) string {
+	path := fmt.Sprintf("%s/api/wallet/policy/set", url)
+	req := &proto.WalletSetPolicyRequest{
+		WalletPolicy: proto.WalletPolicy{
+			DailyLimit:      dailyLimit,
+			WeeklyLimit:     weeklyLimit,
+			MaxTxValue:      maxTxValue,
+			MinSendInterval: minSendInterval,
+			MaxFeesPerKB:    maxFeesPerKB,
+			AllowResign:     allowResign,
+		},
+	}
+	return policyPost(path, token, req)
+}
+
+func policyPost(path string, token string, req interface{}) string {
+	rsp := &WalletPolicyResponse{}
+	rsp.Code = http.StatusOK
+
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	ret := &proto.WalletPolicyResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	rsp.WalletPolicyResponse = *ret
+	return marshal(rsp)
+}
@@ -0,0 +1,75 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"testing"
+
+	"server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIWalletPolicy(t *testing.T) {
+	var token string
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	// Set, tightening.
+	{
+		body := APIWalletSetPolicy(ts.URL, token, 0, 0, 5000, 0, 0, false)
+		rsp := &WalletPolicyResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+		assert.Equal(t, uint64(5000), rsp.Policy.MaxTxValue)
+		assert.Nil(t, rsp.Pending)
+	}
+
+	// Send over the max tx value.
+	{
+		body := APIWalletSend(ts.URL, token, "testnet", mockMasterPrvKey, "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw", 10000, 1000, "")
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 403, rsp.Code)
+		assert.Equal(t, "max_tx_value", rsp.Violation.Rule)
+	}
+
+	// Set, loosening is pending.
+	{
+		body := APIWalletSetPolicy(ts.URL, token, 0, 0, 0, 0, 0, false)
+		rsp := &WalletPolicyResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		assert.Equal(t, uint64(5000), rsp.Policy.MaxTxValue)
+		assert.Equal(t, uint64(0), rsp.Pending.MaxTxValue)
+		assert.True(t, rsp.PendingAt > 0)
+	}
+
+	// Get.
+	{
+		body := APIWalletPolicy(ts.URL, token)
+		rsp := &WalletPolicyResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		assert.Equal(t, uint64(5000), rsp.Policy.MaxTxValue)
+		assert.NotNil(t, rsp.Pending)
+	}
+}
@@ -0,0 +1,108 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"fmt"
+	"net/http"
+
+	"proto"
+)
+
+// WalletBumpFeeResponse --
+// The TxID is the replacement, the Fees are the total fees of it and the AdditionalFees are paid from the change.
+type WalletBumpFeeResponse struct {
+	WalletSendResponse
+	Fees           uint64 `json:"fees"`
+	AdditionalFees uint64 `json:"additional_fees"`
+}
+
+// APIWalletBumpFee -- used to replace the unconfirmed sent tx by the higher fees one(BIP125), the inputs and outputs are the same,
+// the additional fees are paid from the change. The feesPerKB is the target rate, 0 is of the send fee mode.
+func APIWalletBumpFee(url string, token string, masterPrvKey string, txid string, feesPerKB int) string {
+	var masterkey *proto.KeyShare
+	bump := &proto.WalletBumpFeeResponse{}
+
+	rsp := &WalletBumpFeeResponse{}
+	rsp.Code = http.StatusOK
+
+	// Master pravite key, or the refreshed key share.
+	{
+		key, err := proto.ParseKeyShare(masterPrvKey)
+		if err != nil {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+		masterkey = key
+	}
+
+	// The tx to replace.
+	{
+		path := fmt.Sprintf("%s/api/wallet/bumpfee", url)
+		req := &proto.WalletBumpFeeRequest{
+			Txid:      txid,
+			Priority:  sendFeeMode,
+			FeesPerKB: feesPerKB,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+		if err != nil {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+
+		if err := httpRsp.Json(bump); err != nil {
+			rsp.Code = httpRsp.StatusCode()
+			rsp.Message = err.Error()
+			return marshal(rsp)
+		}
+	}
+
+	// Transaction build, the change pays the additional fees and is dropped if it's dust.
+	{
+		var svrPubKeys []string
+		var paid bool
+
+		sendtx := &proto.Tx{Version: 1}
+		for _, in := range bump.Inputs {
+			sendtx.Inputs = append(sendtx.Inputs, proto.TxIn{
+				Pos:          in.Pos,
+				Txid:         in.Txid,
+				Vout:         in.Vout,
+				Value:        in.Value,
+				Sequence:     proto.RBFSequence,
+				Scriptpubkey: in.Scriptpubkey,
+			})
+			svrPubKeys = append(svrPubKeys, in.SvrPubKey)
+		}
+
+		rsp.Fees = bump.TargetFees
+		rsp.AdditionalFees = bump.AdditionalFees
+		for _, out := range bump.Outputs {
+			if out.Change && !paid && out.Value >= bump.AdditionalFees {
+				paid = true
+				out.Value -= bump.AdditionalFees
+				if out.Value < proto.DustLimit {
+					rsp.Fees += out.Value
+					continue
+				}
+			}
+			sendtx.Outputs = append(sendtx.Outputs, proto.TxOut{Value: out.Value, Script: out.Script})
+		}
+		if !paid {
+			rsp.Code = http.StatusInternalServerError
+			rsp.Message = fmt.Sprintf("library.bumpfee.tx[%v].change.not.enough.for.additional.fees[%v]", txid, bump.AdditionalFees)
+			return marshal(rsp)
+		}
+
+		if err := cosignAndPush(url, token, masterkey, sendtx, svrPubKeys, &rsp.WalletSendResponse); err != nil {
+			return marshal(rsp)
+		}
+	}
+	return marshal(rsp)
+}
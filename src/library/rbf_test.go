@@ -0,0 +1,67 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"testing"
+
+	"server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIWalletBumpFee(t *testing.T) {
+	var token string
+	var txid string
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	// Send, signals the rbf.
+	{
+		body := APIWalletSend(ts.URL, token, "testnet", mockMasterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 10000, 1000, "")
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		txid = rsp.TxID
+	}
+
+	// Unknown tx.
+	{
+		body := APIWalletBumpFee(ts.URL, token, mockMasterPrvKey, "xx", 0)
+		rsp := &WalletBumpFeeResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 400, rsp.Code)
+	}
+
+	// Bump.
+	{
+		body := APIWalletBumpFee(ts.URL, token, mockMasterPrvKey, txid, 20000)
+		rsp := &WalletBumpFeeResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+		assert.NotEqual(t, txid, rsp.TxID)
+		assert.True(t, rsp.AdditionalFees > 0)
+		assert.Equal(t, 1000+rsp.AdditionalFees, rsp.Fees)
+
+		// Replaced.
+		body = APIWalletBumpFee(ts.URL, token, mockMasterPrvKey, txid, 20000)
+		unmarshal(body, rsp)
+		assert.Equal(t, 400, rsp.Code)
+	}
+}
@@ -0,0 +1,196 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"proto"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcore/bip32"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+// WalletRefreshResponse --
+// The MasterPrvKey is the refreshed key share, it replaces the old one only after the commit succeeded.
+type WalletRefreshResponse struct {
+	Status
+	ID            string `json:"id"`
+	Cutover       uint32 `json:"cutover"`
+	ChangeCutover uint32 `json:"change_cutover"`
+	MasterPrvKey  string `json:"masterprvkey"`
+}
+
+// APIWalletRefresh -- prepares the key share refresh with the server, returns the refreshed key share.
+// The masterPrvKey is the master private key or the key share refreshed before.
+// The app must store the refreshed key share before the APIWalletRefreshCommit, and keep the old one until it returns ok.
+// The cloud backup should be stored again with the refreshed key share.
+func APIWalletRefresh(url string, token string, chainnet string, masterPrvKey string) string {
+	rsp := &WalletRefreshResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/refresh", url)
+	n := secp256k1.SECP256K1().Params().N
+
+	// Net.
+	net := network.TestNet
+	switch chainnet {
+	case MainNet:
+		net = network.MainNet
+	}
+
+	share, err := proto.ParseKeyShare(masterPrvKey)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	// The new master key and the client factor.
+	master, err := bip32.NewHDKeyRand()
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	masterPubKey := master.HDPublicKey().ToString(net)
+	hash := sha256.Sum256([]byte(masterPubKey))
+	sig, err := xcrypto.EcdsaSign(master.PrivateKey(), hash[:])
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	rc, err := rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(1)))
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	rc.Add(rc, big.NewInt(1))
+
+	// Prepare.
+	req := &proto.WalletRefreshRequest{
+		Factor:       hex.EncodeToString(rc.Bytes()),
+		Signature:    fmt.Sprintf("%x", sig),
+		MasterPubKey: masterPubKey,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	ret := &proto.WalletRefreshResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	// Refresh by the factor.
+	refreshed, err := refreshKeyShare(share, rc, ret, master, net)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	rsp.ID = ret.ID
+	rsp.Cutover = ret.Cutover
+	rsp.ChangeCutover = ret.ChangeCutover
+	rsp.MasterPrvKey = refreshed.String()
+	return marshal(rsp)
+}
+
+// refreshKeyShare -- refreshes the client share by the factor of the client and server, and checks the shared public keys
+// of the refreshed shares are the same as the old ones.
+func refreshKeyShare(share *proto.KeyShare, rc *big.Int, ret *proto.WalletRefreshResponse, master *bip32.HDKey, net *network.Network) (*proto.KeyShare, error) {
+	n := secp256k1.SECP256K1().Params().N
+
+	rsbytes, err := hex.DecodeString(ret.Factor)
+	if err != nil {
+		return nil, err
+	}
+	r := new(big.Int).Mul(rc, new(big.Int).SetBytes(rsbytes))
+	r.Mod(r, n)
+	refreshed, err := share.Refresh(r, ret.Cutover, ret.ChangeCutover, master, net)
+	if err != nil {
+		return nil, err
+	}
+
+	oldsvr, err := proto.ParseKeyShare(ret.SvrPubKey)
+	if err != nil {
+		return nil, err
+	}
+	newsvr, err := proto.ParseKeyShare(ret.NewSvrPubKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, pos := range proto.RefreshPositions(ret.Cutover, ret.ChangeCutover) {
+		oldshared, err := sharedPubKey(share, oldsvr, pos)
+		if err != nil {
+			return nil, err
+		}
+		newshared, err := sharedPubKey(refreshed, newsvr, pos)
+		if err != nil {
+			return nil, err
+		}
+		if oldshared.X.Cmp(newshared.X) != 0 || oldshared.Y.Cmp(newshared.Y) != 0 {
+			return nil, fmt.Errorf("library.refresh.pos[%v].shared.pubkey.changed", pos)
+		}
+	}
+	return refreshed, nil
+}
+
+// sharedPubKey -- the two party public key of the client share and the server public share at the pos.
+func sharedPubKey(cli *proto.KeyShare, svr *proto.KeyShare, pos uint32) (*xcrypto.PubKey, error) {
+	clichild, err := cli.Derive(pos)
+	if err != nil {
+		return nil, err
+	}
+	svrchild, err := svr.Derive(pos)
+	if err != nil {
+		return nil, err
+	}
+	return xcrypto.NewEcdsaParty(clichild.PrivateKey()).Phase1(svrchild.PublicKey()), nil
+}
+
+// WalletRefreshCommitResponse --
+type WalletRefreshCommitResponse struct {
+	Status
+}
+
+// APIWalletRefreshCommit -- commits the key share refresh of the id, the server retires its old share.
+// It's ok to retry with the same id.
+func APIWalletRefreshCommit(url string, token string, id string) string {
+	rsp := &WalletRefreshCommitResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/refresh/commit", url)
+
+	req := &proto.WalletRefreshCommitRequest{
+		ID: id,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	ret := &proto.WalletRefreshCommitResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	return marshal(rsp)
+}
@@ -0,0 +1,81 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"testing"
+
+	"server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIWalletRefresh(t *testing.T) {
+	var token string
+	var masterPrvKey string
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	// Refresh.
+	{
+		body := APIWalletRefresh(ts.URL, token, "testnet", mockMasterPrvKey)
+		rsp := &WalletRefreshResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		assert.NotEqual(t, mockMasterPrvKey, rsp.MasterPrvKey)
+		masterPrvKey = rsp.MasterPrvKey
+
+		body = APIWalletRefreshCommit(ts.URL, token, rsp.ID)
+		commit := &WalletRefreshCommitResponse{}
+		unmarshal(body, commit)
+		assert.Equal(t, 200, commit.Code)
+
+		// Unknown id.
+		body = APIWalletRefreshCommit(ts.URL, token, "unknown")
+		unmarshal(body, commit)
+		assert.Equal(t, 400, commit.Code)
+	}
+
+	// Send by the refreshed share.
+	{
+		body := APIWalletSend(ts.URL, token, "testnet", masterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 100000, 1000, "")
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+	}
+
+	// The old share is retired.
+	{
+		body := APIWalletSend(ts.URL, token, "testnet", mockMasterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 90000, 1000, "")
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 500, rsp.Code)
+	}
+
+	// Refresh the refreshed share again.
+	{
+		body := APIWalletRefresh(ts.URL, token, "testnet", masterPrvKey)
+		rsp := &WalletRefreshResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+
+		body = APIWalletRefreshCommit(ts.URL, token, rsp.ID)
+		commit := &WalletRefreshCommitResponse{}
+		unmarshal(body, commit)
+		assert.Equal(t, 200, commit.Code)
+	}
+}
@@ -8,19 +8,21 @@ package library
 
 import (
 	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"proto"
 
 	"github.com/keyfuse/tokucore/network"
-	"github.com/keyfuse/tokucore/xbase"
 	"github.com/keyfuse/tokucore/xcore"
 	"github.com/keyfuse/tokucore/xcore/bip32"
 	"github.com/keyfuse/tokucore/xcrypto"
-	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+	"github.com/keyfuse/tokucore/xvm"
+)
+
+const (
+	// sendFeeMode -- the fee priority of the send.
+	sendFeeMode = "fast"
 )
 
 // WalletCheckResponse --
@@ -129,6 +131,8 @@ type WalletPortfolioResponse struct {
 	CoinSymbol   string  `json:"coin_symbol"`
 	FiatSymbol   string  `json:"fiat_symbol"`
 	CurrentPrice float64 `json:"current_price"`
+	PriceSource  string  `json:"price_source"`
+	PriceTime    int64   `json:"price_time"`
 }
 
 // APIWalletPortfolio -- portfolio api.
@@ -154,6 +158,46 @@ func APIWalletPortfolio(url string, token string) string {
 	rsp.CoinSymbol = ret.CoinSymbol
 	rsp.FiatSymbol = ret.FiatSymbol
 	rsp.CurrentPrice = ret.CurrentPrice
+	rsp.PriceSource = ret.PriceSource
+	rsp.PriceTime = ret.PriceTime
+	return marshal(rsp)
+}
+
+// WalletPortfolioHistoryResponse --
+type WalletPortfolioHistoryResponse struct {
+	Status
+	CoinSymbol string                       `json:"coin_symbol"`
+	FiatSymbol string                       `json:"fiat_symbol"`
+	FiatCode   string                       `json:"fiat_code"`
+	Points     []proto.WalletPortfolioPoint `json:"points"`
+}
+
+// APIWalletPortfolioHistory -- portfolio history api, the code is the fiat code, empty for the server default.
+func APIWalletPortfolioHistory(url string, token string, code string) string {
+	rsp := &WalletPortfolioHistoryResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/portfolio/history", url)
+
+	req := &proto.WalletPortfolioHistoryRequest{
+		Code: code,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	ret := &proto.WalletPortfolioHistoryResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	rsp.CoinSymbol = ret.CoinSymbol
+	rsp.FiatSymbol = ret.FiatSymbol
+	rsp.FiatCode = ret.FiatCode
+	rsp.Points = ret.Points
 	return marshal(rsp)
 }
 
@@ -296,7 +340,18 @@ type WalletSendFeesResponse struct {
 
 // APIWalletSendFees -- used to prepare the fees before the txn build.
 func APIWalletSendFees(url string, token string, sendValue uint64) string {
-	feemode := "fast"
+	return APIWalletSendFeesByStrategy(url, token, sendValue, "")
+}
+
+// APIWalletSendFeesByStrategy -- used to prepare the fees of the coins selected by the strategy(proto.CoinSelect*),
+// the empty is the legacy largest first.
+func APIWalletSendFeesByStrategy(url string, token string, sendValue uint64, strategy string) string {
+	return walletSendFees(url, token, sendValue, 1, strategy)
+}
+
+// walletSendFees -- the fees of the send value to the outputs.
+func walletSendFees(url string, token string, sendValue uint64, outputs int, strategy string) string {
+	feemode := sendFeeMode
 
 	rsp := &WalletSendFeesResponse{}
 	rsp.Code = http.StatusOK
@@ -306,6 +361,8 @@ func APIWalletSendFees(url string, token string, sendValue uint64) string {
 		req := &proto.WalletSendFeesRequest{
 			Priority:  feemode,
 			SendValue: sendValue,
+			Strategy:  strategy,
+			Outputs:   outputs,
 		}
 		path := fmt.Sprintf("%s/api/wallet/sendfees", url)
 		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
@@ -332,14 +389,59 @@ func APIWalletSendFees(url string, token string, sendValue uint64) string {
 // WalletSendResponse --
 type WalletSendResponse struct {
 	Status
-	TxID string `json:"txid"`
+	TxID      string                 `json:"txid"`
+	Violation *proto.PolicyViolation `json:"violation,omitempty"`
+}
+
+// PolicyError -- the policy violation returned by the co-signer.
+type PolicyError struct {
+	Violation proto.PolicyViolation
 }
 
+func newPolicyError(httpRsp *proto.Response) error {
+	perr := &PolicyError{}
+	if err := unmarshal(httpRsp.Body(), &perr.Violation); err != nil {
+		return err
+	}
+	return perr
+}
+
+// Error -- the implementation method for error interface.
+func (e *PolicyError) Error() string {
+	return e.Violation.Message
+}
+
+// APIWalletSend -- used to send the amount to the address, the unspents are the legacy largest first.
 func APIWalletSend(url string, token string, chainnet string, masterPrvKey string, toAddress string, amount uint64, fees uint64, msg string) string {
+	return APIWalletSendByStrategy(url, token, chainnet, masterPrvKey, toAddress, amount, fees, msg, "")
+}
+
+// APIWalletSendByStrategy -- used to send the amount to the address, the unspents are selected by the strategy,
+// the fees should be from the APIWalletSendFeesByStrategy of the same strategy. The change below the dust goes to the fees.
+func APIWalletSendByStrategy(url string, token string, chainnet string, masterPrvKey string, toAddress string, amount uint64, fees uint64, msg string, strategy string) string {
+	return walletSend(url, token, chainnet, masterPrvKey, []BatchOutput{{Address: toAddress, Value: amount}}, fees, msg, strategy, nil)
+}
+
+// APIWalletSendFromOutpoints -- used to send the amount to the address, the unspents are exactly the outpoints
+// 'txid:vout' separated by comma, the frozen ones can be spent. The change below the dust goes to the fees.
+func APIWalletSendFromOutpoints(url string, token string, chainnet string, masterPrvKey string, toAddress string, amount uint64, fees uint64, msg string, outpoints string) string {
+	ops := splitOutpoints(outpoints)
+	if len(ops) == 0 {
+		rsp := &WalletSendResponse{}
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = fmt.Sprintf("library.send.outpoints[%v].empty", outpoints)
+		return marshal(rsp)
+	}
+	return walletSend(url, token, chainnet, masterPrvKey, []BatchOutput{{Address: toAddress, Value: amount}}, fees, msg, "", ops)
+}
+
+// walletSend -- the send to the outputs in one tx, the unspents are by the outpoints if any, otherwise by the strategy.
+func walletSend(url string, token string, chainnet string, masterPrvKey string, outputs []BatchOutput, fees uint64, msg string, strategy string, outpoints []string) string {
 	var err error
-	var to xcore.Address
+	var amount uint64
+	var tos []xcore.Address
 	var change xcore.Address
-	var masterkey *bip32.HDKey
+	var masterkey *proto.KeyShare
 	var unspents []proto.WalletUnspentResponse
 
 	rsp := &WalletSendResponse{}
@@ -363,9 +465,9 @@ func APIWalletSend(url string, token string, chainnet string, masterPrvKey strin
 		}
 	}
 
-	// Master pravite key.
+	// Master pravite key, or the refreshed key share.
 	{
-		masterkey, err = bip32.NewHDKeyFromString(masterPrvKey)
+		masterkey, err = proto.ParseKeyShare(masterPrvKey)
 		if err != nil {
 			rsp.Code = http.StatusInternalServerError
 			rsp.Message = err.Error()
@@ -373,14 +475,23 @@ func APIWalletSend(url string, token string, chainnet string, masterPrvKey strin
 		}
 	}
 
-	// To address.
+	// To addresses.
 	{
-		to, err = xcore.DecodeAddress(toAddress, net)
-		if err != nil {
+		if len(outputs) == 0 {
 			rsp.Code = http.StatusInternalServerError
-			rsp.Message = err.Error()
+			rsp.Message = "library.send.outputs.empty"
 			return marshal(rsp)
 		}
+		for _, output := range outputs {
+			to, err := xcore.DecodeAddress(output.Address, net)
+			if err != nil {
+				rsp.Code = http.StatusInternalServerError
+				rsp.Message = err.Error()
+				return marshal(rsp)
+			}
+			tos = append(tos, to)
+			amount += output.Value
+		}
 	}
 
 	// Get unspents.
@@ -388,6 +499,17 @@ func APIWalletSend(url string, token string, chainnet string, masterPrvKey strin
 		req := &proto.WalletUnspentRequest{
 			Amount: amount + fees,
 		}
+		if strategy != "" {
+			req.Amount = amount
+			req.Priority = sendFeeMode
+			req.Strategy = strategy
+			req.Outputs = len(outputs)
+		}
+		if len(outpoints) > 0 {
+			req = &proto.WalletUnspentRequest{
+				Outpoints: outpoints,
+			}
+		}
 
 		path := fmt.Sprintf("%s/api/wallet/unspent", url)
 		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
@@ -404,219 +526,200 @@ func APIWalletSend(url string, token string, chainnet string, masterPrvKey strin
 		}
 	}
 
-	// Change address.
-	{
-		changeAddress := unspents[0].Address
-		change, err = xcore.DecodeAddress(changeAddress, net)
-		if err != nil {
-			rsp.Code = http.StatusInternalServerError
-			rsp.Message = err.Error()
-			return marshal(rsp)
-		}
-	}
-
-	// Transaction build.
+	// Change address, the new one of the change branch if the change is there.
 	{
-		// Coins.
-		coinBuilder := xcore.NewCoinBuilder()
+		var totalValue uint64
 		for _, unspent := range unspents {
-			coinBuilder.AddOutput(
-				unspent.Txid,
-				unspent.Vout,
-				unspent.Value,
-				unspent.Scriptpubkey)
-		}
-		coins := coinBuilder.ToCoins()
-
-		// Transaction builder.
-		txBuilder := xcore.NewTransactionBuilder()
-		for _, coin := range coins {
-			txBuilder.AddCoin(coin).Then()
-		}
-		txBuilder.To(to, amount)
-		txBuilder.SetChange(change).SendFees(fees)
-		if msg != "" {
-			txBuilder.AddPushData([]byte(msg))
-		}
-		tx, err := txBuilder.BuildTransaction()
-		if err != nil {
-			rsp.Code = http.StatusInternalServerError
-			rsp.Message = err.Error()
-			return marshal(rsp)
+			totalValue += unspent.Value
 		}
-
-		for i, unspent := range unspents {
-			var sighash []byte
-			if strings.HasPrefix(unspent.Address, net.Bech32HRPSegwit) {
-				_, version, _, err := xbase.WitnessDecode(unspent.Address)
-				if err != nil {
-					rsp.Code = http.StatusInternalServerError
-					rsp.Message = err.Error()
-					return marshal(rsp)
-				}
-
-				switch version {
-				case 0x00:
-					sighash = tx.WitnessV0SignatureHash(i, xcore.SigHashAll)
-				default:
-					rsp.Code = http.StatusInternalServerError
-					rsp.Message = fmt.Sprintf("bench32.address[%v].version.unknow", unspent.Address)
-					return marshal(rsp)
-				}
-			} else {
-				sighash = tx.RawSignatureHash(i, xcore.SigHashAll)
-			}
-
-			cliPrvKey, err := masterkey.Derive(unspent.Pos)
-			if err != nil {
-				rsp.Code = http.StatusInternalServerError
-				rsp.Message = err.Error()
-				return marshal(rsp)
-			}
-			svrPubKey, err := bip32.NewHDKeyFromString(unspent.SvrPubKey)
+		if totalValue >= (amount + fees + proto.DustLimit) {
+			path := fmt.Sprintf("%s/api/wallet/changeaddress", url)
+			req := &proto.WalletChangeAddressRequest{}
+			httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
 			if err != nil {
 				rsp.Code = http.StatusInternalServerError
 				rsp.Message = err.Error()
 				return marshal(rsp)
 			}
 
-			// Signature.
-			scriptHex, err := hex.DecodeString(unspent.Scriptpubkey)
-			if err != nil {
-				rsp.Code = http.StatusInternalServerError
+			changeRsp := &proto.WalletChangeAddressResponse{}
+			if err := httpRsp.Json(changeRsp); err != nil {
+				rsp.Code = httpRsp.StatusCode()
 				rsp.Message = err.Error()
 				return marshal(rsp)
 			}
-
-			// Signature version.
-			script, err := xcore.ParseLockingScript(scriptHex)
+			change, err = checkChangeAddress(masterkey, changeRsp, net)
 			if err != nil {
 				rsp.Code = http.StatusInternalServerError
 				rsp.Message = err.Error()
 				return marshal(rsp)
 			}
-			scriptVersion := script.GetScriptVersion()
-			switch scriptVersion {
-			case xcore.BASE, xcore.WITNESS_V0:
-				if err := signECDSA(url, token, unspent.Pos, i, sighash, tx, cliPrvKey, svrPubKey); err != nil {
-					rsp.Code = http.StatusInternalServerError
-					rsp.Message = err.Error()
-					return marshal(rsp)
-				}
-			default:
-				rsp.Code = http.StatusInternalServerError
-				rsp.Message = fmt.Sprintf("script.version[%v].unsupport", scriptVersion)
-				return marshal(rsp)
-			}
 		}
+	}
 
-		// Verify Tx.
-		if err := tx.Verify(); err != nil {
+	// Transaction build.
+	{
+		var totalValue uint64
+
+		sendtx := &proto.Tx{Version: 1}
+		for _, unspent := range unspents {
+			sendtx.Inputs = append(sendtx.Inputs, proto.TxIn{
+				Pos:          unspent.Pos,
+				Txid:         unspent.Txid,
+				Vout:         unspent.Vout,
+				Value:        unspent.Value,
+				Sequence:     proto.RBFSequence,
+				Scriptpubkey: unspent.Scriptpubkey,
+			})
+			totalValue += unspent.Value
+		}
+		if totalValue < (amount + fees) {
 			rsp.Code = http.StatusInternalServerError
-			rsp.Message = err.Error()
+			rsp.Message = fmt.Sprintf("library.send.unspents[%v].not.enough.amount[%v].fees[%v]", totalValue, amount, fees)
 			return marshal(rsp)
 		}
-		localtxid := tx.ID()
-
-		// Push tx.
-		{
-			path := fmt.Sprintf("%s/api/wallet/pushtx", url)
 
-			req := &proto.TxPushRequest{
-				TxHex: fmt.Sprintf("%x", tx.Serialize()),
-			}
-			httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+		// To.
+		for i, to := range tos {
+			toScript, err := to.LockingScript()
 			if err != nil {
 				rsp.Code = http.StatusInternalServerError
 				rsp.Message = err.Error()
 				return marshal(rsp)
 			}
+			sendtx.Outputs = append(sendtx.Outputs, proto.TxOut{Value: outputs[i].Value, Script: fmt.Sprintf("%x", toScript)})
+		}
 
-			pushrsp := &proto.TxPushResponse{}
-			if err := httpRsp.Json(pushrsp); err != nil {
-				rsp.Code = httpRsp.StatusCode()
+		// Change.
+		if changeValue := totalValue - amount - fees; changeValue >= proto.DustLimit {
+			changeScript, err := change.LockingScript()
+			if err != nil {
+				rsp.Code = http.StatusInternalServerError
 				rsp.Message = err.Error()
 				return marshal(rsp)
 			}
-			rsp.TxID = pushrsp.TxID
-			if localtxid != pushrsp.TxID {
+			sendtx.Outputs = append(sendtx.Outputs, proto.TxOut{Value: changeValue, Script: fmt.Sprintf("%x", changeScript)})
+		}
+
+		// Message.
+		if msg != "" {
+			pushData, err := xvm.NewScriptBuilder().AddOp(xvm.OP_RETURN).AddData([]byte(msg)).Script()
+			if err != nil {
 				rsp.Code = http.StatusInternalServerError
-				rsp.Message = fmt.Sprintf("library.send.to.address[%v].push.tx.txid[local:%v, remote:%v].error", toAddress, localtxid, pushrsp.TxID)
+				rsp.Message = err.Error()
 				return marshal(rsp)
 			}
+			sendtx.Outputs = append(sendtx.Outputs, proto.TxOut{Value: 0, Script: fmt.Sprintf("%x", pushData)})
+		}
+
+		var svrPubKeys []string
+		for _, unspent := range unspents {
+			svrPubKeys = append(svrPubKeys, unspent.SvrPubKey)
+		}
+		if err := cosignAndPush(url, token, masterkey, sendtx, svrPubKeys, rsp); err != nil {
+			return marshal(rsp)
 		}
 	}
 	return marshal(rsp)
 }
 
-func signECDSA(url string, token string, pos uint32, txInIdx int, sighash []byte, tx *xcore.Transaction, cliPrvKey *bip32.HDKey, svrPubKey *bip32.HDKey) error {
-	var shareR1 *secp256k1.Scalar
-
-	aliceParty := xcrypto.NewEcdsaParty(cliPrvKey.PrivateKey())
-	// Phase1.
-	sharepub := aliceParty.Phase1(svrPubKey.PublicKey())
-	// Phase2.
-	encpk1, encpub1, scalarR1 := aliceParty.Phase2(sighash)
-
-	// Get R2.
-	{
-		r2req := &proto.EcdsaR2Request{
-			Pos:  pos,
-			Hash: sighash,
-			R1:   scalarR1,
+// checkChangeAddress -- checks the change address is the two party address of the client share and the server public key
+// at the change branch pos, the change isn't sent to the address the client can't sign.
+func checkChangeAddress(masterkey *proto.KeyShare, changeRsp *proto.WalletChangeAddressResponse, net *network.Network) (xcore.Address, error) {
+	if !proto.IsChangePos(changeRsp.Pos) {
+		return nil, fmt.Errorf("library.change.address[%v].pos[%v].not.of.change.branch", changeRsp.Address, changeRsp.Pos)
+	}
+	cliPrvKey, err := masterkey.Derive(changeRsp.Pos)
+	if err != nil {
+		return nil, err
+	}
+	svrPubKey, err := bip32.NewHDKeyFromString(changeRsp.SvrPubKey)
+	if err != nil {
+		return nil, err
+	}
+	sharepub := xcrypto.NewEcdsaParty(cliPrvKey.PrivateKey()).Phase1(svrPubKey.PublicKey())
+	for _, change := range []xcore.Address{
+		xcore.NewPayToWitnessV0PubKeyHashAddress(sharepub.Hash160()),
+		xcore.NewPayToPubKeyHashAddress(sharepub.Hash160()),
+	} {
+		if change.ToString(net) == changeRsp.Address {
+			return change, nil
 		}
+	}
+	return nil, fmt.Errorf("library.change.address[%v].pos[%v].mismatch", changeRsp.Address, changeRsp.Pos)
+}
 
-		path := fmt.Sprintf("%s/api/ecdsa/r2", url)
-		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, r2req)
-		if err != nil {
-			return err
-		}
-		r2rsp := &proto.EcdsaR2Response{}
-		if err := httpRsp.Json(&r2rsp); err != nil {
-			return err
-		}
+// cosignAndPush -- signs the inputs of the sendtx with the server by the two-party ecdsa, then pushes it.
+// The svrPubKeys are the server public keys of the inputs, the rsp carries the txid or the error.
+func cosignAndPush(url string, token string, masterkey *proto.KeyShare, sendtx *proto.Tx, svrPubKeys []string, rsp *WalletSendResponse) error {
+	fail := func(code int, err error) error {
+		rsp.Code = code
+		rsp.Message = err.Error()
+		return err
+	}
 
-		// Check two party Share R is same or not.
-		shareR1 = aliceParty.Phase3(r2rsp.R2)
-		if r2rsp.ShareR.X.Cmp(shareR1.X) != 0 || r2rsp.ShareR.Y.Cmp(shareR1.Y) != 0 {
-			return fmt.Errorf("shareR.not.equal")
-		}
+	tx, err := sendtx.Transaction()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
 	}
 
-	// Get S2.
-	{
-		s2req := &proto.EcdsaS2Request{
-			Pos:     pos,
-			Hash:    sighash,
-			R1:      scalarR1,
-			EncPK1:  encpk1,
-			EncPub1: encpub1,
-			ShareR:  shareR1,
+	var inputs []*ecdsaInput
+	for i, in := range sendtx.Inputs {
+		sighash, err := sendtx.SignatureHash(i)
+		if err != nil {
+			return fail(http.StatusInternalServerError, err)
 		}
 
-		path := fmt.Sprintf("%s/api/ecdsa/s2", url)
-		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, s2req)
+		cliPrvKey, err := masterkey.Derive(in.Pos)
 		if err != nil {
-			return err
-		}
-		s2rsp := &proto.EcdsaS2Response{}
-		if err := httpRsp.Json(&s2rsp); err != nil {
-			return err
+			return fail(http.StatusInternalServerError, err)
 		}
-
-		// Phase5.
-		sharesig, err := aliceParty.Phase5(shareR1, s2rsp.S2)
+		svrPubKey, err := bip32.NewHDKeyFromString(svrPubKeys[i])
 		if err != nil {
-			return err
+			return fail(http.StatusInternalServerError, err)
 		}
+		inputs = append(inputs, &ecdsaInput{
+			idx:       i,
+			pos:       in.Pos,
+			sighash:   sighash,
+			cliPrvKey: cliPrvKey,
+			svrPubKey: svrPubKey,
+		})
+	}
 
-		// Verify.
-		if err := xcrypto.EcdsaVerify(sharepub, sighash, sharesig); err != nil {
-			return err
+	// Signatures.
+	if err := signECDSABatch(url, token, sendtx, tx, inputs); err != nil {
+		fail(http.StatusInternalServerError, err)
+		if perr, ok := err.(*PolicyError); ok {
+			rsp.Code = http.StatusForbidden
+			rsp.Violation = &perr.Violation
 		}
+		return err
+	}
+
+	// Verify Tx.
+	if err := tx.Verify(); err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+	localtxid := tx.ID()
 
-		// Embed IdxSignature.
-		tx.EmbedIdxEcdsaSignature(txInIdx, sharepub, sharesig, xcore.SigHashAll)
+	// Push tx.
+	path := fmt.Sprintf("%s/api/wallet/pushtx", url)
+	req := &proto.TxPushRequest{
+		TxHex: fmt.Sprintf("%x", tx.Serialize()),
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	pushrsp := &proto.TxPushResponse{}
+	if err := httpRsp.Json(pushrsp); err != nil {
+		return fail(httpRsp.StatusCode(), err)
+	}
+	rsp.TxID = pushrsp.TxID
+	if localtxid != pushrsp.TxID {
+		return fail(http.StatusInternalServerError, fmt.Errorf("library.push.tx.txid[local:%v, remote:%v].error", localtxid, pushrsp.TxID))
 	}
 	return nil
 }
@@ -9,8 +9,10 @@ package library
 import (
 	"testing"
 
+	"proto"
 	"server"
 
+	"github.com/keyfuse/tokucore/network"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -85,6 +87,31 @@ func TestWalletPortfolio(t *testing.T) {
 	assert.Equal(t, 200, rsp.Code)
 }
 
+func TestWalletPortfolioHistory(t *testing.T) {
+	var token string
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	body := APIWalletPortfolioHistory(ts.URL, token, "USD")
+	rsp := &WalletPortfolioHistoryResponse{}
+	unmarshal(body, rsp)
+
+	t.Logf("%+v", body)
+	assert.Equal(t, 200, rsp.Code)
+	assert.Equal(t, "USD", rsp.FiatCode)
+	assert.Equal(t, 4, len(rsp.Points))
+}
+
 func TestWalletBalance(t *testing.T) {
 	var token string
 
@@ -231,6 +258,21 @@ func TestAPIWalletSend(t *testing.T) {
 		assert.Equal(t, 200, rsp.Code)
 	}
 
+	// The fees and send by the strategy.
+	{
+		body := APIWalletSendFeesByStrategy(ts.URL, token, 5000, proto.CoinSelectSmallest)
+		fees := &WalletSendFeesResponse{}
+		unmarshal(body, fees)
+		assert.Equal(t, 200, fees.Code)
+
+		body = APIWalletSendByStrategy(ts.URL, token, "testnet", mockMasterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 5000, fees.Fees, "", proto.CoinSelectSmallest)
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+	}
+
 	// Suffient value.
 	{
 		body := APIWalletSend(ts.URL, token, "testnet", mockMasterPrvKey, "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq", 1000000, 1000, "")
@@ -241,3 +283,77 @@ func TestAPIWalletSend(t *testing.T) {
 		assert.Equal(t, 500, rsp.Code)
 	}
 }
+
+func TestAPIWalletSendPolicy(t *testing.T) {
+	var token string
+
+	conf := server.MockConfig()
+	conf.Policy = &server.Policy{MaxTxValue: 50000}
+	ts, cleanup := server.MockServerWithConfig(conf)
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	{
+		body := APIWalletSend(ts.URL, token, "testnet", mockMasterPrvKey, "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw", 100000, 1000, "")
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 403, rsp.Code)
+		assert.Equal(t, "max_tx_value", rsp.Violation.Rule)
+		assert.Equal(t, uint64(101000), rsp.Violation.Value)
+	}
+}
+
+func TestCheckChangeAddress(t *testing.T) {
+	var token string
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	masterkey, err := proto.ParseKeyShare(mockMasterPrvKey)
+	assert.Nil(t, err)
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(ts.URL+"/api/wallet/changeaddress", &proto.WalletChangeAddressRequest{})
+	assert.Nil(t, err)
+	changeRsp := &proto.WalletChangeAddressResponse{}
+	assert.Nil(t, httpRsp.Json(changeRsp))
+
+	{
+		change, err := checkChangeAddress(masterkey, changeRsp, network.TestNet)
+		assert.Nil(t, err)
+		assert.Equal(t, changeRsp.Address, change.ToString(network.TestNet))
+	}
+
+	// The address isn't of the client share.
+	{
+		bad := *changeRsp
+		bad.Address = "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw"
+		_, err := checkChangeAddress(masterkey, &bad, network.TestNet)
+		assert.NotNil(t, err)
+	}
+
+	// The pos isn't of the change branch.
+	{
+		bad := *changeRsp
+		bad.Pos = 2
+		_, err := checkChangeAddress(masterkey, &bad, network.TestNet)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,116 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"fmt"
+	"net/http"
+
+	"proto"
+)
+
+// WalletWhitelistAddResponse --
+type WalletWhitelistAddResponse struct {
+	Status
+	proto.WhitelistAddress
+}
+
+// APIWalletWhitelistAdd -- adds the address to the whitelist, it's active after the server delay.
+func APIWalletWhitelistAdd(url string, token string, address string, label string) string {
+	rsp := &WalletWhitelistAddResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/whitelist/add", url)
+
+	req := &proto.WhitelistAddRequest{
+		Address: address,
+		Label:   label,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	ret := &proto.WhitelistAddResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	rsp.WhitelistAddress = ret.WhitelistAddress
+	return marshal(rsp)
+}
+
+// WalletWhitelistRemoveResponse --
+type WalletWhitelistRemoveResponse struct {
+	Status
+}
+
+// APIWalletWhitelistRemove -- removes the address from the whitelist.
+func APIWalletWhitelistRemove(url string, token string, address string) string {
+	rsp := &WalletWhitelistRemoveResponse{}
+	rsp.Code = http.StatusOK
+	path := fmt.Sprintf("%s/api/wallet/whitelist/remove", url)
+
+	req := &proto.WhitelistRemoveRequest{
+		Address: address,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	ret := &proto.WhitelistRemoveResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	return marshal(rsp)
+}
+
+// WalletWhitelistResponse --
+type WalletWhitelistResponse struct {
+	Status
+	proto.WhitelistResponse
+}
+
+// APIWalletWhitelist -- get the whitelist of the wallet.
+func APIWalletWhitelist(url string, token string) string {
+	path := fmt.Sprintf("%s/api/wallet/whitelist/list", url)
+	return whitelistPost(path, token, &proto.WhitelistListRequest{})
+}
+
+// APIWalletWhitelistMode -- turns the whitelist mode on immediately, or off after the server delay.
+func APIWalletWhitelistMode(url string, token string, enable bool) string {
+	path := fmt.Sprintf("%s/api/wallet/whitelist/mode", url)
+	return whitelistPost(path, token, &proto.WhitelistModeRequest{Enable: enable})
+}
+
+func whitelistPost(path string, token string, req interface{}) string {
+	rsp := &WalletWhitelistResponse{}
+	rsp.Code = http.StatusOK
+
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", token).Post(path, req)
+	if err != nil {
+		rsp.Code = http.StatusInternalServerError
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+
+	ret := &proto.WhitelistResponse{}
+	if err := httpRsp.Json(ret); err != nil {
+		rsp.Code = httpRsp.StatusCode()
+		rsp.Message = err.Error()
+		return marshal(rsp)
+	}
+	rsp.WhitelistResponse = *ret
+	return marshal(rsp)
+}
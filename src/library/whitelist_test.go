@@ -0,0 +1,100 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package library
+
+import (
+	"testing"
+
+	"server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIWalletWhitelist(t *testing.T) {
+	var token string
+
+	ts, cleanup := server.MockServer()
+	defer cleanup()
+
+	// Token.
+	{
+		body := APIGetToken(ts.URL, mockMobile, "vcode")
+		rsp := &TokenResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		token = rsp.Token
+	}
+
+	// Add.
+	{
+		body := APIWalletWhitelistAdd(ts.URL, token, "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw", "cold")
+		rsp := &WalletWhitelistAddResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+		assert.Equal(t, "cold", rsp.Label)
+		assert.True(t, rsp.ActiveAt > rsp.AddedAt)
+	}
+
+	// Add with invalid address.
+	{
+		body := APIWalletWhitelistAdd(ts.URL, token, "xx", "bad")
+		rsp := &WalletWhitelistAddResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 400, rsp.Code)
+	}
+
+	// Mode on.
+	{
+		body := APIWalletWhitelistMode(ts.URL, token, true)
+		rsp := &WalletWhitelistResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 200, rsp.Code)
+		assert.True(t, rsp.Enabled)
+		assert.Equal(t, 1, len(rsp.Addresses))
+		assert.False(t, rsp.Addresses[0].Active)
+	}
+
+	// Send to the inactive address.
+	{
+		body := APIWalletSend(ts.URL, token, "testnet", mockMasterPrvKey, "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw", 10000, 1000, "")
+		rsp := &WalletSendResponse{}
+		unmarshal(body, rsp)
+
+		t.Logf("%+v", body)
+		assert.Equal(t, 403, rsp.Code)
+		assert.Equal(t, "whitelist", rsp.Violation.Rule)
+		assert.Equal(t, "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw", rsp.Violation.Address)
+	}
+
+	// Mode off, still enabled until the delay.
+	{
+		body := APIWalletWhitelistMode(ts.URL, token, false)
+		rsp := &WalletWhitelistResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+		assert.True(t, rsp.Enabled)
+		assert.True(t, rsp.DisableAt > 0)
+	}
+
+	// Remove.
+	{
+		body := APIWalletWhitelistRemove(ts.URL, token, "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw")
+		rsp := &WalletWhitelistRemoveResponse{}
+		unmarshal(body, rsp)
+		assert.Equal(t, 200, rsp.Code)
+
+		body = APIWalletWhitelist(ts.URL, token)
+		listRsp := &WalletWhitelistResponse{}
+		unmarshal(body, listRsp)
+		assert.Equal(t, 200, listRsp.Code)
+		assert.Equal(t, 0, len(listRsp.Addresses))
+	}
+}
@@ -0,0 +1,42 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+// AuditQueryRequest --
+// The empty uid and event match all, the since and until are the unix time range, 0 is unbounded.
+type AuditQueryRequest struct {
+	UID    string `json:"uid"`
+	Event  string `json:"event"`
+	Since  int64  `json:"since"`
+	Until  int64  `json:"until"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+// AuditEntry -- the record of the audit log, the Hash chains the Prev hash and the entry.
+type AuditEntry struct {
+	Seq       uint64 `json:"seq"`
+	Time      int64  `json:"time"`
+	Event     string `json:"event"`
+	UID       string `json:"uid"`
+	Pos       uint32 `json:"pos"`
+	SigHash   string `json:"sighash,omitempty"`
+	DeviceID  string `json:"deviceid,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Forwarded string `json:"forwarded,omitempty"`
+	Result    string `json:"result"`
+	Detail    string `json:"detail,omitempty"`
+	Prev      string `json:"prev"`
+	Hash      string `json:"hash"`
+}
+
+// AuditQueryResponse -- the matched entries and the head of the chain for the verification.
+type AuditQueryResponse struct {
+	Entries  []AuditEntry `json:"entries"`
+	HeadSeq  uint64       `json:"head_seq"`
+	HeadHash string       `json:"head_hash"`
+}
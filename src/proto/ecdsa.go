@@ -14,29 +14,91 @@ import (
 )
 
 // EcdsaR2Request --
+// The RingPedersen is the ID of the ring-pedersen parameters the client verified before.
 type EcdsaR2Request struct {
-	Pos  uint32            `json:"pos"`
-	Hash []byte            `json:"hash"`
-	R1   *secp256k1.Scalar `json:"R1"`
+	Pos          uint32            `json:"pos"`
+	Idx          int               `json:"idx"`
+	Tx           *Tx               `json:"tx"`
+	Hash         []byte            `json:"hash"`
+	R1           *secp256k1.Scalar `json:"R1"`
+	R1Proof      *DlogProof        `json:"R1proof"`
+	RingPedersen string            `json:"ring_pedersen,omitempty"`
 }
 
 // EcdsaR2Response --
+// The session is used by the S2 once, before the expired(unix time).
+// The RingPedersen is the parameters of the S2 proofs, omitted if the same as the request.
 type EcdsaR2Response struct {
-	R2     *secp256k1.Scalar `json:"R2"`
-	ShareR *secp256k1.Scalar `json:"shareR"`
+	Session      string            `json:"session"`
+	Expired      int64             `json:"expired"`
+	R2           *secp256k1.Scalar `json:"R2"`
+	ShareR       *secp256k1.Scalar `json:"shareR"`
+	RingPedersen *RingPedersen     `json:"ring_pedersen,omitempty"`
 }
 
 // EcdsaS2Request --
 type EcdsaS2Request struct {
-	Pos     uint32            `json:"pos"`
-	Hash    []byte            `json:"hash"`
-	EncPK1  *big.Int          `json:"encpk1"`
-	EncPub1 *paillier.PubKey  `json:"encpub1"`
-	R1      *secp256k1.Scalar `json:"R1"`
-	ShareR  *secp256k1.Scalar `json:"shareR"`
+	Session   string            `json:"session"`
+	Pos       uint32            `json:"pos"`
+	Idx       int               `json:"idx"`
+	Tx        *Tx               `json:"tx"`
+	Hash      []byte            `json:"hash"`
+	EncPK1    *big.Int          `json:"encpk1"`
+	EncPub1   *paillier.PubKey  `json:"encpub1"`
+	R1        *secp256k1.Scalar `json:"R1"`
+	ShareR    *secp256k1.Scalar `json:"shareR"`
+	CliPubKey []byte            `json:"clipubkey"`
+	Proof     *EcdsaProof       `json:"proof"`
 }
 
 // EcdsaS2Response --
 type EcdsaS2Response struct {
 	S2 *big.Int `json:"S2"`
 }
+
+// EcdsaR2Input -- the input of the batch R2.
+type EcdsaR2Input struct {
+	Idx     int               `json:"idx"`
+	Pos     uint32            `json:"pos"`
+	Hash    []byte            `json:"hash"`
+	R1      *secp256k1.Scalar `json:"R1"`
+	R1Proof *DlogProof        `json:"R1proof"`
+}
+
+// EcdsaBatchR2Request -- the R2 of the inputs of the tx in one request.
+type EcdsaBatchR2Request struct {
+	Tx           *Tx            `json:"tx"`
+	Inputs       []EcdsaR2Input `json:"inputs"`
+	RingPedersen string         `json:"ring_pedersen,omitempty"`
+}
+
+// EcdsaBatchR2Response -- the sessions of the inputs, in the order of the request.
+type EcdsaBatchR2Response struct {
+	Inputs       []EcdsaR2Response `json:"inputs"`
+	RingPedersen *RingPedersen     `json:"ring_pedersen,omitempty"`
+}
+
+// EcdsaS2Input -- the input of the batch S2.
+type EcdsaS2Input struct {
+	Session   string            `json:"session"`
+	Idx       int               `json:"idx"`
+	Pos       uint32            `json:"pos"`
+	Hash      []byte            `json:"hash"`
+	EncPK1    *big.Int          `json:"encpk1"`
+	EncPub1   *paillier.PubKey  `json:"encpub1"`
+	R1        *secp256k1.Scalar `json:"R1"`
+	ShareR    *secp256k1.Scalar `json:"shareR"`
+	CliPubKey []byte            `json:"clipubkey"`
+	Proof     *EcdsaProof       `json:"proof"`
+}
+
+// EcdsaBatchS2Request -- the S2 of the inputs of the tx in one request.
+type EcdsaBatchS2Request struct {
+	Tx     *Tx            `json:"tx"`
+	Inputs []EcdsaS2Input `json:"inputs"`
+}
+
+// EcdsaBatchS2Response -- the S2 of the inputs, in the order of the request.
+type EcdsaBatchS2Response struct {
+	Inputs []EcdsaS2Response `json:"inputs"`
+}
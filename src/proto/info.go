@@ -6,9 +6,23 @@
 
 package proto
 
+// ChainHealth -- the health of the chain provider.
+// The error text of the provider isn't exposed(it may have the rpc urls or hosts), it's in the server logs only.
+type ChainHealth struct {
+	Provider      string `json:"provider"`
+	Healthy       bool   `json:"healthy"`
+	Failures      int    `json:"failures"`
+	Calls         uint64 `json:"calls"`
+	Errors        uint64 `json:"errors"`
+	LastErrorAt   int64  `json:"last_error_at"`
+	LastSuccessAt int64  `json:"last_success_at"`
+	LatencyMs     int64  `json:"latency_ms"`
+}
+
 // ServerInfoResponse --
 type ServerInfoResponse struct {
-	ChainNet    string `json:"chainnet"`
-	ServerTime  int64  `json:"server_time"`
-	EnableVCode bool   `json:"enable_vcode"`
+	ChainNet    string         `json:"chainnet"`
+	ServerTime  int64          `json:"server_time"`
+	EnableVCode bool           `json:"enable_vcode"`
+	Chains      []*ChainHealth `json:"chains,omitempty"`
 }
@@ -0,0 +1,195 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xcore/bip32"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+// ChangeBranch -- the first pos of the internal change branch, the change addresses are at ChangeBranch+n,
+// n is the change pos counter of the wallet. The receive addresses are below it.
+const ChangeBranch uint32 = 1 << 30
+
+// IsChangePos -- returns true if the pos is on the change branch.
+func IsChangePos(pos uint32) bool {
+	return pos >= ChangeBranch
+}
+
+// RefreshPositions -- the pos of the child keys refreshed by the cutovers, the receive ones first.
+func RefreshPositions(cutover uint32, changeCutover uint32) []uint32 {
+	positions := make([]uint32, 0, cutover+changeCutover)
+	for pos := uint32(0); pos < cutover; pos++ {
+		positions = append(positions, pos)
+	}
+	for n := uint32(0); n < changeCutover; n++ {
+		positions = append(positions, ChangeBranch+n)
+	}
+	return positions
+}
+
+// KeyShare -- the key share of the party, private or public.
+// The child keys are derived from the bip32 master key, except the pos before the cutover(and the change pos before
+// the change cutover), they are the refreshed child keys which are multiplied by the refresh factors.
+// The share never refreshed is the master key string itself.
+type KeyShare struct {
+	Master        string            `json:"master"`
+	Cutover       uint32            `json:"cutover"`
+	ChangeCutover uint32            `json:"change_cutover,omitempty"`
+	Children      map[uint32]string `json:"children,omitempty"`
+	master        *bip32.HDKey
+}
+
+// ParseKeyShare -- parses the master key string or the json of the refreshed share.
+func ParseKeyShare(s string) (*KeyShare, error) {
+	share := &KeyShare{}
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		if err := json.Unmarshal([]byte(s), share); err != nil {
+			return nil, fmt.Errorf("keyshare.unmarshal.error:%v", err)
+		}
+	} else {
+		share.Master = s
+	}
+	master, err := bip32.NewHDKeyFromString(share.Master)
+	if err != nil {
+		return nil, err
+	}
+	share.master = master
+	if share.Cutover >= ChangeBranch || share.ChangeCutover >= ChangeBranch {
+		return nil, fmt.Errorf("keyshare.cutover[%v].change.cutover[%v].overflow", share.Cutover, share.ChangeCutover)
+	}
+	if uint32(len(share.Children)) != share.Cutover+share.ChangeCutover {
+		return nil, fmt.Errorf("keyshare.children[%v].cutover[%v].change.cutover[%v].mismatch", len(share.Children), share.Cutover, share.ChangeCutover)
+	}
+	return share, nil
+}
+
+// String -- the master key string if never refreshed, otherwise the json.
+func (k *KeyShare) String() string {
+	if k.Cutover == 0 && k.ChangeCutover == 0 {
+		return k.Master
+	}
+	datas, _ := json.Marshal(k)
+	return string(datas)
+}
+
+// refreshed -- returns true if the child key at the pos is the refreshed one.
+func (k *KeyShare) refreshed(pos uint32) bool {
+	if IsChangePos(pos) {
+		return (pos - ChangeBranch) < k.ChangeCutover
+	}
+	return pos < k.Cutover
+}
+
+// Derive -- the child key at the pos.
+func (k *KeyShare) Derive(pos uint32) (*bip32.HDKey, error) {
+	if !k.refreshed(pos) {
+		return k.master.Derive(pos)
+	}
+	child, ok := k.Children[pos]
+	if !ok {
+		return nil, fmt.Errorf("keyshare.child[%v].missing", pos)
+	}
+	return bip32.NewHDKeyFromString(child)
+}
+
+// Public -- the public share.
+func (k *KeyShare) Public(net *network.Network) (*KeyShare, error) {
+	pub := &KeyShare{
+		Master:        k.master.HDPublicKey().ToString(net),
+		Cutover:       k.Cutover,
+		ChangeCutover: k.ChangeCutover,
+		master:        k.master.HDPublicKey(),
+	}
+	positions := RefreshPositions(k.Cutover, k.ChangeCutover)
+	if len(positions) > 0 {
+		pub.Children = make(map[uint32]string, len(positions))
+	}
+	for _, pos := range positions {
+		child, err := k.Derive(pos)
+		if err != nil {
+			return nil, err
+		}
+		pub.Children[pos] = child.HDPublicKey().ToString(net)
+	}
+	return pub, nil
+}
+
+// Refresh -- returns the new share, the child keys before the cutovers are multiplied by the factor,
+// and the others are derived from the new master key.
+// The shared public keys don't change if the other party refreshes by the inverse of the factor.
+func (k *KeyShare) Refresh(factor *big.Int, cutover uint32, changeCutover uint32, master *bip32.HDKey, net *network.Network) (*KeyShare, error) {
+	n := secp256k1.SECP256K1().Params().N
+	if factor.Sign() <= 0 || factor.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("keyshare.refresh.factor.invalid")
+	}
+	if cutover < k.Cutover {
+		return nil, fmt.Errorf("keyshare.refresh.cutover[%v].less.than[%v]", cutover, k.Cutover)
+	}
+	if changeCutover < k.ChangeCutover {
+		return nil, fmt.Errorf("keyshare.refresh.change.cutover[%v].less.than[%v]", changeCutover, k.ChangeCutover)
+	}
+	if cutover >= ChangeBranch || changeCutover >= ChangeBranch {
+		return nil, fmt.Errorf("keyshare.refresh.cutover[%v].change.cutover[%v].overflow", cutover, changeCutover)
+	}
+	if master.PrivateKey() == nil || k.master.PrivateKey() == nil {
+		return nil, fmt.Errorf("keyshare.refresh.private.key.required")
+	}
+
+	positions := RefreshPositions(cutover, changeCutover)
+	share := &KeyShare{
+		Master:        master.ToString(net),
+		Cutover:       cutover,
+		ChangeCutover: changeCutover,
+		Children:      make(map[uint32]string, len(positions)),
+		master:        master,
+	}
+	for _, pos := range positions {
+		child, err := k.Derive(pos)
+		if err != nil {
+			return nil, err
+		}
+		scaled, err := scaleHDKey(child, factor, net)
+		if err != nil {
+			return nil, err
+		}
+		share.Children[pos] = scaled
+	}
+	return share, nil
+}
+
+// scaleHDKey -- multiplies the private key of the hdkey by the factor, the other fields are kept.
+func scaleHDKey(hdkey *bip32.HDKey, factor *big.Int, net *network.Network) (string, error) {
+	n := secp256k1.SECP256K1().Params().N
+
+	// version(4) || depth(1) || parent fingerprint(4) || child num(4) || chain code(32) || 0x00 || key(32) || checksum(4)
+	datas := xbase.Base58Decode(hdkey.ToString(net))
+	if len(datas) != 82 || datas[45] != 0x00 {
+		return "", fmt.Errorf("keyshare.scale.private.key.required")
+	}
+	d := new(big.Int).SetBytes(datas[46:78])
+	d.Mul(d, factor)
+	d.Mod(d, n)
+	if d.Sign() == 0 {
+		return "", fmt.Errorf("keyshare.scale.zero.key")
+	}
+	key := d.Bytes()
+	for i := 46; i < 78; i++ {
+		datas[i] = 0
+	}
+	copy(datas[78-len(key):78], key)
+	copy(datas[78:], xcrypto.DoubleSha256(datas[:78])[:4])
+	return xbase.Base58Encode(datas), nil
+}
@@ -0,0 +1,126 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/paillier"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+// EcdsaParty -- the client party of the two party ecdsa, it's the xcrypto.EcdsaParty with the zero-knowledge proofs,
+// and the paillier key pair is from the caller(such as the pool), used once.
+type EcdsaParty struct {
+	prv  *xcrypto.PrvKey
+	key  *PaillierKey
+	pos  uint32
+	hash []byte
+	k    *big.Int
+	kinv *big.Int
+}
+
+// NewEcdsaParty -- creates new EcdsaParty.
+func NewEcdsaParty(prv *xcrypto.PrvKey, key *PaillierKey) *EcdsaParty {
+	return &EcdsaParty{
+		prv: prv,
+		key: key,
+	}
+}
+
+// Phase1 -- the shared public key.
+func (party *EcdsaParty) Phase1(pub2 *xcrypto.PubKey) *xcrypto.PubKey {
+	curve := secp256k1.SECP256K1()
+	px, py := curve.ScalarMult(pub2.X, pub2.Y, party.prv.D.Bytes())
+	return &xcrypto.PubKey{X: px, Y: py, Curve: curve}
+}
+
+// Phase2 -- the R1 of the random one-time nonce and its DlogProof, bound to the hash and the pos.
+// The nonce must not be deterministic, the server nonce is random and two signatures of the same hash with
+// the same client nonce leak the private key.
+func (party *EcdsaParty) Phase2(hash []byte, pos uint32) (*secp256k1.Scalar, *DlogProof, error) {
+	curve := secp256k1.SECP256K1()
+	N := curve.Params().N
+
+	k, err := randUnit(N)
+	if err != nil {
+		return nil, nil, err
+	}
+	party.pos = pos
+	party.hash = hash
+	party.k = k
+	party.kinv = new(big.Int).ModInverse(party.k, N)
+	rx, ry := curve.ScalarBaseMult(party.k.Bytes())
+	R1 := secp256k1.NewScalar(rx, ry)
+	proof, err := ProveDlog(party.k, R1, hash, pos)
+	if err != nil {
+		return nil, nil, err
+	}
+	return R1, proof, nil
+}
+
+// Phase3 -- the shared R of the R2.
+func (party *EcdsaParty) Phase3(r2 *secp256k1.Scalar) *secp256k1.Scalar {
+	rx, ry := secp256k1.SECP256K1().ScalarMult(r2.X, r2.Y, party.k.Bytes())
+	return secp256k1.NewScalar(rx, ry)
+}
+
+// Phase4 -- the encrypted private key, the paillier public key and the proofs by the ring-pedersen parameters of the server.
+// The rp must be verified by the caller.
+func (party *EcdsaParty) Phase4(rp *RingPedersen) (*big.Int, *paillier.PubKey, *EcdsaProof, error) {
+	keyProof, err := party.key.Proof()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encpk, rho, err := party.key.Encrypt(party.prv.D)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rho.SetInt64(0)
+	rangeProof, err := proveEncRange(rp, party.key, encpk, party.prv.D, rho, party.prv.PubKey(), party.hash, party.pos)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return encpk, party.key.PubKey, &EcdsaProof{Key: keyProof, Range: rangeProof}, nil
+}
+
+// Phase5 -- the final signature of the S2.
+func (party *EcdsaParty) Phase5(shareR *secp256k1.Scalar, sign2 *big.Int) ([]byte, error) {
+	N := secp256k1.SECP256K1().Params().N
+
+	sig, err := party.key.Decrypt(sign2)
+	if err != nil {
+		return nil, err
+	}
+	s := sig.Mul(sig, party.kinv).Mod(sig, N)
+	halfOrder := new(big.Int).Rsh(N, 1)
+	if s.Cmp(halfOrder) == 1 {
+		s.Sub(N, s)
+	}
+	if s.Sign() == 0 {
+		return nil, fmt.Errorf("ecdsa.party.s.is.zero")
+	}
+	esig := xcrypto.NewSignatureEcdsa()
+	esig.R = shareR.X
+	esig.S = s
+	return esig.Serialize()
+}
+
+// Close -- cleanups the secret, the paillier key pair is dropped.
+func (party *EcdsaParty) Close() {
+	if party.key != nil {
+		party.key.Close()
+	}
+	party.prv = nil
+	party.key = nil
+	if party.k != nil {
+		party.k.SetInt64(0)
+		party.kinv.SetInt64(0)
+	}
+}
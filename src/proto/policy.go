@@ -0,0 +1,43 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+// PolicyViolation -- the rule refused the co-signing, the limit and the value are of the rule, the address is of the whitelist rule.
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Limit   uint64 `json:"limit"`
+	Value   uint64 `json:"value"`
+	Address string `json:"address"`
+	Message string `json:"message"`
+}
+
+// WalletPolicy -- the spending rules of the wallet, zero value of the rule means no limit.
+type WalletPolicy struct {
+	DailyLimit      uint64 `json:"daily_limit"`
+	WeeklyLimit     uint64 `json:"weekly_limit"`
+	MaxTxValue      uint64 `json:"max_tx_value"`
+	MinSendInterval int64  `json:"min_send_interval"`
+	MaxFeesPerKB    uint64 `json:"max_fees_per_kb"`
+	AllowResign     bool   `json:"allow_resign"`
+}
+
+// WalletPolicyRequest --
+type WalletPolicyRequest struct {
+}
+
+// WalletSetPolicyRequest --
+// The tightening takes effect immediately, the loosening after the policy delay.
+type WalletSetPolicyRequest struct {
+	WalletPolicy
+}
+
+// WalletPolicyResponse -- the policy in effect and the pending loosening one, the PendingAt is the time it takes effect, 0 if none.
+type WalletPolicyResponse struct {
+	Policy    WalletPolicy  `json:"policy"`
+	Pending   *WalletPolicy `json:"pending,omitempty"`
+	PendingAt int64         `json:"pending_at"`
+}
@@ -0,0 +1,132 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xcore"
+)
+
+const (
+	// DefaultSequence -- the final sequence of the tx input.
+	DefaultSequence = 0xffffffff
+
+	// RBFSequence -- the sequence of the tx input which signals the opt-in replace-by-fee(BIP125).
+	RBFSequence = 0xfffffffd
+)
+
+// IsRBFSequence -- returns true if the input sequence signals the replace-by-fee, it's less than 0xfffffffe.
+func IsRBFSequence(sequence uint32) bool {
+	return sequence < DefaultSequence-1
+}
+
+// TxIn -- the input of the unsigned transaction with its prevout.
+type TxIn struct {
+	Pos          uint32 `json:"pos"`
+	Txid         string `json:"txid"`
+	Vout         uint32 `json:"vout"`
+	Value        uint64 `json:"value"`
+	Sequence     uint32 `json:"sequence"`
+	Scriptpubkey string `json:"scriptpubkey"`
+}
+
+// TxOut -- the output of the unsigned transaction.
+type TxOut struct {
+	Value  uint64 `json:"value"`
+	Script string `json:"script"`
+}
+
+// Tx -- the unsigned transaction which the two parties co-sign.
+type Tx struct {
+	Version  uint32  `json:"version"`
+	LockTime uint32  `json:"locktime"`
+	Inputs   []TxIn  `json:"inputs"`
+	Outputs  []TxOut `json:"outputs"`
+}
+
+// Transaction -- builds the xcore transaction from the unsigned tx.
+func (t *Tx) Transaction() (*xcore.Transaction, error) {
+	if len(t.Inputs) == 0 {
+		return nil, fmt.Errorf("tx.inputs.empty")
+	}
+	if len(t.Outputs) == 0 {
+		return nil, fmt.Errorf("tx.outputs.empty")
+	}
+
+	tx := xcore.NewTransaction()
+	tx.SetVersion(t.Version)
+	tx.SetLockTime(t.LockTime)
+	for i, in := range t.Inputs {
+		hash, err := xbase.NewIDFromString(in.Txid)
+		if err != nil {
+			return nil, err
+		}
+		script, err := hex.DecodeString(in.Scriptpubkey)
+		if err != nil {
+			return nil, err
+		}
+		txin, err := xcore.NewTxIn(hash, in.Vout, in.Value, script, nil)
+		if err != nil {
+			return nil, fmt.Errorf("tx.input[%v].error:%v", i, err)
+		}
+		txin.Sequence = in.Sequence
+		tx.AddInput(txin)
+	}
+	for _, out := range t.Outputs {
+		script, err := hex.DecodeString(out.Script)
+		if err != nil {
+			return nil, err
+		}
+		tx.AddOutput(xcore.NewTxOut(out.Value, script))
+	}
+	return tx, nil
+}
+
+// SignatureHash -- returns the sighash(SigHashAll) of the idx input.
+func (t *Tx) SignatureHash(idx int) ([]byte, error) {
+	if idx < 0 || idx >= len(t.Inputs) {
+		return nil, fmt.Errorf("tx.input.idx[%v].out.of.range[%v]", idx, len(t.Inputs))
+	}
+	tx, err := t.Transaction()
+	if err != nil {
+		return nil, err
+	}
+	script, err := hex.DecodeString(t.Inputs[idx].Scriptpubkey)
+	if err != nil {
+		return nil, err
+	}
+	locking, err := xcore.ParseLockingScript(script)
+	if err != nil {
+		return nil, err
+	}
+	switch version := locking.GetScriptVersion(); version {
+	case xcore.BASE:
+		return tx.RawSignatureHash(idx, xcore.SigHashAll), nil
+	case xcore.WITNESS_V0:
+		return tx.WitnessV0SignatureHash(idx, xcore.SigHashAll), nil
+	default:
+		return nil, fmt.Errorf("tx.input[%v].script.version[%v].unsupport", idx, version)
+	}
+}
+
+// Fees -- returns the fees of the tx, the sum(inputs)-sum(outputs).
+func (t *Tx) Fees() (uint64, error) {
+	var totalIn, totalOut uint64
+	for _, in := range t.Inputs {
+		totalIn += in.Value
+	}
+	for _, out := range t.Outputs {
+		totalOut += out.Value
+	}
+	if totalOut > totalIn {
+		return 0, fmt.Errorf("tx.outputs[%v].larger.than.inputs[%v]", totalOut, totalIn)
+	}
+	return totalIn - totalOut, nil
+}
@@ -18,6 +18,20 @@ type WalletNewAddressResponse struct {
 	Address string `json:"address"`
 }
 
+// WalletChangeAddressRequest --
+// The change address is new on every send, it's on the change branch of the wallet.
+type WalletChangeAddressRequest struct {
+	Type string `json:"type"`
+}
+
+// WalletChangeAddressResponse --
+// The SvrPubKey is the server child public key at the pos, the client checks the address is of its share.
+type WalletChangeAddressResponse struct {
+	Pos       uint32 `json:"pos"`
+	Address   string `json:"address"`
+	SvrPubKey string `json:"svrpubkey"`
+}
+
 // WalletCheckRequest --
 type WalletCheckRequest struct {
 }
@@ -51,6 +65,29 @@ type WalletPortfolioResponse struct {
 	CoinSymbol   string  `json:"coin_symbol"`
 	FiatSymbol   string  `json:"fiat_symbol"`
 	CurrentPrice float64 `json:"current_price"`
+	PriceSource  string  `json:"price_source"`
+	PriceTime    int64   `json:"price_time"`
+}
+
+// WalletPortfolioHistoryRequest --
+type WalletPortfolioHistoryRequest struct {
+	Code string `json:"code"`
+}
+
+// WalletPortfolioPoint -- the balance and its fiat value at the time, the price is 0 if unknown.
+type WalletPortfolioPoint struct {
+	Time      int64   `json:"time"`
+	Balance   int64   `json:"balance"`
+	Price     float64 `json:"price"`
+	FiatValue float64 `json:"fiat_value"`
+}
+
+// WalletPortfolioHistoryResponse --
+type WalletPortfolioHistoryResponse struct {
+	CoinSymbol string                 `json:"coin_symbol"`
+	FiatSymbol string                 `json:"fiat_symbol"`
+	FiatCode   string                 `json:"fiat_code"`
+	Points     []WalletPortfolioPoint `json:"points"`
 }
 
 // WalletBalanceRequest --
@@ -62,9 +99,43 @@ type WalletBalanceResponse struct {
 	CoinValue uint64 `json:"coin_value"`
 }
 
+// DustLimit -- the output value below it is the dust, the change below it goes to the fees.
+const DustLimit uint64 = 546
+
+// Coin selection strategies, they are fee-aware by the input weights of the script types.
+const (
+	// CoinSelectLargest -- the largest first.
+	CoinSelectLargest = "largest"
+
+	// CoinSelectBnB -- the branch and bound for the changeless, the largest first if not found.
+	CoinSelectBnB = "bnb"
+
+	// CoinSelectSmallest -- the smallest first, consolidates the small ones.
+	CoinSelectSmallest = "smallest"
+
+	// CoinSelectPrivacy -- spends the coins of one address together, and avoids merging the addresses.
+	CoinSelectPrivacy = "privacy"
+
+	// CoinSelectConfirmed -- the largest first of the confirmed ones only.
+	CoinSelectConfirmed = "confirmed"
+)
+
 // WalletUnspentRequest --
+// The strategy is the coin selection, the empty is the legacy largest first which amount includes the fees.
+// Otherwise the amount is the send value, and the fees are added by the priority.
+// The outpoints('txid:vout') are the coin control, the unspents are exactly them and the frozen ones can be picked.
+// The outputs is the number of the recipients for the fees of the strategy, 0 is 1.
 type WalletUnspentRequest struct {
-	Amount uint64 `json:"amount"`
+	Amount    uint64   `json:"amount"`
+	Priority  string   `json:"priority,omitempty"`
+	Strategy  string   `json:"strategy,omitempty"`
+	Outpoints []string `json:"outpoints,omitempty"`
+	Outputs   int      `json:"outputs,omitempty"`
+}
+
+// RecipientOutputs -- returns the number of the recipient outputs, at least 1.
+func (r *WalletUnspentRequest) RecipientOutputs() int {
+	return recipientOutputs(r.Outputs)
 }
 
 // WalletUnspentResponse --
@@ -79,6 +150,47 @@ type WalletUnspentResponse struct {
 	Scriptpubkey string `json:"scriptpubkey"`
 }
 
+// WalletUTXOsRequest --
+type WalletUTXOsRequest struct {
+}
+
+// WalletUTXOsResponse --
+// The frozen one is never spent by the automatic selection.
+type WalletUTXOsResponse struct {
+	Pos           uint32 `json:"pos"`
+	Txid          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	Value         uint64 `json:"value"`
+	Address       string `json:"address"`
+	Confirmed     bool   `json:"confirmed"`
+	BlockHeight   int64  `json:"block_height"`
+	Confirmations int64  `json:"confirmations"`
+	Label         string `json:"label"`
+	Frozen        bool   `json:"frozen"`
+}
+
+// WalletUTXOFreezeRequest --
+// The outpoints are 'txid:vout' of the wallet unspents, the frozen false is the unfreeze.
+type WalletUTXOFreezeRequest struct {
+	Outpoints []string `json:"outpoints"`
+	Frozen    bool     `json:"frozen"`
+}
+
+// WalletUTXOFreezeResponse --
+type WalletUTXOFreezeResponse struct {
+}
+
+// WalletUTXOLabelRequest --
+// The empty label removes it.
+type WalletUTXOLabelRequest struct {
+	Outpoint string `json:"outpoint"`
+	Label    string `json:"label"`
+}
+
+// WalletUTXOLabelResponse --
+type WalletUTXOLabelResponse struct {
+}
+
 // TxPushRequest --
 type TxPushRequest struct {
 	TxHex string `json:"txhex"`
@@ -94,6 +206,7 @@ type WalletTxsRequest struct {
 	Offset  int    `json:"offset"`
 	Limit   int    `json:"limit"`
 	OrderBy string `json:"orderby"`
+	Code    string `json:"code"`
 }
 
 // WalletTxsResponse --
@@ -106,6 +219,19 @@ type WalletTxsResponse struct {
 	Confirmed   bool   `json:"confirmed"`
 	BlockTime   int64  `json:"block_time"`
 	BlockHeight int64  `json:"block_height"`
+
+	// Change -- the tx value is of the change address, the UI can hide it.
+	Change bool `json:"change"`
+
+	// Superseded -- the tx is replaced by the fee bumped one of the ReplacedBy txid.
+	Superseded bool   `json:"superseded"`
+	ReplacedBy string `json:"replaced_by,omitempty"`
+
+	// Fiat value of the tx value at the first seen and the confirmation, 0 if the price unknown.
+	FiatCode           string  `json:"fiat_code"`
+	FiatValue          float64 `json:"fiat_value"`
+	FiatValueConfirmed float64 `json:"fiat_value_confirmed"`
+	SeenAt             int64   `json:"seen_at"`
 }
 
 // WalletAddressesRequest --
@@ -121,9 +247,25 @@ type WalletAddressesResponse struct {
 }
 
 // WalletSendFeesRequest --
+// The strategy is the coin selection same as the WalletUnspentRequest, the fees include the changeless excess.
+// The send value is the sum to the outputs(the number of the recipients, 0 is 1) of the batch send.
 type WalletSendFeesRequest struct {
 	Priority  string `json:"priority"`
 	SendValue uint64 `json:"send_value"`
+	Strategy  string `json:"strategy,omitempty"`
+	Outputs   int    `json:"outputs,omitempty"`
+}
+
+// RecipientOutputs -- returns the number of the recipient outputs, at least 1.
+func (r *WalletSendFeesRequest) RecipientOutputs() int {
+	return recipientOutputs(r.Outputs)
+}
+
+func recipientOutputs(outputs int) int {
+	if outputs < 1 {
+		return 1
+	}
+	return outputs
 }
 
 // WalletSendFeesResponse --
@@ -132,3 +274,82 @@ type WalletSendFeesResponse struct {
 	TotalValue    uint64 `json:"total_value"`
 	SendableValue uint64 `json:"sendable_value"`
 }
+
+// WalletBumpFeeRequest --
+// The txid is the unconfirmed tx sent by the wallet, the target fee rate is the FeesPerKB or of the priority if 0.
+type WalletBumpFeeRequest struct {
+	Txid      string `json:"txid"`
+	Priority  string `json:"priority"`
+	FeesPerKB int    `json:"feesperkb,omitempty"`
+}
+
+// WalletBumpFeeOutput -- the output of the tx, the change is back to the wallet.
+type WalletBumpFeeOutput struct {
+	Value  uint64 `json:"value"`
+	Script string `json:"script"`
+	Change bool   `json:"change"`
+}
+
+// WalletBumpFeeResponse --
+// The replacement spends the same inputs to the same outputs, the additional fees are paid from the change.
+// The target fees are the max of the target rate and the fees plus the incremental relay fees(BIP125).
+type WalletBumpFeeResponse struct {
+	Txid           string                  `json:"txid"`
+	Inputs         []WalletUnspentResponse `json:"inputs"`
+	Outputs        []WalletBumpFeeOutput   `json:"outputs"`
+	VSize          int64                   `json:"vsize"`
+	Fees           uint64                  `json:"fees"`
+	FeesPerKB      int                     `json:"feesperkb"`
+	TargetFees     uint64                  `json:"target_fees"`
+	AdditionalFees uint64                  `json:"additional_fees"`
+}
+
+// WalletCPFPRequest --
+// The txid is the unconfirmed parent tx with the outputs of the wallet, the target fee rate is the FeesPerKB or of the priority if 0.
+type WalletCPFPRequest struct {
+	Txid      string `json:"txid"`
+	Priority  string `json:"priority"`
+	FeesPerKB int    `json:"feesperkb,omitempty"`
+}
+
+// WalletCPFPResponse --
+// The child spends the inputs to one wallet address, the child fees make the package of the parent and child to the target rate.
+type WalletCPFPResponse struct {
+	Txid       string                  `json:"txid"`
+	Inputs     []WalletUnspentResponse `json:"inputs"`
+	VSize      int64                   `json:"vsize"`
+	Fees       uint64                  `json:"fees"`
+	FeesPerKB  int                     `json:"feesperkb"`
+	ChildVSize int64                   `json:"child_vsize"`
+	ChildFees  uint64                  `json:"child_fees"`
+}
+
+// WalletRefreshRequest --
+// The factor is the client part of the refresh factor(hex), the master key is the new client master key for the new addresses.
+type WalletRefreshRequest struct {
+	Factor       string `json:"factor"`
+	Signature    string `json:"signature"`
+	MasterPubKey string `json:"masterpubkey"`
+}
+
+// WalletRefreshResponse --
+// The refresh factor is the product of the client and server factors, the client multiplies its child keys before
+// the cutover(and the change child keys before the change cutover) by the factor, the server by the inverse.
+type WalletRefreshResponse struct {
+	ID            string `json:"id"`
+	Factor        string `json:"factor"`
+	Cutover       uint32 `json:"cutover"`
+	ChangeCutover uint32 `json:"change_cutover"`
+	SvrPubKey     string `json:"svrpubkey"`
+	NewSvrPubKey  string `json:"new_svrpubkey"`
+	Expired       int64  `json:"expired"`
+}
+
+// WalletRefreshCommitRequest --
+type WalletRefreshCommitRequest struct {
+	ID string `json:"id"`
+}
+
+// WalletRefreshCommitResponse --
+type WalletRefreshCommitResponse struct {
+}
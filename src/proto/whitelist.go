@@ -0,0 +1,53 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+// WhitelistAddress -- the whitelisted address, it's active for the sends after the ActiveAt.
+type WhitelistAddress struct {
+	Address  string `json:"address"`
+	Label    string `json:"label"`
+	AddedAt  int64  `json:"added_at"`
+	ActiveAt int64  `json:"active_at"`
+	Active   bool   `json:"active"`
+}
+
+// WhitelistAddRequest --
+type WhitelistAddRequest struct {
+	Address string `json:"address"`
+	Label   string `json:"label"`
+}
+
+// WhitelistAddResponse -- the address added, pending until the whitelist delay passed.
+type WhitelistAddResponse struct {
+	WhitelistAddress
+}
+
+// WhitelistRemoveRequest --
+type WhitelistRemoveRequest struct {
+	Address string `json:"address"`
+}
+
+// WhitelistRemoveResponse --
+type WhitelistRemoveResponse struct {
+}
+
+// WhitelistModeRequest --
+// The disable takes effect after the whitelist delay, the enable is immediate.
+type WhitelistModeRequest struct {
+	Enable bool `json:"enable"`
+}
+
+// WhitelistListRequest --
+type WhitelistListRequest struct {
+}
+
+// WhitelistResponse -- the whitelist mode and the addresses, the DisableAt is the pending disable time, 0 if none.
+type WhitelistResponse struct {
+	Enabled   bool               `json:"enabled"`
+	DisableAt int64              `json:"disable_at"`
+	Addresses []WhitelistAddress `json:"addresses"`
+}
@@ -0,0 +1,657 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/paillier"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+// The zero-knowledge proofs of the Lindell 2017 two party ecdsa(https://eprint.iacr.org/2017/552),
+// all non-interactive by the Fiat-Shamir:
+//  1. PaillierKeyProof, the paillier modulus N is well-formed, gcd(N, φ(N)) = 1 and no small factors.
+//  2. EncRangeProof, the paillier ciphertext encrypts the discrete log of the client child public key and it's in range,
+//     it's the range proof and the PDL of the Lindell in one, by the ring-pedersen parameters of the server(as the Π^log* of the CGGMP 2021).
+//  3. DlogProof, the schnorr proof of knowledge of the discrete log of the R1.
+//
+// The RingPedersen parameters are proved by the RingPedersenProof(s is in the group generated by t), so the range proof hides the client share.
+const (
+	PaillierBits = 2048
+
+	zkPaillierRounds     = 11
+	zkPaillierSmallPrime = 6370
+	zkRingPedersenBits   = 2048
+	zkRingPedersenRounds = 80
+	zkRangeBits          = 256
+	zkRangeSlack         = 512
+)
+
+var (
+	zkOne          = big.NewInt(1)
+	zkSmallPrimes  = smallPrimesProduct(zkPaillierSmallPrime)
+	zkRangeAlpha   = new(big.Int).Lsh(zkOne, zkRangeBits+zkRangeSlack)
+	zkRangeMaxBits = zkRangeBits + zkRangeSlack + 1
+)
+
+// smallPrimesProduct -- the product of the primes less than the n.
+func smallPrimesProduct(n int) *big.Int {
+	product := big.NewInt(1)
+	composite := make([]bool, n)
+	for i := 2; i < n; i++ {
+		if composite[i] {
+			continue
+		}
+		product.Mul(product, big.NewInt(int64(i)))
+		for j := i * i; j < n; j += i {
+			composite[j] = true
+		}
+	}
+	return product
+}
+
+// transcript -- the Fiat-Shamir transcript, every item is length prefixed.
+type transcript struct {
+	h hash.Hash
+}
+
+func newTranscript(tag string) *transcript {
+	t := &transcript{h: sha256.New()}
+	t.bytes([]byte(tag))
+	return t
+}
+
+func (t *transcript) bytes(b []byte) *transcript {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+	t.h.Write(size[:])
+	t.h.Write(b)
+	return t
+}
+
+func (t *transcript) ints(xs ...*big.Int) *transcript {
+	for _, x := range xs {
+		t.bytes(x.Bytes())
+	}
+	return t
+}
+
+func (t *transcript) point(x, y *big.Int) *transcript {
+	return t.ints(x, y)
+}
+
+func (t *transcript) context(hash []byte, pos uint32) *transcript {
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], pos)
+	return t.bytes(hash).bytes(p[:])
+}
+
+// stream -- the n bytes expanded from the transcript digest.
+func (t *transcript) stream(n int) []byte {
+	seed := t.h.Sum(nil)
+	out := make([]byte, 0, n+sha256.Size)
+	for i := uint32(0); len(out) < n; i++ {
+		var ctr [4]byte
+		binary.BigEndian.PutUint32(ctr[:], i)
+		h := sha256.New()
+		h.Write(seed)
+		h.Write(ctr[:])
+		out = h.Sum(out)
+	}
+	return out[:n]
+}
+
+// challenge -- the challenge in [0, n), the extra 16 bytes make the bias negligible.
+func (t *transcript) challenge(n *big.Int) *big.Int {
+	e := new(big.Int).SetBytes(t.stream((n.BitLen()+7)/8 + 16))
+	return e.Mod(e, n)
+}
+
+// bits -- the n binary challenges.
+func (t *transcript) bits(n int) []uint {
+	stream := t.stream((n + 7) / 8)
+	bits := make([]uint, n)
+	for i := range bits {
+		bits[i] = uint(stream[i/8]>>(uint(i)%8)) & 1
+	}
+	return bits
+}
+
+// randInt -- the random in [0, n).
+func randInt(n *big.Int) (*big.Int, error) {
+	return rand.Int(rand.Reader, n)
+}
+
+// randUnit -- the random in Z*n.
+func randUnit(n *big.Int) (*big.Int, error) {
+	for {
+		r, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return nil, err
+		}
+		if r.Sign() > 0 && isUnit(r, n) {
+			return r, nil
+		}
+	}
+}
+
+// isUnit -- x in Z*n.
+func isUnit(x *big.Int, n *big.Int) bool {
+	if x == nil || x.Sign() <= 0 || x.Cmp(n) >= 0 {
+		return false
+	}
+	return new(big.Int).GCD(nil, nil, x, n).Cmp(zkOne) == 0
+}
+
+// onCurve -- the point is on the secp256k1 and not the infinity.
+func onCurve(x, y *big.Int) bool {
+	if x == nil || y == nil {
+		return false
+	}
+	return secp256k1.SECP256K1().IsOnCurve(x, y)
+}
+
+// PaillierKey -- the paillier key pair of the client party, the factors are kept to prove the key.
+type PaillierKey struct {
+	PubKey *paillier.PubKey
+	p      *big.Int
+	q      *big.Int
+	lambda *big.Int
+	mu     *big.Int
+	proof  *PaillierKeyProof
+}
+
+// GeneratePaillierKey -- generates the paillier key pair, the N is PaillierBits.
+func GeneratePaillierKey() (*PaillierKey, error) {
+	for {
+		p, err := rand.Prime(rand.Reader, PaillierBits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := rand.Prime(rand.Reader, PaillierBits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		n := new(big.Int).Mul(p, q)
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, zkOne), new(big.Int).Sub(q, zkOne))
+		if !isUnit(new(big.Int).Mod(n, phi), phi) {
+			continue
+		}
+		return &PaillierKey{
+			PubKey: &paillier.PubKey{
+				G:  new(big.Int).Add(n, zkOne),
+				N:  n,
+				NN: new(big.Int).Mul(n, n),
+			},
+			p:      p,
+			q:      q,
+			lambda: phi,
+			mu:     new(big.Int).ModInverse(phi, n),
+		}, nil
+	}
+}
+
+// Encrypt -- encrypts the m in [0, N), returns the ciphertext and the randomness.
+func (k *PaillierKey) Encrypt(m *big.Int) (*big.Int, *big.Int, error) {
+	pub := k.PubKey
+	if m.Sign() < 0 || m.Cmp(pub.N) >= 0 {
+		return nil, nil, fmt.Errorf("paillier.plaintext.out.of.range")
+	}
+	r, err := randUnit(pub.N)
+	if err != nil {
+		return nil, nil, err
+	}
+	return paillierEncrypt(pub, m, r), r, nil
+}
+
+// Decrypt -- decrypts the ciphertext.
+func (k *PaillierKey) Decrypt(c *big.Int) (*big.Int, error) {
+	pub := k.PubKey
+	if !isUnit(c, pub.NN) {
+		return nil, fmt.Errorf("paillier.ciphertext.invalid")
+	}
+	// m = L(c^lambda mod N^2) * mu mod N, L(x) = (x-1)/N
+	m := new(big.Int).Exp(c, k.lambda, pub.NN)
+	m.Sub(m, zkOne)
+	m.Div(m, pub.N)
+	m.Mul(m, k.mu)
+	return m.Mod(m, pub.N), nil
+}
+
+// Proof -- the PaillierKeyProof of the key, it's computed once.
+func (k *PaillierKey) Proof() (*PaillierKeyProof, error) {
+	if k.proof == nil {
+		proof, err := provePaillierKey(k)
+		if err != nil {
+			return nil, err
+		}
+		k.proof = proof
+	}
+	return k.proof, nil
+}
+
+// Close -- cleanups the factors.
+func (k *PaillierKey) Close() {
+	for _, x := range []*big.Int{k.p, k.q, k.lambda, k.mu} {
+		if x != nil {
+			x.SetInt64(0)
+		}
+	}
+}
+
+// paillierEncrypt -- c = (1+N)^m * r^N mod N^2, (1+N)^m = 1+m*N mod N^2.
+func paillierEncrypt(pub *paillier.PubKey, m *big.Int, r *big.Int) *big.Int {
+	gm := new(big.Int).Mul(m, pub.N)
+	gm.Add(gm, zkOne)
+	gm.Mod(gm, pub.NN)
+	c := new(big.Int).Exp(r, pub.N, pub.NN)
+	c.Mul(c, gm)
+	return c.Mod(c, pub.NN)
+}
+
+// checkPaillierPubKey -- the public key is the N of the PaillierBits, G = N+1 and NN = N^2.
+func checkPaillierPubKey(pub *paillier.PubKey) error {
+	if pub == nil || pub.N == nil || pub.G == nil || pub.NN == nil {
+		return fmt.Errorf("zkproof.paillier.pubkey.required")
+	}
+	if pub.N.BitLen() < PaillierBits || pub.N.Bit(0) == 0 {
+		return fmt.Errorf("zkproof.paillier.N.bits[%v].less.than[%v]", pub.N.BitLen(), PaillierBits)
+	}
+	if pub.G.Cmp(new(big.Int).Add(pub.N, zkOne)) != 0 {
+		return fmt.Errorf("zkproof.paillier.G.not.N+1")
+	}
+	if pub.NN.Cmp(new(big.Int).Mul(pub.N, pub.N)) != 0 {
+		return fmt.Errorf("zkproof.paillier.NN.not.N^2")
+	}
+	return nil
+}
+
+// PaillierKeyProof -- the N-th roots of the values derived from the N(Goldberg et al., the key correctness of the Lindell).
+// Only the N with gcd(N, φ(N)) = 1 has the N-th roots of all the values, the small factors are checked by the gcd.
+type PaillierKeyProof struct {
+	Sigma []*big.Int `json:"sigma"`
+}
+
+// paillierKeyRho -- the i-th value to root, derived from the N.
+func paillierKeyRho(n *big.Int, i int) *big.Int {
+	rho := newTranscript("thresh-wallet.zkproof.paillier.key").ints(n, big.NewInt(int64(i))).challenge(n)
+	return rho
+}
+
+func provePaillierKey(k *PaillierKey) (*PaillierKeyProof, error) {
+	n := k.PubKey.N
+	// The N-th root by the CRT, d = N^-1 mod (p-1).
+	dp := new(big.Int).ModInverse(new(big.Int).Mod(n, new(big.Int).Sub(k.p, zkOne)), new(big.Int).Sub(k.p, zkOne))
+	dq := new(big.Int).ModInverse(new(big.Int).Mod(n, new(big.Int).Sub(k.q, zkOne)), new(big.Int).Sub(k.q, zkOne))
+	qinv := new(big.Int).ModInverse(k.q, k.p)
+	if dp == nil || dq == nil || qinv == nil {
+		return nil, fmt.Errorf("zkproof.paillier.key.not.invertible")
+	}
+
+	proof := &PaillierKeyProof{}
+	for i := 0; i < zkPaillierRounds; i++ {
+		rho := paillierKeyRho(n, i)
+		if !isUnit(rho, n) {
+			return nil, fmt.Errorf("zkproof.paillier.key.rho[%v].not.unit", i)
+		}
+		sp := new(big.Int).Exp(rho, dp, k.p)
+		sq := new(big.Int).Exp(rho, dq, k.q)
+		// sigma = sq + q*((sp-sq)*qinv mod p)
+		h := new(big.Int).Sub(sp, sq)
+		h.Mul(h, qinv)
+		h.Mod(h, k.p)
+		sigma := h.Mul(h, k.q)
+		sigma.Add(sigma, sq)
+		proof.Sigma = append(proof.Sigma, sigma)
+	}
+	return proof, nil
+}
+
+// Verify -- verifies the paillier public key.
+func (proof *PaillierKeyProof) Verify(pub *paillier.PubKey) error {
+	if err := checkPaillierPubKey(pub); err != nil {
+		return err
+	}
+	n := pub.N
+	if new(big.Int).GCD(nil, nil, n, zkSmallPrimes).Cmp(zkOne) != 0 {
+		return fmt.Errorf("zkproof.paillier.N.has.small.factor")
+	}
+	if proof == nil || len(proof.Sigma) != zkPaillierRounds {
+		return fmt.Errorf("zkproof.paillier.key.proof.rounds.invalid")
+	}
+	for i, sigma := range proof.Sigma {
+		if !isUnit(sigma, n) {
+			return fmt.Errorf("zkproof.paillier.key.sigma[%v].invalid", i)
+		}
+		if new(big.Int).Exp(sigma, n, n).Cmp(paillierKeyRho(n, i)) != 0 {
+			return fmt.Errorf("zkproof.paillier.key.sigma[%v].verify.failed", i)
+		}
+	}
+	return nil
+}
+
+// RingPedersen -- the ring-pedersen parameters of the server, s = t^λ mod N, the factors of the N and the λ are dropped.
+// The proof is the Π^prm of the CGGMP 2021, the client verifies it before using the parameters.
+type RingPedersen struct {
+	N     *big.Int           `json:"N"`
+	S     *big.Int           `json:"s"`
+	T     *big.Int           `json:"t"`
+	Proof *RingPedersenProof `json:"proof"`
+}
+
+// RingPedersenProof -- the binary challenge proof of knowledge of the λ.
+type RingPedersenProof struct {
+	A []*big.Int `json:"A"`
+	Z []*big.Int `json:"Z"`
+}
+
+// GenerateRingPedersen -- generates the parameters and the proof.
+func GenerateRingPedersen() (*RingPedersen, error) {
+	p, err := rand.Prime(rand.Reader, zkRingPedersenBits/2)
+	if err != nil {
+		return nil, err
+	}
+	q, err := rand.Prime(rand.Reader, zkRingPedersenBits/2)
+	if err != nil {
+		return nil, err
+	}
+	if p.Cmp(q) == 0 {
+		return nil, fmt.Errorf("zkproof.ring.pedersen.primes.equal")
+	}
+	n := new(big.Int).Mul(p, q)
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, zkOne), new(big.Int).Sub(q, zkOne))
+	defer phi.SetInt64(0)
+
+	tau, err := randUnit(n)
+	if err != nil {
+		return nil, err
+	}
+	t := new(big.Int).Exp(tau, big.NewInt(2), n)
+	lambda, err := randInt(phi)
+	if err != nil {
+		return nil, err
+	}
+	defer lambda.SetInt64(0)
+	s := new(big.Int).Exp(t, lambda, n)
+	rp := &RingPedersen{N: n, S: s, T: t, Proof: &RingPedersenProof{}}
+
+	as := make([]*big.Int, zkRingPedersenRounds)
+	for i := range as {
+		if as[i], err = randInt(phi); err != nil {
+			return nil, err
+		}
+		rp.Proof.A = append(rp.Proof.A, new(big.Int).Exp(t, as[i], n))
+	}
+	for i, e := range rp.challenge() {
+		z := new(big.Int).Set(as[i])
+		if e == 1 {
+			z.Add(z, lambda)
+			z.Mod(z, phi)
+		}
+		as[i].SetInt64(0)
+		rp.Proof.Z = append(rp.Proof.Z, z)
+	}
+	return rp, nil
+}
+
+func (rp *RingPedersen) challenge() []uint {
+	tr := newTranscript("thresh-wallet.zkproof.ring.pedersen").ints(rp.N, rp.S, rp.T)
+	tr.ints(rp.Proof.A...)
+	return tr.bits(zkRingPedersenRounds)
+}
+
+// ID -- the hex sha256 of the parameters.
+func (rp *RingPedersen) ID() string {
+	h := newTranscript("thresh-wallet.zkproof.ring.pedersen.id").ints(rp.N, rp.S, rp.T).h.Sum(nil)
+	return hex.EncodeToString(h)
+}
+
+// check -- the parameters are in range, without the proof.
+func (rp *RingPedersen) check() error {
+	if rp == nil || rp.N == nil || rp.S == nil || rp.T == nil {
+		return fmt.Errorf("zkproof.ring.pedersen.required")
+	}
+	if rp.N.BitLen() < zkRingPedersenBits || rp.N.Bit(0) == 0 {
+		return fmt.Errorf("zkproof.ring.pedersen.N.bits[%v].less.than[%v]", rp.N.BitLen(), zkRingPedersenBits)
+	}
+	if !isUnit(rp.S, rp.N) || !isUnit(rp.T, rp.N) || rp.T.Cmp(zkOne) == 0 {
+		return fmt.Errorf("zkproof.ring.pedersen.s.t.invalid")
+	}
+	return nil
+}
+
+// Verify -- verifies the parameters and the proof.
+func (rp *RingPedersen) Verify() error {
+	if err := rp.check(); err != nil {
+		return err
+	}
+	proof := rp.Proof
+	if proof == nil || len(proof.A) != zkRingPedersenRounds || len(proof.Z) != zkRingPedersenRounds {
+		return fmt.Errorf("zkproof.ring.pedersen.proof.rounds.invalid")
+	}
+	for i := range proof.A {
+		if !isUnit(proof.A[i], rp.N) || proof.Z[i] == nil || proof.Z[i].Sign() < 0 {
+			return fmt.Errorf("zkproof.ring.pedersen.proof[%v].invalid", i)
+		}
+	}
+	for i, e := range rp.challenge() {
+		a, z := proof.A[i], proof.Z[i]
+		// t^z = A * s^e
+		lhs := new(big.Int).Exp(rp.T, z, rp.N)
+		rhs := new(big.Int).Set(a)
+		if e == 1 {
+			rhs.Mul(rhs, rp.S)
+			rhs.Mod(rhs, rp.N)
+		}
+		if lhs.Cmp(rhs) != 0 {
+			return fmt.Errorf("zkproof.ring.pedersen.proof[%v].verify.failed", i)
+		}
+	}
+	return nil
+}
+
+// commit -- s^x * t^y mod N.
+func (rp *RingPedersen) commit(x *big.Int, y *big.Int) *big.Int {
+	c := new(big.Int).Exp(rp.S, x, rp.N)
+	c.Mul(c, new(big.Int).Exp(rp.T, y, rp.N))
+	return c.Mod(c, rp.N)
+}
+
+// EncRangeProof -- the proof of the C = Enc(x; ρ) with the X = x*G and the x in the range, the slack is the 2^zkRangeSlack.
+type EncRangeProof struct {
+	S  *big.Int          `json:"S"`
+	A  *big.Int          `json:"A"`
+	Y  *secp256k1.Scalar `json:"Y"`
+	D  *big.Int          `json:"D"`
+	Z1 *big.Int          `json:"z1"`
+	Z2 *big.Int          `json:"z2"`
+	Z3 *big.Int          `json:"z3"`
+}
+
+func encRangeChallenge(rp *RingPedersen, pub *paillier.PubKey, c *big.Int, X *xcrypto.PubKey, hash []byte, pos uint32, proof *EncRangeProof) *big.Int {
+	tr := newTranscript("thresh-wallet.zkproof.enc.range").context(hash, pos)
+	tr.ints(rp.N, rp.S, rp.T, pub.N, c).point(X.X, X.Y)
+	tr.ints(proof.S, proof.A).point(proof.Y.X, proof.Y.Y).ints(proof.D)
+	return tr.challenge(secp256k1.SECP256K1().Params().N)
+}
+
+// proveEncRange -- the x, rho is the plaintext and the randomness of the c.
+func proveEncRange(rp *RingPedersen, key *PaillierKey, c *big.Int, x *big.Int, rho *big.Int, X *xcrypto.PubKey, hash []byte, pos uint32) (*EncRangeProof, error) {
+	pub := key.PubKey
+	curve := secp256k1.SECP256K1()
+
+	alpha, err := randInt(zkRangeAlpha)
+	if err != nil {
+		return nil, err
+	}
+	mu, err := randInt(new(big.Int).Lsh(rp.N, zkRangeBits))
+	if err != nil {
+		return nil, err
+	}
+	r, err := randUnit(pub.N)
+	if err != nil {
+		return nil, err
+	}
+	gamma, err := randInt(new(big.Int).Lsh(rp.N, zkRangeBits+zkRangeSlack))
+	if err != nil {
+		return nil, err
+	}
+
+	yx, yy := curve.ScalarBaseMult(alpha.Bytes())
+	proof := &EncRangeProof{
+		S: rp.commit(x, mu),
+		A: paillierEncrypt(pub, alpha, r),
+		Y: secp256k1.NewScalar(yx, yy),
+		D: rp.commit(alpha, gamma),
+	}
+	e := encRangeChallenge(rp, pub, c, X, hash, pos, proof)
+
+	// z1 = α + e*x, z2 = r * ρ^e mod N, z3 = γ + e*μ
+	proof.Z1 = new(big.Int).Add(alpha, new(big.Int).Mul(e, x))
+	proof.Z2 = new(big.Int).Exp(rho, e, pub.N)
+	proof.Z2.Mul(proof.Z2, r)
+	proof.Z2.Mod(proof.Z2, pub.N)
+	proof.Z3 = new(big.Int).Add(gamma, new(big.Int).Mul(e, mu))
+	return proof, nil
+}
+
+// Verify -- verifies the c encrypts the discrete log of the X under the paillier public key.
+func (proof *EncRangeProof) Verify(rp *RingPedersen, pub *paillier.PubKey, c *big.Int, X *xcrypto.PubKey, hash []byte, pos uint32) error {
+	curve := secp256k1.SECP256K1()
+
+	if err := rp.check(); err != nil {
+		return err
+	}
+	if err := checkPaillierPubKey(pub); err != nil {
+		return err
+	}
+	if proof == nil || proof.Y == nil || proof.Z1 == nil || proof.Z3 == nil {
+		return fmt.Errorf("zkproof.enc.range.proof.required")
+	}
+	if X == nil || !onCurve(X.X, X.Y) || !onCurve(proof.Y.X, proof.Y.Y) {
+		return fmt.Errorf("zkproof.enc.range.point.not.on.curve")
+	}
+	if !isUnit(c, pub.NN) || !isUnit(proof.A, pub.NN) || !isUnit(proof.Z2, pub.N) {
+		return fmt.Errorf("zkproof.enc.range.paillier.invalid")
+	}
+	if !isUnit(proof.S, rp.N) || !isUnit(proof.D, rp.N) {
+		return fmt.Errorf("zkproof.enc.range.commitment.invalid")
+	}
+	if proof.Z1.Sign() < 0 || proof.Z1.BitLen() > zkRangeMaxBits || proof.Z3.Sign() < 0 {
+		return fmt.Errorf("zkproof.enc.range.z1.out.of.range")
+	}
+	e := encRangeChallenge(rp, pub, c, X, hash, pos, proof)
+
+	// (1+N)^z1 * z2^N = A * C^e mod N^2
+	lhs := paillierEncrypt(pub, proof.Z1, proof.Z2)
+	rhs := new(big.Int).Exp(c, e, pub.NN)
+	rhs.Mul(rhs, proof.A)
+	rhs.Mod(rhs, pub.NN)
+	if lhs.Cmp(rhs) != 0 {
+		return fmt.Errorf("zkproof.enc.range.paillier.verify.failed")
+	}
+
+	// z1*G = Y + e*X
+	lx, ly := curve.ScalarBaseMult(new(big.Int).Mod(proof.Z1, curve.Params().N).Bytes())
+	ex, ey := curve.ScalarMult(X.X, X.Y, e.Bytes())
+	rx, ry := curve.Add(proof.Y.X, proof.Y.Y, ex, ey)
+	if lx.Cmp(rx) != 0 || ly.Cmp(ry) != 0 {
+		return fmt.Errorf("zkproof.enc.range.dlog.verify.failed")
+	}
+
+	// s^z1 * t^z3 = D * S^e mod Ñ
+	lhs = rp.commit(proof.Z1, proof.Z3)
+	rhs = new(big.Int).Exp(proof.S, e, rp.N)
+	rhs.Mul(rhs, proof.D)
+	rhs.Mod(rhs, rp.N)
+	if lhs.Cmp(rhs) != 0 {
+		return fmt.Errorf("zkproof.enc.range.commitment.verify.failed")
+	}
+	return nil
+}
+
+// DlogProof -- the schnorr proof of knowledge of the k of the R = k*G.
+type DlogProof struct {
+	A *secp256k1.Scalar `json:"A"`
+	Z *big.Int          `json:"z"`
+}
+
+func dlogChallenge(R *secp256k1.Scalar, A *secp256k1.Scalar, hash []byte, pos uint32) *big.Int {
+	tr := newTranscript("thresh-wallet.zkproof.dlog").context(hash, pos)
+	tr.point(R.X, R.Y).point(A.X, A.Y)
+	return tr.challenge(secp256k1.SECP256K1().Params().N)
+}
+
+// ProveDlog -- proves the k of the R, bound to the hash and the pos.
+func ProveDlog(k *big.Int, R *secp256k1.Scalar, hash []byte, pos uint32) (*DlogProof, error) {
+	curve := secp256k1.SECP256K1()
+	N := curve.Params().N
+
+	a, err := randUnit(N)
+	if err != nil {
+		return nil, err
+	}
+	defer a.SetInt64(0)
+	ax, ay := curve.ScalarBaseMult(a.Bytes())
+	proof := &DlogProof{A: secp256k1.NewScalar(ax, ay)}
+	e := dlogChallenge(R, proof.A, hash, pos)
+	proof.Z = e.Mul(e, k)
+	proof.Z.Add(proof.Z, a)
+	proof.Z.Mod(proof.Z, N)
+	return proof, nil
+}
+
+// Verify -- verifies the z*G = A + e*R.
+func (proof *DlogProof) Verify(R *secp256k1.Scalar, hash []byte, pos uint32) error {
+	curve := secp256k1.SECP256K1()
+
+	if R == nil || !onCurve(R.X, R.Y) {
+		return fmt.Errorf("zkproof.dlog.R.not.on.curve")
+	}
+	if proof == nil || proof.A == nil || !onCurve(proof.A.X, proof.A.Y) || proof.Z == nil {
+		return fmt.Errorf("zkproof.dlog.proof.invalid")
+	}
+	if proof.Z.Sign() <= 0 || proof.Z.Cmp(curve.Params().N) >= 0 {
+		return fmt.Errorf("zkproof.dlog.z.out.of.range")
+	}
+	e := dlogChallenge(R, proof.A, hash, pos)
+	lx, ly := curve.ScalarBaseMult(proof.Z.Bytes())
+	ex, ey := curve.ScalarMult(R.X, R.Y, e.Bytes())
+	rx, ry := curve.Add(proof.A.X, proof.A.Y, ex, ey)
+	if lx.Cmp(rx) != 0 || ly.Cmp(ry) != 0 {
+		return fmt.Errorf("zkproof.dlog.verify.failed")
+	}
+	return nil
+}
+
+// EcdsaProof -- the proofs of the S2 request.
+type EcdsaProof struct {
+	Key   *PaillierKeyProof `json:"key"`
+	Range *EncRangeProof    `json:"range"`
+}
+
+// Verify -- verifies the encpk is the encryption of the client share of the cliPubKey, and the paillier key is well-formed.
+func (proof *EcdsaProof) Verify(rp *RingPedersen, pub *paillier.PubKey, encpk *big.Int, cliPubKey *xcrypto.PubKey, hash []byte, pos uint32) error {
+	if proof == nil {
+		return fmt.Errorf("zkproof.ecdsa.proof.required")
+	}
+	if err := proof.Key.Verify(pub); err != nil {
+		return err
+	}
+	return proof.Range.Verify(rp, pub, encpk, cliPubKey, hash, pos)
+}
@@ -0,0 +1,122 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package proto
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/paillier"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZKProofs(t *testing.T) {
+	curve := secp256k1.SECP256K1()
+	hash := sha256.Sum256([]byte("thresh-wallet"))
+	pos := uint32(3)
+
+	rp, err := GenerateRingPedersen()
+	assert.Nil(t, err)
+	assert.Nil(t, rp.Verify())
+	key, err := GeneratePaillierKey()
+	assert.Nil(t, err)
+
+	prv := xcrypto.PrvKeyFromBytes([]byte("client.private.key.of.the.party"))
+	party := NewEcdsaParty(prv, key)
+	R1, dlog, err := party.Phase2(hash[:], pos)
+	assert.Nil(t, err)
+	encpk, encpub, proof, err := party.Phase4(rp)
+	assert.Nil(t, err)
+
+	// The nonce is random for every signing of the same hash.
+	{
+		other := NewEcdsaParty(prv, key)
+		otherR1, _, err := other.Phase2(hash[:], pos)
+		assert.Nil(t, err)
+		assert.NotEqual(t, 0, party.k.Cmp(other.k))
+		assert.NotEqual(t, 0, R1.X.Cmp(otherR1.X))
+	}
+
+	// Paillier.
+	{
+		m, err := key.Decrypt(encpk)
+		assert.Nil(t, err)
+		assert.Equal(t, prv.D, m)
+	}
+
+	// Ok.
+	{
+		assert.Nil(t, dlog.Verify(R1, hash[:], pos))
+		assert.Nil(t, proof.Verify(rp, encpub, encpk, prv.PubKey(), hash[:], pos))
+	}
+
+	// Dlog of the other context or R.
+	{
+		assert.NotNil(t, dlog.Verify(R1, hash[:], pos+1))
+		gx, gy := curve.ScalarBaseMult([]byte{7})
+		assert.NotNil(t, dlog.Verify(secp256k1.NewScalar(gx, gy), hash[:], pos))
+	}
+
+	// The ring-pedersen proof tampered.
+	{
+		bad := *rp
+		bad.S = new(big.Int).Add(rp.S, big.NewInt(1))
+		assert.NotNil(t, bad.Verify())
+	}
+
+	// The ciphertext of the other value.
+	{
+		other, _, err := key.Encrypt(big.NewInt(1))
+		assert.Nil(t, err)
+		assert.NotNil(t, proof.Verify(rp, encpub, other, prv.PubKey(), hash[:], pos))
+	}
+
+	// The other public key.
+	{
+		other := xcrypto.PrvKeyFromBytes([]byte("the.other.private.key.of.party"))
+		assert.NotNil(t, proof.Verify(rp, encpub, encpk, other.PubKey(), hash[:], pos))
+	}
+
+	// The other context.
+	{
+		assert.NotNil(t, proof.Verify(rp, encpub, encpk, prv.PubKey(), hash[:], pos+1))
+	}
+
+	// The z1 out of range.
+	{
+		bad := *proof.Range
+		bad.Z1 = new(big.Int).Lsh(big.NewInt(1), uint(zkRangeMaxBits+1))
+		assert.NotNil(t, bad.Verify(rp, encpub, encpk, prv.PubKey(), hash[:], pos))
+	}
+
+	// The paillier key of the small factor.
+	{
+		n := new(big.Int).Mul(big.NewInt(3), key.PubKey.N)
+		bad := &paillier.PubKey{N: n, G: new(big.Int).Add(n, big.NewInt(1)), NN: new(big.Int).Mul(n, n)}
+		assert.NotNil(t, proof.Key.Verify(bad))
+	}
+
+	// The paillier key of the wrong G.
+	{
+		bad := *encpub
+		bad.G = new(big.Int).Add(encpub.G, big.NewInt(1))
+		assert.NotNil(t, proof.Key.Verify(&bad))
+	}
+
+	// The paillier key proof of the other key.
+	{
+		other, err := GeneratePaillierKey()
+		assert.Nil(t, err)
+		otherProof, err := other.Proof()
+		assert.Nil(t, err)
+		assert.NotNil(t, otherProof.Verify(encpub))
+		assert.NotNil(t, (&EcdsaProof{Key: otherProof, Range: proof.Range}).Verify(rp, encpub, encpk, prv.PubKey(), hash[:], pos))
+	}
+}
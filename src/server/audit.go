@@ -0,0 +1,363 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"xlog"
+)
+
+const (
+	auditWalletCreate     = "wallet.create"
+	auditWalletNewAddress = "wallet.newaddress"
+	auditWalletNewChange  = "wallet.newchange"
+	auditWalletPushTx     = "wallet.pushtx"
+	auditWalletRefresh    = "wallet.refresh"
+	auditWalletFreeze     = "wallet.freeze"
+	auditWalletUnfreeze   = "wallet.unfreeze"
+	auditWalletPolicy     = "wallet.policy"
+	auditEcdsaR2          = "ecdsa.r2"
+	auditEcdsaS2          = "ecdsa.s2"
+	auditBackupStore      = "backup.store"
+	auditBackupRestore    = "backup.restore"
+	auditBackupVerify     = "backup.verify"
+
+	auditResultOK     = "ok"
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+var (
+	auditGenesis = strings.Repeat("0", sha256.Size*2)
+)
+
+// AuditEntry -- the record of the audit log, one json per line.
+// The hash is the sha256 of the entry json with the empty hash, the prev is the hash of the previous entry,
+// so the edits and the removals break the chain.
+type AuditEntry struct {
+	Seq       uint64 `json:"seq"`
+	Time      int64  `json:"time"`
+	Event     string `json:"event"`
+	UID       string `json:"uid"`
+	Pos       uint32 `json:"pos"`
+	SigHash   string `json:"sighash,omitempty"`
+	DeviceID  string `json:"deviceid,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Forwarded string `json:"forwarded,omitempty"`
+	Result    string `json:"result"`
+	Detail    string `json:"detail,omitempty"`
+	Prev      string `json:"prev"`
+	Hash      string `json:"hash"`
+}
+
+// digest -- the hash of the entry.
+func (e *AuditEntry) digest() (string, error) {
+	entry := *e
+	entry.Hash = ""
+	datas, err := json.Marshal(&entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(datas)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditHead -- the last entry of the audit log.
+// It's kept in the <file>.head to detect the truncation, and should be recorded out of the server as the anchor.
+type AuditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// AuditQuery -- the filter of the audit log, the zero fields match all.
+type AuditQuery struct {
+	UID    string
+	Event  string
+	Since  int64
+	Until  int64
+	Offset int
+	Limit  int
+}
+
+func (q *AuditQuery) match(e *AuditEntry) bool {
+	if q.UID != "" && q.UID != e.UID {
+		return false
+	}
+	if q.Event != "" && q.Event != e.Event {
+		return false
+	}
+	if q.Since > 0 && e.Time < q.Since {
+		return false
+	}
+	if q.Until > 0 && e.Time > q.Until {
+		return false
+	}
+	return true
+}
+
+// scanAuditLog -- reads the audit log and checks the chain, the fn is called on every entry.
+// The missing file is the empty log.
+func scanAuditLog(path string, fn func(e *AuditEntry)) (*AuditHead, error) {
+	head := &AuditHead{Hash: auditGenesis}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return head, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		e := &AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("audit.log.line[%v].unmarshal.error:%v", line, err)
+		}
+		if e.Seq != head.Seq+1 {
+			return nil, fmt.Errorf("audit.log.line[%v].seq[%v].want[%v]", line, e.Seq, head.Seq+1)
+		}
+		if e.Prev != head.Hash {
+			return nil, fmt.Errorf("audit.log.seq[%v].prev.mismatch", e.Seq)
+		}
+		hash, err := e.digest()
+		if err != nil {
+			return nil, err
+		}
+		if e.Hash != hash {
+			return nil, fmt.Errorf("audit.log.seq[%v].hash.mismatch", e.Seq)
+		}
+		head.Seq, head.Hash = e.Seq, e.Hash
+		if fn != nil {
+			fn(e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+// readAuditHead -- reads the <file>.head, nil if not exists.
+func readAuditHead(path string) (*AuditHead, error) {
+	datas, err := ioutil.ReadFile(path + ".head")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	head := &AuditHead{}
+	if err := json.Unmarshal(datas, head); err != nil {
+		return nil, fmt.Errorf("audit.log.head.unmarshal.error:%v", err)
+	}
+	return head, nil
+}
+
+// VerifyAuditLog -- verifies the chain of the audit log and the <file>.head, returns the last entry.
+// The anchor is the head recorded before, the entry at the anchor seq must have the same hash.
+func VerifyAuditLog(path string, anchor *AuditHead) (*AuditHead, error) {
+	saved, err := readAuditHead(path)
+	if err != nil {
+		return nil, err
+	}
+
+	anchors := []*AuditHead{}
+	if saved != nil {
+		anchors = append(anchors, saved)
+	}
+	if anchor != nil {
+		anchors = append(anchors, anchor)
+	}
+	matched := make([]bool, len(anchors))
+	head, err := scanAuditLog(path, func(e *AuditEntry) {
+		for i, a := range anchors {
+			if a.Seq == e.Seq && a.Hash == e.Hash {
+				matched[i] = true
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, a := range anchors {
+		if matched[i] || (a.Seq == 0 && a.Hash == auditGenesis) {
+			continue
+		}
+		if a.Seq > head.Seq {
+			return nil, fmt.Errorf("audit.log.truncated.at.seq[%v].want[%v]", head.Seq, a.Seq)
+		}
+		return nil, fmt.Errorf("audit.log.seq[%v].anchor.mismatch", a.Seq)
+	}
+	return head, nil
+}
+
+// AuditFile -- the audit log file of the config.
+func AuditFile(conf *Config) string {
+	if conf.Audit != nil && conf.Audit.File != "" {
+		return conf.Audit.File
+	}
+	return filepath.Join(conf.DataDir, "audit.log")
+}
+
+// AuditLog -- the append-only hash-chained audit log of the key events.
+type AuditLog struct {
+	mu   sync.Mutex
+	log  *xlog.Log
+	path string
+	file *os.File
+	head *AuditHead
+}
+
+// NewAuditLog -- creates new AuditLog.
+func NewAuditLog(log *xlog.Log, path string) *AuditLog {
+	return &AuditLog{
+		log:  log,
+		path: path,
+	}
+}
+
+// Open -- verifies the log and opens it to append, errors if the chain is broken.
+func (a *AuditLog) Open() error {
+	log := a.log
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	head, err := VerifyAuditLog(a.path, nil)
+	if err != nil {
+		log.Error("audit.log[%v].verify.error:%+v", a.path, err)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	a.file = file
+	a.head = head
+	log.Info("audit.log[%v].open.head[%v:%v]", a.path, head.Seq, head.Hash)
+	return a.writeHead()
+}
+
+// writeHead -- replaces the <file>.head.
+func (a *AuditLog) writeHead() error {
+	datas, err := json.Marshal(a.head)
+	if err != nil {
+		return err
+	}
+	tmp := a.path + ".head.tmp"
+	if err := ioutil.WriteFile(tmp, datas, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path+".head")
+}
+
+// Append -- chains the entry to the log and syncs it to the disk.
+func (a *AuditLog) Append(e *AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return fmt.Errorf("audit.log.not.opened")
+	}
+	e.Seq = a.head.Seq + 1
+	e.Prev = a.head.Hash
+	if e.Time == 0 {
+		e.Time = time.Now().UTC().Unix()
+	}
+	hash, err := e.digest()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	datas, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := a.file.Write(append(datas, '\n')); err != nil {
+		return err
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	a.head = &AuditHead{Seq: e.Seq, Hash: e.Hash}
+	return a.writeHead()
+}
+
+// Head -- returns the last entry.
+func (a *AuditLog) Head() AuditHead {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.head == nil {
+		return AuditHead{Hash: auditGenesis}
+	}
+	return *a.head
+}
+
+// Query -- returns the entries matched and the head, the chain is verified on reading.
+func (a *AuditLog) Query(q *AuditQuery) ([]AuditEntry, *AuditHead, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = auditDefaultLimit
+	}
+	if limit > auditMaxLimit {
+		limit = auditMaxLimit
+	}
+
+	skip := q.Offset
+	entries := []AuditEntry{}
+	head, err := scanAuditLog(a.path, func(e *AuditEntry) {
+		if !q.match(e) || len(entries) >= limit {
+			return
+		}
+		if skip > 0 {
+			skip--
+			return
+		}
+		entries = append(entries, *e)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.head != nil && *head != *a.head {
+		return nil, nil, fmt.Errorf("audit.log.head[%v].mismatch.want[%v]", head.Seq, a.head.Seq)
+	}
+	return entries, head, nil
+}
+
+// Close -- closes the log file.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
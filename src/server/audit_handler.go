@@ -0,0 +1,106 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"proto"
+)
+
+// auditEvent -- appends the event of the request to the audit log, the device id is from the token
+// and the ip is the peer of the connection, the X-Forwarded-For is kept as it is.
+func (h *Handler) auditEvent(r *http.Request, entry *AuditEntry, err error) error {
+	log := h.log
+	audit := h.audit
+
+	entry.DeviceID = h.deviceID(r)
+	entry.IP = r.RemoteAddr
+	if host, _, serr := net.SplitHostPort(r.RemoteAddr); serr == nil {
+		entry.IP = host
+	}
+	entry.Forwarded = r.Header.Get("X-Forwarded-For")
+	entry.Result = auditResultOK
+	if err != nil {
+		entry.Result = err.Error()
+	}
+	if aerr := audit.Append(entry); aerr != nil {
+		log.Error("api.audit[%v].%v.append.error:%+v", entry.UID, entry.Event, aerr)
+		return aerr
+	}
+	return nil
+}
+
+// auditAuthenticator -- the audit query api requires the audit token as the bearer token.
+func (h *Handler) auditAuthenticator(next http.Handler) http.Handler {
+	log := h.log
+	conf := h.conf
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := newResponse(log, w)
+		if conf.Audit == nil || conf.Audit.Token == "" {
+			resp.writeErrorWithStatus(http.StatusForbidden, fmt.Errorf("audit.query.disabled"))
+			return
+		}
+		token := strings.TrimSpace(r.Header.Get("Authorization"))
+		if len(token) > 7 && strings.ToUpper(token[:7]) == "BEARER " {
+			token = token[7:]
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(conf.Audit.Token)) != 1 {
+			log.Warning("api.audit.query.unauthorized.from[%v]", r.RemoteAddr)
+			resp.writeErrorWithStatus(http.StatusUnauthorized, fmt.Errorf("audit.query.unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditQuery -- the handler of querying the audit log.
+func (h *Handler) auditQuery(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	audit := h.audit
+	resp := newResponse(log, w)
+
+	// Request.
+	req := &proto.AuditQueryRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.audit.query.decode.body.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.audit.query.req:%+v", req)
+
+	entries, head, err := audit.Query(&AuditQuery{
+		UID:    req.UID,
+		Event:  req.Event,
+		Since:  req.Since,
+		Until:  req.Until,
+		Offset: req.Offset,
+		Limit:  req.Limit,
+	})
+	if err != nil {
+		log.Error("api.audit.query.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+	rsp := &proto.AuditQueryResponse{
+		Entries:  make([]proto.AuditEntry, 0, len(entries)),
+		HeadSeq:  head.Seq,
+		HeadHash: head.Hash,
+	}
+	for _, entry := range entries {
+		rsp.Entries = append(rsp.Entries, proto.AuditEntry(entry))
+	}
+	log.Info("api.audit.query.rsp.entries:%v", len(rsp.Entries))
+	resp.writeJSON(rsp)
+}
@@ -0,0 +1,158 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"proto"
+	"xlog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockAuditLog(t *testing.T, path string, n int) *AuditLog {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	audit := NewAuditLog(log, path)
+	err := audit.Open()
+	assert.Nil(t, err)
+	for i := 0; i < n; i++ {
+		err := audit.Append(&AuditEntry{Event: auditEcdsaR2, UID: mockUID, Pos: uint32(i), SigHash: "00", Result: auditResultOK})
+		assert.Nil(t, err)
+	}
+	return audit
+}
+
+func TestAuditLog(t *testing.T) {
+	dir := "/tmp/tss-audit"
+	path := filepath.Join(dir, "audit.log")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	// Append and reopen.
+	{
+		audit := mockAuditLog(t, path, 3)
+		audit.Close()
+
+		audit = mockAuditLog(t, path, 2)
+		head := audit.Head()
+		assert.Equal(t, uint64(5), head.Seq)
+
+		entries, qhead, err := audit.Query(&AuditQuery{UID: mockUID, Offset: 1, Limit: 2})
+		assert.Nil(t, err)
+		assert.Equal(t, head, *qhead)
+		assert.Equal(t, 2, len(entries))
+		assert.Equal(t, uint64(2), entries[0].Seq)
+		assert.Equal(t, entries[0].Hash, entries[1].Prev)
+		audit.Close()
+
+		got, err := VerifyAuditLog(path, &AuditHead{Seq: 2, Hash: entries[0].Hash})
+		assert.Nil(t, err)
+		assert.Equal(t, head, *got)
+	}
+
+	datas, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.SplitAfter(string(datas), "\n")
+
+	// Edited.
+	{
+		edited := strings.Replace(string(datas), `"pos":1`, `"pos":9`, 1)
+		err := ioutil.WriteFile(path, []byte(edited), 0600)
+		assert.Nil(t, err)
+		_, err = VerifyAuditLog(path, nil)
+		assert.NotNil(t, err)
+
+		// The server refuses to start.
+		log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+		err = NewAuditLog(log, path).Open()
+		assert.NotNil(t, err)
+	}
+
+	// Removed in the middle.
+	{
+		removed := lines[0] + strings.Join(lines[2:], "")
+		err := ioutil.WriteFile(path, []byte(removed), 0600)
+		assert.Nil(t, err)
+		_, err = VerifyAuditLog(path, nil)
+		assert.NotNil(t, err)
+	}
+
+	// Truncated, by the head file.
+	{
+		truncated := strings.Join(lines[:3], "")
+		err := ioutil.WriteFile(path, []byte(truncated), 0600)
+		assert.Nil(t, err)
+		_, err = VerifyAuditLog(path, nil)
+		assert.NotNil(t, err)
+
+		// And the head file removed, by the anchor.
+		os.Remove(path + ".head")
+		head, err := VerifyAuditLog(path, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(3), head.Seq)
+		_, err = VerifyAuditLog(path, &AuditHead{Seq: 5, Hash: auditGenesis})
+		assert.NotNil(t, err)
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	conf := MockConfig()
+	conf.Audit = &AuditConfig{Token: "audit-token"}
+	ts, cleanup := MockServerWithConfig(conf)
+	defer cleanup()
+
+	// New address.
+	{
+		req := &proto.WalletNewAddressRequest{}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/newaddress", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+	}
+
+	// Unauthorized, the user token.
+	{
+		req := &proto.AuditQueryRequest{}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/audit/query", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 401, httpRsp.StatusCode())
+	}
+
+	// Query.
+	{
+		req := &proto.AuditQueryRequest{UID: mockUID, Event: auditWalletNewAddress}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", "audit-token").Post(ts.URL+"/api/audit/query", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		rsp := &proto.AuditQueryResponse{}
+		httpRsp.Json(rsp)
+		assert.Equal(t, 1, len(rsp.Entries))
+		entry := rsp.Entries[0]
+		assert.Equal(t, mockUID, entry.UID)
+		assert.Equal(t, auditResultOK, entry.Result)
+		assert.Equal(t, "127.0.0.1", entry.IP)
+		assert.Equal(t, rsp.HeadHash, entry.Hash)
+
+		_, err = VerifyAuditLog(AuditFile(conf), &AuditHead{Seq: rsp.HeadSeq, Hash: rsp.HeadHash})
+		assert.Nil(t, err)
+	}
+}
+
+func TestAuditHandlerDisabled(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	req := &proto.AuditQueryRequest{}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", "").Post(ts.URL+"/api/audit/query", req)
+	assert.Nil(t, err)
+	assert.Equal(t, 403, httpRsp.StatusCode())
+}
@@ -0,0 +1,38 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"xlog"
+)
+
+const (
+	jsonBackend = "json"
+	boltBackend = "bolt"
+)
+
+// WalletBackend -- the storage of the wallets behind the WalletStore.
+// The Save must write the wallet atomically, the caller holds the wallet lock.
+type WalletBackend interface {
+	Open(dir string) error
+	Load() ([]*Wallet, error)
+	Save(wallet *Wallet) error
+	Close() error
+}
+
+// NewWalletBackend -- creates the backend by the store_backend, default is the json.
+func NewWalletBackend(log *xlog.Log, conf *Config) WalletBackend {
+	switch conf.StoreBackend {
+	case boltBackend:
+		return NewBoltBackend(log)
+	case jsonBackend, "":
+		return NewJSONBackend(log)
+	default:
+		log.Error("wallet.backend[%v].unknown.use.json", conf.StoreBackend)
+		return NewJSONBackend(log)
+	}
+}
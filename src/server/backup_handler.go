@@ -79,6 +79,7 @@ func (h *Handler) backupStore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Info("api.backup[%v].store.req:%+v", uid, req)
+	entry := &AuditEntry{Event: auditBackupStore, UID: uid, Detail: req.CloudService}
 
 	// Check.
 	backup, err := wdb.GetBackup(uid)
@@ -99,6 +100,7 @@ func (h *Handler) backupStore(w http.ResponseWriter, r *http.Request) {
 		// vcode.
 		if err := vcode.Check(uid, req.VCode); err != nil {
 			log.Error("api.backup.store.vcode.error:%+v", err)
+			h.auditEvent(r, entry, err)
 			resp.writeErrorWithStatus(400, err)
 			return
 		}
@@ -106,6 +108,7 @@ func (h *Handler) backupStore(w http.ResponseWriter, r *http.Request) {
 		// signature.
 		if err := rsaVerify(req.EncryptionPubKey, req.VCode, req.Signature); err != nil {
 			log.Error("api.backup.store.rsa.verify.error:%+v", err)
+			h.auditEvent(r, entry, err)
 			resp.writeErrorWithStatus(400, err)
 			return
 		}
@@ -115,15 +118,20 @@ func (h *Handler) backupStore(w http.ResponseWriter, r *http.Request) {
 	// wdb Backup.
 	if err := wdb.StoreBackup(uid, req.Email, req.DeviceID, req.CloudService, req.EncryptedPrvKey, req.EncryptionPubKey); err != nil {
 		log.Error("api.backup.wdb.store.backup.error:%+v", err)
+		h.auditEvent(r, entry, err)
+		resp.writeErrorWithStatus(500, err)
+		return
+	}
+	if err := h.auditEvent(r, entry, nil); err != nil {
 		resp.writeErrorWithStatus(500, err)
 		return
 	}
 
-	// smtp backup.
-	if err := smtp.Backup(uid, "KeyFuse Labs-Server-Wallet-Backup"); err != nil {
+	// smtp backup, the backup is stored and the smtp errors are logged only.
+	if export, err := wdb.ExportWallet(uid); err != nil {
+		log.Error("api.backup.wdb.store.backup.export.error:%+v", err)
+	} else if err := smtp.Backup(uid, "KeyFuse Labs-Server-Wallet-Backup", export); err != nil {
 		log.Error("api.backup.wdb.store.backup.smtp.error:%+v", err)
-		resp.writeErrorWithStatus(500, nil)
-		return
 	}
 	rsp := &proto.BackupStoreResponse{}
 	log.Info("api.backup.store.rsp:%+v", rsp)
@@ -153,6 +161,7 @@ func (h *Handler) backupRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Info("api.backup[%v].restore.req:%+v", uid, req)
+	entry := &AuditEntry{Event: auditBackupRestore, UID: uid}
 
 	// Check.
 	backup, err := wdb.GetBackup(uid)
@@ -172,6 +181,7 @@ func (h *Handler) backupRestore(w http.ResponseWriter, r *http.Request) {
 		// vcode.
 		if err := vcode.Check(uid, req.VCode); err != nil {
 			log.Error("api.backup.restore.vcode.error:%+v", err)
+			h.auditEvent(r, entry, err)
 			resp.writeErrorWithStatus(400, err)
 			return
 		}
@@ -179,6 +189,7 @@ func (h *Handler) backupRestore(w http.ResponseWriter, r *http.Request) {
 		// signature.
 		if err := rsaVerify(backup.EncryptionPubKey, req.VCode, req.Signature); err != nil {
 			log.Error("api.backup.restore.rsa.verify.error:%+v", err)
+			h.auditEvent(r, entry, err)
 			resp.writeErrorWithStatus(400, err)
 			return
 		}
@@ -186,6 +197,10 @@ func (h *Handler) backupRestore(w http.ResponseWriter, r *http.Request) {
 
 	// OK.
 	vcode.Remove(uid)
+	if err := h.auditEvent(r, entry, nil); err != nil {
+		resp.writeErrorWithStatus(500, err)
+		return
+	}
 	rsp := &proto.BackupRestoreResponse{
 		Time:            backup.Time,
 		EncryptedPrvKey: backup.EncryptedPrvKey,
@@ -231,6 +246,11 @@ func (h *Handler) backupVerify(w http.ResponseWriter, r *http.Request) {
 	if sha256 == req.EncryptionPubKeyHash {
 		passed = true
 	}
+	entry := &AuditEntry{Event: auditBackupVerify, UID: uid, Detail: fmt.Sprintf("passed:%v", passed)}
+	if err := h.auditEvent(r, entry, nil); err != nil {
+		resp.writeErrorWithStatus(500, err)
+		return
+	}
 	rsp := &proto.BackupVerifyResponse{
 		VerifyPassed:    passed,
 		VerifyTimestamp: time.Now().UTC().Unix(),
@@ -0,0 +1,200 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"crypto/ecdh"
+	"crypto/hpke"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"xlog"
+)
+
+const (
+	backupVersion = 1
+	backupSuite   = "DHKEM(X25519, HKDF-SHA256)/HKDF-SHA256/AES-256-GCM"
+	backupInfo    = "thresh-wallet-backup-v1"
+)
+
+// BackupEnvelope -- the wallet backup encrypted to the operator keys.
+// The payload is AES-256-GCM by a random data key, the data key is sealed to every recipient
+// by the RFC 9180 HPKE base mode, the binary fields are base64 encoded.
+type BackupEnvelope struct {
+	Version    int               `json:"version"`
+	Suite      string            `json:"suite"`
+	Recipients []BackupRecipient `json:"recipients"`
+	Ciphertext string            `json:"ciphertext"`
+}
+
+// BackupRecipient -- the data key sealed to the recipient public key(hex).
+type BackupRecipient struct {
+	PubKey    string `json:"pubkey"`
+	SealedKey string `json:"sealed_key"`
+}
+
+// backupKEM -- the HPKE KEM of the backup keys.
+func backupKEM() hpke.KEM {
+	return hpke.DHKEM(ecdh.X25519())
+}
+
+// GenerateBackupKey -- generates the operator key pair, hex encoded X25519 keys.
+func GenerateBackupKey() (string, string, error) {
+	prv, err := backupKEM().GenerateKey()
+	if err != nil {
+		return "", "", err
+	}
+	prvbytes, err := prv.Bytes()
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(prvbytes), hex.EncodeToString(prv.PublicKey().Bytes()), nil
+}
+
+// ValidateBackupRecipients -- checks the operator recipients(hex X25519 public keys) of the backup encryption.
+func ValidateBackupRecipients(recipients []string) error {
+	if len(recipients) == 0 {
+		return fmt.Errorf("backup.encrypt.recipients.required")
+	}
+	for _, recipient := range recipients {
+		recipient = strings.ToLower(strings.TrimSpace(recipient))
+		pubbytes, err := hex.DecodeString(recipient)
+		if err != nil {
+			return fmt.Errorf("backup.encrypt.recipient[%v].decode.error:%v", recipient, err)
+		}
+		if _, err := backupKEM().NewPublicKey(pubbytes); err != nil {
+			return fmt.Errorf("backup.encrypt.recipient[%v].error:%v", recipient, err)
+		}
+	}
+	return nil
+}
+
+// EncryptBackup -- encrypts the wallet export to the operator recipients(hex X25519 public keys).
+func EncryptBackup(recipients []string, datas []byte) ([]byte, error) {
+	if err := ValidateBackupRecipients(recipients); err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	env := &BackupEnvelope{
+		Version: backupVersion,
+		Suite:   backupSuite,
+	}
+	for _, recipient := range recipients {
+		recipient = strings.ToLower(strings.TrimSpace(recipient))
+		pubbytes, err := hex.DecodeString(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("backup.encrypt.recipient[%v].decode.error:%v", recipient, err)
+		}
+		pub, err := backupKEM().NewPublicKey(pubbytes)
+		if err != nil {
+			return nil, fmt.Errorf("backup.encrypt.recipient[%v].error:%v", recipient, err)
+		}
+		sealed, err := hpke.Seal(pub, hpke.HKDFSHA256(), hpke.AES256GCM(), []byte(backupInfo), dataKey)
+		if err != nil {
+			return nil, err
+		}
+		env.Recipients = append(env.Recipients, BackupRecipient{
+			PubKey:    recipient,
+			SealedKey: base64.StdEncoding.EncodeToString(sealed),
+		})
+	}
+
+	ciphertext, err := aesgcmSeal(dataKey, datas, []byte(backupInfo))
+	if err != nil {
+		return nil, err
+	}
+	env.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	return json.MarshalIndent(env, "", " ")
+}
+
+// DecryptBackup -- decrypts the backup by one of the operator private keys(hex).
+func DecryptBackup(prvkeys []string, datas []byte) ([]byte, error) {
+	env := &BackupEnvelope{}
+	if err := json.Unmarshal(datas, env); err != nil {
+		return nil, fmt.Errorf("backup.decrypt.unmarshal.error:%v", err)
+	}
+	if env.Version != backupVersion {
+		return nil, fmt.Errorf("backup.decrypt.version[%v].unsupported", env.Version)
+	}
+
+	for _, prvkey := range prvkeys {
+		prvbytes, err := hex.DecodeString(strings.TrimSpace(prvkey))
+		if err != nil {
+			return nil, fmt.Errorf("backup.decrypt.prvkey.decode.error:%v", err)
+		}
+		prv, err := backupKEM().NewPrivateKey(prvbytes)
+		if err != nil {
+			return nil, fmt.Errorf("backup.decrypt.prvkey.error:%v", err)
+		}
+		pubkey := hex.EncodeToString(prv.PublicKey().Bytes())
+
+		for _, recipient := range env.Recipients {
+			if recipient.PubKey != pubkey {
+				continue
+			}
+			sealed, err := base64.StdEncoding.DecodeString(recipient.SealedKey)
+			if err != nil {
+				return nil, err
+			}
+			dataKey, err := hpke.Open(prv, hpke.HKDFSHA256(), hpke.AES256GCM(), []byte(backupInfo), sealed)
+			if err != nil {
+				return nil, fmt.Errorf("backup.decrypt.recipient[%v].open.error:%v", pubkey, err)
+			}
+			ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+			if err != nil {
+				return nil, err
+			}
+			plaintext, err := aesgcmOpen(dataKey, ciphertext, []byte(backupInfo))
+			if err != nil {
+				return nil, fmt.Errorf("backup.decrypt.payload.error:%v", err)
+			}
+			return plaintext, nil
+		}
+	}
+	return nil, fmt.Errorf("backup.decrypt.no.matched.recipient")
+}
+
+// ImportBackup -- imports the decrypted wallet into the store of the datadir, returns the uid.
+// Errors if the wallet exists.
+func ImportBackup(log *xlog.Log, conf *Config, datas []byte) (string, error) {
+	wallet := NewWallet()
+	if err := json.Unmarshal(datas, wallet); err != nil {
+		return "", fmt.Errorf("backup.import.unmarshal.error:%v", err)
+	}
+	uid := wallet.UID
+	if uid == "" {
+		return "", fmt.Errorf("backup.import.uid.empty")
+	}
+	if wallet.Address == nil {
+		wallet.Address = make(map[string]*Address)
+	}
+
+	store := NewWalletStore(log, conf)
+	if err := store.Open(conf.DataDir); err != nil {
+		return "", err
+	}
+	defer store.Close()
+
+	if store.Get(uid) != nil {
+		return "", fmt.Errorf("backup.import.wallet[%v].exists", uid)
+	}
+	wallet.net = store.net
+	if err := store.Write(wallet); err != nil {
+		return "", err
+	}
+	log.Info("backup.import.wallet[%v].done", uid)
+	return uid, nil
+}
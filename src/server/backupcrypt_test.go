@@ -0,0 +1,127 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xlog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupEncrypt(t *testing.T) {
+	datas := []byte(mock13888888888Json)
+	prv1, pub1, err := GenerateBackupKey()
+	assert.Nil(t, err)
+	prv2, pub2, err := GenerateBackupKey()
+	assert.Nil(t, err)
+	prv3, _, err := GenerateBackupKey()
+	assert.Nil(t, err)
+
+	encrypted, err := EncryptBackup([]string{pub1, " " + pub2 + " "}, datas)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(encrypted), mockSvrMasterPrvKey)
+	assert.NotContains(t, string(encrypted), mockUID)
+
+	// Every recipient decrypts.
+	{
+		got, err := DecryptBackup([]string{prv1}, encrypted)
+		assert.Nil(t, err)
+		assert.Equal(t, datas, got)
+		got, err = DecryptBackup([]string{prv3, prv2}, encrypted)
+		assert.Nil(t, err)
+		assert.Equal(t, datas, got)
+	}
+
+	// Not a recipient.
+	{
+		_, err := DecryptBackup([]string{prv3}, encrypted)
+		assert.NotNil(t, err)
+	}
+
+	// Tampered.
+	{
+		env := &BackupEnvelope{}
+		err := json.Unmarshal(encrypted, env)
+		assert.Nil(t, err)
+		env.Ciphertext = "AAAA" + env.Ciphertext[4:]
+		tampered, _ := json.Marshal(env)
+		_, err = DecryptBackup([]string{prv1}, tampered)
+		assert.NotNil(t, err)
+	}
+
+	// Recipients.
+	{
+		_, err := EncryptBackup(nil, datas)
+		assert.NotNil(t, err)
+		_, err = EncryptBackup([]string{"xx"}, datas)
+		assert.NotNil(t, err)
+		_, err = EncryptBackup([]string{"0102"}, datas)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestBackupImport(t *testing.T) {
+	dir := "/tmp/tss-backup-import"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	prvkey, pubkey, err := GenerateBackupKey()
+	assert.Nil(t, err)
+
+	// The smtp requires the recipients.
+	{
+		conf := MockConfig()
+		conf.Smtp = &SmtpConfig{BackupTo: "a@gmail.com"}
+		err := NewSmtp(log, conf).Backup(mockUID, "test", []byte(mock13888888888Json))
+		assert.NotNil(t, err)
+	}
+
+	// Export, encrypt, decrypt and import.
+	src := dir + "/src"
+	os.MkdirAll(src, os.ModePerm)
+	err = ioutil.WriteFile(filepath.Join(src, mockUID+".json"), []byte(mock13888888888Json), 0644)
+	assert.Nil(t, err)
+	wdb := NewWalletDB(log, MockConfig())
+	wdb.setChain(newMockChain(log))
+	err = wdb.Open(src)
+	assert.Nil(t, err)
+	defer wdb.Close()
+
+	export, err := wdb.ExportWallet(mockUID)
+	assert.Nil(t, err)
+	encrypted, err := EncryptBackup([]string{pubkey}, export)
+	assert.Nil(t, err)
+	decrypted, err := DecryptBackup([]string{prvkey}, encrypted)
+	assert.Nil(t, err)
+
+	conf := MockConfig()
+	conf.DataDir = dir + "/dst"
+	uid, err := ImportBackup(log, conf, decrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, mockUID, uid)
+
+	// Exists.
+	_, err = ImportBackup(log, conf, decrypted)
+	assert.NotNil(t, err)
+
+	// The imported wallet.
+	store := NewWalletStore(log, conf)
+	err = store.Open(conf.DataDir)
+	assert.Nil(t, err)
+	defer store.Close()
+	wallet := store.Get(mockUID)
+	assert.NotNil(t, wallet)
+	assert.Equal(t, mockSvrMasterPrvKey, wallet.SvrMasterPrvKey)
+	assert.Equal(t, wdb.Wallet(mockUID).LastPos, wallet.LastPos)
+}
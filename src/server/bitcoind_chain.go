@@ -0,0 +1,560 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"xlog"
+
+	"github.com/keyfuse/tokucore/xrpc"
+)
+
+const (
+	bitcoindTimeout   = 10 * time.Second
+	bitcoindListCount = 1000
+
+	// bitcoindRawTxCacheSize -- the max decoded txs cached, the least recently used one is evicted.
+	bitcoindRawTxCacheSize = 10000
+)
+
+// Bitcoind rpc error codes of the tx refused by the node.
+const (
+	bitcoindErrDeserialization = -22
+	bitcoindErrVerify          = -25
+	bitcoindErrVerifyRejected  = -26
+)
+
+var (
+	// bitcoindFeeTargets -- the confirmation targets(in blocks) of the estimatesmartfee.
+	bitcoindFeeTargets = []int{2, 4, 6, 10}
+)
+
+// BitcoindUnspent -- the result of the listunspent.
+type BitcoindUnspent struct {
+	Txid          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	Address       string  `json:"address"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	Confirmations int64   `json:"confirmations"`
+}
+
+// BitcoindAddressInfo -- the result of the getaddressinfo.
+type BitcoindAddressInfo struct {
+	IsMine      bool `json:"ismine"`
+	IsWatchOnly bool `json:"iswatchonly"`
+}
+
+// BitcoindImportResult -- the result of the importmulti.
+type BitcoindImportResult struct {
+	Success bool        `json:"success"`
+	Error   *xrpc.Error `json:"error"`
+}
+
+// BitcoindReceived -- the result of the listreceivedbyaddress.
+type BitcoindReceived struct {
+	Address string   `json:"address"`
+	Txids   []string `json:"txids"`
+}
+
+// BitcoindListTx -- the result of the listtransactions.
+type BitcoindListTx struct {
+	Txid     string `json:"txid"`
+	Category string `json:"category"`
+}
+
+// BitcoindWalletTx -- the result of the gettransaction.
+type BitcoindWalletTx struct {
+	Txid          string  `json:"txid"`
+	Fee           float64 `json:"fee"`
+	Confirmations int64   `json:"confirmations"`
+	BlockHash     string  `json:"blockhash"`
+	BlockTime     int64   `json:"blocktime"`
+	Hex           string  `json:"hex"`
+}
+
+// BitcoindRawTx -- the result of the decoderawtransaction.
+type BitcoindRawTx struct {
+	Txid  string `json:"txid"`
+	VSize int64  `json:"vsize"`
+	Vin   []struct {
+		Txid string `json:"txid"`
+		Vout uint32 `json:"vout"`
+	} `json:"vin"`
+	Vout []struct {
+		Value        float64 `json:"value"`
+		N            uint32  `json:"n"`
+		ScriptPubKey struct {
+			Hex       string   `json:"hex"`
+			Type      string   `json:"type"`
+			Address   string   `json:"address"`
+			Addresses []string `json:"addresses"`
+		} `json:"scriptPubKey"`
+	} `json:"vout"`
+}
+
+// BitcoindFee -- the result of the estimatesmartfee.
+type BitcoindFee struct {
+	FeeRate float64  `json:"feerate"`
+	Errors  []string `json:"errors"`
+	Blocks  int      `json:"blocks"`
+}
+
+// bitcoindError -- the error of the rpc response, the node has handled the call.
+type bitcoindError struct {
+	Method  string
+	Code    int
+	Message string
+}
+
+// Error -- the implementation method for error interface.
+func (e *bitcoindError) Error() string {
+	return fmt.Sprintf("bitcoind.%v.error:%v", e.Method, e.Message)
+}
+
+// BitcoindChain -- the chain backed by a bitcoind node.
+// The wallet addresses are imported into the node wallet as watch-only,
+// so the node must run with a legacy wallet.
+// The node rescans the blocks from the address creation time when it's imported.
+type BitcoindChain struct {
+	mu       sync.Mutex
+	url      string
+	log      *xlog.Log
+	conf     *Config
+	client   *xrpc.Client
+	http     *http.Client
+	imported map[string]bool
+	births   map[string]int64
+	rawtxs   *lruCache
+}
+
+// NewBitcoindChain -- creates new BitcoindChain.
+func NewBitcoindChain(log *xlog.Log, conf *Config) Chain {
+	bconf := conf.Bitcoind
+	if bconf == nil {
+		bconf = &BitcoindConfig{}
+	}
+	url := fmt.Sprintf("http://%v/", bconf.Host)
+	if bconf.Wallet != "" {
+		url = fmt.Sprintf("http://%v/wallet/%v", bconf.Host, bconf.Wallet)
+	}
+
+	return &BitcoindChain{
+		url:      url,
+		log:      log,
+		conf:     conf,
+		client:   xrpc.NewClient(bconf.Host, bconf.User, bconf.Password),
+		http:     &http.Client{Timeout: bitcoindTimeout},
+		imported: make(map[string]bool),
+		births:   make(map[string]int64),
+		rawtxs:   newLRUCache(bitcoindRawTxCacheSize),
+	}
+}
+
+// call -- the wallet RPC call, the xrpc.Client only has the block methods.
+func (c *BitcoindChain) call(result interface{}, method string, params ...interface{}) error {
+	bconf := c.conf.Bitcoind
+	if bconf == nil {
+		return fmt.Errorf("bitcoind.config.is.null")
+	}
+
+	if params == nil {
+		params = []interface{}{}
+	}
+	enc, err := json.Marshal(&xrpc.Request{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(enc))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(bconf.User, bconf.Password)
+	ctx, cancel := context.WithTimeout(context.Background(), bitcoindTimeout)
+	defer cancel()
+
+	httpRsp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer httpRsp.Body.Close()
+
+	rsp := &xrpc.Response{}
+	if err := json.NewDecoder(httpRsp.Body).Decode(rsp); err != nil {
+		return fmt.Errorf("bitcoind.%v.rsp[%v].error:%v", method, httpRsp.StatusCode, err)
+	}
+	if rsp.Error != nil {
+		return &bitcoindError{Method: method, Code: rsp.Error.Code, Message: rsp.Error.Message}
+	}
+	if result == nil || rsp.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(*rsp.Result, result)
+}
+
+// WatchAddress -- records the creation time of the address and imports it, the node rescans the blocks from it.
+func (c *BitcoindChain) WatchAddress(address string, createdAt int64) error {
+	c.mu.Lock()
+	c.births[address] = createdAt
+	c.mu.Unlock()
+	return c.importAddress(address)
+}
+
+// importAddress -- imports the address as watch-only if the node doesn't watch it yet.
+// The node rescans the blocks from the address creation time, or from the scan height if the creation is unknown.
+func (c *BitcoindChain) importAddress(address string) error {
+	c.mu.Lock()
+	imported := c.imported[address]
+	birth := c.births[address]
+	c.mu.Unlock()
+	if imported {
+		return nil
+	}
+
+	info := &BitcoindAddressInfo{}
+	if err := c.call(info, "getaddressinfo", address); err != nil {
+		return err
+	}
+	if !info.IsMine && !info.IsWatchOnly {
+		timestamp, err := c.rescanTime(birth)
+		if err != nil {
+			return err
+		}
+		req := []map[string]interface{}{
+			{
+				"scriptPubKey": map[string]string{"address": address},
+				"timestamp":    timestamp,
+				"watchonly":    true,
+			},
+		}
+		var results []BitcoindImportResult
+		if err := c.call(&results, "importmulti", req, map[string]bool{"rescan": true}); err != nil {
+			return err
+		}
+		if len(results) != 1 || !results[0].Success {
+			var reason string
+			if len(results) == 1 && results[0].Error != nil {
+				reason = results[0].Error.Message
+			}
+			return fmt.Errorf("bitcoind.import.address[%v].error:%v", address, reason)
+		}
+	}
+	c.mu.Lock()
+	c.imported[address] = true
+	c.mu.Unlock()
+	return nil
+}
+
+// rescanTime -- the time the rescan starts from, it's the creation time of the address,
+// or the time of the block at the scan height if the creation is unknown, 0 is the genesis.
+func (c *BitcoindChain) rescanTime(birth int64) (int64, error) {
+	if birth > 0 {
+		return birth, nil
+	}
+	bconf := c.conf.Bitcoind
+	if bconf == nil || bconf.ScanHeight <= 0 {
+		return 0, nil
+	}
+	var hash string
+	if err := c.call(&hash, "getblockhash", bconf.ScanHeight); err != nil {
+		return 0, err
+	}
+	header, err := c.client.GetBlockHeader(hash)
+	if err != nil {
+		return 0, err
+	}
+	return int64(header.Time), nil
+}
+
+// blockHeight -- returns the height of the block.
+func (c *BitcoindChain) blockHeight(hash string) (int64, error) {
+	header, err := c.client.GetBlockHeader(hash)
+	if err != nil {
+		return 0, err
+	}
+	return header.Height, nil
+}
+
+// getTx -- returns the wallet tx and the decoded one, the decoded txs are cached since they are immutable.
+func (c *BitcoindChain) getTx(txid string) (*BitcoindWalletTx, *BitcoindRawTx, error) {
+	wtx := &BitcoindWalletTx{}
+	if err := c.call(wtx, "gettransaction", txid, true); err != nil {
+		return nil, nil, err
+	}
+	raw, err := c.decodeTx(wtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wtx, raw, nil
+}
+
+// decodeTx -- returns the decoded tx of the wallet tx by the cache or the decoderawtransaction.
+func (c *BitcoindChain) decodeTx(wtx *BitcoindWalletTx) (*BitcoindRawTx, error) {
+	c.mu.Lock()
+	cached, ok := c.rawtxs.get(wtx.Txid)
+	c.mu.Unlock()
+	if ok {
+		return cached.(*BitcoindRawTx), nil
+	}
+
+	raw := &BitcoindRawTx{}
+	if err := c.call(raw, "decoderawtransaction", wtx.Hex); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.rawtxs.add(wtx.Txid, raw)
+	c.mu.Unlock()
+	return raw, nil
+}
+
+// spentTxids -- returns the txids the node wallet lists as send, all the pages of the listtransactions.
+func (c *BitcoindChain) spentTxids() ([]string, error) {
+	var txids []string
+	seen := make(map[string]bool)
+	for skip := 0; ; skip += bitcoindListCount {
+		var list []BitcoindListTx
+		if err := c.call(&list, "listtransactions", "*", bitcoindListCount, skip, true); err != nil {
+			return nil, err
+		}
+		for _, ltx := range list {
+			if ltx.Category == "send" && !seen[ltx.Txid] {
+				seen[ltx.Txid] = true
+				txids = append(txids, ltx.Txid)
+			}
+		}
+		if len(list) < bitcoindListCount {
+			return txids, nil
+		}
+	}
+}
+
+// GetUTXO -- used to get all the unspents of this address.
+func (c *BitcoindChain) GetUTXO(address string) ([]Unspent, error) {
+	if err := c.importAddress(address); err != nil {
+		return nil, err
+	}
+
+	var utxos []BitcoindUnspent
+	if err := c.call(&utxos, "listunspent", 0, 9999999, []string{address}, true); err != nil {
+		return nil, err
+	}
+
+	var unspents []Unspent
+	for _, utxo := range utxos {
+		unspent := Unspent{
+			Txid:         utxo.Txid,
+			Vout:         utxo.Vout,
+			Value:        bitcoindSatoshis(utxo.Amount),
+			Confirmed:    utxo.Confirmations > 0,
+			Scriptpubkey: utxo.ScriptPubKey,
+		}
+		if unspent.Confirmed {
+			wtx := &BitcoindWalletTx{}
+			if err := c.call(wtx, "gettransaction", utxo.Txid, true); err != nil {
+				return nil, fmt.Errorf("bitcoind.utxo[%v].get.tx.error:%v", utxo.Txid, err)
+			}
+			height, err := c.blockHeight(wtx.BlockHash)
+			if err != nil {
+				return nil, fmt.Errorf("bitcoind.utxo[%v].get.block[%v].error:%v", utxo.Txid, wtx.BlockHash, err)
+			}
+			unspent.BlockTime = uint32(wtx.BlockTime)
+			unspent.BlockHeight = uint32(height)
+		}
+		unspents = append(unspents, unspent)
+	}
+	return unspents, nil
+}
+
+// GetTxs -- used to get transactions by address.
+// The received txs are listed by the address, the sent ones are filtered by the outpoints of the received.
+func (c *BitcoindChain) GetTxs(address string) ([]Tx, error) {
+	if err := c.importAddress(address); err != nil {
+		return nil, err
+	}
+
+	type bitcoindTx struct {
+		wtx *BitcoindWalletTx
+		raw *BitcoindRawTx
+	}
+	var btxs []bitcoindTx
+	seen := make(map[string]bool)
+	outpoints := make(map[string]int64)
+
+	// Received txs.
+	var received []BitcoindReceived
+	if err := c.call(&received, "listreceivedbyaddress", 0, true, true, address); err != nil {
+		return nil, err
+	}
+	for _, recv := range received {
+		if recv.Address != address {
+			continue
+		}
+		for _, txid := range recv.Txids {
+			if seen[txid] {
+				continue
+			}
+			seen[txid] = true
+			wtx, raw, err := c.getTx(txid)
+			if err != nil {
+				return nil, fmt.Errorf("bitcoind.txs[%v].get.tx.error:%v", txid, err)
+			}
+			for _, vout := range raw.Vout {
+				if bitcoindPaysTo(vout.ScriptPubKey.Address, vout.ScriptPubKey.Addresses, address) {
+					outpoints[fmt.Sprintf("%v:%v", raw.Txid, vout.N)] = int64(bitcoindSatoshis(vout.Value))
+				}
+			}
+			btxs = append(btxs, bitcoindTx{wtx: wtx, raw: raw})
+		}
+	}
+
+	// Spent txs, the watch-only inputs are listed as send, only the ones spend the received outputs are of the address.
+	if len(outpoints) > 0 {
+		txids, err := c.spentTxids()
+		if err != nil {
+			return nil, err
+		}
+		for _, txid := range txids {
+			if seen[txid] {
+				continue
+			}
+			seen[txid] = true
+			wtx, raw, err := c.getTx(txid)
+			if err != nil {
+				return nil, fmt.Errorf("bitcoind.txs[%v].get.tx.error:%v", txid, err)
+			}
+			for _, vin := range raw.Vin {
+				if _, ok := outpoints[fmt.Sprintf("%v:%v", vin.Txid, vin.Vout)]; ok {
+					btxs = append(btxs, bitcoindTx{wtx: wtx, raw: raw})
+					break
+				}
+			}
+		}
+	}
+
+	var txs []Tx
+	for _, btx := range btxs {
+		var data string
+		var sentValue int64
+		var receivedValue int64
+
+		for _, vin := range btx.raw.Vin {
+			sentValue += outpoints[fmt.Sprintf("%v:%v", vin.Txid, vin.Vout)]
+		}
+		for _, vout := range btx.raw.Vout {
+			if bitcoindPaysTo(vout.ScriptPubKey.Address, vout.ScriptPubKey.Addresses, address) {
+				receivedValue += int64(bitcoindSatoshis(vout.Value))
+			}
+			if vout.ScriptPubKey.Type == "nulldata" {
+				hexstr := vout.ScriptPubKey.Hex
+				if len(hexstr) > 4 {
+					hexstr = hexstr[4:]
+				}
+				bytes, _ := hex.DecodeString(hexstr)
+				data = string(bytes)
+			}
+		}
+		if sentValue == 0 && receivedValue == 0 {
+			continue
+		}
+
+		tx := Tx{
+			Txid:      btx.raw.Txid,
+			Fee:       int64(bitcoindSatoshis(math.Abs(btx.wtx.Fee))),
+			VSize:     btx.raw.VSize,
+			Data:      data,
+			Value:     receivedValue - sentValue,
+			Confirmed: btx.wtx.Confirmations > 0,
+		}
+		if tx.Confirmed {
+			height, err := c.blockHeight(btx.wtx.BlockHash)
+			if err != nil {
+				return nil, fmt.Errorf("bitcoind.txs[%v].get.block[%v].error:%v", tx.Txid, btx.wtx.BlockHash, err)
+			}
+			tx.BlockTime = btx.wtx.BlockTime
+			tx.BlockHeight = height
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// GetFees -- used to get the fees(satoshis per vbyte) from the estimatesmartfee.
+func (c *BitcoindChain) GetFees() (map[string]float32, error) {
+	fees := make(map[string]float32)
+	for _, target := range bitcoindFeeTargets {
+		fee := &BitcoindFee{}
+		if err := c.call(fee, "estimatesmartfee", target); err != nil {
+			return nil, err
+		}
+		if fee.FeeRate <= 0 {
+			continue
+		}
+		// BTC/kvB to sat/vB.
+		fees[strconv.Itoa(target)] = float32(fee.FeeRate * 1e8 / 1000)
+	}
+	if len(fees) == 0 {
+		return nil, fmt.Errorf("bitcoind.estimatesmartfee.no.estimates")
+	}
+	return fees, nil
+}
+
+// GetTxLink -- get the tx web link.
+func (c *BitcoindChain) GetTxLink() string {
+	var link string
+	if c.conf.Bitcoind != nil {
+		link = c.conf.Bitcoind.TxLink
+	}
+	return txLink(c.conf.ChainNet, link)
+}
+
+// PushTx -- used to push tx to the node by sendrawtransaction.
+func (c *BitcoindChain) PushTx(hex string) (string, error) {
+	log := c.log
+
+	log.Info("chain.bitcoind.strart.pushtx.tx:%v", hex)
+	var txid string
+	if err := c.call(&txid, "sendrawtransaction", hex); err != nil {
+		if rerr, ok := err.(*bitcoindError); ok {
+			switch rerr.Code {
+			case bitcoindErrDeserialization, bitcoindErrVerify, bitcoindErrVerifyRejected:
+				return "", &TxRejectError{Provider: bitcoind, Reason: rerr.Message}
+			}
+		}
+		return "", err
+	}
+	log.Info("chain.bitcoind.end.pushtx.txid:%v", txid)
+	return txid, nil
+}
+
+// bitcoindSatoshis -- converts the BTC amount to satoshis.
+func bitcoindSatoshis(amount float64) uint64 {
+	return uint64(math.Round(amount * 1e8))
+}
+
+// bitcoindPaysTo -- returns true if the output script pays to the address.
+// Newer bitcoind returns the 'address', older returns the 'addresses'.
+func bitcoindPaysTo(addr string, addrs []string, address string) bool {
+	if addr == address {
+		return true
+	}
+	for _, a := range addrs {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
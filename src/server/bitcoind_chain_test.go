@@ -0,0 +1,277 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"xlog"
+
+	"github.com/keyfuse/tokucore/xrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	mockBitcoindAddress = "mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU"
+	mockBitcoindScript  = "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac"
+	mockBitcoindTxA     = "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df"
+	mockBitcoindTxB     = "e0c328bd49e9a1c2ef5f7a1c14f0f9893658f5673fb415ceec1125dcd6641993"
+	mockBitcoindTxC     = "2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a"
+	mockBitcoindTxD     = "4f4e7c5d45bd4e4fd1b3fd9ad3e38f4d3d0a4e1d6b3c9e1b7d2c7a8e9f0a1b2c"
+	mockBitcoindBlock   = "000000000058b74204bb9d59128e7975b683ac73910660b6531e59523fb4a102"
+)
+
+// mockBitcoindServer -- the xrpc mock server, the result is found by the 'method' or 'method/param0',
+// the error by the 'error/method'.
+func mockBitcoindServer(results map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		req := &xrpc.Request{}
+		json.NewDecoder(r.Body).Decode(req)
+		str, ok := results[req.Method]
+		if len(req.Params) > 0 {
+			if v, has := results[fmt.Sprintf("%v/%v", req.Method, req.Params[0])]; has {
+				str, ok = v, has
+			}
+		}
+
+		resp := &xrpc.Response{}
+		if errstr, has := results["error/"+req.Method]; has {
+			resp.Error = &xrpc.Error{}
+			json.Unmarshal([]byte(errstr), resp.Error)
+		} else if ok {
+			data := []byte(str)
+			resp.Result = (*json.RawMessage)(&data)
+		} else {
+			resp.Error = &xrpc.Error{Code: -32601, Message: "mock.method.not.found"}
+		}
+		enc, _ := json.Marshal(resp)
+		w.Write(enc)
+	}))
+}
+
+func mockBitcoindResults() map[string]string {
+	return map[string]string{
+		"getaddressinfo": `{"ismine":false,"iswatchonly":false}`,
+		"importmulti":    `[{"success":true}]`,
+		"listunspent": fmt.Sprintf(`[{"txid":"%v","vout":1,"address":"%v","scriptPubKey":"%v","amount":0.0001,"confirmations":0}]`,
+			mockBitcoindTxC, mockBitcoindAddress, mockBitcoindScript),
+		"listreceivedbyaddress": fmt.Sprintf(`[{"address":"%v","amount":0.00103266,"confirmations":0,"txids":["%v","%v"]}]`,
+			mockBitcoindAddress, mockBitcoindTxA, mockBitcoindTxC),
+		"listtransactions": fmt.Sprintf(`[{"txid":"%v","category":"receive"},{"txid":"%v","category":"send"},{"txid":"%v","category":"send"}]`,
+			mockBitcoindTxA, mockBitcoindTxB, mockBitcoindTxD),
+
+		// Wallet txs, the hex is the txid for decoding.
+		"gettransaction/" + mockBitcoindTxA: fmt.Sprintf(`{"txid":"%v","confirmations":10,"blockhash":"%v","blocktime":1562492930,"hex":"%v"}`,
+			mockBitcoindTxA, mockBitcoindBlock, mockBitcoindTxA),
+		"gettransaction/" + mockBitcoindTxB: fmt.Sprintf(`{"txid":"%v","fee":-0.00001,"confirmations":2,"blockhash":"%v","blocktime":1562492930,"hex":"%v"}`,
+			mockBitcoindTxB, mockBitcoindBlock, mockBitcoindTxB),
+		"gettransaction/" + mockBitcoindTxC: fmt.Sprintf(`{"txid":"%v","confirmations":0,"hex":"%v"}`,
+			mockBitcoindTxC, mockBitcoindTxC),
+		"gettransaction/" + mockBitcoindTxD: fmt.Sprintf(`{"txid":"%v","fee":-0.00001,"confirmations":0,"hex":"%v"}`,
+			mockBitcoindTxD, mockBitcoindTxD),
+
+		"decoderawtransaction/" + mockBitcoindTxA: fmt.Sprintf(`{"txid":"%v","vin":[{"txid":"%v","vout":0}],"vout":[{"value":0.00093266,"n":0,"scriptPubKey":{"hex":"%v","type":"pubkeyhash","addresses":["%v"]}}]}`,
+			mockBitcoindTxA, mockBitcoindTxC, mockBitcoindScript, mockBitcoindAddress),
+		"decoderawtransaction/" + mockBitcoindTxB: fmt.Sprintf(`{"txid":"%v","vin":[{"txid":"%v","vout":0}],"vout":[{"value":0.00092266,"n":0,"scriptPubKey":{"hex":"76a914000000000000000000000000000000000000000088ac","type":"pubkeyhash","address":"mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw"}},{"value":0,"n":1,"scriptPubKey":{"hex":"6a0474657374","type":"nulldata"}}]}`,
+			mockBitcoindTxB, mockBitcoindTxA),
+		"decoderawtransaction/" + mockBitcoindTxC: fmt.Sprintf(`{"txid":"%v","vin":[{"txid":"%v","vout":0}],"vout":[{"value":0.0005,"n":0,"scriptPubKey":{"hex":"76a914000000000000000000000000000000000000000088ac","type":"pubkeyhash","address":"mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw"}},{"value":0.0001,"n":1,"scriptPubKey":{"hex":"%v","type":"pubkeyhash","address":"%v"}}]}`,
+			mockBitcoindTxC, mockBitcoindTxB, mockBitcoindScript, mockBitcoindAddress),
+		// The send of the other watch-only address.
+		"decoderawtransaction/" + mockBitcoindTxD: fmt.Sprintf(`{"txid":"%v","vin":[{"txid":"%v","vout":0}],"vout":[{"value":0.0004,"n":0,"scriptPubKey":{"hex":"76a914000000000000000000000000000000000000000088ac","type":"pubkeyhash","address":"mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw"}}]}`,
+			mockBitcoindTxD, mockBitcoindTxC),
+
+		"getblockhash":   `"` + mockBitcoindBlock + `"`,
+		"getblockheader": `{"hash":"` + mockBitcoindBlock + `","height":1567884,"time":1562492930}`,
+
+		"estimatesmartfee/2":  `{"feerate":0.00012,"blocks":2}`,
+		"estimatesmartfee/4":  `{"feerate":0.0001,"blocks":4}`,
+		"estimatesmartfee/6":  `{"errors":["Insufficient data or no feerate found"],"blocks":6}`,
+		"estimatesmartfee/10": `{"feerate":0.00001,"blocks":10}`,
+		"sendrawtransaction":  `"` + mockBitcoindTxB + `"`,
+	}
+}
+
+func mockBitcoindChain(results map[string]string) (*BitcoindChain, func()) {
+	ts := mockBitcoindServer(results)
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+	conf := MockConfig()
+	conf.SpvProvider = "bitcoind"
+	conf.Bitcoind = &BitcoindConfig{Host: ts.URL[7:], User: "user", Password: "pass"}
+	return NewChainProxy(log, conf).(*BitcoindChain), ts.Close
+}
+
+func TestBitcoindChainGetUTXO(t *testing.T) {
+	chain, cleanup := mockBitcoindChain(mockBitcoindResults())
+	defer cleanup()
+
+	unspents, err := chain.GetUTXO(mockBitcoindAddress)
+	assert.Nil(t, err)
+	want := []Unspent{
+		{
+			Txid:         mockBitcoindTxC,
+			Vout:         1,
+			Value:        10000,
+			Scriptpubkey: mockBitcoindScript,
+		},
+	}
+	assert.Equal(t, want, unspents)
+	assert.True(t, chain.imported[mockBitcoindAddress])
+}
+
+func TestBitcoindChainGetTxs(t *testing.T) {
+	chain, cleanup := mockBitcoindChain(mockBitcoindResults())
+	defer cleanup()
+
+	txs, err := chain.GetTxs(mockBitcoindAddress)
+	assert.Nil(t, err)
+	want := []Tx{
+		{
+			Txid:        mockBitcoindTxA,
+			Value:       93266,
+			Confirmed:   true,
+			BlockTime:   1562492930,
+			BlockHeight: 1567884,
+		},
+		{
+			Txid:  mockBitcoindTxC,
+			Value: 10000,
+		},
+		{
+			Txid:        mockBitcoindTxB,
+			Fee:         1000,
+			Data:        "test",
+			Value:       -93266,
+			Confirmed:   true,
+			BlockTime:   1562492930,
+			BlockHeight: 1567884,
+		},
+	}
+	assert.Equal(t, want, txs)
+
+	// The decoded txs are cached.
+	_, ok := chain.rawtxs.get(mockBitcoindTxD)
+	assert.True(t, ok)
+}
+
+func TestBitcoindChainWatchAddress(t *testing.T) {
+	results := mockBitcoindResults()
+	chain, cleanup := mockBitcoindChain(results)
+	defer cleanup()
+
+	// The creation time of the address.
+	{
+		ts, err := chain.rescanTime(1562492000)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1562492000), ts)
+
+		assert.Nil(t, chain.WatchAddress(mockBitcoindAddress, 1562492000))
+		assert.Equal(t, int64(1562492000), chain.births[mockBitcoindAddress])
+		assert.True(t, chain.imported[mockBitcoindAddress])
+	}
+
+	// The creation time unknown, rescans from the scan height.
+	{
+		ts, err := chain.rescanTime(0)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), ts)
+
+		chain.conf.Bitcoind.ScanHeight = 1567884
+		ts, err = chain.rescanTime(0)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1562492930), ts)
+	}
+
+	// The node watches the address already.
+	{
+		results["getaddressinfo"] = `{"ismine":false,"iswatchonly":true}`
+		delete(results, "importmulti")
+		assert.Nil(t, chain.WatchAddress("mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw", 0))
+	}
+
+	// Import failed.
+	{
+		results["getaddressinfo"] = `{"ismine":false,"iswatchonly":false}`
+		results["importmulti"] = `[{"success":false,"error":{"code":-4,"message":"Rescan is disabled in pruned mode"}}]`
+		err := chain.WatchAddress("mgGWKSBHY2a2EnK4X6bjBYkGAqGzZkmSnX", 0)
+		assert.NotNil(t, err)
+		assert.False(t, chain.imported["mgGWKSBHY2a2EnK4X6bjBYkGAqGzZkmSnX"])
+	}
+}
+
+func TestBitcoindChainGetFees(t *testing.T) {
+	chain, cleanup := mockBitcoindChain(mockBitcoindResults())
+	defer cleanup()
+
+	fees, err := chain.GetFees()
+	assert.Nil(t, err)
+	want := map[string]float32{
+		"2":  12,
+		"4":  10,
+		"10": 1,
+	}
+	assert.Equal(t, want, fees)
+}
+
+func TestBitcoindChainPushTx(t *testing.T) {
+	chain, cleanup := mockBitcoindChain(mockBitcoindResults())
+	defer cleanup()
+
+	txid, err := chain.PushTx("0100")
+	assert.Nil(t, err)
+	assert.Equal(t, mockBitcoindTxB, txid)
+	assert.Equal(t, "https://blockstream.info/testnet/tx/%v", chain.GetTxLink())
+}
+
+func TestBitcoindChainPushTxRejected(t *testing.T) {
+	results := mockBitcoindResults()
+	results["error/sendrawtransaction"] = `{"code":-26,"message":"min relay fee not met"}`
+	chain, cleanup := mockBitcoindChain(results)
+	defer cleanup()
+
+	_, err := chain.PushTx("0100")
+	rerr, ok := err.(*TxRejectError)
+	assert.True(t, ok)
+	assert.Equal(t, "min relay fee not met", rerr.Reason)
+}
+
+func TestBitcoindChainError(t *testing.T) {
+	results := mockBitcoindResults()
+	delete(results, "sendrawtransaction")
+	delete(results, "importmulti")
+	chain, cleanup := mockBitcoindChain(results)
+	defer cleanup()
+
+	_, err := chain.PushTx("0100")
+	assert.NotNil(t, err)
+	_, ok := err.(*TxRejectError)
+	assert.False(t, ok)
+	_, err = chain.GetUTXO(mockBitcoindAddress)
+	assert.NotNil(t, err)
+	_, err = chain.GetTxs(mockBitcoindAddress)
+	assert.NotNil(t, err)
+}
+
+func TestBitcoindChainBlockError(t *testing.T) {
+	results := mockBitcoindResults()
+	delete(results, "getblockheader")
+	chain, cleanup := mockBitcoindChain(results)
+	defer cleanup()
+
+	_, err := chain.GetTxs(mockBitcoindAddress)
+	assert.NotNil(t, err)
+
+	results["listunspent"] = fmt.Sprintf(`[{"txid":"%v","vout":0,"address":"%v","scriptPubKey":"%v","amount":0.00093266,"confirmations":10}]`,
+		mockBitcoindTxA, mockBitcoindAddress, mockBitcoindScript)
+	_, err = chain.GetUTXO(mockBitcoindAddress)
+	assert.NotNil(t, err)
+}
@@ -186,6 +186,7 @@ func (c *BlockstreamChain) GetTxs(address string) ([]Tx, error) {
 		tx := Tx{
 			Txid:        tx.Txid,
 			Fee:         tx.Fee,
+			VSize:       int64((tx.Weight + 3) / 4),
 			Data:        data,
 			Value:       receivedValue - sentValue,
 			Confirmed:   tx.Status.Confirmed,
@@ -216,22 +217,6 @@ func (c *BlockstreamChain) GetFees() (map[string]float32, error) {
 	return fees, nil
 }
 
-func (c *BlockstreamChain) GetTickers() (map[string]Ticker, error) {
-	httpRsp, err := proto.NewRequest().Get("https://blockchain.info/ticker")
-	if err != nil {
-		return nil, err
-	}
-	if httpRsp.StatusCode() != 200 {
-		return nil, fmt.Errorf("blockstream.get.tickers.rsp.error:%v", httpRsp.StatusCode())
-	}
-
-	tickers := make(map[string]Ticker)
-	if err := httpRsp.Json(&tickers); err != nil {
-		return nil, err
-	}
-	return tickers, nil
-}
-
 // GetTxLink -- get the tx web link.
 func (c *BlockstreamChain) GetTxLink() string {
 	var url string
@@ -257,6 +242,10 @@ func (c *BlockstreamChain) PushTx(hex string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// The 400 is the tx refused by the node, the body is the reason.
+	if httpRsp.StatusCode() == 400 {
+		return "", &TxRejectError{Provider: blockstream, Reason: httpRsp.Body()}
+	}
 	if httpRsp.StatusCode() != 200 {
 		return "", fmt.Errorf("blockstream.push.tx.rsp.error:%v", httpRsp.StatusCode())
 	}
@@ -0,0 +1,283 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"xlog"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltFile        = "wallets.db"
+	boltLockTimeout = 10 * time.Second
+
+	// boltSep -- the key separator, it can't be in the uid or the address.
+	boltSep = "\x00"
+)
+
+var (
+	boltWallets   = []byte("wallets")
+	boltAddresses = []byte("addresses")
+	boltUnspents  = []byte("unspents")
+	boltTxs       = []byte("txs")
+)
+
+// BoltBackend -- the wallets in the embedded transactional KV database.
+// The wallet, addresses, unspents and txs are the separate records in the buckets:
+// wallets(uid), addresses(uid\0address), unspents(uid\0address\0txid:vout) and txs(uid\0address\0txid).
+// The wallet record is without the addresses, and the address record without the unspents and txs.
+// A wallet is saved in one transaction, only the changed records are written.
+type BoltBackend struct {
+	log *xlog.Log
+	dir string
+	db  *bolt.DB
+}
+
+// NewBoltBackend -- creates new BoltBackend.
+func NewBoltBackend(log *xlog.Log) *BoltBackend {
+	return &BoltBackend{log: log}
+}
+
+// Open -- opens the wallets.db in the dir.
+// If the db is new, the json wallets in the dir are imported.
+func (b *BoltBackend) Open(dir string) error {
+	log := b.log
+
+	b.dir = dir
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	db, err := bolt.Open(filepath.Join(dir, boltFile), 0600, &bolt.Options{Timeout: boltLockTimeout})
+	if err != nil {
+		return err
+	}
+	b.db = db
+
+	empty := false
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltWallets, boltAddresses, boltUnspents, boltTxs} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		k, _ := tx.Bucket(boltWallets).Cursor().First()
+		empty = (k == nil)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if empty {
+		jb := NewJSONBackend(log)
+		if err := jb.Open(dir); err != nil {
+			return err
+		}
+		wallets, err := jb.Load()
+		if err != nil {
+			return err
+		}
+		for _, wallet := range wallets {
+			if err := b.Save(wallet); err != nil {
+				return err
+			}
+			log.Info("wallet.backend.bolt.import.json.wallet[%v]", wallet.UID)
+		}
+	}
+	return nil
+}
+
+// Load -- reads all the wallets.
+func (b *BoltBackend) Load() ([]*Wallet, error) {
+	var wallets []*Wallet
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltWallets).ForEach(func(k, v []byte) error {
+			wallet := NewWallet()
+			if err := json.Unmarshal(v, wallet); err != nil {
+				return err
+			}
+			if wallet.Address == nil {
+				wallet.Address = make(map[string]*Address)
+			}
+			if err := boltLoadWallet(tx, wallet); err != nil {
+				return err
+			}
+			wallets = append(wallets, wallet)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// boltLoadWallet -- reads the addresses, unspents and txs records of the wallet.
+func boltLoadWallet(tx *bolt.Tx, wallet *Wallet) error {
+	uid := wallet.UID
+	prefix := []byte(uid + boltSep)
+
+	c := tx.Bucket(boltAddresses).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		address := &Address{}
+		if err := json.Unmarshal(v, address); err != nil {
+			return err
+		}
+		wallet.Address[address.Address] = address
+	}
+
+	// The address of the record key.
+	recordAddress := func(k []byte) (*Address, error) {
+		keys := bytes.SplitN(k[len(prefix):], []byte(boltSep), 2)
+		address, ok := wallet.Address[string(keys[0])]
+		if !ok {
+			return nil, fmt.Errorf("wallet.backend.bolt.wallet[%v].record[%q].address.cant.found", uid, k)
+		}
+		return address, nil
+	}
+
+	c = tx.Bucket(boltUnspents).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		address, err := recordAddress(k)
+		if err != nil {
+			return err
+		}
+		unspent := Unspent{}
+		if err := json.Unmarshal(v, &unspent); err != nil {
+			return err
+		}
+		address.Unspents = append(address.Unspents, unspent)
+	}
+
+	c = tx.Bucket(boltTxs).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		address, err := recordAddress(k)
+		if err != nil {
+			return err
+		}
+		t := Tx{}
+		if err := json.Unmarshal(v, &t); err != nil {
+			return err
+		}
+		address.Txs = append(address.Txs, t)
+	}
+	return nil
+}
+
+// Save -- writes the changed records of the wallet in one transaction, the stale records are deleted.
+func (b *BoltBackend) Save(wallet *Wallet) error {
+	uid := wallet.UID
+
+	// Records.
+	meta, err := boltWalletMeta(wallet)
+	if err != nil {
+		return err
+	}
+	addresses := make(map[string][]byte)
+	unspents := make(map[string][]byte)
+	txs := make(map[string][]byte)
+	for _, addr := range wallet.Address {
+		if err := boltAddressRecords(uid, addr, addresses, unspents, txs); err != nil {
+			return err
+		}
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltWallets).Put([]byte(uid), meta); err != nil {
+			return err
+		}
+		prefix := []byte(uid + boltSep)
+		if err := boltSync(tx.Bucket(boltAddresses), prefix, addresses); err != nil {
+			return err
+		}
+		if err := boltSync(tx.Bucket(boltUnspents), prefix, unspents); err != nil {
+			return err
+		}
+		return boltSync(tx.Bucket(boltTxs), prefix, txs)
+	})
+}
+
+// boltAddressRecords -- adds the records of the address to the addresses, unspents and txs.
+func boltAddressRecords(uid string, addr *Address, addresses, unspents, txs map[string][]byte) error {
+	addr.mu.Lock()
+	defer addr.mu.Unlock()
+
+	prefix := uid + boltSep + addr.Address
+	datas, err := json.Marshal(&Address{Pos: addr.Pos, Address: addr.Address, Balance: addr.Balance})
+	if err != nil {
+		return err
+	}
+	addresses[prefix] = datas
+	for _, unspent := range addr.Unspents {
+		if datas, err = json.Marshal(unspent); err != nil {
+			return err
+		}
+		unspents[fmt.Sprintf("%s%s%s:%d", prefix, boltSep, unspent.Txid, unspent.Vout)] = datas
+	}
+	for _, tx := range addr.Txs {
+		if datas, err = json.Marshal(tx); err != nil {
+			return err
+		}
+		txs[prefix+boltSep+tx.Txid] = datas
+	}
+	return nil
+}
+
+// boltSync -- makes the records with the prefix same as the kvs.
+func boltSync(bucket *bolt.Bucket, prefix []byte, kvs map[string][]byte) error {
+	var stales [][]byte
+	same := make(map[string]bool)
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		datas, ok := kvs[string(k)]
+		if !ok {
+			stales = append(stales, append([]byte{}, k...))
+			continue
+		}
+		if bytes.Equal(datas, v) {
+			same[string(k)] = true
+		}
+	}
+	for _, k := range stales {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	for k, v := range kvs {
+		if same[k] {
+			continue
+		}
+		if err := bucket.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltWalletMeta -- the wallet record without the addresses.
+// The addresses are detached during the marshal, the caller holds the wallet lock.
+func boltWalletMeta(wallet *Wallet) ([]byte, error) {
+	addresses := wallet.Address
+	wallet.Address = nil
+	defer func() { wallet.Address = addresses }()
+	return json.Marshal(wallet)
+}
+
+// Close -- closes the db.
+func (b *BoltBackend) Close() error {
+	if b.db == nil {
+		return nil
+	}
+	return b.db.Close()
+}
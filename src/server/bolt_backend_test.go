@@ -0,0 +1,220 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xlog"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/keyfuse/tokucore/network"
+	"github.com/stretchr/testify/assert"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func mockBoltWallet() *Wallet {
+	wallet := NewWallet()
+	wallet.UID = mockUID
+	wallet.LastPos = 2
+	wallet.SvrMasterPrvKey = mockSvrMasterPrvKey
+	wallet.CliMasterPubKey = mockCliMasterPubKey
+	wallet.Whitelist.Enabled = true
+	wallet.Address["a1"] = &Address{
+		Pos:      1,
+		Address:  "a1",
+		Balance:  Balance{TotalBalance: 3000, UnconfirmedBalance: 1000},
+		Unspents: []Unspent{{Txid: "t1", Vout: 0, Value: 2000, Confirmed: true}, {Txid: "t2", Vout: 1, Value: 1000}},
+		Txs:      []Tx{{Txid: "t1", Value: 2000, Confirmed: true, BlockHeight: 10}, {Txid: "t2", Value: 1000}},
+	}
+	wallet.Address["a2"] = &Address{Pos: 2, Address: "a2"}
+	return wallet
+}
+
+// mockBoltKeys -- returns the number of the keys in the bucket.
+func mockBoltKeys(t *testing.T, db *bolt.DB, bucket []byte) int {
+	n := 0
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			n++
+			return nil
+		})
+	})
+	assert.Nil(t, err)
+	return n
+}
+
+func TestBoltBackend(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	dir := "/tmp/tss-bolt"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	wallet := mockBoltWallet()
+	backend := NewBoltBackend(log)
+
+	// Save.
+	{
+		err := backend.Open(dir)
+		assert.Nil(t, err)
+		err = backend.Save(wallet)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 1, mockBoltKeys(t, backend.db, boltWallets))
+		assert.Equal(t, 2, mockBoltKeys(t, backend.db, boltAddresses))
+		assert.Equal(t, 2, mockBoltKeys(t, backend.db, boltUnspents))
+		assert.Equal(t, 2, mockBoltKeys(t, backend.db, boltTxs))
+	}
+
+	// Update, the spent and dropped records are deleted.
+	{
+		addr := wallet.Address["a1"]
+		addr.Unspents = addr.Unspents[1:]
+		addr.Txs = []Tx{{Txid: "t1", Value: 2000, Confirmed: true, BlockHeight: 10}, {Txid: "t3", Value: -1000}}
+		wallet.LastPos = 3
+		err := backend.Save(wallet)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 1, mockBoltKeys(t, backend.db, boltUnspents))
+		assert.Equal(t, 2, mockBoltKeys(t, backend.db, boltTxs))
+	}
+
+	// Load.
+	{
+		wallets, err := backend.Load()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(wallets))
+		got := wallets[0]
+		assert.Equal(t, mockUID, got.UID)
+		assert.Equal(t, uint32(3), got.LastPos)
+		assert.Equal(t, mockSvrMasterPrvKey, got.SvrMasterPrvKey)
+		assert.True(t, got.Whitelist.Enabled)
+		assert.Equal(t, 2, len(got.Address))
+		assert.Equal(t, wallet.Address["a1"].Balance, got.Address["a1"].Balance)
+		assert.Equal(t, wallet.Address["a1"].Unspents, got.Address["a1"].Unspents)
+		assert.Equal(t, wallet.Address["a1"].Txs, got.Address["a1"].Txs)
+		assert.Equal(t, uint32(2), got.Address["a2"].Pos)
+		assert.Nil(t, got.Address["a2"].Txs)
+	}
+
+	// re-Open.
+	{
+		err := backend.Close()
+		assert.Nil(t, err)
+
+		backend = NewBoltBackend(log)
+		err = backend.Open(dir)
+		assert.Nil(t, err)
+		wallets, err := backend.Load()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(wallets))
+		assert.Equal(t, 2, len(wallets[0].Address["a1"].Txs))
+		backend.Close()
+	}
+}
+
+func TestBoltBackendImportJSON(t *testing.T) {
+	dir := "/tmp/tss-bolt"
+	os.RemoveAll(dir)
+	os.MkdirAll(dir, os.ModePerm)
+	defer os.RemoveAll(dir)
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	err := ioutil.WriteFile(filepath.Join(dir, mockUID+".json"), []byte(mock13888888888Json), 0644)
+	assert.Nil(t, err)
+	want, err := readWalletFile(filepath.Join(dir, mockUID+".json"))
+	assert.Nil(t, err)
+
+	// Imported.
+	{
+		backend := NewBoltBackend(log)
+		err := backend.Open(dir)
+		assert.Nil(t, err)
+		wallets, err := backend.Load()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(wallets))
+		assert.Equal(t, want.CliMasterPubKey, wallets[0].CliMasterPubKey)
+		assert.Equal(t, len(want.Address), len(wallets[0].Address))
+		backend.Close()
+	}
+
+	// The json backend skips the db file.
+	{
+		backend := NewJSONBackend(log)
+		err := backend.Open(dir)
+		assert.Nil(t, err)
+		wallets, err := backend.Load()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(wallets))
+	}
+
+	// Not imported again once the db has wallets.
+	{
+		err := os.Remove(filepath.Join(dir, mockUID+".json"))
+		assert.Nil(t, err)
+		err = ioutil.WriteFile(filepath.Join(dir, "13999999999.json"), []byte(`{"uid":"13999999999"}`), 0644)
+		assert.Nil(t, err)
+
+		backend := NewBoltBackend(log)
+		err = backend.Open(dir)
+		assert.Nil(t, err)
+		wallets, err := backend.Load()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(wallets))
+		assert.Equal(t, mockUID, wallets[0].UID)
+		backend.Close()
+	}
+}
+
+func TestWalletDBBoltBackend(t *testing.T) {
+	uid := "U003"
+	dir := "/tmp/tss-bolt"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	conf := MockConfig()
+	conf.StoreBackend = boltBackend
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// Create and sync.
+	{
+		wdb := NewWalletDB(log, conf)
+		wdb.setChain(newMockChain(log))
+		err := wdb.Open(dir)
+		assert.Nil(t, err)
+		err = wdb.CreateWallet(uid, mockCliMasterPubKey)
+		assert.Nil(t, err)
+		_, err = wdb.NewAddress(uid, "")
+		assert.Nil(t, err)
+		wdb.syncer.Sync()
+		wdb.Close()
+	}
+
+	// re-Open.
+	{
+		wdb := NewWalletDB(log, conf)
+		wdb.setChain(newMockChain(log))
+		err := wdb.Open(dir)
+		assert.Nil(t, err)
+		defer wdb.Close()
+
+		wallet := wdb.Wallet(uid)
+		assert.NotNil(t, wallet)
+		assert.Equal(t, 1, len(wallet.Addresses()))
+		want, err := createSvrChildPubKey(0, wallet.SvrMasterPubKey, network.TestNet)
+		assert.Nil(t, err)
+		got, err := wdb.KeyManager().ChildPubKey(uid, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+	}
+}
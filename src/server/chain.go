@@ -7,6 +7,8 @@
 package server
 
 import (
+	"fmt"
+
 	"xlog"
 )
 
@@ -15,17 +17,61 @@ const (
 	mainnet = "mainnet"
 )
 
+// Spv providers.
+const (
+	blockstream = "blockstream"
+	bitcoind    = "bitcoind"
+	electrum    = "electrum"
+	p2p         = "p2p"
+	composite   = "composite"
+)
+
 // Chain --
 type Chain interface {
 	GetTxs(address string) ([]Tx, error)
 	GetFees() (map[string]float32, error)
 	GetUTXO(address string) ([]Unspent, error)
-	GetTickers() (map[string]Ticker, error)
 	GetTxLink() string
 	PushTx(hex string) (string, error)
 }
 
-// NewChainProxy -- creates new Chain, default provider is blockstream.info.
+// TxRejectError -- the provider received the pushed tx and refused it, the transport errors are not of it.
+type TxRejectError struct {
+	Provider string
+	Reason   string
+}
+
+// Error -- the implementation method for error interface.
+func (e *TxRejectError) Error() string {
+	return fmt.Sprintf("%v.push.tx.rejected:%v", e.Provider, e.Reason)
+}
+
+// NewChainProxy -- creates new Chain by the spv provider, default provider is blockstream.info.
+// The unknown spv provider is refused by the config validation before.
 func NewChainProxy(log *xlog.Log, conf *Config) Chain {
-	return NewBlockstreamChain(log, conf)
+	switch conf.SpvProvider {
+	case bitcoind:
+		return NewBitcoindChain(log, conf)
+	case electrum:
+		return NewElectrumChain(log, conf)
+	case p2p:
+		return NewP2PChain(log, conf)
+	case composite:
+		return NewCompositeChain(log, conf)
+	default:
+		return NewBlockstreamChain(log, conf)
+	}
+}
+
+// txLink -- returns the tx web link, default is the blockstream.info explorer.
+func txLink(chainnet string, link string) string {
+	if link != "" {
+		return link
+	}
+	switch chainnet {
+	case mainnet:
+		return "https://blockstream.info/tx/%v"
+	default:
+		return "https://blockstream.info/testnet/tx/%v"
+	}
 }
@@ -0,0 +1,237 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CoinControl -- the user state of the unspent, keyed by the outpoint 'txid:vout' in the wallet.
+// It's kept apart from the address unspents which are replaced by the syncer.
+// The frozen one is never spent by the automatic selection, only by the explicit outpoints.
+type CoinControl struct {
+	Frozen    bool   `json:"frozen"`
+	Label     string `json:"label"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// CoinUTXO -- the unspent of the wallet with the confirmations and the coin control.
+type CoinUTXO struct {
+	Pos           uint32
+	Txid          string
+	Vout          uint32
+	Value         uint64
+	Address       string
+	Confirmed     bool
+	BlockHeight   int64
+	Confirmations int64
+	Label         string
+	Frozen        bool
+}
+
+// parseOutpoint -- parses the outpoint 'txid:vout', the txid is lower cased.
+func parseOutpoint(outpoint string) (string, uint32, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 || len(parts[0]) != 64 {
+		return "", 0, fmt.Errorf("outpoint[%v].invalid", outpoint)
+	}
+	for _, c := range parts[0] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return "", 0, fmt.Errorf("outpoint[%v].invalid", outpoint)
+		}
+	}
+	vout, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("outpoint[%v].vout.invalid", outpoint)
+	}
+	return strings.ToLower(parts[0]), uint32(vout), nil
+}
+
+// outpointKey -- the key of the outpoint in the coin control.
+func outpointKey(txid string, vout uint32) string {
+	return fmt.Sprintf("%v:%v", txid, vout)
+}
+
+// CoinUTXOs -- returns all the unspents with the coin control, sorted by the value desc.
+// The confirmations are of the tip height, the best height seen by the wallet if the tip is 0.
+func (w *Wallet) CoinUTXOs(tip int64) []CoinUTXO {
+	w.Lock()
+	defer w.Unlock()
+
+	if tip == 0 {
+		tip = w.bestHeight()
+	}
+	var utxos []CoinUTXO
+	for _, addr := range w.Address {
+		for _, unspent := range addr.Unspents {
+			utxo := CoinUTXO{
+				Pos:         addr.Pos,
+				Txid:        unspent.Txid,
+				Vout:        unspent.Vout,
+				Value:       unspent.Value,
+				Address:     addr.Address,
+				Confirmed:   unspent.Confirmed,
+				BlockHeight: int64(unspent.BlockHeight),
+			}
+			if utxo.Confirmed && utxo.BlockHeight > 0 && tip >= utxo.BlockHeight {
+				utxo.Confirmations = tip - utxo.BlockHeight + 1
+			}
+			if cc, ok := w.Coins[outpointKey(unspent.Txid, unspent.Vout)]; ok {
+				utxo.Label = cc.Label
+				utxo.Frozen = cc.Frozen
+			}
+			utxos = append(utxos, utxo)
+		}
+	}
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].Value != utxos[j].Value {
+			return utxos[i].Value > utxos[j].Value
+		}
+		return outpointKey(utxos[i].Txid, utxos[i].Vout) < outpointKey(utxos[j].Txid, utxos[j].Vout)
+	})
+	return utxos
+}
+
+// FreezeCoins -- freezes or unfreezes the unspents of the outpoints, all or nothing.
+func (w *Wallet) FreezeCoins(outpoints []string, frozen bool) error {
+	w.Lock()
+	defer w.Unlock()
+
+	keys, err := w.coinKeys(outpoints)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, key := range keys {
+		cc := w.coinControl(key)
+		cc.Frozen = frozen
+		cc.UpdatedAt = now
+		w.pruneCoinControl(key)
+	}
+	return nil
+}
+
+// LabelCoin -- sets the label of the unspent, the empty label removes it.
+func (w *Wallet) LabelCoin(outpoint string, label string) error {
+	w.Lock()
+	defer w.Unlock()
+
+	keys, err := w.coinKeys([]string{outpoint})
+	if err != nil {
+		return err
+	}
+	cc := w.coinControl(keys[0])
+	cc.Label = label
+	cc.UpdatedAt = time.Now().Unix()
+	w.pruneCoinControl(keys[0])
+	return nil
+}
+
+// OutpointUnspents -- returns the unspents of the outpoints in order, the frozen ones included since they are picked explicitly.
+func (w *Wallet) OutpointUnspents(outpoints []string) ([]UTXO, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	keys, err := w.coinKeys(outpoints)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := w.utxos(true)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]UTXO)
+	for _, utxo := range utxos {
+		byKey[outpointKey(utxo.Txid, utxo.Vout)] = utxo
+	}
+
+	var rsp []UTXO
+	for _, key := range keys {
+		rsp = append(rsp, byKey[key])
+	}
+	return rsp, nil
+}
+
+// coinKeys -- returns the keys of the outpoints, they must be the distinct unspents of the wallet.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) coinKeys(outpoints []string) ([]string, error) {
+	if len(outpoints) == 0 {
+		return nil, fmt.Errorf("wallet.coin.outpoints.empty")
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	for _, outpoint := range outpoints {
+		txid, vout, err := parseOutpoint(outpoint)
+		if err != nil {
+			return nil, err
+		}
+		key := outpointKey(txid, vout)
+		if seen[key] {
+			return nil, fmt.Errorf("wallet.coin[%v].duplicate", key)
+		}
+		if _, unspent := w.unspent(txid, vout); unspent == nil {
+			return nil, fmt.Errorf("wallet.coin[%v].not.unspent", key)
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// coinControl -- returns the coin control of the key, created if not exists.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) coinControl(key string) *CoinControl {
+	if w.Coins == nil {
+		w.Coins = make(map[string]*CoinControl)
+	}
+	cc, ok := w.Coins[key]
+	if !ok {
+		cc = &CoinControl{}
+		w.Coins[key] = cc
+	}
+	return cc
+}
+
+// pruneCoinControl -- removes the coin control of the key if it's neither frozen nor labeled.
+// The spent ones are kept, a missing unspent from the syncer must not unfreeze it.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) pruneCoinControl(key string) {
+	if cc, ok := w.Coins[key]; ok && !cc.Frozen && cc.Label == "" {
+		delete(w.Coins, key)
+	}
+}
+
+// frozen -- returns true if the unspent is frozen.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) frozen(txid string, vout uint32) bool {
+	cc, ok := w.Coins[outpointKey(txid, vout)]
+	return ok && cc.Frozen
+}
+
+// bestHeight -- returns the best block height seen in the txs and unspents of the wallet.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) bestHeight() int64 {
+	var best int64
+	for _, addr := range w.Address {
+		for _, tx := range addr.Txs {
+			if tx.BlockHeight > best {
+				best = tx.BlockHeight
+			}
+		}
+		for _, unspent := range addr.Unspents {
+			if int64(unspent.BlockHeight) > best {
+				best = int64(unspent.BlockHeight)
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,141 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"proto"
+)
+
+func (h *Handler) walletUTXOs(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletUTXOs", r)
+	if err != nil {
+		log.Error("api.wallet.utxos.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletUTXOsRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].utxos.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].utxos.req:%+v", uid, req)
+
+	utxos, err := wdb.CoinUTXOs(uid)
+	if err != nil {
+		log.Error("api.wallet[%v].utxos.wdb.coin.utxos.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+
+	rsp := []proto.WalletUTXOsResponse{}
+	for _, utxo := range utxos {
+		rsp = append(rsp, proto.WalletUTXOsResponse{
+			Pos:           utxo.Pos,
+			Txid:          utxo.Txid,
+			Vout:          utxo.Vout,
+			Value:         utxo.Value,
+			Address:       utxo.Address,
+			Confirmed:     utxo.Confirmed,
+			BlockHeight:   utxo.BlockHeight,
+			Confirmations: utxo.Confirmations,
+			Label:         utxo.Label,
+			Frozen:        utxo.Frozen,
+		})
+	}
+	log.Info("api.wallet.utxos.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+// walletUTXOFreeze -- the handler of the freeze and unfreeze, the frozen unspents are skipped by the automatic selection.
+func (h *Handler) walletUTXOFreeze(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletUTXOFreeze", r)
+	if err != nil {
+		log.Error("api.wallet.utxos.freeze.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletUTXOFreezeRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].utxos.freeze.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].utxos.freeze.req:%+v", uid, req)
+
+	event := auditWalletFreeze
+	if !req.Frozen {
+		event = auditWalletUnfreeze
+	}
+	entry := &AuditEntry{Event: event, UID: uid, Detail: strings.Join(req.Outpoints, ",")}
+	if err := wdb.FreezeCoins(uid, req.Outpoints, req.Frozen); err != nil {
+		log.Error("api.wallet[%v].utxos.freeze.wdb.freeze.coins.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+	if err := h.auditEvent(r, entry, nil); err != nil {
+		resp.writeError(err)
+		return
+	}
+	rsp := &proto.WalletUTXOFreezeResponse{}
+	log.Info("api.wallet.utxos.freeze.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+func (h *Handler) walletUTXOLabel(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletUTXOLabel", r)
+	if err != nil {
+		log.Error("api.wallet.utxos.label.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletUTXOLabelRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].utxos.label.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].utxos.label.req:%+v", uid, req)
+
+	if err := wdb.LabelCoin(uid, req.Outpoint, req.Label); err != nil {
+		log.Error("api.wallet[%v].utxos.label.wdb.label.coin.error:%+v", uid, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+	rsp := &proto.WalletUTXOLabelResponse{}
+	log.Info("api.wallet.utxos.label.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
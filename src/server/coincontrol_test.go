@@ -0,0 +1,111 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletCoinControl(t *testing.T) {
+	addr := "mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU"
+	big := "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df:0"
+	small := "2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a:1"
+
+	wallet := mockPolicyWallet(t)
+	unspents, err := newMockChain(nil).GetUTXO(addr)
+	assert.Nil(t, err)
+	wallet.UpdateUnspents(addr, unspents)
+
+	// Invalid.
+	{
+		assert.NotNil(t, wallet.FreezeCoins(nil, true))
+		assert.NotNil(t, wallet.FreezeCoins([]string{"xx:0"}, true))
+		assert.NotNil(t, wallet.FreezeCoins([]string{big, "2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a:9"}, true))
+		assert.NotNil(t, wallet.FreezeCoins([]string{big, big}, true))
+		assert.Nil(t, wallet.Coins)
+	}
+
+	// Freeze.
+	{
+		assert.Nil(t, wallet.FreezeCoins([]string{big}, true))
+		assert.Nil(t, wallet.LabelCoin(small, "kyc"))
+
+		utxos := wallet.CoinUTXOs(0)
+		assert.Equal(t, 2, len(utxos))
+		assert.True(t, utxos[0].Frozen)
+		assert.Equal(t, "kyc", utxos[1].Label)
+		assert.False(t, utxos[1].Frozen)
+		assert.Equal(t, int64(1), utxos[0].Confirmations)
+		assert.Equal(t, int64(11), wallet.CoinUTXOs(1567894)[0].Confirmations)
+	}
+
+	// The automatic selections skip the frozen.
+	{
+		utxos, err := wallet.Unspents(66)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(utxos))
+		assert.Equal(t, uint64(10000), utxos[0].Value)
+
+		_, err = wallet.Unspents(20000)
+		assert.NotNil(t, err)
+
+		sel, err := wallet.SelectCoins(66, 1, 1000, "largest")
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(10000), sel.Value)
+
+		// Send all.
+		fees, err := wallet.SendFees(103266, 1, 1000, "")
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(10000), fees.TotalValue)
+		assert.Equal(t, uint64(10000)-fees.Fees, fees.SendableValue)
+
+		fees, err = wallet.SendFees(103266, 1, 1000, "largest")
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(10000)-fees.Fees, fees.SendableValue)
+	}
+
+	// The explicit outpoints, the frozen can be picked.
+	{
+		utxos, err := wallet.OutpointUnspents([]string{small, big})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(utxos))
+		assert.Equal(t, uint64(10000), utxos[0].Value)
+		assert.Equal(t, uint64(93266), utxos[1].Value)
+		assert.NotEqual(t, "", utxos[1].SvrPubKey)
+
+		_, err = wallet.OutpointUnspents([]string{small, small})
+		assert.NotNil(t, err)
+	}
+
+	// Persisted and survives the sync.
+	{
+		datas, err := json.Marshal(wallet)
+		assert.Nil(t, err)
+		restored := mockPolicyWallet(t)
+		assert.Nil(t, json.Unmarshal(datas, restored))
+		restored.UpdateUnspents(addr, nil)
+		restored.UpdateUnspents(addr, unspents)
+
+		utxos := restored.CoinUTXOs(0)
+		assert.True(t, utxos[0].Frozen)
+		assert.Equal(t, "kyc", utxos[1].Label)
+	}
+
+	// Unfreeze and unlabel.
+	{
+		assert.Nil(t, wallet.FreezeCoins([]string{big}, false))
+		assert.Nil(t, wallet.LabelCoin(small, ""))
+		assert.Equal(t, 0, len(wallet.Coins))
+
+		utxos, err := wallet.Unspents(20000)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(93266), utxos[0].Value)
+	}
+}
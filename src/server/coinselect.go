@@ -0,0 +1,324 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"proto"
+)
+
+// The weights of the coin selection, in the weight units, the vsize is the weight/4.
+const (
+	coinTxWeight        = 4 * (4 + 1 + 1 + 4) // version, input count, output count, locktime
+	coinSegwitWeight    = 2                   // the segwit marker and flag
+	coinP2PKHInWeight   = 4 * 148             // outpoint, script len, the sigscript of the compressed pubkey, sequence
+	coinP2WPKHInWeight  = 4*41 + 109          // outpoint, empty sigscript, sequence, and the witness
+	coinP2PKHOutWeight  = 4 * 34
+	coinP2WPKHOutWeight = 4 * 31
+	coinBnBTries        = 100000
+)
+
+// CoinSelection -- the utxos selected and the fees, the change is back to the wallet, 0 is changeless.
+// The value is the sum of the utxos, it's the amount+fees+change.
+type CoinSelection struct {
+	UTXOs  []UTXO
+	Value  uint64
+	Fees   uint64
+	Change uint64
+}
+
+// coin -- the candidate of the selection, the effective value is the value minus the fees of spending it.
+type coin struct {
+	utxo      UTXO
+	weight    int64
+	witness   bool
+	effective int64
+}
+
+// coinSelector -- selects the coins for the amount to the outputs, the recipients are the P2PKH outputs as the worst case.
+type coinSelector struct {
+	amount    uint64
+	outputs   int
+	feesPerKB int
+	coins     []*coin
+}
+
+// newCoinSelector -- creates the selector of the utxos, the uneconomic ones(effective value not positive) are skipped,
+// and only the confirmed ones for the confirmed strategy.
+func newCoinSelector(utxos []UTXO, amount uint64, outputs int, feesPerKB int, strategy string) (*coinSelector, error) {
+	switch strategy {
+	case proto.CoinSelectLargest, proto.CoinSelectSmallest, proto.CoinSelectBnB, proto.CoinSelectPrivacy, proto.CoinSelectConfirmed:
+	default:
+		return nil, fmt.Errorf("coinselect.strategy[%v].unknown", strategy)
+	}
+	if feesPerKB < 0 {
+		return nil, fmt.Errorf("coinselect.feesperkb[%v].invalid", feesPerKB)
+	}
+	if outputs < 1 {
+		return nil, fmt.Errorf("coinselect.outputs[%v].invalid", outputs)
+	}
+
+	s := &coinSelector{amount: amount, outputs: outputs, feesPerKB: feesPerKB}
+	for _, utxo := range utxos {
+		if strategy == proto.CoinSelectConfirmed && !utxo.Confirmed {
+			continue
+		}
+		c := &coin{utxo: utxo, weight: coinP2PKHInWeight}
+		if isP2WPKHScript(utxo.Scriptpubkey) {
+			c.weight = coinP2WPKHInWeight
+			c.witness = true
+		}
+		c.effective = int64(utxo.Value) - int64(s.fees(c.weight))
+		if c.effective <= 0 {
+			continue
+		}
+		s.coins = append(s.coins, c)
+	}
+	// The same utxos, the same selection.
+	sort.SliceStable(s.coins, func(i, j int) bool {
+		ci, cj := s.coins[i], s.coins[j]
+		if ci.effective != cj.effective {
+			return ci.effective > cj.effective
+		}
+		if ci.utxo.Txid != cj.utxo.Txid {
+			return ci.utxo.Txid < cj.utxo.Txid
+		}
+		return ci.utxo.Vout < cj.utxo.Vout
+	})
+	return s, nil
+}
+
+// selectCoins -- selects the utxos for the amount to the outputs by the strategy, the fees are for the feesPerKB.
+func selectCoins(utxos []UTXO, amount uint64, outputs int, feesPerKB int, strategy string) (*CoinSelection, error) {
+	s, err := newCoinSelector(utxos, amount, outputs, feesPerKB, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	var sel *CoinSelection
+	switch strategy {
+	case proto.CoinSelectLargest, proto.CoinSelectConfirmed:
+		sel = s.accumulate(s.coins)
+	case proto.CoinSelectSmallest:
+		coins := make([]*coin, len(s.coins))
+		for i, c := range s.coins {
+			coins[len(coins)-1-i] = c
+		}
+		sel = s.accumulate(coins)
+	case proto.CoinSelectBnB:
+		if sel = s.branchAndBound(); sel == nil {
+			sel = s.accumulate(s.coins)
+		}
+	case proto.CoinSelectPrivacy:
+		sel = s.privacy()
+	}
+	if sel == nil {
+		return nil, fmt.Errorf("coinselect.strategy[%v].amount[%v].feesperkb[%v].insufficient", strategy, amount, feesPerKB)
+	}
+	return sel, nil
+}
+
+// sweepCoins -- selects all the utxos of the strategy to the outputs, the amount is the value minus the fees.
+func sweepCoins(utxos []UTXO, outputs int, feesPerKB int, strategy string) (*CoinSelection, error) {
+	s, err := newCoinSelector(utxos, 0, outputs, feesPerKB, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	sel := &CoinSelection{}
+	weight := s.baseWeight(s.coins)
+	for _, c := range s.coins {
+		sel.UTXOs = append(sel.UTXOs, c.utxo)
+		sel.Value += c.utxo.Value
+		weight += c.weight
+	}
+	sel.Fees = s.fees(weight)
+	if sel.Value <= sel.Fees {
+		return nil, fmt.Errorf("coinselect.strategy[%v].sweep.value[%v].feesperkb[%v].insufficient", strategy, sel.Value, feesPerKB)
+	}
+	return sel, nil
+}
+
+// fees -- the fees of the weight, rounded up to the vsize.
+func (s *coinSelector) fees(weight int64) uint64 {
+	vsize := (weight + 3) / 4
+	return uint64((vsize*int64(s.feesPerKB) + 999) / 1000)
+}
+
+// baseWeight -- the weight of the tx without the inputs, the change output not included.
+func (s *coinSelector) baseWeight(coins []*coin) int64 {
+	weight := int64(coinTxWeight + s.outputs*coinP2PKHOutWeight)
+	for _, c := range coins {
+		if c.witness {
+			weight += coinSegwitWeight
+			break
+		}
+	}
+	return weight
+}
+
+// finalize -- the selection of the coins if they cover the amount and fees, nil if not.
+// The change is added only if it's allowed and not dust after its output fees, otherwise the excess goes to the fees.
+func (s *coinSelector) finalize(coins []*coin, allowChange bool) *CoinSelection {
+	var value uint64
+	weight := s.baseWeight(coins)
+	for _, c := range coins {
+		value += c.utxo.Value
+		weight += c.weight
+	}
+	fees := s.fees(weight)
+	if value < s.amount+fees {
+		return nil
+	}
+	sel := &CoinSelection{Value: value, Fees: value - s.amount}
+	for _, c := range coins {
+		sel.UTXOs = append(sel.UTXOs, c.utxo)
+	}
+	if allowChange {
+		changeFees := s.fees(weight+coinP2WPKHOutWeight) - fees
+		if excess := value - s.amount - fees; excess > changeFees && (excess-changeFees) >= proto.DustLimit {
+			sel.Fees = fees + changeFees
+			sel.Change = excess - changeFees
+		}
+	}
+	return sel
+}
+
+// accumulate -- adds the coins in order until the amount and fees are covered.
+func (s *coinSelector) accumulate(coins []*coin) *CoinSelection {
+	var selected []*coin
+	for _, c := range coins {
+		selected = append(selected, c)
+		if sel := s.finalize(selected, true); sel != nil {
+			return sel
+		}
+	}
+	return nil
+}
+
+// branchAndBound -- searches the changeless coins which effective values are between the target and the target plus
+// the cost of the change(the change output and spending it later), the least waste wins. The coins are sorted by the
+// effective value desc, nil if not found in the tries.
+func (s *coinSelector) branchAndBound() *CoinSelection {
+	base := s.baseWeight(s.coins)
+	target := int64(s.amount + s.fees(base))
+	costOfChange := int64(s.fees(coinP2WPKHOutWeight) + s.fees(coinP2WPKHInWeight))
+
+	var available int64
+	for _, c := range s.coins {
+		available += c.effective
+	}
+	if available < target {
+		return nil
+	}
+
+	var best []bool
+	var bestWaste int64 = -1
+	selected := make([]bool, len(s.coins))
+	var value int64
+	tries := 0
+	var search func(depth int, remaining int64)
+	search = func(depth int, remaining int64) {
+		if tries >= coinBnBTries {
+			return
+		}
+		tries++
+		// Bound.
+		if value > target+costOfChange || value+remaining < target {
+			return
+		}
+		if value >= target {
+			if waste := value - target; bestWaste < 0 || waste < bestWaste {
+				bestWaste = waste
+				best = append(best[:0], selected...)
+			}
+			return
+		}
+		if depth == len(s.coins) {
+			return
+		}
+		c := s.coins[depth]
+		// Inclusion branch first, it's the same as the previous one if the previous coin of the same value is omitted.
+		if depth == 0 || selected[depth-1] || s.coins[depth-1].effective != c.effective {
+			selected[depth] = true
+			value += c.effective
+			search(depth+1, remaining-c.effective)
+			value -= c.effective
+			selected[depth] = false
+		}
+		// Omission branch.
+		search(depth+1, remaining-c.effective)
+	}
+	search(0, available)
+	if best == nil {
+		return nil
+	}
+
+	var coins []*coin
+	for i, ok := range best {
+		if ok {
+			coins = append(coins, s.coins[i])
+		}
+	}
+	return s.finalize(coins, false)
+}
+
+// privacy -- spends the coins of one address together and avoids linking the addresses in one tx.
+// The address which covers the amount alone and has the least value wins, otherwise the addresses are merged
+// by the value desc.
+func (s *coinSelector) privacy() *CoinSelection {
+	var addrs []string
+	groups := make(map[string][]*coin)
+	values := make(map[string]int64)
+	for _, c := range s.coins {
+		addr := c.utxo.Address
+		if _, ok := groups[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+		groups[addr] = append(groups[addr], c)
+		values[addr] += c.effective
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if values[addrs[i]] != values[addrs[j]] {
+			return values[addrs[i]] > values[addrs[j]]
+		}
+		return addrs[i] < addrs[j]
+	})
+
+	// One address.
+	var best *CoinSelection
+	for _, addr := range addrs {
+		if sel := s.finalize(groups[addr], true); sel != nil {
+			best = sel
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	// Merged.
+	var selected []*coin
+	for _, addr := range addrs {
+		selected = append(selected, groups[addr]...)
+		if sel := s.finalize(selected, true); sel != nil {
+			return sel
+		}
+	}
+	return nil
+}
+
+// isP2WPKHScript -- returns true if the locking script hex is the P2WPKH, 'OP_0 <20 bytes>'.
+func isP2WPKHScript(scriptHex string) bool {
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		return false
+	}
+	return len(script) == 22 && script[0] == 0x00 && script[1] == 0x14
+}
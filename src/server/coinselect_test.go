@@ -0,0 +1,166 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	mockP2PKHScript  = "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac"
+	mockP2WPKHScript = "0014490e0eebcc5d462221ea38d00a6aee1238db2a57"
+)
+
+func mockCoinUTXOs() []UTXO {
+	utxo := func(i int, addr string, value uint64, script string, confirmed bool) UTXO {
+		return UTXO{Txid: fmt.Sprintf("%064x", i), Address: addr, Value: value, Scriptpubkey: script, Confirmed: confirmed}
+	}
+	return []UTXO{
+		utxo(1, "a", 100000, mockP2PKHScript, false),
+		utxo(2, "b", 50000, mockP2WPKHScript, true),
+		utxo(3, "b", 20000, mockP2PKHScript, true),
+		utxo(4, "c", 5000, mockP2PKHScript, true),
+		// Dust at the rate.
+		utxo(5, "c", 300, mockP2PKHScript, true),
+	}
+}
+
+func TestCoinSelect(t *testing.T) {
+	feesPerKB := 10000
+	utxos := mockCoinUTXOs()
+	txids := func(sel *CoinSelection) []string {
+		var ids []string
+		for _, utxo := range sel.UTXOs {
+			ids = append(ids, utxo.Txid[62:])
+		}
+		return ids
+	}
+	check := func(sel *CoinSelection, amount uint64) {
+		var value uint64
+		for _, utxo := range sel.UTXOs {
+			value += utxo.Value
+		}
+		assert.Equal(t, value, sel.Value)
+		assert.Equal(t, sel.Value, amount+sel.Fees+sel.Change)
+		assert.True(t, sel.Change == 0 || sel.Change >= proto.DustLimit)
+	}
+
+	// Unknown.
+	{
+		_, err := selectCoins(utxos, 1000, 1, feesPerKB, "random")
+		assert.NotNil(t, err)
+	}
+
+	// Largest.
+	{
+		sel, err := selectCoins(utxos, 60000, 1, feesPerKB, proto.CoinSelectLargest)
+		assert.Nil(t, err)
+		check(sel, 60000)
+		assert.Equal(t, []string{"01"}, txids(sel))
+		// 44+148 vbytes, and the change output of 31 vbytes.
+		assert.Equal(t, uint64(2230), sel.Fees)
+
+		// The batch of 3 outputs.
+		sel, err = selectCoins(utxos, 60000, 3, feesPerKB, proto.CoinSelectLargest)
+		assert.Nil(t, err)
+		check(sel, 60000)
+		assert.Equal(t, uint64(2230+680), sel.Fees)
+
+		_, err = selectCoins(utxos, 60000, 0, feesPerKB, proto.CoinSelectLargest)
+		assert.NotNil(t, err)
+	}
+
+	// Smallest, the dust is skipped.
+	{
+		sel, err := selectCoins(utxos, 60000, 1, feesPerKB, proto.CoinSelectSmallest)
+		assert.Nil(t, err)
+		check(sel, 60000)
+		assert.Equal(t, []string{"04", "03", "02"}, txids(sel))
+	}
+
+	// Confirmed only.
+	{
+		sel, err := selectCoins(utxos, 60000, 1, feesPerKB, proto.CoinSelectConfirmed)
+		assert.Nil(t, err)
+		check(sel, 60000)
+		assert.Equal(t, []string{"02", "03"}, txids(sel))
+
+		_, err = selectCoins(utxos, 80000, 1, feesPerKB, proto.CoinSelectConfirmed)
+		assert.NotNil(t, err)
+	}
+
+	// BnB, changeless.
+	{
+		// The 20000 and 5000, the fees are 44+1 and 148*2 vbytes.
+		amount := uint64(25000 - 3410)
+		sel, err := selectCoins(utxos, amount, 1, feesPerKB, proto.CoinSelectBnB)
+		assert.Nil(t, err)
+		check(sel, amount)
+		assert.Equal(t, []string{"03", "04"}, txids(sel))
+		assert.Equal(t, uint64(0), sel.Change)
+
+		// The excess in the cost of change goes to the fees.
+		sel, err = selectCoins(utxos, amount-500, 1, feesPerKB, proto.CoinSelectBnB)
+		assert.Nil(t, err)
+		check(sel, amount-500)
+		assert.Equal(t, []string{"03", "04"}, txids(sel))
+		assert.Equal(t, uint64(0), sel.Change)
+
+		// Not found, the largest with change.
+		sel, err = selectCoins(utxos, 1000, 1, feesPerKB, proto.CoinSelectBnB)
+		assert.Nil(t, err)
+		check(sel, 1000)
+		assert.Equal(t, []string{"01"}, txids(sel))
+		assert.True(t, sel.Change > 0)
+	}
+
+	// Privacy.
+	{
+		// The address b covers it alone, and less than a.
+		sel, err := selectCoins(utxos, 60000, 1, feesPerKB, proto.CoinSelectPrivacy)
+		assert.Nil(t, err)
+		check(sel, 60000)
+		assert.Equal(t, []string{"02", "03"}, txids(sel))
+
+		// Only the a.
+		sel, err = selectCoins(utxos, 90000, 1, feesPerKB, proto.CoinSelectPrivacy)
+		assert.Nil(t, err)
+		check(sel, 90000)
+		assert.Equal(t, []string{"01"}, txids(sel))
+
+		// Merged by the address value.
+		sel, err = selectCoins(utxos, 120000, 1, feesPerKB, proto.CoinSelectPrivacy)
+		assert.Nil(t, err)
+		check(sel, 120000)
+		assert.Equal(t, []string{"01", "02", "03"}, txids(sel))
+	}
+
+	// Insufficient.
+	{
+		_, err := selectCoins(utxos, 175000, 1, feesPerKB, proto.CoinSelectLargest)
+		assert.NotNil(t, err)
+	}
+
+	// Sweep, the dust is skipped.
+	{
+		sel, err := sweepCoins(utxos, 1, feesPerKB, proto.CoinSelectLargest)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(sel.UTXOs))
+		assert.Equal(t, uint64(175000), sel.Value)
+		// 44.5+148*3+68.25 vbytes.
+		assert.Equal(t, uint64(5570), sel.Fees)
+
+		sel, err = sweepCoins(utxos, 1, feesPerKB, proto.CoinSelectConfirmed)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(sel.UTXOs))
+	}
+}
@@ -0,0 +1,343 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"xlog"
+)
+
+const (
+	compositeDefaultTimeoutMs   = 10 * 1000
+	compositeDefaultMaxFailures = 3
+	compositeDefaultRetryMs     = 60 * 1000
+)
+
+// ChainHealth -- the health of a provider in the composite chain.
+type ChainHealth struct {
+	Provider      string
+	Healthy       bool
+	Failures      int
+	Calls         uint64
+	Errors        uint64
+	LastError     string
+	LastErrorAt   int64
+	LastSuccessAt int64
+	LatencyMs     int64
+}
+
+// compositeMember -- the provider with its health.
+type compositeMember struct {
+	name     string
+	chain    Chain
+	failedAt time.Time
+	health   ChainHealth
+}
+
+// CompositeChain -- the chain wraps several providers.
+// The calls fail over to the next provider on error or timeout, the provider is
+// skipped after max failures in a row until the retry interval passed.
+// If the quorum is more than 1, the UTXO sets must be agreed by the quorum providers.
+type CompositeChain struct {
+	mu          sync.Mutex
+	log         *xlog.Log
+	conf        *Config
+	quorum      int
+	timeout     time.Duration
+	maxFailures int
+	retry       time.Duration
+	members     []*compositeMember
+}
+
+// NewCompositeChain -- creates new CompositeChain with the configured providers.
+func NewCompositeChain(log *xlog.Log, conf *Config) Chain {
+	cconf := conf.Composite
+	if cconf == nil {
+		cconf = &CompositeConfig{}
+	}
+
+	var names []string
+	var chains []Chain
+	for _, provider := range cconf.Providers {
+		pconf := *conf
+		pconf.SpvProvider = provider
+		names = append(names, provider)
+		chains = append(chains, NewChainProxy(log, &pconf))
+	}
+	return newCompositeChain(log, conf, names, chains)
+}
+
+func newCompositeChain(log *xlog.Log, conf *Config, names []string, chains []Chain) *CompositeChain {
+	cconf := conf.Composite
+	if cconf == nil {
+		cconf = &CompositeConfig{}
+	}
+	timeoutMs := cconf.TimeoutMs
+	if timeoutMs <= 0 {
+		timeoutMs = compositeDefaultTimeoutMs
+	}
+	maxFailures := cconf.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = compositeDefaultMaxFailures
+	}
+	retryMs := cconf.RetryMs
+	if retryMs <= 0 {
+		retryMs = compositeDefaultRetryMs
+	}
+
+	c := &CompositeChain{
+		log:         log,
+		conf:        conf,
+		quorum:      cconf.Quorum,
+		timeout:     time.Duration(timeoutMs) * time.Millisecond,
+		maxFailures: maxFailures,
+		retry:       time.Duration(retryMs) * time.Millisecond,
+	}
+	for i, chain := range chains {
+		c.members = append(c.members, &compositeMember{
+			name:   names[i],
+			chain:  chain,
+			health: ChainHealth{Provider: names[i], Healthy: true},
+		})
+	}
+	return c
+}
+
+// Health -- returns the health of all the providers.
+func (c *CompositeChain) Health() []ChainHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var healths []ChainHealth
+	for _, m := range c.members {
+		healths = append(healths, m.health)
+	}
+	return healths
+}
+
+// Close -- closes the providers which keep the connections.
+func (c *CompositeChain) Close() {
+	for _, m := range c.members {
+		if closer, ok := m.chain.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// ordered -- the healthy providers first in the config order, then the unhealthy as the last resort.
+// The unhealthy provider becomes healthy for a try after the retry interval.
+func (c *CompositeChain) ordered() []*compositeMember {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var healthy, unhealthy []*compositeMember
+	now := time.Now()
+	for _, m := range c.members {
+		if !m.health.Healthy && now.Sub(m.failedAt) >= c.retry {
+			m.health.Healthy = true
+		}
+		if m.health.Healthy {
+			healthy = append(healthy, m)
+		} else {
+			unhealthy = append(unhealthy, m)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// do -- calls the fn on the provider with the timeout, and updates the health.
+// The result of the timed out call is dropped.
+func (c *CompositeChain) do(m *compositeMember, method string, fn func(Chain) (interface{}, error)) (interface{}, error) {
+	log := c.log
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	start := time.Now()
+	resCh := make(chan result, 1)
+	go func() {
+		value, err := fn(m.chain)
+		resCh <- result{value: value, err: err}
+	}()
+
+	var res result
+	select {
+	case res = <-resCh:
+	case <-time.After(c.timeout):
+		res.err = fmt.Errorf("composite.provider[%v].%v.timeout", m.name, method)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	health := &m.health
+	health.Calls++
+	health.LatencyMs = int64(time.Since(start) / time.Millisecond)
+
+	// The tx refused is answered by the provider, it's healthy.
+	_, rejected := res.err.(*TxRejectError)
+	if res.err != nil && !rejected {
+		health.Errors++
+		health.Failures++
+		health.LastError = res.err.Error()
+		m.failedAt = time.Now()
+		health.LastErrorAt = m.failedAt.Unix()
+		if health.Healthy && health.Failures >= c.maxFailures {
+			health.Healthy = false
+			log.Warning("composite.provider[%v].unhealthy.failures[%v].last.error:%v", m.name, health.Failures, res.err)
+		}
+		return nil, res.err
+	}
+	health.Failures = 0
+	health.Healthy = true
+	health.LastSuccessAt = time.Now().Unix()
+	return res.value, res.err
+}
+
+// failover -- calls the providers in order until one succeeds, the tx refused by one provider is not retried by the others.
+func (c *CompositeChain) failover(method string, fn func(Chain) (interface{}, error)) (interface{}, error) {
+	log := c.log
+
+	var errs []string
+	for _, m := range c.ordered() {
+		value, err := c.do(m, method, fn)
+		if err == nil {
+			return value, nil
+		}
+		if _, ok := err.(*TxRejectError); ok {
+			return nil, err
+		}
+		log.Error("composite.provider[%v].%v.error:%v", m.name, method, err)
+		errs = append(errs, fmt.Sprintf("%v:%v", m.name, err))
+	}
+	return nil, fmt.Errorf("composite.%v.all.providers.failed[%v]", method, strings.Join(errs, ", "))
+}
+
+// GetUTXO -- used to get all the unspents of this address.
+// With the quorum, all the providers are asked and the set agreed by the quorum returned.
+func (c *CompositeChain) GetUTXO(address string) ([]Unspent, error) {
+	log := c.log
+
+	getUTXO := func(chain Chain) (interface{}, error) {
+		return chain.GetUTXO(address)
+	}
+	if c.quorum <= 1 {
+		value, err := c.failover("getutxo", getUTXO)
+		if err != nil {
+			return nil, err
+		}
+		return value.([]Unspent), nil
+	}
+
+	type result struct {
+		utxos []Unspent
+		err   error
+	}
+	members := c.ordered()
+	results := make([]result, len(members))
+
+	var wg sync.WaitGroup
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m *compositeMember) {
+			defer wg.Done()
+			value, err := c.do(m, "getutxo", getUTXO)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			results[i].utxos = value.([]Unspent)
+		}(i, m)
+	}
+	wg.Wait()
+
+	// Count the agreements by the outpoints and values, the first in order wins.
+	votes := make(map[string]int)
+	keys := make([]string, len(results))
+	for i, r := range results {
+		if r.err != nil {
+			log.Error("composite.provider[%v].getutxo.error:%v", members[i].name, r.err)
+			continue
+		}
+		keys[i] = compositeUTXOKey(r.utxos)
+		votes[keys[i]]++
+	}
+	for i, r := range results {
+		if r.err == nil && votes[keys[i]] >= c.quorum {
+			return r.utxos, nil
+		}
+	}
+	return nil, fmt.Errorf("composite.address[%v].utxo.quorum[%v].not.reached", address, c.quorum)
+}
+
+// compositeUTXOKey -- the canonical key of the UTXO set.
+func compositeUTXOKey(utxos []Unspent) string {
+	var outpoints []string
+	for _, utxo := range utxos {
+		outpoints = append(outpoints, fmt.Sprintf("%v:%v:%v", utxo.Txid, utxo.Vout, utxo.Value))
+	}
+	sort.Strings(outpoints)
+	return strings.Join(outpoints, ",")
+}
+
+// GetTxs -- used to get transactions by address.
+func (c *CompositeChain) GetTxs(address string) ([]Tx, error) {
+	value, err := c.failover("gettxs", func(chain Chain) (interface{}, error) {
+		return chain.GetTxs(address)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]Tx), nil
+}
+
+// WatchAddress -- passes the address to the providers which track the addresses, the errors are logged only
+// since the other providers can serve the address.
+func (c *CompositeChain) WatchAddress(address string, createdAt int64) error {
+	log := c.log
+
+	for _, m := range c.members {
+		if watcher, ok := m.chain.(interface{ WatchAddress(string, int64) error }); ok {
+			if err := watcher.WatchAddress(address, createdAt); err != nil {
+				log.Error("composite.provider[%v].watch.address[%v].error:%v", m.name, address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetFees -- used to get the fees.
+func (c *CompositeChain) GetFees() (map[string]float32, error) {
+	value, err := c.failover("getfees", func(chain Chain) (interface{}, error) {
+		return chain.GetFees()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]float32), nil
+}
+
+// GetTxLink -- get the tx web link of the first provider.
+func (c *CompositeChain) GetTxLink() string {
+	return c.members[0].chain.GetTxLink()
+}
+
+// PushTx -- broadcasts the tx by the first provider succeeds.
+func (c *CompositeChain) PushTx(hex string) (string, error) {
+	value, err := c.failover("pushtx", func(chain Chain) (interface{}, error) {
+		return chain.PushTx(hex)
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
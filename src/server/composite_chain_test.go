@@ -0,0 +1,236 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"proto"
+	"xlog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockScriptChain -- the mockChain with the scripted utxos, error and delay.
+type mockScriptChain struct {
+	*mockChain
+	mu    sync.Mutex
+	utxos []Unspent
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func newMockScriptChain(log *xlog.Log, utxos []Unspent, err error) *mockScriptChain {
+	return &mockScriptChain{mockChain: newMockChain(log), utxos: utxos, err: err}
+}
+
+func (c *mockScriptChain) set(utxos []Unspent, err error, delay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.utxos, c.err, c.delay = utxos, err, delay
+}
+
+func (c *mockScriptChain) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func (c *mockScriptChain) GetUTXO(address string) ([]Unspent, error) {
+	c.mu.Lock()
+	c.calls++
+	utxos, err, delay := c.utxos, c.err, c.delay
+	c.mu.Unlock()
+
+	time.Sleep(delay)
+	return utxos, err
+}
+
+func (c *mockScriptChain) PushTx(hex string) (string, error) {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return c.mockChain.PushTx(hex)
+}
+
+func mockCompositeChain(log *xlog.Log, quorum int, names []string, chains ...Chain) *CompositeChain {
+	conf := MockConfig()
+	conf.SpvProvider = "composite"
+	conf.Composite = &CompositeConfig{
+		Providers:   names,
+		Quorum:      quorum,
+		TimeoutMs:   100,
+		MaxFailures: 2,
+		RetryMs:     300,
+	}
+	return newCompositeChain(log, conf, names, chains)
+}
+
+func TestCompositeChainFailover(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	utxos := []Unspent{{Txid: mockBitcoindTxA, Vout: 0, Value: 93266}}
+	a := newMockScriptChain(log, nil, errors.New("mock.a.error"))
+	b := newMockScriptChain(log, utxos, nil)
+	chain := mockCompositeChain(log, 0, []string{"a", "b"}, a, b)
+
+	// Fail over to b.
+	{
+		got, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, utxos, got)
+		assert.Equal(t, 1, a.count())
+
+		health := chain.Health()
+		assert.Equal(t, 2, len(health))
+		assert.Equal(t, "a", health[0].Provider)
+		assert.True(t, health[0].Healthy)
+		assert.Equal(t, 1, health[0].Failures)
+		assert.Equal(t, "mock.a.error", health[0].LastError)
+		assert.True(t, health[1].Healthy)
+		assert.Equal(t, uint64(1), health[1].Calls)
+	}
+
+	// a is unhealthy after max failures, b goes first.
+	{
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, a.count())
+		assert.False(t, chain.Health()[0].Healthy)
+
+		_, err = chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, a.count())
+	}
+
+	// a is retried after the retry interval, and healthy again.
+	{
+		a.set(utxos, nil, 0)
+		time.Sleep(time.Second)
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, a.count())
+		health := chain.Health()[0]
+		assert.True(t, health.Healthy)
+		assert.Equal(t, 0, health.Failures)
+		assert.Equal(t, uint64(2), health.Errors)
+	}
+
+	// Timeout.
+	{
+		a.set(utxos, nil, 500*time.Millisecond)
+		got, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, utxos, got)
+		assert.Equal(t, "composite.provider[a].getutxo.timeout", chain.Health()[0].LastError)
+	}
+
+	// All failed.
+	{
+		a.set(nil, errors.New("mock.a.error"), 0)
+		b.set(nil, errors.New("mock.b.error"), 0)
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.NotNil(t, err)
+		assert.Equal(t, "composite.getutxo.all.providers.failed[a:mock.a.error, b:mock.b.error]", err.Error())
+
+		_, err = chain.PushTx("00")
+		assert.NotNil(t, err)
+
+		// The tx refused is not retried by the others.
+		a.set(nil, &TxRejectError{Provider: "a", Reason: "mock.rejected"}, 0)
+		b.set(nil, nil, 0)
+		_, err = chain.PushTx("00")
+		_, ok := err.(*TxRejectError)
+		assert.True(t, ok)
+		a.set(nil, errors.New("mock.a.error"), 0)
+	}
+
+	// Others.
+	{
+		b.set(nil, nil, 0)
+		txid, err := chain.PushTx("00")
+		assert.Nil(t, err)
+		assert.NotEqual(t, "", txid)
+
+		fees, err := chain.GetFees()
+		assert.Nil(t, err)
+		assert.NotNil(t, fees)
+
+		txs, err := chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(txs))
+		assert.Equal(t, a.GetTxLink(), chain.GetTxLink())
+	}
+}
+
+func TestCompositeChainQuorum(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	utxos1 := []Unspent{{Txid: mockBitcoindTxA, Vout: 0, Value: 93266}, {Txid: mockBitcoindTxC, Vout: 1, Value: 10000}}
+	utxos2 := []Unspent{{Txid: mockBitcoindTxC, Vout: 1, Value: 10000, Confirmed: true}, {Txid: mockBitcoindTxA, Vout: 0, Value: 93266}}
+	stale := []Unspent{{Txid: mockBitcoindTxA, Vout: 0, Value: 93266}}
+
+	a := newMockScriptChain(log, stale, nil)
+	b := newMockScriptChain(log, utxos1, nil)
+	c := newMockScriptChain(log, utxos2, nil)
+	chain := mockCompositeChain(log, 2, []string{"a", "b", "c"}, a, b, c)
+
+	// b and c agree in any order.
+	{
+		got, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, utxos1, got)
+	}
+
+	// c failed, no quorum.
+	{
+		c.set(nil, errors.New("mock.c.error"), 0)
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.NotNil(t, err)
+		assert.Equal(t, "composite.address[mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU].utxo.quorum[2].not.reached", err.Error())
+	}
+
+	// The empty sets agree too.
+	{
+		a.set(nil, nil, 0)
+		c.set([]Unspent{}, nil, 0)
+		got, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(got))
+	}
+}
+
+func TestCompositeChainServerInfo(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	a := newMockScriptChain(log, nil, errors.New("mock.a.error"))
+	b := newMockScriptChain(log, nil, nil)
+	chain := mockCompositeChain(log, 0, []string{"a", "b"}, a, b)
+
+	ts, cleanup := mockServerWithChain(MockConfig(), chain)
+	defer cleanup()
+
+	// Wait the syncer.
+	time.Sleep(200 * time.Millisecond)
+	httpRsp, err := proto.NewRequest().Get(ts.URL + "/api/server/info")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, httpRsp.StatusCode())
+	rsp := &proto.ServerInfoResponse{}
+	httpRsp.Json(rsp)
+	assert.Equal(t, 2, len(rsp.Chains))
+	assert.Equal(t, "a", rsp.Chains[0].Provider)
+	assert.True(t, rsp.Chains[0].Errors > 0)
+	assert.True(t, rsp.Chains[0].LastErrorAt > 0)
+	assert.NotContains(t, httpRsp.Body(), "mock.a.error")
+	assert.Equal(t, "b", rsp.Chains[1].Provider)
+	assert.True(t, rsp.Chains[1].Healthy)
+	assert.True(t, rsp.Chains[1].LastSuccessAt > 0)
+}
@@ -8,30 +8,138 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 )
 
 // SmtpConfig --
+// The wallet backups to the backup_to are encrypted to the backup recipients, the operator X25519 public keys(hex).
 type SmtpConfig struct {
-	Server   string `json:"server"`
-	Port     int    `json:"port"`
-	UserName string `json:"username"`
-	Password string `json:"password"`
-	BackupTo string `json:"backup_to"`
+	Server           string   `json:"server"`
+	Port             int      `json:"port"`
+	UserName         string   `json:"username"`
+	Password         string   `json:"password"`
+	BackupTo         string   `json:"backup_to"`
+	BackupRecipients []string `json:"backup_recipients"`
+}
+
+// BitcoindConfig -- the bitcoind node for the 'bitcoind' spv provider.
+// The scan height is where the node rescans from for the addresses whose creation time is unknown, 0 is the genesis.
+type BitcoindConfig struct {
+	Host       string `json:"host"`
+	User       string `json:"user"`
+	Password   string `json:"password"`
+	Wallet     string `json:"wallet"`
+	ScanHeight int64  `json:"scan_height"`
+	TxLink     string `json:"tx_link"`
+}
+
+// ElectrumConfig -- the electrum server for the 'electrum' spv provider.
+type ElectrumConfig struct {
+	Host       string `json:"host"`
+	TLS        bool   `json:"tls"`
+	SkipVerify bool   `json:"skip_verify"`
+	TxLink     string `json:"tx_link"`
+}
+
+// P2PConfig -- the bitcoin nodes for the 'p2p' spv provider.
+// The headers file should be out of the datadir, which belongs to the wallet store.
+// The scan height is where the addresses whose creation time is unknown are rescanned from, the new addresses
+// are rescanned from their creation.
+type P2PConfig struct {
+	Peers       []string           `json:"peers"`
+	ScanHeight  int64              `json:"scan_height"`
+	HeadersFile string             `json:"headers_file"`
+	Fees        map[string]float32 `json:"fees"`
+	TxLink      string             `json:"tx_link"`
+}
+
+// CompositeConfig -- the providers for the 'composite' spv provider, each uses its own config section.
+// The quorum is the number of the providers must agree on the UTXO set, 0 or 1 means the first succeeds.
+type CompositeConfig struct {
+	Providers   []string `json:"providers"`
+	Quorum      int      `json:"quorum"`
+	TimeoutMs   int      `json:"timeout_ms"`
+	MaxFailures int      `json:"max_failures"`
+	RetryMs     int      `json:"retry_ms"`
+}
+
+// validate -- the providers are required and not nested, the quorum can't be more than the providers.
+func (c *CompositeConfig) validate() error {
+	if c == nil || len(c.Providers) == 0 {
+		return fmt.Errorf("config.composite.providers.empty")
+	}
+	for _, provider := range c.Providers {
+		switch provider {
+		case blockstream, bitcoind, electrum, p2p:
+		default:
+			return fmt.Errorf("config.composite.provider[%v].unknown(blockstream, bitcoind, electrum or p2p)", provider)
+		}
+	}
+	if c.Quorum > len(c.Providers) {
+		return fmt.Errorf("config.composite.quorum[%v].more.than.providers[%v]", c.Quorum, len(c.Providers))
+	}
+	return nil
+}
+
+// PriceFeedConfig -- the price feeds, the median of the feeds is used.
+// The price older than max age(seconds) is stale, the fiat code is the default of the portfolio.
+type PriceFeedConfig struct {
+	Feeds    []string           `json:"feeds"`
+	Static   map[string]float64 `json:"static"`
+	MinFeeds int                `json:"min_feeds"`
+	MaxAge   int64              `json:"max_age"`
+	FiatCode string             `json:"fiat_code"`
+}
+
+// MasterKeyConfig -- the operator master key which wraps the data keys of the server shares.
+// The key file has the hex encoded 32 bytes key, or the key is derived from the passphrase and salt.
+type MasterKeyConfig struct {
+	KeyFile    string `json:"key_file"`
+	Passphrase string `json:"passphrase"`
+	Salt       string `json:"salt"`
+}
+
+// KeyManagerConfig -- the custodian of the server master private keys.
+// The 'wallet'(default) keeps them in the wallet records, 'local' in the <uid>.key files of the key dir
+// wrapped by the master key, and 'socket' asks the key server(threshwallet-keyd) on the unix socket.
+type KeyManagerConfig struct {
+	Type   string `json:"type"`
+	KeyDir string `json:"key_dir"`
+	Socket string `json:"socket"`
+}
+
+// AuditConfig -- the audit log of the key events, default is the audit.log in the datadir.
+// The token is the bearer token of the audit query api, the api is disabled if empty.
+type AuditConfig struct {
+	File  string `json:"file"`
+	Token string `json:"token"`
 }
 
 // Config --
 type Config struct {
-	DataDir              string      `json:"datadir"`
-	ChainNet             string      `json:"chainnet"`
-	Endpoint             string      `json:"endpoint"`
-	TokenSecret          string      `json:"token_secret"`
-	SpvProvider          string      `json:"spv_provider"`
-	EnableVCode          bool        `json:"enable_vcode"`
-	ForceRecover         bool        `json:"force_recover"`
-	VCodeExpired         int         `json:"vcode_expired"`
-	WalletSyncIntervalMs int         `json:"wallet_sync_interval_ms"`
-	Smtp                 *SmtpConfig `json:"smtp"`
+	DataDir              string            `json:"datadir"`
+	ChainNet             string            `json:"chainnet"`
+	Endpoint             string            `json:"endpoint"`
+	TokenSecret          string            `json:"token_secret"`
+	SpvProvider          string            `json:"spv_provider"`
+	StoreBackend         string            `json:"store_backend"`
+	EnableVCode          bool              `json:"enable_vcode"`
+	ForceRecover         bool              `json:"force_recover"`
+	VCodeExpired         int               `json:"vcode_expired"`
+	WalletSyncIntervalMs int               `json:"wallet_sync_interval_ms"`
+	WhitelistDelay       int64             `json:"whitelist_delay"`
+	PolicyDelay          int64             `json:"policy_delay"`
+	Smtp                 *SmtpConfig       `json:"smtp"`
+	Bitcoind             *BitcoindConfig   `json:"bitcoind"`
+	Electrum             *ElectrumConfig   `json:"electrum"`
+	P2P                  *P2PConfig        `json:"p2p"`
+	Composite            *CompositeConfig  `json:"composite"`
+	PriceFeed            *PriceFeedConfig  `json:"price_feed"`
+	Policy               *Policy           `json:"policy"`
+	MasterKey            *MasterKeyConfig  `json:"master_key"`
+	KeyManager           *KeyManagerConfig `json:"key_manager"`
+	Audit                *AuditConfig      `json:"audit"`
 }
 
 // DefaultConfig -- returns default server config.
@@ -41,10 +149,13 @@ func DefaultConfig() *Config {
 		ChainNet:             "testnet",
 		Endpoint:             ":9099",
 		SpvProvider:          "blockstream",
+		StoreBackend:         "json",
 		TokenSecret:          "thresh-wallet-demo-token-secret",
 		EnableVCode:          true,
 		VCodeExpired:         5 * 60,
 		WalletSyncIntervalMs: 30 * 1000,
+		WhitelistDelay:       48 * 60 * 60,
+		PolicyDelay:          48 * 60 * 60,
 	}
 }
 
@@ -69,5 +180,28 @@ func LoadConfig(path string) (*Config, error) {
 	if err := json.Unmarshal([]byte(data), conf); err != nil {
 		return nil, err
 	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
 	return conf, nil
 }
+
+// Validate -- checks the settings which can't be fixed by the defaults, the server refuses to start if it fails.
+// The spv provider must be known, and the backup recipients are required if the smtp is set.
+func (c *Config) Validate() error {
+	switch c.SpvProvider {
+	case blockstream, bitcoind, electrum, p2p:
+	case composite:
+		if err := c.Composite.validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("config.spv_provider[%v].unknown(blockstream, bitcoind, electrum, p2p or composite)", c.SpvProvider)
+	}
+	if c.Smtp != nil {
+		if err := ValidateBackupRecipients(c.Smtp.BackupRecipients); err != nil {
+			return fmt.Errorf("config.smtp.backup_recipients.invalid(the operator X25519 public keys in hex are required for the encrypted backups):%v", err)
+		}
+	}
+	return nil
+}
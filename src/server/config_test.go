@@ -11,6 +11,8 @@ import (
 	"io/ioutil"
 	"testing"
 
+	"xlog"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,18 +43,20 @@ func TestLoadConfig(t *testing.T) {
 		"port": 456,
 		"username": "keyfuse",
 		"password": "keyfuse",
-		"backup_to": "a@gmail.com,b@gmail.com"
+		"backup_to": "a@gmail.com,b@gmail.com",
+		"backup_recipients": ["350a5453afef7e27b09698e5664fa807c2b428d6800168fb38ff91b223fd7c0c"]
 	}
 }
 */
 func TestLoadSmtpConfig(t *testing.T) {
 	conf := DefaultConfig()
 	conf.Smtp = &SmtpConfig{
-		Server:   "smtp.gmail.com",
-		Port:     456,
-		UserName: "keyfuse",
-		Password: "keyfuse",
-		BackupTo: "a@gmail.com,b@gmail.com",
+		Server:           "smtp.gmail.com",
+		Port:             456,
+		UserName:         "keyfuse",
+		Password:         "keyfuse",
+		BackupTo:         "a@gmail.com,b@gmail.com",
+		BackupRecipients: []string{"350a5453afef7e27b09698e5664fa807c2b428d6800168fb38ff91b223fd7c0c"},
 	}
 	b, err := json.MarshalIndent(conf, "", "\t")
 	assert.Nil(t, err)
@@ -63,3 +67,71 @@ func TestLoadSmtpConfig(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, conf, got)
 }
+
+func TestLoadSmtpConfigWithoutRecipients(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Smtp = &SmtpConfig{
+		Server:   "smtp.gmail.com",
+		Port:     456,
+		BackupTo: "a@gmail.com",
+	}
+	b, err := json.MarshalIndent(conf, "", "\t")
+	assert.Nil(t, err)
+	err = ioutil.WriteFile("/tmp/test.json", b, 0644)
+	assert.Nil(t, err)
+
+	_, err = LoadConfig("/tmp/test.json")
+	assert.NotNil(t, err)
+
+	// Invalid key.
+	conf.Smtp.BackupRecipients = []string{"xx"}
+	assert.NotNil(t, conf.Validate())
+
+	// The server refuses to start.
+	conf.Smtp.BackupRecipients = nil
+	handler := NewHandler(xlog.NewStdLog(xlog.Level(xlog.PANIC)), conf)
+	assert.NotNil(t, handler.Init())
+}
+
+func TestLoadConfigUnknownSpvProvider(t *testing.T) {
+	conf := DefaultConfig()
+	conf.SpvProvider = "blockstrem"
+	b, err := json.MarshalIndent(conf, "", "\t")
+	assert.Nil(t, err)
+	err = ioutil.WriteFile("/tmp/test.json", b, 0644)
+	assert.Nil(t, err)
+
+	_, err = LoadConfig("/tmp/test.json")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "blockstrem")
+
+	// The server refuses to start.
+	handler := NewHandler(xlog.NewStdLog(xlog.Level(xlog.PANIC)), conf)
+	assert.NotNil(t, handler.Init())
+}
+
+func TestCompositeConfigValidate(t *testing.T) {
+	conf := DefaultConfig()
+	conf.SpvProvider = "composite"
+
+	// Providers empty.
+	assert.NotNil(t, conf.Validate())
+	conf.Composite = &CompositeConfig{}
+	assert.NotNil(t, conf.Validate())
+
+	// Nested or unknown.
+	conf.Composite.Providers = []string{"blockstream", "composite"}
+	assert.NotNil(t, conf.Validate())
+	conf.Composite.Providers = []string{"blockstream", "xx"}
+	assert.NotNil(t, conf.Validate())
+
+	// Quorum more than the providers.
+	conf.Composite.Providers = []string{"blockstream", "electrum"}
+	conf.Composite.Quorum = 3
+	err := conf.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "quorum[3]")
+
+	conf.Composite.Quorum = 2
+	assert.Nil(t, conf.Validate())
+}
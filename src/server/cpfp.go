@@ -0,0 +1,129 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"proto"
+)
+
+const (
+	// minRelayFeesPerKB -- the child pays its own size at this rate at least.
+	minRelayFeesPerKB = 1000
+)
+
+// CPFP -- the unconfirmed outputs of the parent tx to spend by the child, the child fees make the package
+// of the parent and child to the target rate.
+type CPFP struct {
+	Txid       string
+	Inputs     []UTXO
+	VSize      int64
+	Fees       uint64
+	FeesPerKB  int
+	ChildVSize int64
+	ChildFees  uint64
+}
+
+// CPFP -- returns the unconfirmed outputs of the wallet in the parent tx and the fees of the child to one wallet output,
+// the parent size and fees are of the chain tx.
+func (w *Wallet) CPFP(txid string, feesPerKB int) (*CPFP, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	var parent *Tx
+	var utxos []UTXO
+	share, err := proto.ParseKeyShare(w.svrMasterKey())
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range w.Address {
+		for i := range addr.Txs {
+			if addr.Txs[i].Txid == txid {
+				parent = &addr.Txs[i]
+			}
+		}
+		for _, unspent := range addr.Unspents {
+			if unspent.Txid != txid || unspent.Confirmed {
+				continue
+			}
+			svrchild, err := share.Derive(addr.Pos)
+			if err != nil {
+				return nil, err
+			}
+			utxos = append(utxos, UTXO{
+				Pos:          addr.Pos,
+				Txid:         unspent.Txid,
+				Vout:         unspent.Vout,
+				Value:        unspent.Value,
+				Address:      addr.Address,
+				SvrPubKey:    svrchild.HDPublicKey().ToString(w.net),
+				Scriptpubkey: unspent.Scriptpubkey,
+			})
+		}
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("wallet.cpfp.tx[%v].cant.found", txid)
+	}
+	if parent.Confirmed || parent.BlockHeight > 0 {
+		return nil, fmt.Errorf("wallet.cpfp.tx[%v].confirmed", txid)
+	}
+	if len(utxos) == 0 {
+		return nil, fmt.Errorf("wallet.cpfp.tx[%v].unconfirmed.output.cant.found", txid)
+	}
+	if parent.Fee <= 0 || parent.VSize <= 0 {
+		return nil, fmt.Errorf("wallet.cpfp.tx[%v].fee[%v].vsize[%v].unknown", txid, parent.Fee, parent.VSize)
+	}
+	sort.Slice(utxos, func(i, j int) bool { return utxos[i].Vout < utxos[j].Vout })
+
+	cpfp := &CPFP{
+		Txid:       txid,
+		Inputs:     utxos,
+		VSize:      parent.VSize,
+		Fees:       uint64(parent.Fee),
+		FeesPerKB:  feesPerKB,
+		ChildVSize: childVSize(utxos),
+	}
+	if rate := int64(cpfp.Fees) * 1000 / cpfp.VSize; rate >= int64(feesPerKB) {
+		return nil, fmt.Errorf("wallet.cpfp.tx[%v].feesperkb[%v].not.less.than.target[%v]", txid, rate, feesPerKB)
+	}
+
+	// The package fees minus the parent fees, its own size at the min relay rate at least.
+	packageFees := uint64(((cpfp.VSize+cpfp.ChildVSize)*int64(feesPerKB) + 999) / 1000)
+	cpfp.ChildFees = packageFees - cpfp.Fees
+	if min := uint64((cpfp.ChildVSize*minRelayFeesPerKB + 999) / 1000); cpfp.ChildFees < min {
+		cpfp.ChildFees = min
+	}
+
+	var total uint64
+	for _, utxo := range utxos {
+		total += utxo.Value
+	}
+	if total < cpfp.ChildFees+proto.DustLimit {
+		return nil, fmt.Errorf("wallet.cpfp.tx[%v].outputs.value[%v].not.enough.for.fees[%v]", txid, total, cpfp.ChildFees)
+	}
+	return cpfp, nil
+}
+
+// childVSize -- the vsize of the child which spends the utxos to one P2PKH output as the worst case.
+func childVSize(utxos []UTXO) int64 {
+	var witness bool
+	weight := int64(coinTxWeight + coinP2PKHOutWeight)
+	for _, utxo := range utxos {
+		if isP2WPKHScript(utxo.Scriptpubkey) {
+			weight += coinP2WPKHInWeight
+			witness = true
+		} else {
+			weight += coinP2PKHInWeight
+		}
+	}
+	if witness {
+		weight += coinSegwitWeight
+	}
+	return (weight + 3) / 4
+}
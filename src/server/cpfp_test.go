@@ -0,0 +1,139 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"proto"
+
+	"xlog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	mockCPFPAddress = "mmBRSnFG7o1BX5DaK8Da3xKxvjBh6fzNQq"
+	mockCPFPTxid    = "9d1f6054d9e8710fc13261754a1fd53ab3c27f9bfdb91aa25f62a974470fa465"
+)
+
+// mockCPFPChain -- the mock chain with the low fees incoming tx to the address of the pos 3.
+type mockCPFPChain struct {
+	*mockChain
+}
+
+func (c *mockCPFPChain) GetUTXO(address string) ([]Unspent, error) {
+	if address == mockCPFPAddress {
+		return []Unspent{{Txid: mockCPFPTxid, Vout: 1, Value: 20000, Scriptpubkey: "76a9143e0ed9c3e7b8bff5bd7f2f8f3bd4bc6fcf1e3b5788ac"}}, nil
+	}
+	return c.mockChain.GetUTXO(address)
+}
+
+func (c *mockCPFPChain) GetTxs(address string) ([]Tx, error) {
+	if address == mockCPFPAddress {
+		return []Tx{{Txid: mockCPFPTxid, Fee: 200, VSize: 200, Value: 20000}}, nil
+	}
+	return c.mockChain.GetTxs(address)
+}
+
+func TestWalletCPFP(t *testing.T) {
+	chain := &mockCPFPChain{mockChain: newMockChain(nil)}
+	wallet := mockPolicyWallet(t)
+	for _, addr := range []string{mockCPFPAddress, "mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU"} {
+		unspents, err := chain.GetUTXO(addr)
+		assert.Nil(t, err)
+		wallet.UpdateUnspents(addr, unspents)
+		txs, err := chain.GetTxs(addr)
+		assert.Nil(t, err)
+		wallet.UpdateTxs(addr, txs)
+	}
+
+	// Child of the P2PKH input and output: 192 vbytes, the package 392 vbytes at 5 sat/vbyte.
+	{
+		cpfp, err := wallet.CPFP(mockCPFPTxid, 5000)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(cpfp.Inputs))
+		assert.Equal(t, uint32(3), cpfp.Inputs[0].Pos)
+		assert.NotEqual(t, "", cpfp.Inputs[0].SvrPubKey)
+		assert.Equal(t, int64(192), cpfp.ChildVSize)
+		assert.Equal(t, uint64(200), cpfp.Fees)
+		assert.Equal(t, uint64(1960-200), cpfp.ChildFees)
+	}
+
+	// The parent rate isn't less than the target.
+	{
+		_, err := wallet.CPFP(mockCPFPTxid, 1000)
+		assert.NotNil(t, err)
+	}
+
+	// The outputs can't pay the fees.
+	{
+		_, err := wallet.CPFP(mockCPFPTxid, 100000)
+		assert.NotNil(t, err)
+	}
+
+	// Unknown, or no unconfirmed output of the wallet.
+	{
+		_, err := wallet.CPFP("xx", 5000)
+		assert.NotNil(t, err)
+		_, err = wallet.CPFP("0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df", 5000)
+		assert.NotNil(t, err)
+	}
+
+	// The parent fee unknown.
+	{
+		wallet.UpdateTxs(mockCPFPAddress, []Tx{{Txid: mockCPFPTxid, Value: 20000}})
+		_, err := wallet.CPFP(mockCPFPTxid, 5000)
+		assert.NotNil(t, err)
+	}
+
+	// Confirmed.
+	{
+		wallet.UpdateTxs(mockCPFPAddress, []Tx{{Txid: mockCPFPTxid, Fee: 200, VSize: 200, Confirmed: true, BlockHeight: 1568858}})
+		_, err := wallet.CPFP(mockCPFPTxid, 5000)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestWalletCPFPHandler(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	ts, cleanup := mockServerWithChain(MockConfig(), &mockCPFPChain{mockChain: newMockChain(log)})
+	defer cleanup()
+
+	// Wait the syncer.
+	time.Sleep(200 * time.Millisecond)
+
+	// CPFP.
+	{
+		req := &proto.WalletCPFPRequest{
+			Txid:      mockCPFPTxid,
+			FeesPerKB: 5000,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/cpfp", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		rsp := &proto.WalletCPFPResponse{}
+		httpRsp.Json(rsp)
+		assert.Equal(t, 1, len(rsp.Inputs))
+		assert.Equal(t, mockCPFPAddress, rsp.Inputs[0].Address)
+		assert.Equal(t, int64(200), rsp.VSize)
+		assert.Equal(t, uint64(1760), rsp.ChildFees)
+	}
+
+	// The fees of the priority are less than the parent.
+	{
+		req := &proto.WalletCPFPRequest{
+			Txid:     mockCPFPTxid,
+			Priority: "slow",
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/cpfp", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+}
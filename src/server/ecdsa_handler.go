@@ -7,12 +7,18 @@
 package server
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"proto"
 )
 
+const (
+	ecdsaBatchMax = 256
+)
+
 // ecdsaR2 -- the handler of creating R2 of two party.
 func (h *Handler) ecdsaR2(w http.ResponseWriter, r *http.Request) {
 	log := h.log
@@ -33,27 +39,61 @@ func (h *Handler) ecdsaR2(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Error("api.ecdsa.r2.req.decode.error:%+v", err)
 		resp.writeError(err)
+		return
 	}
 	log.Info("api.ecdsa.r2.req:%+v", req)
+	entry := &AuditEntry{
+		Event:   auditEcdsaR2,
+		UID:     uid,
+		Pos:     req.Pos,
+		SigHash: hex.EncodeToString(req.Hash),
+		Detail:  fmt.Sprintf("idx:%v", req.Idx),
+	}
+
+	// Check the tx.
+	if err := wdb.CheckSignTx(uid, req.Tx, req.Idx, req.Pos, req.Hash); err != nil {
+		log.Error("api.ecdsa.r2[%v].check.tx.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		writeCheckTxError(resp, err)
+		return
+	}
 
-	// Master Private Key.
-	masterPrvKey, err := wdb.MasterPrvKey(uid)
+	// Ring-pedersen.
+	rp, err := h.ringPedersen(req.RingPedersen)
 	if err != nil {
-		log.Error("api.ecdsa.r2[%v].master.prvkey.error:%+v", uid, err)
+		log.Error("api.ecdsa.r2[%v].ring.pedersen.error:%+v", uid, err)
 		resp.writeError(err)
 		return
 	}
 
+	// Session.
+	session, err := wdb.OpenSignSession(uid, h.deviceID(r), req.Pos, req.Hash)
+	if err != nil {
+		log.Error("api.ecdsa.r2[%v].open.session.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		resp.writeError(err)
+		return
+	}
+	entry.Detail = fmt.Sprintf("idx:%v,session:%v", req.Idx, session.ID)
+
 	// R2.
-	r2, shareR, err := createEcdsaR2(req.Pos, masterPrvKey, req.Hash, req.R1)
+	r2, shareR, err := wdb.KeyManager().EcdsaR2(uid, session.ID, req.Pos, req.Hash, req.R1, req.R1Proof)
 	if err != nil {
 		log.Error("api.ecdsa.r2[%v].create.ecdsar2.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		resp.writeError(err)
+		return
+	}
+	if err := h.auditEvent(r, entry, nil); err != nil {
 		resp.writeError(err)
 		return
 	}
 	rsp := &proto.EcdsaR2Response{
-		R2:     r2,
-		ShareR: shareR,
+		Session:      session.ID,
+		Expired:      session.Expired,
+		R2:           r2,
+		ShareR:       shareR,
+		RingPedersen: rp,
 	}
 	log.Info("api.ecdsa.r2.rsp:%+v", rsp)
 	resp.writeJSON(rsp)
@@ -82,19 +122,57 @@ func (h *Handler) ecdsaS2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Info("api.ecdsa.s2.req:%+v", req)
+	entry := &AuditEntry{
+		Event:   auditEcdsaS2,
+		UID:     uid,
+		Pos:     req.Pos,
+		SigHash: hex.EncodeToString(req.Hash),
+		Detail:  fmt.Sprintf("idx:%v,session:%v", req.Idx, req.Session),
+	}
 
-	// Master Private Key.
-	masterPrvKey, err := wdb.MasterPrvKey(uid)
-	if err != nil {
-		log.Error("api.ecdsa.s2[%v].master.prvkey.error:%+v", uid, err)
-		resp.writeError(err)
+	// Check the tx.
+	if err := wdb.CheckSignTx(uid, req.Tx, req.Idx, req.Pos, req.Hash); err != nil {
+		log.Error("api.ecdsa.s2[%v].check.tx.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		writeCheckTxError(resp, err)
+		return
+	}
+
+	// Session.
+	if err := wdb.CloseSignSession(uid, h.deviceID(r), req.Session, req.Tx, req.Idx, req.Pos, req.Hash); err != nil {
+		log.Error("api.ecdsa.s2[%v].close.session.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		writeCheckTxError(resp, err)
+		return
+	}
+
+	// The client public key of the proofs.
+	if err := wdb.CheckCliPubKey(uid, req.Pos, req.CliPubKey); err != nil {
+		log.Error("api.ecdsa.s2[%v].check.cli.pubkey.error:%+v", uid, err)
+		wdb.AbortSignSession(uid, req.Hash)
+		h.auditEvent(r, entry, err)
+		resp.writeErrorWithStatus(http.StatusBadRequest, err)
 		return
 	}
 
 	// S2.
-	s2, err := createEcdsaS2(req.Pos, masterPrvKey, req.Hash, req.R1, req.ShareR, req.EncPK1, req.EncPub1)
+	s2, err := wdb.KeyManager().EcdsaS2(uid, req.Session, req.Pos, req.Hash, req.ShareR, req.CliPubKey, req.EncPK1, req.EncPub1, req.Proof)
 	if err != nil {
 		log.Error("api.ecdsa.s2[%v].create.ecdsar2.error:%+v", uid, err)
+		wdb.AbortSignSession(uid, req.Hash)
+		h.auditEvent(r, entry, err)
+		resp.writeError(err)
+		return
+	}
+
+	// Record the spend for the policy.
+	if err := wdb.RecordSpend(uid, req.Tx); err != nil {
+		log.Error("api.ecdsa.s2[%v].record.spend.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		resp.writeError(err)
+		return
+	}
+	if err := h.auditEvent(r, entry, nil); err != nil {
 		resp.writeError(err)
 		return
 	}
@@ -104,3 +182,255 @@ func (h *Handler) ecdsaS2(w http.ResponseWriter, r *http.Request) {
 	log.Info("api.ecdsa.s2.rsp:%+v", rsp)
 	resp.writeJSON(rsp)
 }
+
+// ringPedersen -- the ring-pedersen parameters of the key manager, nil if the client has the same id.
+func (h *Handler) ringPedersen(id string) (*proto.RingPedersen, error) {
+	rp, err := h.wdb.KeyManager().RingPedersen()
+	if err != nil {
+		return nil, err
+	}
+	if id != "" && id == rp.ID() {
+		return nil, nil
+	}
+	return rp, nil
+}
+
+// checkBatchInputs -- the batch must have the inputs of the tx, each input once.
+func checkBatchInputs(tx *proto.Tx, idxs []int) error {
+	if tx == nil {
+		return fmt.Errorf("api.ecdsa.batch.tx.is.nil")
+	}
+	if len(idxs) == 0 || len(idxs) > ecdsaBatchMax {
+		return fmt.Errorf("api.ecdsa.batch.inputs[%v].out.of.range[1, %v]", len(idxs), ecdsaBatchMax)
+	}
+	seen := make(map[int]bool, len(idxs))
+	for _, idx := range idxs {
+		if idx < 0 || idx >= len(tx.Inputs) {
+			return fmt.Errorf("api.ecdsa.batch.idx[%v].out.of.tx.inputs[%v]", idx, len(tx.Inputs))
+		}
+		if seen[idx] {
+			return fmt.Errorf("api.ecdsa.batch.idx[%v].duplicate", idx)
+		}
+		seen[idx] = true
+	}
+	return nil
+}
+
+// ecdsaBatchR2 -- the handler of creating R2 of the inputs of the tx in one request.
+// All or nothing, the sessions opened are expired if one input fails.
+func (h *Handler) ecdsaBatchR2(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("ecdsaBatchR2", r)
+	if err != nil {
+		log.Error("api.ecdsa.batch.r2.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.EcdsaBatchR2Request{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.ecdsa.batch.r2.req.decode.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+	idxs := make([]int, len(req.Inputs))
+	for i, in := range req.Inputs {
+		idxs[i] = in.Idx
+	}
+	if err := checkBatchInputs(req.Tx, idxs); err != nil {
+		log.Error("api.ecdsa.batch.r2[%v].check.inputs.error:%+v", uid, err)
+		resp.writeErrorWithStatus(http.StatusBadRequest, err)
+		return
+	}
+	log.Info("api.ecdsa.batch.r2[%v].inputs[%v]", uid, len(req.Inputs))
+
+	// Ring-pedersen.
+	rp, err := h.ringPedersen(req.RingPedersen)
+	if err != nil {
+		log.Error("api.ecdsa.batch.r2[%v].ring.pedersen.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+
+	did := h.deviceID(r)
+	rsp := &proto.EcdsaBatchR2Response{RingPedersen: rp}
+	for _, in := range req.Inputs {
+		entry := &AuditEntry{
+			Event:   auditEcdsaR2,
+			UID:     uid,
+			Pos:     in.Pos,
+			SigHash: hex.EncodeToString(in.Hash),
+			Detail:  fmt.Sprintf("idx:%v,batch:%v", in.Idx, len(req.Inputs)),
+		}
+
+		// Check the tx.
+		if err := wdb.CheckSignTx(uid, req.Tx, in.Idx, in.Pos, in.Hash); err != nil {
+			log.Error("api.ecdsa.batch.r2[%v].idx[%v].check.tx.error:%+v", uid, in.Idx, err)
+			h.auditEvent(r, entry, err)
+			writeCheckTxError(resp, err)
+			return
+		}
+
+		// Session.
+		session, err := wdb.OpenSignSession(uid, did, in.Pos, in.Hash)
+		if err != nil {
+			log.Error("api.ecdsa.batch.r2[%v].idx[%v].open.session.error:%+v", uid, in.Idx, err)
+			h.auditEvent(r, entry, err)
+			resp.writeError(err)
+			return
+		}
+		entry.Detail = fmt.Sprintf("idx:%v,batch:%v,session:%v", in.Idx, len(req.Inputs), session.ID)
+
+		// R2.
+		r2, shareR, err := wdb.KeyManager().EcdsaR2(uid, session.ID, in.Pos, in.Hash, in.R1, in.R1Proof)
+		if err != nil {
+			log.Error("api.ecdsa.batch.r2[%v].idx[%v].create.ecdsar2.error:%+v", uid, in.Idx, err)
+			h.auditEvent(r, entry, err)
+			resp.writeError(err)
+			return
+		}
+		if err := h.auditEvent(r, entry, nil); err != nil {
+			resp.writeError(err)
+			return
+		}
+		rsp.Inputs = append(rsp.Inputs, proto.EcdsaR2Response{
+			Session: session.ID,
+			Expired: session.Expired,
+			R2:      r2,
+			ShareR:  shareR,
+		})
+	}
+	resp.writeJSON(rsp)
+}
+
+// ecdsaBatchS2 -- the handler of creating S2 of the inputs of the tx in one request.
+// All or nothing, the S2 is returned only if all the inputs are co-signed.
+func (h *Handler) ecdsaBatchS2(w http.ResponseWriter, r *http.Request) {
+	var reserved [][]byte
+
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("ecdsaBatchS2", r)
+	if err != nil {
+		log.Error("api.ecdsa.batch.s2.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.EcdsaBatchS2Request{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.ecdsa.batch.s2[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	idxs := make([]int, len(req.Inputs))
+	for i, in := range req.Inputs {
+		idxs[i] = in.Idx
+	}
+	if err := checkBatchInputs(req.Tx, idxs); err != nil {
+		log.Error("api.ecdsa.batch.s2[%v].check.inputs.error:%+v", uid, err)
+		resp.writeErrorWithStatus(http.StatusBadRequest, err)
+		return
+	}
+	log.Info("api.ecdsa.batch.s2[%v].inputs[%v]", uid, len(req.Inputs))
+
+	abort := func() {
+		for _, hash := range reserved {
+			wdb.AbortSignSession(uid, hash)
+		}
+	}
+	did := h.deviceID(r)
+	entries := make([]*AuditEntry, len(req.Inputs))
+	rsp := &proto.EcdsaBatchS2Response{}
+	for i, in := range req.Inputs {
+		entry := &AuditEntry{
+			Event:   auditEcdsaS2,
+			UID:     uid,
+			Pos:     in.Pos,
+			SigHash: hex.EncodeToString(in.Hash),
+			Detail:  fmt.Sprintf("idx:%v,batch:%v,session:%v", in.Idx, len(req.Inputs), in.Session),
+		}
+		entries[i] = entry
+
+		// Check the tx.
+		if err := wdb.CheckSignTx(uid, req.Tx, in.Idx, in.Pos, in.Hash); err != nil {
+			log.Error("api.ecdsa.batch.s2[%v].idx[%v].check.tx.error:%+v", uid, in.Idx, err)
+			abort()
+			h.auditEvent(r, entry, err)
+			writeCheckTxError(resp, err)
+			return
+		}
+
+		// Session.
+		if err := wdb.CloseSignSession(uid, did, in.Session, req.Tx, in.Idx, in.Pos, in.Hash); err != nil {
+			log.Error("api.ecdsa.batch.s2[%v].idx[%v].close.session.error:%+v", uid, in.Idx, err)
+			abort()
+			h.auditEvent(r, entry, err)
+			writeCheckTxError(resp, err)
+			return
+		}
+		reserved = append(reserved, in.Hash)
+
+		// The client public key of the proofs.
+		if err := wdb.CheckCliPubKey(uid, in.Pos, in.CliPubKey); err != nil {
+			log.Error("api.ecdsa.batch.s2[%v].idx[%v].check.cli.pubkey.error:%+v", uid, in.Idx, err)
+			abort()
+			h.auditEvent(r, entry, err)
+			resp.writeErrorWithStatus(http.StatusBadRequest, err)
+			return
+		}
+
+		// S2.
+		s2, err := wdb.KeyManager().EcdsaS2(uid, in.Session, in.Pos, in.Hash, in.ShareR, in.CliPubKey, in.EncPK1, in.EncPub1, in.Proof)
+		if err != nil {
+			log.Error("api.ecdsa.batch.s2[%v].idx[%v].create.ecdsas2.error:%+v", uid, in.Idx, err)
+			abort()
+			h.auditEvent(r, entry, err)
+			resp.writeError(err)
+			return
+		}
+		rsp.Inputs = append(rsp.Inputs, proto.EcdsaS2Response{S2: s2})
+	}
+
+	// Record the spend for the policy.
+	if err := wdb.RecordSpend(uid, req.Tx); err != nil {
+		log.Error("api.ecdsa.batch.s2[%v].record.spend.error:%+v", uid, err)
+		abort()
+		resp.writeError(err)
+		return
+	}
+	for _, entry := range entries {
+		if err := h.auditEvent(r, entry, nil); err != nil {
+			resp.writeError(err)
+			return
+		}
+	}
+	resp.writeJSON(rsp)
+}
+
+// writeCheckTxError -- the policy violation returns 403 with the rule, others return 400.
+func writeCheckTxError(resp *response, err error) {
+	if perr, ok := err.(*PolicyError); ok {
+		resp.StatusCode = http.StatusForbidden
+		resp.writeJSON(&proto.PolicyViolation{
+			Rule:    perr.Rule,
+			Limit:   perr.Limit,
+			Value:   perr.Value,
+			Address: perr.Address,
+			Message: perr.Error(),
+		})
+		return
+	}
+	resp.writeErrorWithStatus(http.StatusBadRequest, err)
+}
@@ -7,6 +7,8 @@
 package server
 
 import (
+	"encoding/json"
+	"math/big"
 	"testing"
 
 	"proto"
@@ -18,15 +20,40 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func mockSignTx() *proto.Tx {
+	return &proto.Tx{
+		Version: 1,
+		Inputs: []proto.TxIn{
+			{
+				Pos:          2,
+				Txid:         "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df",
+				Vout:         0,
+				Value:        93266,
+				Sequence:     proto.DefaultSequence,
+				Scriptpubkey: "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac",
+			},
+		},
+		Outputs: []proto.TxOut{
+			{
+				Value:  90000,
+				Script: "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac",
+			},
+		},
+	}
+}
+
 func TestEcdsaR2S2Handler(t *testing.T) {
 	var pos uint32
 	var shareR *secp256k1.Scalar
+	var rp *proto.RingPedersen
 
 	ts, cleanup := MockServer()
 	defer cleanup()
 
-	pos = 1
-	hash := []byte{0x01, 0x02, 0x03, 0x04}
+	pos = 2
+	tx := mockSignTx()
+	hash, err := tx.SignatureHash(0)
+	assert.Nil(t, err)
 
 	// Client.
 	climasterkey, err := bip32.NewHDKeyFromString(mockCliMasterPrvKey)
@@ -34,12 +61,13 @@ func TestEcdsaR2S2Handler(t *testing.T) {
 	clichildkey, err := climasterkey.Derive(pos)
 	assert.Nil(t, err)
 	cliprv := clichildkey.PrivateKey()
-	aliceParty := xcrypto.NewEcdsaParty(cliprv)
+	key, err := proto.GeneratePaillierKey()
+	assert.Nil(t, err)
+	aliceParty := proto.NewEcdsaParty(cliprv, key)
 
 	// Phase2.
-	encpk1, encpub1, r1 := aliceParty.Phase2(hash)
-	_ = encpk1
-	_ = encpub1
+	r1, r1proof, err := aliceParty.Phase2(hash, pos)
+	assert.Nil(t, err)
 
 	// Token.
 	{
@@ -51,50 +79,328 @@ func TestEcdsaR2S2Handler(t *testing.T) {
 		assert.Nil(t, err)
 	}
 
-	// R2.
+	r2 := func() *proto.EcdsaR2Response {
+		req := &proto.EcdsaR2Request{
+			Pos:     pos,
+			Tx:      tx,
+			Hash:    hash,
+			R1:      r1,
+			R1Proof: r1proof,
+		}
+		if rp != nil {
+			req.RingPedersen = rp.ID()
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		rsp := &proto.EcdsaR2Response{}
+		err = httpRsp.Json(rsp)
+		assert.Nil(t, err)
+		assert.NotEqual(t, "", rsp.Session)
+		if rp == nil {
+			assert.Nil(t, rsp.RingPedersen.Verify())
+			rp = rsp.RingPedersen
+		} else {
+			assert.Nil(t, rsp.RingPedersen)
+		}
+		return rsp
+	}
+	s2req := func(session string, shareR *secp256k1.Scalar) *proto.EcdsaS2Request {
+		encpk1, encpub1, proof, err := aliceParty.Phase4(rp)
+		assert.Nil(t, err)
+		return &proto.EcdsaS2Request{
+			Session:   session,
+			Pos:       pos,
+			Tx:        tx,
+			Hash:      hash,
+			R1:        r1,
+			EncPK1:    encpk1,
+			EncPub1:   encpub1,
+			ShareR:    shareR,
+			CliPubKey: cliprv.PubKey().Serialize(),
+			Proof:     proof,
+		}
+	}
+	s2 := func(req *proto.EcdsaS2Request) (int, *big.Int) {
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/s2", req)
+		assert.Nil(t, err)
+		rsp := &proto.EcdsaS2Response{}
+		httpRsp.Json(rsp)
+		return httpRsp.StatusCode(), rsp.S2
+	}
+
+	// R2 without the R1 proof.
 	{
 		req := &proto.EcdsaR2Request{
 			Pos:  pos,
+			Tx:   tx,
 			Hash: hash,
 			R1:   r1,
 		}
 		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
 		assert.Nil(t, err)
-		assert.Equal(t, 200, httpRsp.StatusCode())
+		assert.Equal(t, 500, httpRsp.StatusCode())
+	}
 
-		rsp := &proto.EcdsaR2Response{}
-		err = httpRsp.Json(rsp)
+	// S2 error, the session is closed.
+	{
+		rsp := r2()
+		code, _ := s2(s2req(rsp.Session, r1))
+		assert.Equal(t, 500, code)
+		code, _ = s2(s2req(rsp.Session, rsp.ShareR))
+		assert.Equal(t, 400, code)
+	}
+
+	// Unknown session.
+	{
+		code, _ := s2(s2req("unknown", r1))
+		assert.Equal(t, 400, code)
+	}
+
+	// The client public key isn't the address.
+	{
+		rsp := r2()
+		req := s2req(rsp.Session, aliceParty.Phase3(rsp.R2))
+		req.CliPubKey = climasterkey.PublicKey().Serialize()
+		code, _ := s2(req)
+		assert.Equal(t, 400, code)
+	}
+
+	// The range proof of the other value.
+	{
+		rsp := r2()
+		req := s2req(rsp.Session, aliceParty.Phase3(rsp.R2))
+		req.EncPK1, _, err = key.Encrypt(big.NewInt(1))
 		assert.Nil(t, err)
-		shareR = rsp.ShareR
+		code, _ := s2(req)
+		assert.Equal(t, 500, code)
 	}
 
-	// S2.
+	// R2 and S2.
+	{
+		rsp := r2()
+		shareR = aliceParty.Phase3(rsp.R2)
+		assert.Equal(t, rsp.ShareR, shareR)
+		code, sign2 := s2(s2req(rsp.Session, shareR))
+		assert.Equal(t, 200, code)
+
+		svrmasterkey, err := bip32.NewHDKeyFromString(mockSvrMasterPrvKey)
+		assert.Nil(t, err)
+		svrchildkey, err := svrmasterkey.Derive(pos)
+		assert.Nil(t, err)
+		sharepub := aliceParty.Phase1(svrchildkey.PublicKey())
+		sig, err := aliceParty.Phase5(shareR, sign2)
+		assert.Nil(t, err)
+		assert.Nil(t, xcrypto.EcdsaVerify(sharepub, hash, sig))
+
+		// Once, and the hash is co-signed.
+		code, _ = s2(s2req(rsp.Session, shareR))
+		assert.Equal(t, 403, code)
+	}
+
+	// The hash is co-signed, replay refused.
 	{
-		req := &proto.EcdsaS2Request{
+		req := &proto.EcdsaR2Request{
 			Pos:     pos,
+			Tx:      tx,
 			Hash:    hash,
 			R1:      r1,
-			EncPK1:  encpk1,
-			EncPub1: encpub1,
-			ShareR:  shareR,
+			R1Proof: r1proof,
 		}
-		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/s2", req)
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 403, httpRsp.StatusCode())
+		violation := &proto.PolicyViolation{}
+		err = json.Unmarshal([]byte(httpRsp.Body()), violation)
+		assert.Nil(t, err)
+		assert.Equal(t, PolicyResign, violation.Rule)
+	}
+}
+
+func TestEcdsaR2CheckTxHandler(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	tx := mockSignTx()
+	hash, err := tx.SignatureHash(0)
+	assert.Nil(t, err)
+
+	climasterkey, err := bip32.NewHDKeyFromString(mockCliMasterPrvKey)
+	assert.Nil(t, err)
+	clichildkey, err := climasterkey.Derive(2)
+	assert.Nil(t, err)
+	aliceParty := proto.NewEcdsaParty(clichildkey.PrivateKey(), nil)
+	r1, _, err := aliceParty.Phase2(hash, 2)
+	assert.Nil(t, err)
+
+	// Without tx.
+	{
+		req := &proto.EcdsaR2Request{
+			Pos:  2,
+			Hash: hash,
+			R1:   r1,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+
+	// Hash mismatch.
+	{
+		req := &proto.EcdsaR2Request{
+			Pos:  2,
+			Tx:   tx,
+			Hash: []byte{0x01, 0x02, 0x03, 0x04},
+			R1:   r1,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+
+	// Pos mismatch.
+	{
+		req := &proto.EcdsaR2Request{
+			Pos:  1,
+			Tx:   tx,
+			Hash: hash,
+			R1:   r1,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+
+	// Duplicate outpoints, the input value counts twice.
+	{
+		tx := mockSignTx()
+		tx.Inputs = append(tx.Inputs, tx.Inputs[0])
+		tx.Outputs[0].Value = 180000
+		hash, err := tx.SignatureHash(0)
+		assert.Nil(t, err)
+
+		req := &proto.EcdsaR2Request{
+			Pos:  2,
+			Tx:   tx,
+			Hash: hash,
+			R1:   r1,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+		assert.Contains(t, httpRsp.Body(), "duplicate")
+	}
+
+	// Input not belongs to the wallet.
+	{
+		tx := mockSignTx()
+		tx.Inputs[0].Txid = "e0c328bd49e9a1c2ef5f7a1c14f0f9893658f5673fb415ceec1125dcd6641993"
+		hash, err := tx.SignatureHash(0)
+		assert.Nil(t, err)
+
+		req := &proto.EcdsaR2Request{
+			Pos:  2,
+			Tx:   tx,
+			Hash: hash,
+			R1:   r1,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+}
+
+func TestEcdsaBatchHandler(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	tx := mockSignTx()
+	tx.Inputs = append(tx.Inputs, proto.TxIn{
+		Pos:          2,
+		Txid:         "2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a",
+		Vout:         1,
+		Value:        10000,
+		Sequence:     proto.DefaultSequence,
+		Scriptpubkey: "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac",
+	})
+
+	climasterkey, err := bip32.NewHDKeyFromString(mockCliMasterPrvKey)
+	assert.Nil(t, err)
+	clichildkey, err := climasterkey.Derive(2)
+	assert.Nil(t, err)
+	svrmasterkey, err := bip32.NewHDKeyFromString(mockSvrMasterPrvKey)
+	assert.Nil(t, err)
+	svrchildkey, err := svrmasterkey.Derive(2)
+	assert.Nil(t, err)
+
+	var hashes [][]byte
+	var parties []*proto.EcdsaParty
+	r2req := &proto.EcdsaBatchR2Request{Tx: tx}
+	s2req := &proto.EcdsaBatchS2Request{Tx: tx}
+	for i := range tx.Inputs {
+		hash, err := tx.SignatureHash(i)
+		assert.Nil(t, err)
+		key, err := proto.GeneratePaillierKey()
+		assert.Nil(t, err)
+		party := proto.NewEcdsaParty(clichildkey.PrivateKey(), key)
+		r1, r1proof, err := party.Phase2(hash, 2)
+		assert.Nil(t, err)
+		hashes = append(hashes, hash)
+		parties = append(parties, party)
+		r2req.Inputs = append(r2req.Inputs, proto.EcdsaR2Input{Idx: i, Pos: 2, Hash: hash, R1: r1, R1Proof: r1proof})
+		s2req.Inputs = append(s2req.Inputs, proto.EcdsaS2Input{Idx: i, Pos: 2, Hash: hash, R1: r1, CliPubKey: clichildkey.PublicKey().Serialize()})
+	}
+
+	// Duplicate input.
+	{
+		req := &proto.EcdsaBatchR2Request{Tx: tx, Inputs: []proto.EcdsaR2Input{r2req.Inputs[0], r2req.Inputs[0]}}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2/batch", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+
+	// R2.
+	{
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2/batch", r2req)
 		assert.Nil(t, err)
 		assert.Equal(t, 200, httpRsp.StatusCode())
+		rsp := &proto.EcdsaBatchR2Response{}
+		err = httpRsp.Json(rsp)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(rsp.Inputs))
+		assert.Nil(t, rsp.RingPedersen.Verify())
+		for i := range rsp.Inputs {
+			shareR := parties[i].Phase3(rsp.Inputs[i].R2)
+			assert.Equal(t, rsp.Inputs[i].ShareR, shareR)
+			s2req.Inputs[i].Session = rsp.Inputs[i].Session
+			s2req.Inputs[i].ShareR = shareR
+			s2req.Inputs[i].EncPK1, s2req.Inputs[i].EncPub1, s2req.Inputs[i].Proof, err = parties[i].Phase4(rsp.RingPedersen)
+			assert.Nil(t, err)
+		}
 	}
 
-	// S2 error.
+	// S2.
 	{
-		req := &proto.EcdsaS2Request{
-			Pos:     2,
-			Hash:    hash,
-			R1:      r1,
-			EncPK1:  encpk1,
-			EncPub1: encpub1,
-			ShareR:  shareR,
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/s2/batch", s2req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+		rsp := &proto.EcdsaBatchS2Response{}
+		err = httpRsp.Json(rsp)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(rsp.Inputs))
+		for i := range rsp.Inputs {
+			sharepub := parties[i].Phase1(svrchildkey.PublicKey())
+			sig, err := parties[i].Phase5(s2req.Inputs[i].ShareR, rsp.Inputs[i].S2)
+			assert.Nil(t, err)
+			assert.Nil(t, xcrypto.EcdsaVerify(sharepub, hashes[i], sig))
 		}
-		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/s2", req)
+	}
+
+	// Replay.
+	{
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2/batch", r2req)
 		assert.Nil(t, err)
-		assert.Equal(t, 500, httpRsp.StatusCode())
+		assert.Equal(t, 403, httpRsp.StatusCode())
 	}
 }
@@ -0,0 +1,444 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"xlog"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xcore"
+)
+
+const (
+	// electrumRawTxCacheSize -- the max decoded txs cached, the least recently used one is evicted.
+	electrumRawTxCacheSize = 10000
+)
+
+var (
+	// electrumFeeTargets -- the confirmation targets(in blocks) of the estimatefee.
+	electrumFeeTargets = []int{2, 4, 6, 10}
+)
+
+// ElectrumUnspent -- the result of the blockchain.scripthash.listunspent.
+type ElectrumUnspent struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height int64  `json:"height"`
+	Value  uint64 `json:"value"`
+}
+
+// ElectrumHistory -- the result of the blockchain.scripthash.get_history.
+// Height is 0 or -1 for the mempool tx, the fee only returns for the mempool tx.
+type ElectrumHistory struct {
+	TxHash string `json:"tx_hash"`
+	Height int64  `json:"height"`
+	Fee    int64  `json:"fee"`
+}
+
+// electrumScript -- the subscribed scripthash with the results of the status.
+type electrumScript struct {
+	script   []byte
+	epoch    uint64
+	status   string
+	utxos    []Unspent
+	utxosAt  string
+	hasUtxos bool
+	txs      []Tx
+	txsAt    string
+	hasTxs   bool
+}
+
+// ElectrumChain -- the chain backed by an electrum server.
+// The scripthashes are subscribed on the persistent connection, the results are
+// refetched only when the server notifies the status changed.
+type ElectrumChain struct {
+	mu      sync.Mutex
+	log     *xlog.Log
+	conf    *Config
+	net     *network.Network
+	client  *electrumClient
+	scripts map[string]*electrumScript
+	rawtxs  *lruCache
+	times   map[int64]int64
+}
+
+// NewElectrumChain -- creates new ElectrumChain.
+func NewElectrumChain(log *xlog.Log, conf *Config) Chain {
+	var net *network.Network
+
+	econf := conf.Electrum
+	if econf == nil {
+		econf = &ElectrumConfig{}
+	}
+	switch conf.ChainNet {
+	case testnet:
+		net = network.TestNet
+	case mainnet:
+		net = network.MainNet
+	}
+
+	c := &ElectrumChain{
+		log:     log,
+		conf:    conf,
+		net:     net,
+		scripts: make(map[string]*electrumScript),
+		rawtxs:  newLRUCache(electrumRawTxCacheSize),
+		times:   make(map[int64]int64),
+	}
+	c.client = newElectrumClient(econf.Host, econf.TLS, econf.SkipVerify, c.onNotify)
+	return c
+}
+
+// onNotify -- handles the scripthash status notification.
+func (c *ElectrumChain) onNotify(method string, params json.RawMessage) {
+	log := c.log
+
+	if method != "blockchain.scripthash.subscribe" {
+		return
+	}
+	var args []*string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 || args[0] == nil {
+		log.Error("electrum.notify[%v].params[%s].invalid", method, params)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.scripts[*args[0]]; ok {
+		s.status = electrumStatus(args[1])
+	}
+}
+
+// subscribe -- subscribes the address scripthash if not subscribed on the current connection.
+func (c *ElectrumChain) subscribe(address string) (string, *electrumScript, error) {
+	addr, err := xcore.DecodeAddress(address, c.net)
+	if err != nil {
+		return "", nil, err
+	}
+	script, err := addr.LockingScript()
+	if err != nil {
+		return "", nil, err
+	}
+	scripthash := electrumScripthash(script)
+
+	epoch := c.client.Epoch()
+	c.mu.Lock()
+	s, ok := c.scripts[scripthash]
+	if ok && s.epoch == epoch {
+		c.mu.Unlock()
+		return scripthash, s, nil
+	}
+	c.mu.Unlock()
+
+	var status *string
+	if err := c.client.Call(&status, "blockchain.scripthash.subscribe", scripthash); err != nil {
+		return "", nil, err
+	}
+
+	// The notifications may be lost when reconnecting, refetch anyway.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok = c.scripts[scripthash]
+	if !ok {
+		s = &electrumScript{script: script}
+		c.scripts[scripthash] = s
+	}
+	s.epoch = c.client.Epoch()
+	s.status = electrumStatus(status)
+	s.hasUtxos = false
+	s.hasTxs = false
+	return scripthash, s, nil
+}
+
+// blockTime -- returns the timestamp of the block header at the height.
+func (c *ElectrumChain) blockTime(height int64) (int64, error) {
+	c.mu.Lock()
+	t, ok := c.times[height]
+	c.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	var header string
+	if err := c.client.Call(&header, "blockchain.block.header", height); err != nil {
+		return 0, err
+	}
+	data, err := hex.DecodeString(header)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 80 {
+		return 0, fmt.Errorf("electrum.block.header[%v].size[%v].invalid", height, len(data))
+	}
+	t = int64(binary.LittleEndian.Uint32(data[68:72]))
+
+	c.mu.Lock()
+	c.times[height] = t
+	c.mu.Unlock()
+	return t, nil
+}
+
+// getTx -- returns the decoded tx, the txs are cached since they are immutable.
+func (c *ElectrumChain) getTx(txid string) (*rawTx, error) {
+	c.mu.Lock()
+	cached, ok := c.rawtxs.get(txid)
+	c.mu.Unlock()
+	if ok {
+		return cached.(*rawTx), nil
+	}
+
+	var hexstr string
+	if err := c.client.Call(&hexstr, "blockchain.transaction.get", txid); err != nil {
+		return nil, err
+	}
+	tx, err := parseRawTxHex(hexstr)
+	if err != nil {
+		return nil, err
+	}
+	if tx.Txid != txid {
+		return nil, fmt.Errorf("electrum.tx[%v].id.mismatch[%v]", txid, tx.Txid)
+	}
+
+	c.mu.Lock()
+	c.rawtxs.add(txid, tx)
+	c.mu.Unlock()
+	return tx, nil
+}
+
+// GetUTXO -- used to get all the unspents of this address.
+func (c *ElectrumChain) GetUTXO(address string) ([]Unspent, error) {
+	scripthash, s, err := c.subscribe(address)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	status := s.status
+	if s.hasUtxos && s.utxosAt == status {
+		unspents := s.utxos
+		c.mu.Unlock()
+		return unspents, nil
+	}
+	c.mu.Unlock()
+
+	var utxos []ElectrumUnspent
+	if err := c.client.Call(&utxos, "blockchain.scripthash.listunspent", scripthash); err != nil {
+		return nil, err
+	}
+
+	var unspents []Unspent
+	for _, utxo := range utxos {
+		unspent := Unspent{
+			Txid:         utxo.TxHash,
+			Vout:         utxo.TxPos,
+			Value:        utxo.Value,
+			Confirmed:    utxo.Height > 0,
+			Scriptpubkey: hex.EncodeToString(s.script),
+		}
+		if unspent.Confirmed {
+			t, err := c.blockTime(utxo.Height)
+			if err != nil {
+				return nil, fmt.Errorf("electrum.utxo[%v].get.header[%v].error:%v", utxo.TxHash, utxo.Height, err)
+			}
+			unspent.BlockTime = uint32(t)
+			unspent.BlockHeight = uint32(utxo.Height)
+		}
+		unspents = append(unspents, unspent)
+	}
+
+	c.mu.Lock()
+	s.utxos = unspents
+	s.utxosAt = status
+	s.hasUtxos = true
+	c.mu.Unlock()
+	return unspents, nil
+}
+
+// GetTxs -- used to get transactions by address.
+// The txs are not cached if the fee of one is unknown, they are refetched by the next call.
+func (c *ElectrumChain) GetTxs(address string) ([]Tx, error) {
+	log := c.log
+
+	scripthash, s, err := c.subscribe(address)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	status := s.status
+	if s.hasTxs && s.txsAt == status {
+		txs := s.txs
+		c.mu.Unlock()
+		return txs, nil
+	}
+	c.mu.Unlock()
+
+	var history []ElectrumHistory
+	if err := c.client.Call(&history, "blockchain.scripthash.get_history", scripthash); err != nil {
+		return nil, err
+	}
+
+	// Outputs paying to the address.
+	var rawtxs []*rawTx
+	outpoints := make(map[string]uint64)
+	for _, h := range history {
+		tx, err := c.getTx(h.TxHash)
+		if err != nil {
+			return nil, err
+		}
+		for i, out := range tx.Outputs {
+			if string(out.Script) == string(s.script) {
+				outpoints[fmt.Sprintf("%v:%v", tx.Txid, i)] = out.Value
+			}
+		}
+		rawtxs = append(rawtxs, tx)
+	}
+
+	var txs []Tx
+	var partial bool
+	for i, h := range history {
+		var data string
+		var sentValue int64
+		var receivedValue int64
+		var totalOut uint64
+		tx := rawtxs[i]
+
+		for _, in := range tx.Inputs {
+			sentValue += int64(outpoints[fmt.Sprintf("%v:%v", in.Txid, in.Vout)])
+		}
+		for _, out := range tx.Outputs {
+			totalOut += out.Value
+			if string(out.Script) == string(s.script) {
+				receivedValue += int64(out.Value)
+			}
+			if d, ok := opReturnData(out.Script); ok {
+				data = d
+			}
+		}
+
+		fee := h.Fee
+		if h.Height > 0 {
+			totalIn, err := c.inputsValue(tx)
+			if err != nil {
+				log.Error("electrum.txs[%v].get.fee.error:%+v", tx.Txid, err)
+				partial = true
+			} else if totalIn >= totalOut {
+				fee = int64(totalIn - totalOut)
+			}
+		}
+
+		etx := Tx{
+			Txid:      tx.Txid,
+			Fee:       fee,
+			VSize:     tx.vsize(),
+			Data:      data,
+			Value:     receivedValue - sentValue,
+			Confirmed: h.Height > 0,
+		}
+		if etx.Confirmed {
+			t, err := c.blockTime(h.Height)
+			if err != nil {
+				return nil, fmt.Errorf("electrum.txs[%v].get.header[%v].error:%v", tx.Txid, h.Height, err)
+			}
+			etx.BlockTime = t
+			etx.BlockHeight = h.Height
+		}
+		txs = append(txs, etx)
+	}
+	if partial {
+		return txs, nil
+	}
+
+	c.mu.Lock()
+	s.txs = txs
+	s.txsAt = status
+	s.hasTxs = true
+	c.mu.Unlock()
+	return txs, nil
+}
+
+// inputsValue -- returns the sum of the prevouts value of the tx.
+func (c *ElectrumChain) inputsValue(tx *rawTx) (uint64, error) {
+	var total uint64
+	for _, in := range tx.Inputs {
+		prev, err := c.getTx(in.Txid)
+		if err != nil {
+			return 0, err
+		}
+		if int(in.Vout) >= len(prev.Outputs) {
+			return 0, fmt.Errorf("electrum.tx[%v].vout[%v].out.of.range", in.Txid, in.Vout)
+		}
+		total += prev.Outputs[in.Vout].Value
+	}
+	return total, nil
+}
+
+// GetFees -- used to get the fees(satoshis per vbyte) from the blockchain.estimatefee.
+func (c *ElectrumChain) GetFees() (map[string]float32, error) {
+	fees := make(map[string]float32)
+	for _, target := range electrumFeeTargets {
+		var feerate float64
+		if err := c.client.Call(&feerate, "blockchain.estimatefee", target); err != nil {
+			return nil, err
+		}
+		if feerate <= 0 {
+			continue
+		}
+		// BTC/kB to sat/vB.
+		fees[strconv.Itoa(target)] = float32(feerate * 1e8 / 1000)
+	}
+	if len(fees) == 0 {
+		return nil, fmt.Errorf("electrum.estimatefee.no.estimates")
+	}
+	return fees, nil
+}
+
+// GetTxLink -- get the tx web link.
+func (c *ElectrumChain) GetTxLink() string {
+	var link string
+	if c.conf.Electrum != nil {
+		link = c.conf.Electrum.TxLink
+	}
+	return txLink(c.conf.ChainNet, link)
+}
+
+// PushTx -- used to push tx to the chain by blockchain.transaction.broadcast.
+func (c *ElectrumChain) PushTx(hex string) (string, error) {
+	log := c.log
+
+	log.Info("chain.electrum.strart.pushtx.tx:%v", hex)
+	var txid string
+	if err := c.client.Call(&txid, "blockchain.transaction.broadcast", hex); err != nil {
+		if rerr, ok := err.(*electrumError); ok {
+			return "", &TxRejectError{Provider: electrum, Reason: rerr.Message}
+		}
+		return "", err
+	}
+	log.Info("chain.electrum.end.pushtx.txid:%v", txid)
+	return txid, nil
+}
+
+// electrumScripthash -- the reversed sha256 of the locking script.
+func electrumScripthash(script []byte) string {
+	hash := sha256.Sum256(script)
+	return xbase.NewIDToString(hash[:])
+}
+
+// electrumStatus -- the null status means the script has no history.
+func electrumStatus(status *string) string {
+	if status == nil {
+		return ""
+	}
+	return *status
+}
@@ -0,0 +1,426 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"xlog"
+
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xcore"
+	"github.com/keyfuse/tokucore/xvm"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockElectrum -- the fake electrum server, the result is found by the 'method' or 'method/param0'.
+type mockElectrum struct {
+	mu       sync.Mutex
+	ln       net.Listener
+	results  map[string]string
+	calls    map[string]int
+	conns    []net.Conn
+	closedCh chan struct{}
+}
+
+func newMockElectrum(results map[string]string, useTLS bool) *mockElectrum {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	if useTLS {
+		ts := httptest.NewUnstartedServer(nil)
+		ts.StartTLS()
+		ln = tls.NewListener(ln, &tls.Config{Certificates: ts.TLS.Certificates})
+		ts.Close()
+	}
+
+	m := &mockElectrum{
+		ln:      ln,
+		results: results,
+		calls:   make(map[string]int),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			m.conns = append(m.conns, conn)
+			m.mu.Unlock()
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *mockElectrum) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		req := &electrumRequest{}
+		json.Unmarshal(line, req)
+
+		m.mu.Lock()
+		m.calls[req.Method]++
+		str, ok := m.results[req.Method]
+		if len(req.Params) > 0 {
+			if v, has := m.results[fmt.Sprintf("%v/%v", req.Method, req.Params[0])]; has {
+				str, ok = v, has
+			}
+		}
+		m.mu.Unlock()
+
+		var rsp string
+		if ok {
+			rsp = fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"result":%v}`, req.ID, str)
+		} else {
+			rsp = fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"error":{"code":-32601,"message":"unknown method"}}`, req.ID)
+		}
+		conn.Write([]byte(rsp + "\n"))
+	}
+}
+
+func (m *mockElectrum) addr() string {
+	return m.ln.Addr().String()
+}
+
+func (m *mockElectrum) count(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[method]
+}
+
+func (m *mockElectrum) set(key string, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[key] = result
+}
+
+func (m *mockElectrum) notify(method string, params string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, conn := range m.conns {
+		conn.Write([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"%v","params":%v}`, method, params) + "\n"))
+	}
+}
+
+func (m *mockElectrum) dropConns() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+	m.conns = nil
+}
+
+func (m *mockElectrum) close() {
+	m.ln.Close()
+	m.dropConns()
+}
+
+// mockElectrumTx -- builds the raw tx hex and its id.
+func mockElectrumTx(t *testing.T, prev string, vout uint32, outs ...*xcore.TxOut) (string, string) {
+	hash, err := xbase.NewIDFromString(prev)
+	assert.Nil(t, err)
+	script, _ := hex.DecodeString(mockBitcoindScript)
+	in, err := xcore.NewTxIn(hash, vout, 0, script, nil)
+	assert.Nil(t, err)
+
+	tx := xcore.NewTransaction()
+	tx.SetVersion(1)
+	tx.AddInput(in)
+	for _, out := range outs {
+		tx.AddOutput(out)
+	}
+	return hex.EncodeToString(tx.Serialize()), tx.ID()
+}
+
+func mockElectrumHeader(timestamp uint32) string {
+	header := make([]byte, 80)
+	binary.LittleEndian.PutUint32(header[68:72], timestamp)
+	return hex.EncodeToString(header)
+}
+
+type mockElectrumData struct {
+	results    map[string]string
+	scripthash string
+	script     string
+	fund       string
+	spend      string
+}
+
+func mockElectrumResults(t *testing.T) *mockElectrumData {
+	script, _ := hex.DecodeString(mockBitcoindScript)
+	other, _ := hex.DecodeString("76a914000000000000000000000000000000000000000088ac")
+	opreturn, err := xvm.NewScriptBuilder().AddOp(xvm.OP_RETURN).AddData([]byte("test")).Script()
+	assert.Nil(t, err)
+
+	zeroHex, zeroID := mockElectrumTx(t, "1111111111111111111111111111111111111111111111111111111111111111", 0, xcore.NewTxOut(100000, other))
+	fundHex, fundID := mockElectrumTx(t, zeroID, 0, xcore.NewTxOut(93266, script), xcore.NewTxOut(5000, other))
+	spendHex, spendID := mockElectrumTx(t, fundID, 0, xcore.NewTxOut(92266, other), xcore.NewTxOut(0, opreturn))
+	scripthash := electrumScripthash(script)
+
+	results := map[string]string{
+		"server.version":                        `["ElectrumX 1.13.0", "1.4"]`,
+		"blockchain.scripthash.subscribe":       `"status-1"`,
+		"blockchain.scripthash.listunspent":     fmt.Sprintf(`[{"tx_hash":"%v","tx_pos":0,"height":1567884,"value":93266}]`, fundID),
+		"blockchain.scripthash.get_history":     fmt.Sprintf(`[{"tx_hash":"%v","height":1567884},{"tx_hash":"%v","height":0,"fee":1000}]`, fundID, spendID),
+		"blockchain.transaction.get/" + zeroID:  `"` + zeroHex + `"`,
+		"blockchain.transaction.get/" + fundID:  `"` + fundHex + `"`,
+		"blockchain.transaction.get/" + spendID: `"` + spendHex + `"`,
+		"blockchain.block.header":               `"` + mockElectrumHeader(1562492930) + `"`,
+		"blockchain.estimatefee/2":              `0.00012`,
+		"blockchain.estimatefee/4":              `0.0001`,
+		"blockchain.estimatefee/6":              `-1`,
+		"blockchain.estimatefee/10":             `0.00001`,
+		"blockchain.transaction.broadcast":      `"` + spendID + `"`,
+	}
+	return &mockElectrumData{
+		results:    results,
+		scripthash: scripthash,
+		script:     mockBitcoindScript,
+		fund:       fundID,
+		spend:      spendID,
+	}
+}
+
+func mockElectrumChain(addr string, useTLS bool) *ElectrumChain {
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+	conf := MockConfig()
+	conf.SpvProvider = "electrum"
+	conf.Electrum = &ElectrumConfig{Host: addr, TLS: useTLS, SkipVerify: true}
+	return NewChainProxy(log, conf).(*ElectrumChain)
+}
+
+func TestRawTx(t *testing.T) {
+	data := mockElectrumResults(t)
+	var hexstr string
+	json.Unmarshal([]byte(data.results["blockchain.transaction.get/"+data.spend]), &hexstr)
+
+	tx, err := parseRawTxHex(hexstr)
+	assert.Nil(t, err)
+	assert.Equal(t, data.spend, tx.Txid)
+	assert.Equal(t, []rawTxIn{{Txid: data.fund, Vout: 0, Sequence: 0xffffffff}}, tx.Inputs)
+	assert.Equal(t, 2, len(tx.Outputs))
+	d, ok := opReturnData(tx.Outputs[1].Script)
+	assert.True(t, ok)
+	assert.Equal(t, "test", d)
+
+	_, err = parseRawTxHex(hexstr + "00")
+	assert.NotNil(t, err)
+	_, err = parseRawTxHex(hexstr[:20])
+	assert.NotNil(t, err)
+}
+
+func TestElectrumChain(t *testing.T) {
+	data := mockElectrumResults(t)
+	server := newMockElectrum(data.results, false)
+	defer server.close()
+
+	chain := mockElectrumChain(server.addr(), false)
+	defer chain.client.Close()
+
+	// UTXO.
+	{
+		unspents, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		want := []Unspent{
+			{
+				Txid:         data.fund,
+				Vout:         0,
+				Value:        93266,
+				Confirmed:    true,
+				BlockTime:    1562492930,
+				BlockHeight:  1567884,
+				Scriptpubkey: data.script,
+			},
+		}
+		assert.Equal(t, want, unspents)
+	}
+
+	// Txs.
+	{
+		txs, err := chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		want := []Tx{
+			{
+				Txid:        data.fund,
+				Fee:         1734,
+				VSize:       119,
+				Value:       93266,
+				Confirmed:   true,
+				BlockTime:   1562492930,
+				BlockHeight: 1567884,
+			},
+			{
+				Txid:  data.spend,
+				Fee:   1000,
+				VSize: 100,
+				Data:  "test",
+				Value: -93266,
+			},
+		}
+		assert.Equal(t, want, txs)
+	}
+
+	// Fees.
+	{
+		fees, err := chain.GetFees()
+		assert.Nil(t, err)
+		want := map[string]float32{
+			"2":  12,
+			"4":  10,
+			"10": 1,
+		}
+		assert.Equal(t, want, fees)
+	}
+
+	// PushTx.
+	{
+		txid, err := chain.PushTx("0100")
+		assert.Nil(t, err)
+		assert.Equal(t, data.spend, txid)
+		assert.Equal(t, "https://blockstream.info/testnet/tx/%v", chain.GetTxLink())
+	}
+}
+
+func TestElectrumChainSubscribe(t *testing.T) {
+	data := mockElectrumResults(t)
+	server := newMockElectrum(data.results, false)
+	defer server.close()
+
+	chain := mockElectrumChain(server.addr(), false)
+	defer chain.client.Close()
+
+	// Cached until the status changed.
+	{
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		_, err = chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		_, err = chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		_, err = chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, server.count("blockchain.scripthash.subscribe"))
+		assert.Equal(t, 1, server.count("blockchain.scripthash.listunspent"))
+		assert.Equal(t, 1, server.count("blockchain.scripthash.get_history"))
+	}
+
+	// Notify.
+	{
+		server.set("blockchain.scripthash.listunspent", `[]`)
+		server.notify("blockchain.scripthash.subscribe", fmt.Sprintf(`["%v","status-2"]`, data.scripthash))
+		for i := 0; i < 100; i++ {
+			chain.mu.Lock()
+			status := chain.scripts[data.scripthash].status
+			chain.mu.Unlock()
+			if status == "status-2" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		unspents, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(unspents))
+		assert.Equal(t, 2, server.count("blockchain.scripthash.listunspent"))
+	}
+
+	// Reconnect and resubscribe.
+	{
+		server.dropConns()
+		for i := 0; i < 100; i++ {
+			chain.client.mu.Lock()
+			conn := chain.client.conn
+			chain.client.mu.Unlock()
+			if conn == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, server.count("server.version"))
+		assert.Equal(t, 2, server.count("blockchain.scripthash.subscribe"))
+		assert.Equal(t, 3, server.count("blockchain.scripthash.listunspent"))
+	}
+}
+
+func TestElectrumChainTLS(t *testing.T) {
+	data := mockElectrumResults(t)
+	server := newMockElectrum(data.results, true)
+	defer server.close()
+
+	chain := mockElectrumChain(server.addr(), true)
+	defer chain.client.Close()
+
+	fees, err := chain.GetFees()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(fees))
+}
+
+func TestElectrumChainError(t *testing.T) {
+	data := mockElectrumResults(t)
+	delete(data.results, "blockchain.transaction.broadcast")
+	server := newMockElectrum(data.results, false)
+	chain := mockElectrumChain(server.addr(), false)
+	defer chain.client.Close()
+
+	_, err := chain.PushTx("0100")
+	assert.NotNil(t, err)
+
+	// Server down.
+	server.close()
+	_, err = chain.GetFees()
+	assert.NotNil(t, err)
+}
+
+func TestElectrumChainHeaderError(t *testing.T) {
+	data := mockElectrumResults(t)
+	header := data.results["blockchain.block.header"]
+	delete(data.results, "blockchain.block.header")
+	server := newMockElectrum(data.results, false)
+	defer server.close()
+
+	chain := mockElectrumChain(server.addr(), false)
+	defer chain.client.Close()
+
+	// The error is returned and the results are not cached.
+	{
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.NotNil(t, err)
+		_, err = chain.GetTxs(mockBitcoindAddress)
+		assert.NotNil(t, err)
+	}
+
+	{
+		server.set("blockchain.block.header", header)
+		unspents, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(unspents))
+		txs, err := chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(txs))
+		assert.Equal(t, 2, server.count("blockchain.scripthash.listunspent"))
+		assert.Equal(t, 2, server.count("blockchain.scripthash.get_history"))
+	}
+}
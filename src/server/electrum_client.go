@@ -0,0 +1,238 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	electrumTimeout         = 10 * time.Second
+	electrumClientName      = "thresh-wallet"
+	electrumProtocolVersion = "1.4"
+)
+
+// electrumRequest -- the line-delimited JSON-RPC request.
+type electrumRequest struct {
+	ID     uint64        `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// electrumMessage -- the response or the notification(without id) from the server.
+type electrumMessage struct {
+	ID     *uint64          `json:"id"`
+	Result json.RawMessage  `json:"result"`
+	Error  *json.RawMessage `json:"error"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+// electrumError -- the error of the response, the server has handled the call.
+type electrumError struct {
+	Method  string
+	Message string
+}
+
+// Error -- the implementation method for error interface.
+func (e *electrumError) Error() string {
+	return fmt.Sprintf("electrum.%v.error:%s", e.Method, e.Message)
+}
+
+// electrumClient -- the persistent connection to the electrum server.
+// It reconnects on the next call if the connection broken, the epoch increases on each connect and close.
+type electrumClient struct {
+	mu         sync.Mutex
+	host       string
+	tls        bool
+	skipVerify bool
+	conn       net.Conn
+	id         uint64
+	epoch      uint64
+	pending    map[uint64]chan *electrumMessage
+	notify     func(method string, params json.RawMessage)
+}
+
+func newElectrumClient(host string, useTLS bool, skipVerify bool, notify func(method string, params json.RawMessage)) *electrumClient {
+	return &electrumClient{
+		host:       host,
+		tls:        useTLS,
+		skipVerify: skipVerify,
+		notify:     notify,
+		pending:    make(map[uint64]chan *electrumMessage),
+	}
+}
+
+// connect -- dials and negotiates the protocol version.
+// Not thread-safe, the caller must hold the client lock.
+func (c *electrumClient) connect() error {
+	var err error
+	var conn net.Conn
+
+	dialer := &net.Dialer{Timeout: electrumTimeout}
+	if c.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.host, &tls.Config{InsecureSkipVerify: c.skipVerify})
+	} else {
+		conn, err = dialer.Dial("tcp", c.host)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Handshake.
+	reader := bufio.NewReader(conn)
+	c.id++
+	if err := c.write(conn, &electrumRequest{ID: c.id, Method: "server.version", Params: []interface{}{electrumClientName, electrumProtocolVersion}}); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(electrumTimeout))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	msg := &electrumMessage{}
+	if err := json.Unmarshal(line, msg); err != nil {
+		conn.Close()
+		return err
+	}
+	if msg.Error != nil {
+		conn.Close()
+		return fmt.Errorf("electrum.server.version.error:%s", *msg.Error)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	c.conn = conn
+	c.epoch++
+	go c.read(conn, reader)
+	return nil
+}
+
+// write -- writes the request as one line.
+func (c *electrumClient) write(conn net.Conn, req *electrumRequest) error {
+	if req.Params == nil {
+		req.Params = []interface{}{}
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(electrumTimeout))
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
+
+// read -- the reader loop dispatchs the responses to the callers and the notifications to the notify.
+func (c *electrumClient) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			c.close(conn)
+			return
+		}
+		msg := &electrumMessage{}
+		if err := json.Unmarshal(line, msg); err != nil {
+			continue
+		}
+		if msg.ID == nil {
+			if msg.Method != "" && c.notify != nil {
+				c.notify(msg.Method, msg.Params)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[*msg.ID]
+		delete(c.pending, *msg.ID)
+		c.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+// close -- closes the connection and fails all the pending calls.
+func (c *electrumClient) close(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn.Close()
+	if c.conn != conn {
+		return
+	}
+	c.conn = nil
+	c.epoch++
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Epoch -- returns the connection epoch, the subscriptions are lost if the epoch changed.
+func (c *electrumClient) Epoch() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// Call -- calls the method and unmarshals the result.
+func (c *electrumClient) Call(result interface{}, method string, params ...interface{}) error {
+	c.mu.Lock()
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			c.mu.Unlock()
+			return fmt.Errorf("electrum.connect[%v].error:%v", c.host, err)
+		}
+	}
+	c.id++
+	id := c.id
+	ch := make(chan *electrumMessage, 1)
+	c.pending[id] = ch
+	conn := c.conn
+	c.mu.Unlock()
+
+	if err := c.write(conn, &electrumRequest{ID: id, Method: method, Params: params}); err != nil {
+		c.close(conn)
+		return err
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("electrum.%v.connection.closed", method)
+		}
+		if msg.Error != nil {
+			return &electrumError{Method: method, Message: string(*msg.Error)}
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	case <-time.After(electrumTimeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("electrum.%v.timeout", method)
+	}
+}
+
+// Close -- closes the connection.
+func (c *electrumClient) Close() {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.close(conn)
+	}
+}
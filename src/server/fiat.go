@@ -0,0 +1,176 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"math"
+	"sort"
+)
+
+// FiatSnapshot -- the BTC prices(code to price) when the tx first seen and confirmed.
+// The prices are from the syncer, the ones older than the max age are not snapshotted.
+type FiatSnapshot struct {
+	SeenAt          int64              `json:"seen_at"`
+	SeenPrices      map[string]float64 `json:"seen_prices,omitempty"`
+	ConfirmedAt     int64              `json:"confirmed_at"`
+	ConfirmedPrices map[string]float64 `json:"confirmed_prices,omitempty"`
+}
+
+// values -- returns the fiat value of the satoshis at the first seen and the confirmation, 0 if the price unknown.
+func (fs *FiatSnapshot) values(code string, sats int64) (float64, float64) {
+	if fs == nil {
+		return 0, 0
+	}
+	return fiatValue(sats, fs.SeenPrices[code]), fiatValue(sats, fs.ConfirmedPrices[code])
+}
+
+// PortfolioPoint -- the wallet balance and its fiat value at the time.
+// The price is 0 if unknown, such as the txs seen before the snapshot recorded.
+type PortfolioPoint struct {
+	Time      int64
+	Balance   int64
+	Price     float64
+	FiatValue float64
+}
+
+// fiatValue -- the fiat value of the satoshis, rounded to the cent.
+func fiatValue(sats int64, price float64) float64 {
+	return math.Round(float64(sats)/1e8*price*100) / 100
+}
+
+// copyPrices -- returns the copy of the prices, nil if empty.
+func copyPrices(prices map[string]float64) map[string]float64 {
+	if len(prices) == 0 {
+		return nil
+	}
+	cp := make(map[string]float64, len(prices))
+	for code, price := range prices {
+		cp[code] = price
+	}
+	return cp
+}
+
+// SnapshotFiat -- records the prices for the txs first seen or just confirmed, returns the number of the updated txs.
+// Nothing recorded if the prices are empty, the txs are retried at the next sync.
+// The tx confirmed more than max age seconds before first seen(such as the history of the restored wallet)
+// is recorded at the block time without the prices, the current prices are not its prices.
+func (w *Wallet) SnapshotFiat(prices map[string]float64, now int64, maxAge int64) int {
+	if len(prices) == 0 {
+		return 0
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.FiatSnapshots == nil {
+		w.FiatSnapshots = make(map[string]*FiatSnapshot)
+	}
+	updated := 0
+	for _, addr := range w.Address {
+		addr.mu.Lock()
+		for _, tx := range addr.Txs {
+			snapshot, ok := w.FiatSnapshots[tx.Txid]
+			if !ok {
+				if tx.Confirmed && tx.BlockTime > 0 && now-tx.BlockTime > maxAge {
+					snapshot = &FiatSnapshot{SeenAt: tx.BlockTime, ConfirmedAt: tx.BlockTime}
+				} else {
+					snapshot = &FiatSnapshot{SeenAt: now, SeenPrices: copyPrices(prices)}
+				}
+				w.FiatSnapshots[tx.Txid] = snapshot
+				updated++
+			}
+			if tx.Confirmed && snapshot.ConfirmedAt == 0 {
+				snapshot.ConfirmedAt = now
+				snapshot.ConfirmedPrices = copyPrices(prices)
+				updated++
+			}
+		}
+		addr.mu.Unlock()
+	}
+	return updated
+}
+
+// fiatSnapshot -- returns the copy of the tx snapshot, nil if not found.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) fiatSnapshot(txid string) *FiatSnapshot {
+	snapshot, ok := w.FiatSnapshots[txid]
+	if !ok {
+		return nil
+	}
+	return &FiatSnapshot{
+		SeenAt:          snapshot.SeenAt,
+		SeenPrices:      copyPrices(snapshot.SeenPrices),
+		ConfirmedAt:     snapshot.ConfirmedAt,
+		ConfirmedPrices: copyPrices(snapshot.ConfirmedPrices),
+	}
+}
+
+// PortfolioHistory -- returns the balance and its value of the code after each tx in time order, priced at the tx first seen.
+// The tx time is the first seen, or the block time if the tx seen before the snapshot recorded.
+// The last point is the current balance at the current price.
+func (w *Wallet) PortfolioHistory(code string, price float64, now int64) []PortfolioPoint {
+	type event struct {
+		txid  string
+		time  int64
+		value int64
+		price float64
+	}
+
+	w.Lock()
+	// The tx value is per address so sum them by txid.
+	events := make(map[string]*event)
+	for _, addr := range w.Address {
+		addr.mu.Lock()
+		for _, tx := range addr.Txs {
+			e, ok := events[tx.Txid]
+			if !ok {
+				e = &event{txid: tx.Txid, time: tx.BlockTime}
+				if snapshot, ok := w.FiatSnapshots[tx.Txid]; ok {
+					e.time = snapshot.SeenAt
+					e.price = snapshot.SeenPrices[code]
+				}
+				if e.time == 0 {
+					e.time = now
+				}
+				events[tx.Txid] = e
+			}
+			e.value += tx.Value
+		}
+		addr.mu.Unlock()
+	}
+	w.Unlock()
+
+	var list []*event
+	for _, e := range events {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].time == list[j].time {
+			return list[i].txid < list[j].txid
+		}
+		return list[i].time < list[j].time
+	})
+
+	var balance int64
+	var points []PortfolioPoint
+	for _, e := range list {
+		balance += e.value
+		points = append(points, PortfolioPoint{
+			Time:      e.time,
+			Balance:   balance,
+			Price:     e.price,
+			FiatValue: fiatValue(balance, e.price),
+		})
+	}
+	points = append(points, PortfolioPoint{
+		Time:      now,
+		Balance:   balance,
+		Price:     price,
+		FiatValue: fiatValue(balance, price),
+	})
+	return points
+}
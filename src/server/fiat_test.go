@@ -0,0 +1,123 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletSnapshotFiat(t *testing.T) {
+	now := int64(1565000000)
+	maxAge := int64(3600)
+	wallet := NewWallet()
+	wallet.Address["a"] = &Address{Address: "a"}
+	wallet.Address["b"] = &Address{Address: "b"}
+
+	// No prices, nothing recorded.
+	{
+		wallet.UpdateTxs("a", []Tx{{Txid: "t1", Value: 100000000}})
+		assert.Equal(t, 0, wallet.SnapshotFiat(nil, now, maxAge))
+		assert.Equal(t, 0, len(wallet.FiatSnapshots))
+	}
+
+	// First seen.
+	{
+		prices := map[string]float64{"USD": 10000, "CNY": 70000}
+		assert.Equal(t, 1, wallet.SnapshotFiat(prices, now, maxAge))
+		prices["USD"] = 1
+		snapshot := wallet.FiatSnapshots["t1"]
+		assert.Equal(t, now, snapshot.SeenAt)
+		assert.Equal(t, 10000.0, snapshot.SeenPrices["USD"])
+		assert.Equal(t, int64(0), snapshot.ConfirmedAt)
+
+		// Seen again, unchanged.
+		assert.Equal(t, 0, wallet.SnapshotFiat(map[string]float64{"USD": 20000}, now+60, maxAge))
+		assert.Equal(t, now, wallet.FiatSnapshots["t1"].SeenAt)
+	}
+
+	// Confirmed, the tx is replaced by the sync but the snapshot is kept.
+	{
+		wallet.UpdateTxs("a", []Tx{{Txid: "t1", Value: 100000000, Confirmed: true, BlockTime: now + 600, BlockHeight: 100}})
+		wallet.UpdateTxs("b", []Tx{{Txid: "t2", Value: -50000000, Confirmed: true, BlockTime: now - 86400, BlockHeight: 10}})
+		assert.Equal(t, 2, wallet.SnapshotFiat(map[string]float64{"USD": 11000}, now+600, maxAge))
+
+		snapshot := wallet.FiatSnapshots["t1"]
+		assert.Equal(t, 10000.0, snapshot.SeenPrices["USD"])
+		assert.Equal(t, now+600, snapshot.ConfirmedAt)
+		assert.Equal(t, 11000.0, snapshot.ConfirmedPrices["USD"])
+
+		// The old history has no prices.
+		snapshot = wallet.FiatSnapshots["t2"]
+		assert.Equal(t, now-86400, snapshot.SeenAt)
+		assert.Nil(t, snapshot.SeenPrices)
+		assert.Nil(t, snapshot.ConfirmedPrices)
+	}
+
+	// The txs with the snapshot values.
+	{
+		txs := wallet.Txs(0, 10)
+		assert.Equal(t, 2, len(txs))
+		assert.Equal(t, "t1", txs[0].Txid)
+		seen, confirmed := txs[0].Fiat.values("USD", txs[0].Value)
+		assert.Equal(t, 10000.0, seen)
+		assert.Equal(t, 11000.0, confirmed)
+		seen, confirmed = txs[0].Fiat.values("EUR", txs[0].Value)
+		assert.Equal(t, 0.0, seen)
+		assert.Equal(t, 0.0, confirmed)
+
+		seen, _ = txs[1].Fiat.values("USD", txs[1].Value)
+		assert.Equal(t, 0.0, seen)
+
+		var nilSnapshot *FiatSnapshot
+		seen, confirmed = nilSnapshot.values("USD", 1)
+		assert.Equal(t, 0.0, seen)
+		assert.Equal(t, 0.0, confirmed)
+	}
+}
+
+func TestWalletPortfolioHistoryPoints(t *testing.T) {
+	now := int64(1565000000)
+	wallet := NewWallet()
+	wallet.Address["a"] = &Address{Address: "a"}
+	wallet.Address["b"] = &Address{Address: "b"}
+
+	// Empty.
+	{
+		points := wallet.PortfolioHistory("USD", 10000, now)
+		assert.Equal(t, []PortfolioPoint{{Time: now, Balance: 0, Price: 10000, FiatValue: 0}}, points)
+	}
+
+	// t3 spends 0.3 from a and returns 0.1 change to b.
+	wallet.UpdateTxs("a", []Tx{
+		{Txid: "t1", Value: 100000000, Confirmed: true, BlockTime: now - 7200, BlockHeight: 1},
+		{Txid: "t3", Value: -30000000},
+	})
+	wallet.UpdateTxs("b", []Tx{
+		{Txid: "t2", Value: 50000000, Confirmed: true, BlockTime: now - 3600, BlockHeight: 2},
+		{Txid: "t3", Value: 10000000},
+	})
+	wallet.FiatSnapshots = map[string]*FiatSnapshot{
+		"t2": {SeenAt: now - 4000, SeenPrices: map[string]float64{"USD": 9000}},
+		"t3": {SeenAt: now - 60, SeenPrices: map[string]float64{"USD": 9500}},
+	}
+
+	points := wallet.PortfolioHistory("USD", 10000, now)
+	assert.Equal(t, []PortfolioPoint{
+		{Time: now - 7200, Balance: 100000000, Price: 0, FiatValue: 0},
+		{Time: now - 4000, Balance: 150000000, Price: 9000, FiatValue: 13500},
+		{Time: now - 60, Balance: 130000000, Price: 9500, FiatValue: 12350},
+		{Time: now, Balance: 130000000, Price: 10000, FiatValue: 13000},
+	}, points)
+}
+
+func TestFiatValue(t *testing.T) {
+	assert.Equal(t, 3.37, fiatValue(4569, 73711.13))
+	assert.Equal(t, -10.0, fiatValue(-93266, 10721.13))
+	assert.Equal(t, 0.0, fiatValue(100000000, 0))
+}
@@ -25,6 +25,7 @@ type Handler struct {
 	tokenAuth  *jwtauth.JWTAuth
 	loginCode  *Vcode
 	backupCode *Vcode
+	audit      *AuditLog
 }
 
 // NewHandler -- creates new Handler.
@@ -41,6 +42,7 @@ func NewHandler(log *xlog.Log, conf *Config) *Handler {
 	loginCode := NewVcode(log, conf)
 	backupCode := NewVcode(log, conf)
 	tokenAuth := jwtauth.New("HS256", []byte(conf.TokenSecret), nil)
+	audit := NewAuditLog(log, AuditFile(conf))
 	handler := &Handler{
 		log:        log,
 		wdb:        wdb,
@@ -50,6 +52,7 @@ func NewHandler(log *xlog.Log, conf *Config) *Handler {
 		backupCode: backupCode,
 		netprefix:  netprefix,
 		tokenAuth:  tokenAuth,
+		audit:      audit,
 	}
 	return handler
 }
@@ -58,13 +61,23 @@ func NewHandler(log *xlog.Log, conf *Config) *Handler {
 func (h *Handler) Init() error {
 	conf := h.conf
 	wdb := h.wdb
+	audit := h.audit
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	if err := audit.Open(); err != nil {
+		return err
+	}
 	return wdb.Open(conf.DataDir)
 }
 
 // Close -- used to close the handler.
 func (h *Handler) Close() {
 	wdb := h.wdb
+	audit := h.audit
 	wdb.Close()
+	audit.Close()
 }
 
 func (h *Handler) userinfo(tag string, r *http.Request) (string, error) {
@@ -77,3 +90,33 @@ func (h *Handler) userinfo(tag string, r *http.Request) (string, error) {
 	}
 	return fmt.Sprintf("%v", claims["uid"]), nil
 }
+
+// deviceID -- the device id of the token, empty if the token has no did.
+func (h *Handler) deviceID(r *http.Request) string {
+	if _, claims, err := jwtauth.FromContext(r.Context()); err == nil {
+		if did, ok := claims["did"]; ok && did != nil {
+			return fmt.Sprintf("%v", did)
+		}
+	}
+	return ""
+}
+
+// notify -- sends the notification to the user email, the uid or the backup email.
+func (h *Handler) notify(uid string, subject string, body string) {
+	log := h.log
+	wdb := h.wdb
+	smtp := h.smtp
+
+	to := uid
+	if loginType(uid) != Email {
+		backup, err := wdb.GetBackup(uid)
+		if err != nil || backup.Email == "" {
+			log.Warning("api.notify[%v].email.not.found", uid)
+			return
+		}
+		to = backup.Email
+	}
+	if err := smtp.Notify(to, "KeyFuse Labs", subject, body); err != nil {
+		log.Error("api.notify[%v].smtp.error:%+v", uid, err)
+	}
+}
@@ -0,0 +1,91 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"xlog"
+)
+
+// JSONBackend -- the wallets in the <uid>.json files, one file per wallet.
+// The whole file is rewritten on every save.
+type JSONBackend struct {
+	log *xlog.Log
+	dir string
+}
+
+// NewJSONBackend -- creates new JSONBackend.
+func NewJSONBackend(log *xlog.Log) *JSONBackend {
+	return &JSONBackend{log: log}
+}
+
+// Open -- creates the dir if not exists.
+func (b *JSONBackend) Open(dir string) error {
+	b.dir = dir
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return os.MkdirAll(dir, os.ModePerm)
+	}
+	return nil
+}
+
+// Load -- reads all the .json wallet files in the dir.
+func (b *JSONBackend) Load() ([]*Wallet, error) {
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var wallets []*Wallet
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		wallet, err := readWalletFile(filepath.Join(b.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
+	}
+	return wallets, nil
+}
+
+// Save -- writes the wallet to the <uid>.json file.
+func (b *JSONBackend) Save(wallet *Wallet) error {
+	file := fmt.Sprintf("%s/%s.json", b.dir, wallet.UID)
+	datas, err := json.MarshalIndent(wallet, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, datas, os.ModePerm)
+}
+
+// Close -- nothing to close.
+func (b *JSONBackend) Close() error {
+	return nil
+}
+
+// readWalletFile -- reads a wallet from the json file.
+func readWalletFile(path string) (*Wallet, error) {
+	wallet := NewWallet()
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, wallet); err != nil {
+		return nil, err
+	}
+	if wallet.Address == nil {
+		wallet.Address = make(map[string]*Address)
+	}
+	return wallet, nil
+}
@@ -0,0 +1,457 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"proto"
+	"xlog"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcore/bip32"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/paillier"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+const (
+	walletKeyManager = "wallet"
+	localKeyManager  = "local"
+	socketKeyManager = "socket"
+)
+
+// KeyManager -- the custodian of the server master private keys.
+// The child keys are derived and the party signs inside the manager, the key material never leaves it.
+type KeyManager interface {
+	// CreateKey -- creates the master key of the uid, returns the master public key.
+	CreateKey(uid string) (string, error)
+
+	// ChildPubKey -- the child public key at the pos.
+	ChildPubKey(uid string, pos uint32) (string, error)
+
+	// SharedPubKey -- the two party public key of the server child key and the client child public key(serialized) at the pos.
+	SharedPubKey(uid string, pos uint32, cliPubKey []byte) ([]byte, error)
+
+	// RingPedersen -- the ring-pedersen parameters of the range proofs, generated once.
+	RingPedersen() (*proto.RingPedersen, error)
+
+	// EcdsaR2 -- the R2 and the ShareR of the party at the pos, the nonce is random and kept by the session
+	// until the S2 or expired. The R1 must have the proof of its discrete log.
+	EcdsaR2(uid string, session string, pos uint32, hash []byte, R1 *secp256k1.Scalar, R1Proof *proto.DlogProof) (*secp256k1.Scalar, *secp256k1.Scalar, error)
+
+	// EcdsaS2 -- the S2 of the session, the nonce is dropped after the first call whatever the result.
+	// The proof must show the encPK1 is the encryption of the client child private key of the cliPubKey(serialized)
+	// under the well-formed encPub1, the caller checks the cliPubKey is the client child public key at the pos.
+	EcdsaS2(uid string, session string, pos uint32, hash []byte, shareR *secp256k1.Scalar, cliPubKey []byte, encPK1 *big.Int, encPub1 *paillier.PubKey, proof *proto.EcdsaProof) (*big.Int, error)
+
+	// PrepareRefresh -- refreshes the key share, the child keys before the cutover(and the change ones before the change cutover)
+	// are multiplied by the factor(big-endian), the others are derived from a new master key.
+	// The refreshed share is pending until committed, returns its public share.
+	PrepareRefresh(uid string, factor []byte, cutover uint32, changeCutover uint32) (string, error)
+
+	// CommitRefresh -- replaces the key share by the pending one of the public share, the old key is retired.
+	CommitRefresh(uid string, pubkey string) error
+
+	Close() error
+}
+
+// NewKeyManager -- creates the key manager by the key_manager config, default is the wallet.
+func NewKeyManager(log *xlog.Log, conf *Config, store *WalletStore, mkey *MasterKey) (KeyManager, error) {
+	var net *network.Network
+	switch conf.ChainNet {
+	case testnet:
+		net = network.TestNet
+	case mainnet:
+		net = network.MainNet
+	}
+
+	kconf := conf.KeyManager
+	if kconf == nil {
+		return NewWalletKeyManager(log, net, store, mkey), nil
+	}
+
+	switch kconf.Type {
+	case walletKeyManager, "":
+		return NewWalletKeyManager(log, net, store, mkey), nil
+	case localKeyManager:
+		return NewLocalKeyManager(log, net, kconf.KeyDir, mkey)
+	case socketKeyManager:
+		return NewSocketKeyManager(log, kconf.Socket)
+	default:
+		return nil, fmt.Errorf("keymanager.type[%v].unknown", kconf.Type)
+	}
+}
+
+// ecdsaNonce -- the one-time nonce of the signing session, bound to the uid, pos and hash of the R2.
+type ecdsaNonce struct {
+	uid     string
+	pos     uint32
+	hash    []byte
+	k       *big.Int
+	shareR  *secp256k1.Scalar
+	expired int64
+}
+
+// hdKeys -- the operations on the parsed key shares, the key managers provide the loader and the saver.
+// The parsed keys are cached in memory.
+type hdKeys struct {
+	mu      sync.Mutex
+	net     *network.Network
+	keys    map[string]*proto.KeyShare
+	pending map[string]*proto.KeyShare
+	nonces  map[string]*ecdsaNonce
+	rpmu    sync.Mutex
+	rp      *proto.RingPedersen
+	load    func(uid string) (string, error)
+	save    func(uid string, prvkey string) error
+}
+
+func newHDKeys(net *network.Network, load func(uid string) (string, error), save func(uid string, prvkey string) error) hdKeys {
+	return hdKeys{
+		net:     net,
+		keys:    make(map[string]*proto.KeyShare),
+		pending: make(map[string]*proto.KeyShare),
+		nonces:  make(map[string]*ecdsaNonce),
+		load:    load,
+		save:    save,
+	}
+}
+
+// key -- returns the parsed key share of the uid.
+func (k *hdKeys) key(uid string) (*proto.KeyShare, error) {
+	k.mu.Lock()
+	hdkey, ok := k.keys[uid]
+	k.mu.Unlock()
+	if ok {
+		return hdkey, nil
+	}
+
+	prvkey, err := k.load(uid)
+	if err != nil {
+		return nil, err
+	}
+	hdkey, err = proto.ParseKeyShare(prvkey)
+	if err != nil {
+		return nil, fmt.Errorf("keymanager.uid[%v].master.key.parse.error:%v", uid, err)
+	}
+	k.mu.Lock()
+	k.keys[uid] = hdkey
+	k.mu.Unlock()
+	return hdkey, nil
+}
+
+// forget -- drops the cached key of the uid.
+func (k *hdKeys) forget(uid string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, uid)
+}
+
+// ChildPubKey -- the child public key at the pos.
+func (k *hdKeys) ChildPubKey(uid string, pos uint32) (string, error) {
+	hdkey, err := k.key(uid)
+	if err != nil {
+		return "", err
+	}
+	child, err := hdkey.Derive(pos)
+	if err != nil {
+		return "", err
+	}
+	return child.HDPublicKey().ToString(k.net), nil
+}
+
+// SharedPubKey -- the two party public key at the pos.
+func (k *hdKeys) SharedPubKey(uid string, pos uint32, cliPubKey []byte) ([]byte, error) {
+	hdkey, err := k.key(uid)
+	if err != nil {
+		return nil, err
+	}
+	clipub, err := xcrypto.PubKeyFromBytes(cliPubKey)
+	if err != nil {
+		return nil, err
+	}
+	sharepub, err := createSharedPubKey(hdkey, pos, clipub)
+	if err != nil {
+		return nil, err
+	}
+	return sharepub.Serialize(), nil
+}
+
+// RingPedersen -- the ring-pedersen parameters, generated on the first call.
+func (k *hdKeys) RingPedersen() (*proto.RingPedersen, error) {
+	k.rpmu.Lock()
+	defer k.rpmu.Unlock()
+
+	if k.rp == nil {
+		rp, err := proto.GenerateRingPedersen()
+		if err != nil {
+			return nil, err
+		}
+		k.rp = rp
+	}
+	return k.rp, nil
+}
+
+// EcdsaR2 -- the R2 and the ShareR of the party at the pos, the nonce is kept by the session.
+func (k *hdKeys) EcdsaR2(uid string, session string, pos uint32, hash []byte, R1 *secp256k1.Scalar, R1Proof *proto.DlogProof) (*secp256k1.Scalar, *secp256k1.Scalar, error) {
+	if session == "" {
+		return nil, nil, fmt.Errorf("keymanager.uid[%v].session.required", uid)
+	}
+	if err := R1Proof.Verify(R1, hash, pos); err != nil {
+		return nil, nil, fmt.Errorf("keymanager.uid[%v].R1.proof.error:%v", uid, err)
+	}
+	// The key must be there.
+	if _, err := k.key(uid); err != nil {
+		return nil, nil, err
+	}
+	nonce, r2, shareR, err := createEcdsaR2(R1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().Unix()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id, n := range k.nonces {
+		if now > n.expired {
+			n.k.SetInt64(0)
+			delete(k.nonces, id)
+		}
+	}
+	if _, ok := k.nonces[session]; ok {
+		nonce.SetInt64(0)
+		return nil, nil, fmt.Errorf("keymanager.uid[%v].session[%v].exists", uid, session)
+	}
+	k.nonces[session] = &ecdsaNonce{
+		uid:     uid,
+		pos:     pos,
+		hash:    hash,
+		k:       nonce,
+		shareR:  shareR,
+		expired: now + signSessionExpired,
+	}
+	return r2, shareR, nil
+}
+
+// EcdsaS2 -- the S2 of the session, the nonce is used once.
+func (k *hdKeys) EcdsaS2(uid string, session string, pos uint32, hash []byte, shareR *secp256k1.Scalar, cliPubKey []byte, encPK1 *big.Int, encPub1 *paillier.PubKey, proof *proto.EcdsaProof) (*big.Int, error) {
+	k.mu.Lock()
+	nonce, ok := k.nonces[session]
+	delete(k.nonces, session)
+	k.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("keymanager.uid[%v].session[%v].nonce.not.found", uid, session)
+	}
+	defer nonce.k.SetInt64(0)
+
+	if time.Now().Unix() > nonce.expired {
+		return nil, fmt.Errorf("keymanager.uid[%v].session[%v].nonce.expired", uid, session)
+	}
+	if nonce.uid != uid || nonce.pos != pos || !bytes.Equal(nonce.hash, hash) {
+		return nil, fmt.Errorf("keymanager.uid[%v].session[%v].mismatch", uid, session)
+	}
+	if shareR == nil || nonce.shareR.X.Cmp(shareR.X) != 0 || nonce.shareR.Y.Cmp(shareR.Y) != 0 {
+		return nil, fmt.Errorf("api.ecdsa.s2.shareR.not.equal")
+	}
+
+	// Proofs.
+	clipub, err := xcrypto.PubKeyFromBytes(cliPubKey)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := k.RingPedersen()
+	if err != nil {
+		return nil, err
+	}
+	if err := proof.Verify(rp, encPub1, encPK1, clipub, hash, pos); err != nil {
+		return nil, fmt.Errorf("keymanager.uid[%v].session[%v].proof.error:%v", uid, session, err)
+	}
+	hdkey, err := k.key(uid)
+	if err != nil {
+		return nil, err
+	}
+	return createEcdsaS2(hdkey, pos, hash, nonce.k, nonce.shareR, encPK1, encPub1)
+}
+
+// PrepareRefresh -- refreshes the key share to the pending.
+func (k *hdKeys) PrepareRefresh(uid string, factor []byte, cutover uint32, changeCutover uint32) (string, error) {
+	share, err := k.key(uid)
+	if err != nil {
+		return "", err
+	}
+	master, err := bip32.NewHDKeyRand()
+	if err != nil {
+		return "", err
+	}
+	refreshed, err := share.Refresh(new(big.Int).SetBytes(factor), cutover, changeCutover, master, k.net)
+	if err != nil {
+		return "", err
+	}
+	pub, err := refreshed.Public(k.net)
+	if err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	k.pending[uid] = refreshed
+	k.mu.Unlock()
+	return pub.String(), nil
+}
+
+// CommitRefresh -- saves the pending key share.
+func (k *hdKeys) CommitRefresh(uid string, pubkey string) error {
+	k.mu.Lock()
+	refreshed, ok := k.pending[uid]
+	current := k.keys[uid]
+	k.mu.Unlock()
+	if !ok {
+		// Committed already.
+		if current != nil {
+			if pub, err := current.Public(k.net); err == nil && pub.String() == pubkey {
+				return nil
+			}
+		}
+		return fmt.Errorf("keymanager.uid[%v].refresh.not.prepared", uid)
+	}
+	pub, err := refreshed.Public(k.net)
+	if err != nil {
+		return err
+	}
+	if pub.String() != pubkey {
+		return fmt.Errorf("keymanager.uid[%v].refresh.pubkey.mismatch", uid)
+	}
+	if err := k.save(uid, refreshed.String()); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	delete(k.pending, uid)
+	k.keys[uid] = refreshed
+	k.mu.Unlock()
+	return nil
+}
+
+// WalletKeyManager -- the keys in the wallet records, plaintext or in the envelope of the master key.
+type WalletKeyManager struct {
+	hdKeys
+	log   *xlog.Log
+	store *WalletStore
+	mkey  *MasterKey
+}
+
+// NewWalletKeyManager -- creates new WalletKeyManager, the mkey is nil if the master key not configured.
+func NewWalletKeyManager(log *xlog.Log, net *network.Network, store *WalletStore, mkey *MasterKey) *WalletKeyManager {
+	km := &WalletKeyManager{
+		log:   log,
+		store: store,
+		mkey:  mkey,
+	}
+	km.hdKeys = newHDKeys(net, km.loadKey, km.saveKey)
+	return km
+}
+
+// loadKey -- returns the master private key of the wallet, the encrypted one is decrypted in memory.
+func (km *WalletKeyManager) loadKey(uid string) (string, error) {
+	wallet := km.store.Get(uid)
+	if wallet == nil {
+		return "", fmt.Errorf("keymanager.wallet.uid[%v].cant.found", uid)
+	}
+
+	wallet.Lock()
+	env := wallet.SvrKeyEnvelope
+	prvkey := wallet.SvrMasterPrvKey
+	wallet.Unlock()
+	if env == nil {
+		if prvkey == "" {
+			return "", fmt.Errorf("keymanager.wallet.uid[%v].master.key.not.in.wallet", uid)
+		}
+		return prvkey, nil
+	}
+
+	if km.mkey == nil {
+		return "", fmt.Errorf("keymanager.wallet.uid[%v].encrypted.but.master.key.not.configured", uid)
+	}
+	secret, err := km.mkey.Open(uid, env)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// saveKey -- replaces the key share of the wallet, encrypted if the master key configured.
+// The caller updates the public share of the wallet.
+func (km *WalletKeyManager) saveKey(uid string, prvkey string) error {
+	wallet := km.store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("keymanager.wallet.uid[%v].cant.found", uid)
+	}
+
+	wallet.Lock()
+	if km.mkey != nil {
+		env, err := km.mkey.Seal(uid, []byte(prvkey))
+		if err != nil {
+			wallet.Unlock()
+			return err
+		}
+		wallet.SvrKeyEnvelope = env
+		wallet.SvrMasterPrvKey = ""
+	} else {
+		wallet.SvrKeyEnvelope = nil
+		wallet.SvrMasterPrvKey = prvkey
+	}
+	wallet.Unlock()
+	return km.store.Write(wallet)
+}
+
+// CreateKey -- creates the master key into the wallet record, encrypted if the master key configured.
+// The wallet must be in the store.
+func (km *WalletKeyManager) CreateKey(uid string) (string, error) {
+	net := km.net
+	store := km.store
+
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return "", fmt.Errorf("keymanager.wallet.uid[%v].cant.found", uid)
+	}
+	masterKey, err := bip32.NewHDKeyRand()
+	if err != nil {
+		return "", err
+	}
+
+	wallet.Lock()
+	if wallet.SvrMasterPrvKey != "" || wallet.SvrKeyEnvelope != nil {
+		wallet.Unlock()
+		return "", fmt.Errorf("keymanager.wallet.uid[%v].master.key.exists", uid)
+	}
+	wallet.SvrMasterPrvKey = masterKey.ToString(net)
+	wallet.SvrMasterPubKey = masterKey.HDPublicKey().ToString(net)
+	if km.mkey != nil {
+		if _, err := wallet.encryptSvrKey(km.mkey); err != nil {
+			wallet.SvrMasterPrvKey = ""
+			wallet.SvrMasterPubKey = ""
+			wallet.Unlock()
+			return "", err
+		}
+	}
+	pubkey := wallet.SvrMasterPubKey
+	wallet.Unlock()
+
+	if err := store.Write(wallet); err != nil {
+		return "", err
+	}
+	return pubkey, nil
+}
+
+// Close -- nothing to close.
+func (km *WalletKeyManager) Close() error {
+	return nil
+}
@@ -0,0 +1,294 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"proto"
+	"xlog"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcore/bip32"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSharedAddress -- the default type address of the server master private key(or key share) and the mock client at the pos.
+func mockSharedAddress(t *testing.T, svrMasterPrvKey string, pos uint32) string {
+	hdkey, err := proto.ParseKeyShare(svrMasterPrvKey)
+	assert.Nil(t, err)
+	clipub, err := createCliChildPubKey(pos, mockCliMasterPubKey)
+	assert.Nil(t, err)
+	sharepub, err := createSharedPubKey(hdkey, pos, clipub)
+	assert.Nil(t, err)
+	return createSharedAddress(sharepub, network.TestNet, "")
+}
+
+func TestLocalKeyManager(t *testing.T) {
+	dir := "/tmp/tss-keys"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	mkey, err := NewMasterKey(bytes.Repeat([]byte{0x11}, 32))
+	assert.Nil(t, err)
+
+	// Master key required.
+	{
+		_, err := NewLocalKeyManager(log, network.TestNet, dir, nil)
+		assert.NotNil(t, err)
+	}
+
+	km, err := NewLocalKeyManager(log, network.TestNet, dir, mkey)
+	assert.Nil(t, err)
+	defer km.Close()
+
+	// Import.
+	{
+		pubkey, err := km.Import(mockUID, mockSvrMasterPrvKey)
+		assert.Nil(t, err)
+		want, err := createSvrChildPubKey(0, mockSvrMasterPrvKey, network.TestNet)
+		assert.Nil(t, err)
+		got, err := createSvrChildPubKey(0, pubkey, network.TestNet)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+
+		datas, err := ioutil.ReadFile(filepath.Join(dir, mockUID+".key"))
+		assert.Nil(t, err)
+		assert.NotContains(t, string(datas), mockSvrMasterPrvKey)
+
+		// Exists.
+		_, err = km.Import(mockUID, mockSvrMasterPrvKey)
+		assert.NotNil(t, err)
+		_, err = km.CreateKey(mockUID)
+		assert.NotNil(t, err)
+	}
+
+	// Derive and shared address from the file, not the cache.
+	{
+		km2, err := NewLocalKeyManager(log, network.TestNet, dir, mkey)
+		assert.Nil(t, err)
+		want, err := createSvrChildPubKey(5, mockSvrMasterPrvKey, network.TestNet)
+		assert.Nil(t, err)
+		got, err := km2.ChildPubKey(mockUID, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+
+		wallet := NewWallet()
+		wallet.UID = mockUID
+		wallet.net = network.TestNet
+		wallet.LastPos = 3
+		wallet.CliMasterPubKey = mockCliMasterPubKey
+		address, err := wallet.NewAddress("", km2)
+		assert.Nil(t, err)
+		assert.Equal(t, mockSharedAddress(t, mockSvrMasterPrvKey, 3), address.Address)
+	}
+
+	// Create.
+	{
+		uid := "13999999999"
+		pubkey, err := km.CreateKey(uid)
+		assert.Nil(t, err)
+		want, err := createSvrChildPubKey(1, pubkey, network.TestNet)
+		assert.Nil(t, err)
+		got, err := km.ChildPubKey(uid, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// Errors.
+	{
+		_, err := km.ChildPubKey("13000000000", 0)
+		assert.NotNil(t, err)
+		_, err = km.CreateKey("../13000000000")
+		assert.NotNil(t, err)
+
+		other, _ := NewMasterKey(bytes.Repeat([]byte{0x22}, 32))
+		km2, err := NewLocalKeyManager(log, network.TestNet, dir, other)
+		assert.Nil(t, err)
+		_, err = km2.ChildPubKey(mockUID, 0)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSocketKeyManager(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	dir := "/tmp/tss-keyd"
+	sock := filepath.Join(dir, "keyd.sock")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	mkey, err := NewMasterKey(bytes.Repeat([]byte{0x11}, 32))
+	assert.Nil(t, err)
+	local, err := NewLocalKeyManager(log, network.TestNet, dir, mkey)
+	assert.Nil(t, err)
+	_, err = local.Import(mockUID, mockSvrMasterPrvKey)
+	assert.Nil(t, err)
+
+	server := NewKeyServer(log, local)
+	err = server.Serve(sock)
+	assert.Nil(t, err)
+	fi, err := os.Stat(sock)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	km, err := NewSocketKeyManager(log, sock)
+	assert.Nil(t, err)
+
+	// Child pubkey.
+	{
+		want, err := createSvrChildPubKey(2, mockSvrMasterPrvKey, network.TestNet)
+		assert.Nil(t, err)
+		got, err := km.ChildPubKey(mockUID, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+
+		// The error from the key server.
+		_, err = km.ChildPubKey("13000000000", 2)
+		assert.NotNil(t, err)
+	}
+
+	// Two party signing through the socket.
+	{
+		var pos uint32 = 2
+		hash := sha256.Sum256([]byte("thresh-wallet"))
+		climasterkey, err := bip32.NewHDKeyFromString(mockCliMasterPrvKey)
+		assert.Nil(t, err)
+		clichild, err := climasterkey.Derive(pos)
+		assert.Nil(t, err)
+		key, err := proto.GeneratePaillierKey()
+		assert.Nil(t, err)
+		aliceParty := proto.NewEcdsaParty(clichild.PrivateKey(), key)
+		defer aliceParty.Close()
+		clipub := clichild.PublicKey().Serialize()
+
+		shared, err := km.SharedPubKey(mockUID, pos, clipub)
+		assert.Nil(t, err)
+		sharepub, err := xcrypto.PubKeyFromBytes(shared)
+		assert.Nil(t, err)
+
+		r1, r1proof, err := aliceParty.Phase2(hash[:], pos)
+		assert.Nil(t, err)
+		r2, shareR, err := km.EcdsaR2(mockUID, "session", pos, hash[:], r1, r1proof)
+		assert.Nil(t, err)
+		assert.Equal(t, shareR, aliceParty.Phase3(r2))
+
+		rp, err := km.RingPedersen()
+		assert.Nil(t, err)
+		assert.Nil(t, rp.Verify())
+		encpk1, encpub1, proof, err := aliceParty.Phase4(rp)
+		assert.Nil(t, err)
+		s2, err := km.EcdsaS2(mockUID, "session", pos, hash[:], shareR, clipub, encpk1, encpub1, proof)
+		assert.Nil(t, err)
+		sig, err := aliceParty.Phase5(shareR, s2)
+		assert.Nil(t, err)
+		err = xcrypto.EcdsaVerify(sharepub, hash[:], sig)
+		assert.Nil(t, err)
+
+		// The nonce is used once.
+		_, err = km.EcdsaS2(mockUID, "session", pos, hash[:], shareR, clipub, encpk1, encpub1, proof)
+		assert.NotNil(t, err)
+
+		// R1 proof of the other pos.
+		_, _, err = km.EcdsaR2(mockUID, "session2", pos+1, hash[:], r1, r1proof)
+		assert.NotNil(t, err)
+
+		// ShareR mismatch.
+		_, _, err = km.EcdsaR2(mockUID, "session2", pos, hash[:], r1, r1proof)
+		assert.Nil(t, err)
+		_, err = km.EcdsaS2(mockUID, "session2", pos, hash[:], r1, clipub, encpk1, encpub1, proof)
+		assert.NotNil(t, err)
+
+		// The proof of the other public key.
+		r2, shareR, err = km.EcdsaR2(mockUID, "session3", pos, hash[:], r1, r1proof)
+		assert.Nil(t, err)
+		_, err = km.EcdsaS2(mockUID, "session3", pos, hash[:], shareR, climasterkey.PublicKey().Serialize(), encpk1, encpub1, proof)
+		assert.NotNil(t, err)
+	}
+
+	// The key server restarted, the client re-dials.
+	{
+		server.Close()
+		server = NewKeyServer(log, local)
+		err := server.Serve(sock)
+		assert.Nil(t, err)
+
+		_, err = km.ChildPubKey(mockUID, 0)
+		assert.Nil(t, err)
+	}
+
+	km.Close()
+	server.Close()
+}
+
+func TestMoveWalletKeys(t *testing.T) {
+	dir := "/tmp/tss-movekeys"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	conf := MockConfig()
+	conf.DataDir = filepath.Join(dir, "wallet")
+	conf.MasterKey = &MasterKeyConfig{Passphrase: "passphrase"}
+	conf.KeyManager = &KeyManagerConfig{Type: localKeyManager, KeyDir: filepath.Join(dir, "keys")}
+
+	os.MkdirAll(conf.DataDir, os.ModePerm)
+	err := ioutil.WriteFile(filepath.Join(conf.DataDir, mockUID+".json"), []byte(mock13888888888Json), 0644)
+	assert.Nil(t, err)
+
+	// Move.
+	{
+		n, err := MoveWalletKeys(log, conf)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+
+		datas, err := ioutil.ReadFile(filepath.Join(conf.DataDir, mockUID+".json"))
+		assert.Nil(t, err)
+		assert.NotContains(t, string(datas), mockSvrMasterPrvKey)
+		assert.NotContains(t, string(datas), "svrkeyenvelope")
+		assert.Contains(t, string(datas), "svrmasterpubkey")
+		_, err = os.Stat(filepath.Join(conf.KeyManager.KeyDir, mockUID+".key"))
+		assert.Nil(t, err)
+
+		// Again.
+		n, err = MoveWalletKeys(log, conf)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	}
+
+	// The wallet db with the local key manager.
+	{
+		wdb := NewWalletDB(log, conf)
+		wdb.setChain(newMockChain(log))
+		err := wdb.Open(conf.DataDir)
+		assert.Nil(t, err)
+		defer wdb.Close()
+
+		pos := wdb.Wallet(mockUID).LastPos
+		got, err := wdb.NewAddress(mockUID, "")
+		assert.Nil(t, err)
+		assert.Equal(t, mockSharedAddress(t, mockSvrMasterPrvKey, pos), got.Address)
+
+		// New wallet key in the key dir.
+		uid := "13999999999"
+		err = wdb.CreateWallet(uid, mockCliMasterPubKey)
+		assert.Nil(t, err)
+		assert.Equal(t, "", wdb.Wallet(uid).SvrMasterPrvKey)
+		assert.Nil(t, wdb.Wallet(uid).SvrKeyEnvelope)
+		_, err = os.Stat(filepath.Join(conf.KeyManager.KeyDir, uid+".key"))
+		assert.Nil(t, err)
+	}
+}
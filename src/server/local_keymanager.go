@@ -0,0 +1,237 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"proto"
+	"xlog"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcore/bip32"
+)
+
+// LocalKeyManager -- the keys in the <uid>.key files of the key dir, separated from the wallet records.
+// The file is the envelope of the master key, the key is decrypted in memory only.
+type LocalKeyManager struct {
+	hdKeys
+	log  *xlog.Log
+	dir  string
+	mkey *MasterKey
+}
+
+// NewLocalKeyManager -- creates new LocalKeyManager, the master key is required.
+func NewLocalKeyManager(log *xlog.Log, net *network.Network, dir string, mkey *MasterKey) (*LocalKeyManager, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("keymanager.local.key.dir.required")
+	}
+	if mkey == nil {
+		return nil, fmt.Errorf("keymanager.local.master.key.required")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	km := &LocalKeyManager{
+		log:  log,
+		dir:  dir,
+		mkey: mkey,
+	}
+	km.hdKeys = newHDKeys(net, km.loadKey, km.saveKey)
+	return km, nil
+}
+
+// keyFile -- the key file path of the uid.
+func (km *LocalKeyManager) keyFile(uid string) (string, error) {
+	if uid == "" || strings.ContainsAny(uid, `/\`) || strings.HasPrefix(uid, ".") {
+		return "", fmt.Errorf("keymanager.local.uid[%v].invalid", uid)
+	}
+	return filepath.Join(km.dir, uid+".key"), nil
+}
+
+// loadKey -- reads and decrypts the key file of the uid.
+func (km *LocalKeyManager) loadKey(uid string) (string, error) {
+	file, err := km.keyFile(uid)
+	if err != nil {
+		return "", err
+	}
+	datas, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("keymanager.local.uid[%v].read.error:%v", uid, err)
+	}
+	env := &KeyEnvelope{}
+	if err := json.Unmarshal(datas, env); err != nil {
+		return "", fmt.Errorf("keymanager.local.uid[%v].unmarshal.error:%v", uid, err)
+	}
+	secret, err := km.mkey.Open(uid, env)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// CreateKey -- creates the master key to the key file, errors if the key file exists.
+func (km *LocalKeyManager) CreateKey(uid string) (string, error) {
+	masterKey, err := bip32.NewHDKeyRand()
+	if err != nil {
+		return "", err
+	}
+	return km.Import(uid, masterKey.ToString(km.net))
+}
+
+// seal -- the key file content of the key share.
+func (km *LocalKeyManager) seal(uid string, prvkey string) ([]byte, error) {
+	env, err := km.mkey.Seal(uid, []byte(prvkey))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// saveKey -- replaces the key file by the new key share.
+func (km *LocalKeyManager) saveKey(uid string, prvkey string) error {
+	file, err := km.keyFile(uid)
+	if err != nil {
+		return err
+	}
+	datas, err := km.seal(uid, prvkey)
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(datas); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Import -- writes the master private key or the key share to the key file, returns the public key.
+// Errors if the key file exists.
+func (km *LocalKeyManager) Import(uid string, prvkey string) (string, error) {
+	file, err := km.keyFile(uid)
+	if err != nil {
+		return "", err
+	}
+	share, err := proto.ParseKeyShare(prvkey)
+	if err != nil {
+		return "", err
+	}
+	pub, err := share.Public(km.net)
+	if err != nil {
+		return "", err
+	}
+	datas, err := km.seal(uid, prvkey)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("keymanager.local.uid[%v].key.exists", uid)
+		}
+		return "", err
+	}
+	if _, err := f.Write(datas); err != nil {
+		f.Close()
+		os.Remove(file)
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(file)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return pub.String(), nil
+}
+
+// Close -- nothing to close.
+func (km *LocalKeyManager) Close() error {
+	return nil
+}
+
+// MoveWalletKeys -- moves the server master private keys from the wallet records to the key files of the key dir,
+// returns the number moved. The wallets keep the master public keys.
+func MoveWalletKeys(log *xlog.Log, conf *Config) (int, error) {
+	if conf.MasterKey == nil {
+		return 0, fmt.Errorf("migrate.master.key.config.required")
+	}
+	if conf.KeyManager == nil || conf.KeyManager.KeyDir == "" {
+		return 0, fmt.Errorf("migrate.key.manager.key.dir.required")
+	}
+	mkey, err := LoadMasterKey(conf.MasterKey)
+	if err != nil {
+		return 0, err
+	}
+
+	store := NewWalletStore(log, conf)
+	if err := store.Open(conf.DataDir); err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	wkm := NewWalletKeyManager(log, store.net, store, mkey)
+	lkm, err := NewLocalKeyManager(log, store.net, conf.KeyManager.KeyDir, mkey)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, uid := range store.AllUID() {
+		wallet := store.Get(uid)
+		wallet.Lock()
+		inWallet := (wallet.SvrMasterPrvKey != "" || wallet.SvrKeyEnvelope != nil)
+		wallet.Unlock()
+		if !inWallet {
+			continue
+		}
+
+		prvkey, err := wkm.loadKey(uid)
+		if err != nil {
+			return n, fmt.Errorf("migrate.wallet[%v].error:%v", uid, err)
+		}
+		pubkey, err := lkm.Import(uid, prvkey)
+		if err != nil {
+			return n, fmt.Errorf("migrate.wallet[%v].error:%v", uid, err)
+		}
+		wallet.Lock()
+		wallet.SvrMasterPubKey = pubkey
+		wallet.SvrMasterPrvKey = ""
+		wallet.SvrKeyEnvelope = nil
+		wallet.Unlock()
+		if err := store.Write(wallet); err != nil {
+			return n, err
+		}
+		n++
+		log.Info("migrate.wallet[%v].svrkey.moved.to[%v]", uid, conf.KeyManager.KeyDir)
+	}
+	return n, nil
+}
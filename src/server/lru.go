@@ -0,0 +1,59 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"container/list"
+)
+
+// lruEntry -- the key and value of the cache.
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// lruCache -- the LRU cache of the immutable values, such as the decoded txs.
+// Not thread-safe, the caller must hold the lock.
+type lruCache struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+// newLRUCache -- creates new lruCache of the max size.
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get -- returns the value and marks it as the most recently used.
+func (c *lruCache) get(key string) (interface{}, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+// add -- adds the value, the least recently used one is evicted if the cache is full.
+func (c *lruCache) add(key string, value interface{}) {
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*lruEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
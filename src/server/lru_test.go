@@ -0,0 +1,37 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache(2)
+	cache.add("a", 1)
+	cache.add("b", 2)
+
+	// The a is used, the b is evicted.
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	cache.add("c", 3)
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	v, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	_, ok = cache.get("c")
+	assert.True(t, ok)
+
+	// Updated.
+	cache.add("c", 4)
+	v, _ = cache.get("c")
+	assert.Equal(t, 4, v)
+	assert.Equal(t, 2, len(cache.items))
+}
@@ -0,0 +1,220 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"proto"
+	"xlog"
+
+	"github.com/keyfuse/tokucore/xcrypto/pbkdf2"
+)
+
+const (
+	masterKeySize        = 32
+	masterKeyIter        = 100000
+	masterKeyDefaultSalt = "thresh-wallet-master-key"
+)
+
+// MasterKey -- the operator key which wraps the data keys of the wallets.
+type MasterKey struct {
+	id  string
+	key []byte
+}
+
+// NewMasterKey -- creates new MasterKey from the 32 bytes key.
+func NewMasterKey(key []byte) (*MasterKey, error) {
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("masterkey.size[%v].must.be[%v]", len(key), masterKeySize)
+	}
+	id := sha256.Sum256(append([]byte("thresh-wallet-master-key-id"), key...))
+	return &MasterKey{id: hex.EncodeToString(id[:8]), key: key}, nil
+}
+
+// LoadMasterKey -- loads the master key from the key file(hex encoded), or derives it from the passphrase.
+func LoadMasterKey(mconf *MasterKeyConfig) (*MasterKey, error) {
+	switch {
+	case mconf.KeyFile != "":
+		datas, err := ioutil.ReadFile(mconf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(datas)))
+		if err != nil {
+			return nil, fmt.Errorf("masterkey.file[%v].hex.decode.error:%v", mconf.KeyFile, err)
+		}
+		return NewMasterKey(key)
+	case mconf.Passphrase != "":
+		salt := mconf.Salt
+		if salt == "" {
+			salt = masterKeyDefaultSalt
+		}
+		return NewMasterKey(pbkdf2.Key([]byte(mconf.Passphrase), []byte(salt), masterKeyIter, masterKeySize, sha256.New))
+	default:
+		return nil, fmt.Errorf("masterkey.key.file.or.passphrase.required")
+	}
+}
+
+// ID -- the id of the master key, the envelope records it to detect the wrong key.
+func (mk *MasterKey) ID() string {
+	return mk.id
+}
+
+// KeyEnvelope -- the secret encrypted by the random data key, and the data key wrapped by the master key.
+// Both are AES-256-GCM with the uid as the additional data, hex encoded nonce||ciphertext.
+type KeyEnvelope struct {
+	KeyID      string `json:"key_id"`
+	DataKey    string `json:"data_key"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Seal -- encrypts the secret of the uid with a new data key.
+func (mk *MasterKey) Seal(uid string, secret []byte) (*KeyEnvelope, error) {
+	dataKey := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := aesgcmSeal(mk.key, dataKey, []byte(uid))
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := aesgcmSeal(dataKey, secret, []byte(uid))
+	if err != nil {
+		return nil, err
+	}
+	return &KeyEnvelope{
+		KeyID:      mk.id,
+		DataKey:    hex.EncodeToString(wrapped),
+		Ciphertext: hex.EncodeToString(ciphertext),
+	}, nil
+}
+
+// Open -- decrypts the secret of the uid.
+func (mk *MasterKey) Open(uid string, env *KeyEnvelope) ([]byte, error) {
+	if env.KeyID != mk.id {
+		return nil, fmt.Errorf("masterkey.envelope.uid[%v].key.id[%v].mismatch[%v]", uid, env.KeyID, mk.id)
+	}
+	wrapped, err := hex.DecodeString(env.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aesgcmOpen(mk.key, wrapped, []byte(uid))
+	if err != nil {
+		return nil, fmt.Errorf("masterkey.envelope.uid[%v].unwrap.data.key.error:%v", uid, err)
+	}
+	ciphertext, err := hex.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := aesgcmOpen(dataKey, ciphertext, []byte(uid))
+	if err != nil {
+		return nil, fmt.Errorf("masterkey.envelope.uid[%v].decrypt.error:%v", uid, err)
+	}
+	return secret, nil
+}
+
+func aesgcmSeal(key []byte, plaintext []byte, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func aesgcmOpen(key []byte, sealed []byte, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("aesgcm.sealed.too.short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+// encryptSvrKey -- moves the plaintext server master private key into the envelope, returns false if already encrypted.
+// The master public key is kept in plaintext for deriving the child public keys.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) encryptSvrKey(mkey *MasterKey) (bool, error) {
+	if w.SvrKeyEnvelope != nil {
+		return false, nil
+	}
+	share, err := proto.ParseKeyShare(w.SvrMasterPrvKey)
+	if err != nil {
+		return false, err
+	}
+	pub, err := share.Public(w.net)
+	if err != nil {
+		return false, err
+	}
+	env, err := mkey.Seal(w.UID, []byte(w.SvrMasterPrvKey))
+	if err != nil {
+		return false, err
+	}
+	w.SvrMasterPubKey = pub.String()
+	w.SvrKeyEnvelope = env
+	w.SvrMasterPrvKey = ""
+	return true, nil
+}
+
+// MigrateWalletKeys -- encrypts the plaintext server master private keys of the wallets in place, returns the number encrypted.
+func MigrateWalletKeys(log *xlog.Log, conf *Config) (int, error) {
+	if conf.MasterKey == nil {
+		return 0, fmt.Errorf("migrate.master.key.config.required")
+	}
+	mkey, err := LoadMasterKey(conf.MasterKey)
+	if err != nil {
+		return 0, err
+	}
+
+	store := NewWalletStore(log, conf)
+	if err := store.Open(conf.DataDir); err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	n := 0
+	for _, uid := range store.AllUID() {
+		wallet := store.Get(uid)
+		wallet.Lock()
+		encrypted, err := wallet.encryptSvrKey(mkey)
+		wallet.Unlock()
+		if err != nil {
+			return n, fmt.Errorf("migrate.wallet[%v].error:%v", uid, err)
+		}
+		if !encrypted {
+			continue
+		}
+		if err := store.Write(wallet); err != nil {
+			return n, err
+		}
+		n++
+		log.Info("migrate.wallet[%v].svrkey.encrypted.key.id[%v]", uid, mkey.ID())
+	}
+	return n, nil
+}
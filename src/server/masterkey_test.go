@@ -0,0 +1,183 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xlog"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasterKey(t *testing.T) {
+	keyFile := "/tmp/tss-master.key"
+	defer os.RemoveAll(keyFile)
+
+	// Size.
+	{
+		_, err := NewMasterKey([]byte("short"))
+		assert.NotNil(t, err)
+		_, err = LoadMasterKey(&MasterKeyConfig{})
+		assert.NotNil(t, err)
+	}
+
+	// Key file.
+	key := bytes.Repeat([]byte{0x11}, 32)
+	err := ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	assert.Nil(t, err)
+	mkey, err := LoadMasterKey(&MasterKeyConfig{KeyFile: keyFile})
+	assert.Nil(t, err)
+
+	// Passphrase, the salt changes the key.
+	{
+		mkey1, err := LoadMasterKey(&MasterKeyConfig{Passphrase: "passphrase"})
+		assert.Nil(t, err)
+		mkey2, err := LoadMasterKey(&MasterKeyConfig{Passphrase: "passphrase", Salt: "salt"})
+		assert.Nil(t, err)
+		assert.NotEqual(t, mkey1.ID(), mkey2.ID())
+		assert.NotEqual(t, mkey.ID(), mkey1.ID())
+	}
+
+	// Seal and open.
+	{
+		env, err := mkey.Seal(mockUID, []byte(mockSvrMasterPrvKey))
+		assert.Nil(t, err)
+		assert.Equal(t, mkey.ID(), env.KeyID)
+		assert.NotContains(t, env.Ciphertext, hex.EncodeToString([]byte(mockSvrMasterPrvKey)))
+
+		secret, err := mkey.Open(mockUID, env)
+		assert.Nil(t, err)
+		assert.Equal(t, mockSvrMasterPrvKey, string(secret))
+
+		// The envelope is bound to the uid.
+		_, err = mkey.Open("13999999999", env)
+		assert.NotNil(t, err)
+
+		// Wrong key.
+		other, _ := NewMasterKey(bytes.Repeat([]byte{0x22}, 32))
+		_, err = other.Open(mockUID, env)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "mismatch")
+
+		// Tampered.
+		tampered := *env
+		tampered.Ciphertext = tampered.Ciphertext[:len(tampered.Ciphertext)-2] + "00"
+		_, err = mkey.Open(mockUID, &tampered)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestWalletDBMasterKey(t *testing.T) {
+	dir := "/tmp/tss-mkey"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	conf := MockConfig()
+	conf.DataDir = dir
+	conf.MasterKey = &MasterKeyConfig{Passphrase: "passphrase"}
+
+	// Migrate the json wallet.
+	{
+		os.MkdirAll(dir, os.ModePerm)
+		err := ioutil.WriteFile(filepath.Join(dir, mockUID+".json"), []byte(mock13888888888Json), 0644)
+		assert.Nil(t, err)
+
+		n, err := MigrateWalletKeys(log, conf)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+
+		datas, err := ioutil.ReadFile(filepath.Join(dir, mockUID+".json"))
+		assert.Nil(t, err)
+		assert.NotContains(t, string(datas), mockSvrMasterPrvKey)
+		assert.Contains(t, string(datas), "svrkeyenvelope")
+
+		// Again.
+		n, err = MigrateWalletKeys(log, conf)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	}
+
+	wdb := NewWalletDB(log, conf)
+	wdb.setChain(newMockChain(log))
+	err := wdb.Open(dir)
+	assert.Nil(t, err)
+	defer wdb.Close()
+
+	// Decrypted in memory.
+	{
+		want, err := createSvrChildPubKey(0, mockSvrMasterPrvKey, network.TestNet)
+		assert.Nil(t, err)
+		got, err := wdb.KeyManager().ChildPubKey(mockUID, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// The svrpubkey is derived from the master public key.
+	{
+		wdb.syncer.Sync()
+		utxos, err := wdb.Unspents(mockUID, 0)
+		assert.Nil(t, err)
+		assert.True(t, len(utxos) > 0)
+		for _, utxo := range utxos {
+			svrpubkey, err := createSvrChildPubKey(utxo.Pos, mockSvrMasterPrvKey, network.TestNet)
+			assert.Nil(t, err)
+			assert.Equal(t, svrpubkey, utxo.SvrPubKey)
+		}
+	}
+
+	// New wallet is encrypted.
+	{
+		uid := "13999999999"
+		err := wdb.CreateWallet(uid, mockCliMasterPubKey)
+		assert.Nil(t, err)
+		wallet := wdb.Wallet(uid)
+		assert.Equal(t, "", wallet.SvrMasterPrvKey)
+		assert.NotNil(t, wallet.SvrKeyEnvelope)
+
+		// The first address same as the plaintext one.
+		hdkey, err := wdb.KeyManager().(*WalletKeyManager).key(uid)
+		assert.Nil(t, err)
+		got, err := wdb.NewAddress(uid, "")
+		assert.Nil(t, err)
+		assert.Equal(t, mockSharedAddress(t, hdkey.String(), 0), got.Address)
+	}
+
+	// The address of the migrated wallet same as the plaintext one.
+	{
+		pos := wdb.Wallet(mockUID).LastPos
+		got, err := wdb.NewAddress(mockUID, "")
+		assert.Nil(t, err)
+		assert.Equal(t, mockSharedAddress(t, mockSvrMasterPrvKey, pos), got.Address)
+	}
+
+	// Wrong master key.
+	{
+		conf2 := MockConfig()
+		conf2.DataDir = dir
+		conf2.MasterKey = &MasterKeyConfig{Passphrase: "wrong"}
+		wdb2 := NewWalletDB(log, conf2)
+		wdb2.setChain(newMockChain(log))
+		err := wdb2.Open(dir)
+		assert.Nil(t, err)
+		defer wdb2.Close()
+		_, err = wdb2.KeyManager().ChildPubKey(mockUID, 0)
+		assert.NotNil(t, err)
+
+		// No master key.
+		km := NewWalletKeyManager(log, network.TestNet, wdb2.store, nil)
+		_, err = km.ChildPubKey(mockUID, 0)
+		assert.NotNil(t, err)
+	}
+}
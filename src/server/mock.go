@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/hex"
 	"io/ioutil"
 	"net/http/httptest"
 	"os"
@@ -118,12 +119,24 @@ func MockConfig() *Config {
 	conf.EnableVCode = false
 	conf.DataDir = "/tmp/tss"
 	conf.WalletSyncIntervalMs = 30
+	conf.PriceFeed = &PriceFeedConfig{
+		Feeds:  []string{"static"},
+		Static: map[string]float64{"CNY": 73711.13, "USD": 10721.13},
+	}
 	return conf
 }
 
 func MockServer() (*httptest.Server, func()) {
+	return MockServerWithConfig(MockConfig())
+}
+
+func MockServerWithConfig(conf *Config) (*httptest.Server, func()) {
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+	return mockServerWithChain(conf, newMockChain(log))
+}
+
+func mockServerWithChain(conf *Config, chain Chain) (*httptest.Server, func()) {
 	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
-	conf := MockConfig()
 
 	os.MkdirAll(conf.DataDir, os.ModePerm)
 	os.RemoveAll(conf.DataDir + "/*")
@@ -131,7 +144,7 @@ func MockServer() (*httptest.Server, func()) {
 		panic(err)
 	}
 	router := NewAPIRouter(log, conf)
-	router.handler.wdb.setChain(newMockChain(log))
+	router.handler.wdb.setChain(chain)
 	if err := router.Init(); err != nil {
 		panic(err)
 	}
@@ -223,25 +236,12 @@ func (c *mockChain) GetTxLink() string {
 	return "https://blockstream.info/testnet/tx/%v"
 }
 
-func (c *mockChain) GetTickers() (map[string]Ticker, error) {
-	tickers := make(map[string]Ticker)
-	tickers["CNY"] = Ticker{
-		One5M:  73711.13,
-		Last:   73711.13,
-		Buy:    73711.13,
-		Sell:   73711.13,
-		Symbol: "¥",
-	}
-	tickers["USD"] = Ticker{
-		One5M:  10721.13,
-		Last:   10721.13,
-		Buy:    10721.13,
-		Sell:   10721.13,
-		Symbol: "$",
+// PushTx -- returns the txid of the tx hex, the fixed one if it's not a tx.
+func (c *mockChain) PushTx(txhex string) (string, error) {
+	if datas, err := hex.DecodeString(txhex); err == nil {
+		if tx, err := parseRawTx(datas); err == nil {
+			return tx.Txid, nil
+		}
 	}
-	return tickers, nil
-}
-
-func (c *mockChain) PushTx(hex string) (string, error) {
 	return "e0c328bd49e9a1c2ef5f7a1c14f0f9893658f5673fb415ceec1125dcd6641993", nil
 }
@@ -12,42 +12,66 @@ import (
 	"strings"
 
 	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
 
+	"proto"
+
 	"github.com/keyfuse/tokucore/network"
 	"github.com/keyfuse/tokucore/xcore"
 	"github.com/keyfuse/tokucore/xcore/bip32"
 	"github.com/keyfuse/tokucore/xcrypto"
+	xecdsa "github.com/keyfuse/tokucore/xcrypto/ecdsa"
 	"github.com/keyfuse/tokucore/xcrypto/paillier"
 	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
 )
 
 const ()
 
-func createSharedAddress(pos uint32, svrMasterPrvKey string, cliMasterPubkey string, net *network.Network, typ string) (string, error) {
-	svrmasterkey, err := bip32.NewHDKeyFromString(svrMasterPrvKey)
+// childDeriver -- derives the child key at the pos, the bip32 master key or the refreshed key share.
+type childDeriver interface {
+	Derive(pos uint32) (*bip32.HDKey, error)
+}
+
+// randScalar -- the random scalar in [1, N).
+func randScalar() (*big.Int, error) {
+	n := secp256k1.SECP256K1().Params().N
+	k, err := rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(1)))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	svrchild, err := svrmasterkey.Derive(pos)
+	return k.Add(k, big.NewInt(1)), nil
+}
+
+// createSharedPubKey -- the two party shared public key of the server child key at the pos and the client child public key.
+func createSharedPubKey(svrMasterKey childDeriver, pos uint32, cliPubKey *xcrypto.PubKey) (*xcrypto.PubKey, error) {
+	svrchild, err := svrMasterKey.Derive(pos)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	party := xcrypto.NewEcdsaParty(svrchild.PrivateKey())
+	return party.Phase1(cliPubKey), nil
+}
+
+// createCliChildPubKey -- the client child public key at the pos.
+func createCliChildPubKey(pos uint32, cliMasterPubkey string) (*xcrypto.PubKey, error) {
 	climasterkey, err := bip32.NewHDKeyFromString(cliMasterPubkey)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	clichild, err := climasterkey.Derive(pos)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	party := xcrypto.NewEcdsaParty(svrchild.PrivateKey())
-	sharepub := party.Phase1(clichild.PublicKey())
+	return clichild.PublicKey(), nil
+}
 
+// createSharedAddress -- the address of the shared public key, default is the P2WPKH.
+func createSharedAddress(sharepub *xcrypto.PubKey, net *network.Network, typ string) string {
 	var shared xcore.Address
 	switch strings.ToUpper(typ) {
 	case "P2PKH":
@@ -55,58 +79,73 @@ func createSharedAddress(pos uint32, svrMasterPrvKey string, cliMasterPubkey str
 	default:
 		shared = xcore.NewPayToWitnessV0PubKeyHashAddress(sharepub.Hash160())
 	}
-	return shared.ToString(net), nil
+	return shared.ToString(net)
 }
 
-// createEcdsaR2 -- used to create the R2.
+// createEcdsaR2 -- used to create the R2 by a random one-time nonce, the nonce must be kept for the S2 only.
 // Returns:
-// R2, ShareR
-func createEcdsaR2(pos uint32, svrMasterPrvKey string, hash []byte, R1 *secp256k1.Scalar) (*secp256k1.Scalar, *secp256k1.Scalar, error) {
-	masterkey, err := bip32.NewHDKeyFromString(svrMasterPrvKey)
-	if err != nil {
-		return nil, nil, err
+// k2, R2, ShareR
+func createEcdsaR2(R1 *secp256k1.Scalar) (*big.Int, *secp256k1.Scalar, *secp256k1.Scalar, error) {
+	curve := secp256k1.SECP256K1()
+	if R1 == nil || R1.X == nil || R1.Y == nil || !curve.IsOnCurve(R1.X, R1.Y) {
+		return nil, nil, nil, fmt.Errorf("api.ecdsa.r2.R1.not.on.curve")
 	}
-	childkey, err := masterkey.Derive(pos)
+	k, err := randScalar()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-
-	bobParty := xcrypto.NewEcdsaParty(childkey.PrivateKey())
-	// Skip phase1.
-	// Phase2.
-	_, _, r2 := bobParty.Phase2(hash)
-	shareR := bobParty.Phase3(R1)
-	return r2, shareR, nil
+	rx, ry := curve.ScalarBaseMult(k.Bytes())
+	sx, sy := curve.ScalarMult(R1.X, R1.Y, k.Bytes())
+	return k, secp256k1.NewScalar(rx, ry), secp256k1.NewScalar(sx, sy), nil
 }
 
-// createEcdsaS2 -- used to create S2.
+// createEcdsaS2 -- used to create S2 by the nonce of the R2, it's the phase4 of the party(Lindell 2017):
+// s2 = Enc(ρ*q + z/k2 mod q) + e(pk1)*(r*pk2/k2 mod q)
+// The ρ*q masks the plaintext over the integers, the client gets the s2/k2 mod q only.
+// The encPK1 and the encPub1 must be verified by the proofs.
 // Returns:
 // S2
-func createEcdsaS2(pos uint32, svrMasterPrvKey string, hash []byte, R1 *secp256k1.Scalar, shareR *secp256k1.Scalar, encPK1 *big.Int, encPub1 *paillier.PubKey) (*big.Int, error) {
-	masterkey, err := bip32.NewHDKeyFromString(svrMasterPrvKey)
+func createEcdsaS2(svrMasterKey childDeriver, pos uint32, hash []byte, k *big.Int, shareR *secp256k1.Scalar, encPK1 *big.Int, encPub1 *paillier.PubKey) (*big.Int, error) {
+	curve := secp256k1.SECP256K1()
+	N := curve.Params().N
+	childkey, err := svrMasterKey.Derive(pos)
+	if err != nil {
+		return nil, err
+	}
+	if encPK1 == nil || encPub1 == nil {
+		return nil, fmt.Errorf("api.ecdsa.s2.encpk1.required")
+	}
+
+	z := xecdsa.HashToInt(curve, hash)
+	kinv := new(big.Int).ModInverse(k, N)
+	defer kinv.SetInt64(0)
+
+	// ρ in [0, q^5).
+	rho, err := rand.Int(rand.Reader, new(big.Int).Exp(N, big.NewInt(5), nil))
 	if err != nil {
 		return nil, err
 	}
-	childkey, err := masterkey.Derive(pos)
+	m := rho.Mul(rho, N)
+	m.Add(m, new(big.Int).Mod(new(big.Int).Mul(z, kinv), N))
+	c1, err := encPub1.Encrypt(m)
 	if err != nil {
 		return nil, err
 	}
 
-	bobParty := xcrypto.NewEcdsaParty(childkey.PrivateKey())
-	// Skip phase1.
-	// Phase2.
-	bobParty.Phase2(hash)
-	bobShareR := bobParty.Phase3(R1)
-	if bobShareR.X.Cmp(shareR.X) != 0 || bobShareR.Y.Cmp(shareR.Y) != 0 {
-		return nil, fmt.Errorf("api.ecdsa.s2.shareR.not.equal")
+	v := new(big.Int).Mul(kinv, shareR.X)
+	v.Mul(v, childkey.PrivateKey().D)
+	v.Mod(v, N)
+	defer v.SetInt64(0)
+	c2, err := encPub1.MultPlaintext(encPK1, v)
+	if err != nil {
+		return nil, err
 	}
-	// Skip phase3.
-	// Phase4.
-	return bobParty.Phase4(encPK1, encPub1, shareR)
+	return encPub1.Add(c1, c2)
 }
 
-func createSvrChildPubKey(pos uint32, svrMasterPrvKey string, net *network.Network) (string, error) {
-	svrmasterkey, err := bip32.NewHDKeyFromString(svrMasterPrvKey)
+// createSvrChildPubKey -- the svrMasterKey is the master private or public key, or the key share.
+func createSvrChildPubKey(pos uint32, svrMasterKey string, net *network.Network) (string, error) {
+	svrmasterkey, err := proto.ParseKeyShare(svrMasterKey)
 	if err != nil {
 		return "", err
 	}
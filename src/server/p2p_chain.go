@@ -0,0 +1,911 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"xlog"
+
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xcore"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xprotocol"
+)
+
+const (
+	p2pMaxInflight  = 16
+	p2pPingInterval = 2 * time.Minute
+
+	// p2pBirthWindow -- the block time may be earlier than the txs in it, the address is scanned from the blocks
+	// this window before its creation, the same as the bitcoind rescan.
+	p2pBirthWindow = 2 * 60 * 60
+)
+
+var (
+	// p2pReconnectDelay -- the delay before connecting to the next peer.
+	p2pReconnectDelay = 5 * time.Second
+
+	// p2pDefaultFees -- the fees(satoshis per vbyte) if not configured, the p2p network has no estimation.
+	p2pDefaultFees = map[string]float32{"2": 20, "4": 10, "6": 5, "10": 1}
+)
+
+// p2pScript -- the watched address.
+// The birth is the creation time of the address, 0 if it's unknown, the from is the height to scan from,
+// 0 until the headers synced. Ready is false until the blocks from the height are scanned for it.
+type p2pScript struct {
+	address string
+	script  []byte
+	birth   int64
+	from    int64
+	ready   bool
+}
+
+// p2pTx -- the wallet-related tx, the height is 0 if it's in the mempool.
+type p2pTx struct {
+	tx     *rawTx
+	height int64
+	time   int64
+}
+
+// p2pOutput -- the output paying to a watched script.
+type p2pOutput struct {
+	script string
+	value  uint64
+}
+
+// p2pPush -- the tx to broadcast, the result is nil once the peer accepted it.
+type p2pPush struct {
+	tx     *rawTx
+	data   []byte
+	result chan error
+}
+
+// p2pBlockJob -- the requested block.
+type p2pBlockJob struct {
+	hash   []byte
+	height int64
+	rescan bool
+}
+
+// P2PChain -- the chain talks to the bitcoin nodes by the p2p protocol without any third-party indexer.
+// It keeps a validated header chain and scans the full blocks for the watched addresses.
+// A new address is rescanned from its creation before its results are returned, the address whose creation
+// is unknown(the imported or the old wallets) is rescanned from the scan height.
+type P2PChain struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	log     *xlog.Log
+	conf    *Config
+	params  *p2pParams
+	headers *p2pHeaders
+	synced  bool
+	caught  bool
+	peer    *p2pPeer
+	scanned int64
+	rescan  int64
+	scripts map[string]*p2pScript
+	txs     map[string]*p2pTx
+	outputs map[string]*p2pOutput
+	spents  map[string]string
+	pushCh  chan *p2pPush
+	wakeCh  chan struct{}
+	done    chan struct{}
+}
+
+// NewP2PChain -- creates new P2PChain and starts to sync with the peers.
+func NewP2PChain(log *xlog.Log, conf *Config) Chain {
+	return newP2PChain(log, conf, p2pParamsByNet(conf.ChainNet))
+}
+
+func newP2PChain(log *xlog.Log, conf *Config, params *p2pParams) *P2PChain {
+	c := &P2PChain{
+		log:     log,
+		conf:    conf,
+		params:  params,
+		headers: newP2PHeaders(params),
+		scripts: make(map[string]*p2pScript),
+		txs:     make(map[string]*p2pTx),
+		outputs: make(map[string]*p2pOutput),
+		spents:  make(map[string]string),
+		pushCh:  make(chan *p2pPush, 16),
+		wakeCh:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if err := c.loadHeaders(); err != nil {
+		log.Error("p2p.load.headers.error:%+v", err)
+	}
+
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// Close -- stops the peer connection.
+func (c *P2PChain) Close() {
+	close(c.done)
+	c.mu.Lock()
+	if c.peer != nil {
+		c.peer.close()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+// loadHeaders -- loads and validates the headers from the headers file.
+func (c *P2PChain) loadHeaders() error {
+	pconf := c.conf.P2P
+	if pconf == nil || pconf.HeadersFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(pconf.HeadersFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var headers []*xprotocol.BlockHeader
+	for i := 0; i+p2pHeaderSize <= len(data); i += p2pHeaderSize {
+		header, err := decodeBlockHeader(data[i : i+p2pHeaderSize])
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header)
+	}
+	_, err = c.headers.connect(headers, p2pNow())
+	return err
+}
+
+// saveHeaders -- writes the best header chain to the headers file.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) saveHeaders(from int64) error {
+	pconf := c.conf.P2P
+	if pconf == nil || pconf.HeadersFile == "" {
+		return nil
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if from <= 1 {
+		from = 1
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(pconf.HeadersFile, flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	for height := from; height <= c.headers.tip().height; height++ {
+		buf.Write(encodeBlockHeader(c.headers.at(height).header))
+	}
+	if _, err := f.WriteAt(buf.Bytes(), (from-1)*p2pHeaderSize); err != nil {
+		return err
+	}
+	return f.Truncate((c.headers.tip().height) * p2pHeaderSize)
+}
+
+// run -- connects to the peers one by one, reconnects to the next if the session ended.
+func (c *P2PChain) run() {
+	log := c.log
+	defer c.wg.Done()
+
+	var peers []string
+	if c.conf.P2P != nil {
+		peers = c.conf.P2P.Peers
+	}
+	if len(peers) == 0 {
+		log.Error("p2p.peers.is.empty")
+		return
+	}
+
+	for i := 0; ; i++ {
+		addr := peers[i%len(peers)]
+		peer, err := dialP2PPeer(addr, c.params.net, c.tipHeight())
+		if err != nil {
+			log.Error("p2p.peer[%v].connect.error:%+v", addr, err)
+		} else {
+			log.Info("p2p.peer[%v].connected.version[%v].agent[%v]", addr, peer.version.Version, peer.version.UserAgent)
+			c.session(peer)
+			peer.close()
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(p2pReconnectDelay):
+		}
+	}
+}
+
+type p2pInbound struct {
+	command string
+	data    []byte
+}
+
+// session -- syncs the headers and blocks with the peer until the connection broken.
+func (c *P2PChain) session(peer *p2pPeer) {
+	log := c.log
+
+	c.mu.Lock()
+	c.peer = peer
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.peer = nil
+		c.mu.Unlock()
+	}()
+
+	quit := make(chan struct{})
+	defer close(quit)
+	inbound := make(chan *p2pInbound)
+	go func() {
+		defer close(inbound)
+		for {
+			command, data, err := peer.readMessage(time.Now().Add(p2pIdleTimeout))
+			if err != nil {
+				log.Error("p2p.peer[%v].read.error:%+v", peer.addr, err)
+				return
+			}
+			select {
+			case inbound <- &p2pInbound{command: command, data: data}:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	defer peer.close()
+
+	var syncing bool
+	var inflight []*p2pBlockJob
+	pushes := make(map[uint64]*p2pPush)
+	defer func() {
+		for _, push := range pushes {
+			push.result <- fmt.Errorf("p2p.pushtx[%v].peer[%v].disconnected", push.tx.Txid, peer.addr)
+		}
+	}()
+	getHeaders := func() error {
+		syncing = true
+		msg := xprotocol.NewMsgGetHeaders(c.params.net)
+		c.mu.Lock()
+		locator := c.headers.locator()
+		c.mu.Unlock()
+		for _, hash := range locator {
+			msg.AddBlockLocatorHash(hash)
+		}
+		return peer.send(msg)
+	}
+	getBlocks := func() error {
+		if syncing || len(inflight) > 0 {
+			return nil
+		}
+		inflight = c.nextBlocks()
+		if len(inflight) == 0 {
+			return nil
+		}
+		msg := xprotocol.NewMsgGetData()
+		for _, job := range inflight {
+			msg.AddInvVect(xprotocol.NewInvVect(xprotocol.InvTypeBlock, job.hash))
+		}
+		return peer.send(msg)
+	}
+
+	if err := getHeaders(); err != nil {
+		log.Error("p2p.peer[%v].getheaders.error:%+v", peer.addr, err)
+		return
+	}
+	ticker := time.NewTicker(p2pPingInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-c.done:
+			return
+		case push := <-c.pushCh:
+			// The pong means the peer has processed the tx, the reject comes before it if refused.
+			if err = peer.send(xprotocol.NewMsgTx(push.data)); err != nil {
+				push.result <- err
+				break
+			}
+			nonce := rand.Uint64()
+			pushes[nonce] = push
+			err = peer.send(xprotocol.NewMsgPing(nonce))
+		case <-c.wakeCh:
+			err = getBlocks()
+		case <-ticker.C:
+			if err = peer.send(xprotocol.NewMsgPing(rand.Uint64())); err == nil {
+				err = getBlocks()
+			}
+		case msg, ok := <-inbound:
+			if !ok {
+				return
+			}
+			switch msg.command {
+			case xprotocol.CommandPing:
+				ping := &xprotocol.MsgPing{}
+				if err = ping.Decode(msg.data); err == nil {
+					err = peer.send(xprotocol.NewMsgPong(ping.Nonce))
+				}
+			case xprotocol.CommandPong:
+				pong := &xprotocol.MsgPong{}
+				if err = pong.Decode(msg.data); err != nil {
+					break
+				}
+				if push, ok := pushes[pong.Nonce]; ok {
+					delete(pushes, pong.Nonce)
+					c.mu.Lock()
+					c.processTx(push.tx, 0, 0, false)
+					c.mu.Unlock()
+					push.result <- nil
+				}
+			case xprotocol.CommandReject:
+				reject := &xprotocol.MsgReject{}
+				if err = reject.Decode(msg.data); err != nil || reject.Cmd != xprotocol.CommandTx {
+					break
+				}
+				for nonce, push := range pushes {
+					if push.tx.Txid == xbase.NewIDToString(reject.Hash) {
+						delete(pushes, nonce)
+						push.result <- &TxRejectError{Provider: "p2p", Reason: reject.Reason}
+					}
+				}
+			case xprotocol.CommandHeaders:
+				headers := &xprotocol.MsgHeaders{}
+				if err = headers.Decode(msg.data); err != nil {
+					break
+				}
+				if err = c.connectHeaders(headers.Headers); err != nil {
+					break
+				}
+				if len(headers.Headers) == xprotocol.MaxBlockHeadersPerMsg {
+					err = getHeaders()
+				} else {
+					syncing = false
+					c.mu.Lock()
+					c.synced = true
+					c.mu.Unlock()
+					err = getBlocks()
+				}
+			case xprotocol.CommandInventory:
+				inv := &xprotocol.MsgInv{}
+				if err = inv.Decode(msg.data); err != nil {
+					break
+				}
+				getdata := xprotocol.NewMsgGetData()
+				for _, iv := range inv.Invs {
+					switch iv.Type {
+					case xprotocol.InvTypeBlock:
+						if !syncing {
+							err = getHeaders()
+						}
+					case xprotocol.InvTypeTx:
+						getdata.AddInvVect(iv)
+					}
+				}
+				if err == nil && len(getdata.InvList) > 0 {
+					err = peer.send(getdata)
+				}
+			case xprotocol.CommandBlock:
+				if len(inflight) == 0 {
+					break
+				}
+				job := inflight[0]
+				inflight = inflight[1:]
+				if err = c.processBlock(job, msg.data); err != nil {
+					break
+				}
+				err = getBlocks()
+			case xprotocol.CommandTx:
+				var tx *rawTx
+				if tx, err = parseRawTx(msg.data); err == nil {
+					c.mu.Lock()
+					c.processTx(tx, 0, 0, false)
+					c.mu.Unlock()
+				}
+			}
+		}
+		if err != nil {
+			log.Error("p2p.peer[%v].session.error:%+v", peer.addr, err)
+			return
+		}
+	}
+}
+
+// tipHeight -- returns the height of the best header.
+func (c *P2PChain) tipHeight() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.headers.tip().height
+}
+
+// connectHeaders -- connects the headers and rollbacks the scanned blocks if reorganized.
+func (c *P2PChain) connectHeaders(headers []*xprotocol.BlockHeader) error {
+	log := c.log
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	oldTip := c.headers.tip().height
+	fork, err := c.headers.connect(headers, p2pNow())
+	if err != nil {
+		return err
+	}
+	from := oldTip + 1
+	if fork >= 0 {
+		log.Warning("p2p.headers.reorganized.fork.at[%v].old.tip[%v].new.tip[%v]", fork, oldTip, c.headers.tip().height)
+		from = fork + 1
+		c.rollback(fork)
+	}
+	if c.headers.tip().height >= from {
+		if err := c.saveHeaders(from); err != nil {
+			log.Error("p2p.save.headers.error:%+v", err)
+		}
+	}
+	return nil
+}
+
+// rollback -- the txs above the fork height are back to the mempool, the blocks will be rescanned.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) rollback(fork int64) {
+	for _, tx := range c.txs {
+		if tx.height > fork {
+			tx.height = 0
+			tx.time = 0
+		}
+	}
+	if c.scanned > fork {
+		c.scanned = fork
+	}
+	if c.rescan > fork+1 {
+		c.rescan = fork + 1
+	}
+}
+
+// nextBlocks -- returns the next blocks to download, the rescan for the new scripts goes first.
+func (c *P2PChain) nextBlocks() []*p2pBlockJob {
+	var jobs []*p2pBlockJob
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.synced {
+		return nil
+	}
+	c.schedule()
+	c.promote()
+	c.catchUp()
+	rescan := c.pending() > 0
+	next, last := c.scanned+1, c.headers.tip().height
+	if rescan {
+		next, last = c.rescan, c.scanned
+	}
+	for height := next; height <= last && len(jobs) < p2pMaxInflight; height++ {
+		jobs = append(jobs, &p2pBlockJob{hash: c.headers.at(height).hash, height: height, rescan: rescan})
+	}
+	return jobs
+}
+
+// pending -- returns the number of the scripts waiting for rescan.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) pending() int {
+	var n int
+	for _, s := range c.scripts {
+		if !s.ready {
+			n++
+		}
+	}
+	return n
+}
+
+// schedule -- sets the heights of the new scripts by their creation once the headers synced, the rescan starts from
+// the lowest of the pending. The forward scanning skips the blocks below all the ready scripts, nothing is in them.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) schedule() {
+	lowest := c.headers.tip().height + 1
+	for _, s := range c.scripts {
+		if s.from == 0 {
+			s.from = c.birthHeight(s.birth)
+			if c.rescan == 0 || s.from < c.rescan {
+				c.rescan = s.from
+			}
+		}
+		if s.ready && s.from < lowest {
+			lowest = s.from
+		}
+	}
+	if c.scanned < lowest-1 {
+		c.scanned = lowest - 1
+	}
+}
+
+// birthHeight -- returns the height to scan from for the address created at the birth, it's the scan height
+// if the birth is unknown, or the next height if the address is newer than the tip.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) birthHeight(birth int64) int64 {
+	if birth <= 0 {
+		return c.startHeight()
+	}
+	height := c.headers.tip().height + 1
+	for height > 1 && int64(c.headers.at(height-1).header.Timestamp) >= birth-p2pBirthWindow {
+		height--
+	}
+	return height
+}
+
+// promote -- marks the scheduled scripts ready if the rescan reached the scanned height,
+// the rescan restarts for the next new scripts.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) promote() {
+	if c.rescan == 0 || c.rescan <= c.scanned {
+		return
+	}
+	for _, s := range c.scripts {
+		if s.from > 0 {
+			s.ready = true
+		}
+	}
+	c.rescan = 0
+}
+
+// processBlock -- verifies the block against the header and scans the txs.
+func (c *P2PChain) processBlock(job *p2pBlockJob, data []byte) error {
+	header, txs, err := parseRawBlock(data)
+	if err != nil {
+		return err
+	}
+	hash := header.BlockHash()
+	if !bytes.Equal(hash, job.hash) {
+		return fmt.Errorf("p2p.block[%v].hash[%v].unexpected", job.height, xbase.NewIDToString(hash))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Reorganized or restarted since requested.
+	node := c.headers.at(job.height)
+	if node == nil || !bytes.Equal(node.hash, hash) {
+		return nil
+	}
+	if job.rescan {
+		if c.rescan != job.height {
+			return nil
+		}
+		c.rescan++
+	} else {
+		if c.scanned != job.height-1 {
+			return nil
+		}
+		c.scanned++
+	}
+
+	for _, tx := range txs {
+		c.processTx(tx, job.height, int64(header.Timestamp), !job.rescan)
+	}
+	c.promote()
+	c.catchUp()
+	return nil
+}
+
+// catchUp -- marks the forward scanning reached the tip once the headers synced.
+// Before that the results are incomplete even for the ready scripts.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) catchUp() {
+	if c.synced && c.scanned >= c.headers.tip().height {
+		c.caught = true
+	}
+}
+
+// processTx -- records the tx if it pays to or spends from the watched scripts.
+// For the forward scanning, only the ready scripts are matched, the pending will be rescanned.
+// Not thread-safe, the caller must hold the chain lock.
+func (c *P2PChain) processTx(tx *rawTx, height int64, blocktime int64, readyOnly bool) {
+	var related bool
+
+	for i, out := range tx.Outputs {
+		s, ok := c.scripts[hex.EncodeToString(out.Script)]
+		if !ok || (readyOnly && !s.ready) {
+			continue
+		}
+		related = true
+		c.outputs[fmt.Sprintf("%v:%v", tx.Txid, i)] = &p2pOutput{script: hex.EncodeToString(s.script), value: out.Value}
+	}
+	for _, in := range tx.Inputs {
+		outpoint := fmt.Sprintf("%v:%v", in.Txid, in.Vout)
+		if _, ok := c.outputs[outpoint]; ok {
+			related = true
+			c.spents[outpoint] = tx.Txid
+		}
+	}
+	if !related {
+		return
+	}
+
+	ptx, ok := c.txs[tx.Txid]
+	if !ok {
+		ptx = &p2pTx{tx: tx}
+		c.txs[tx.Txid] = ptx
+	}
+	if height > 0 {
+		ptx.height = height
+		ptx.time = blocktime
+	}
+}
+
+// WatchAddress -- adds the address to the watched with its creation time, the rescan starts from it.
+func (c *P2PChain) WatchAddress(address string, createdAt int64) error {
+	_, _, err := c.watch(address, createdAt)
+	return err
+}
+
+// watch -- adds the address to the watched, returns the script and whether it's ready.
+// The birth is the creation time of the address, 0 if it's unknown.
+func (c *P2PChain) watch(address string, birth int64) (string, bool, error) {
+	addr, err := xcore.DecodeAddress(address, c.params.net)
+	if err != nil {
+		return "", false, err
+	}
+	script, err := addr.LockingScript()
+	if err != nil {
+		return "", false, err
+	}
+	key := hex.EncodeToString(script)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.scripts[key]
+	if !ok {
+		s = &p2pScript{address: address, script: script, birth: birth}
+		c.scripts[key] = s
+
+		// Scheduled by the next blocks.
+		select {
+		case c.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+	return key, s.ready && c.caught, nil
+}
+
+// startHeight -- the scan height for the addresses whose creation is unknown.
+func (c *P2PChain) startHeight() int64 {
+	if c.conf.P2P != nil && c.conf.P2P.ScanHeight > 1 {
+		return c.conf.P2P.ScanHeight
+	}
+	return 1
+}
+
+// GetUTXO -- used to get all the unspents of this address.
+func (c *P2PChain) GetUTXO(address string) ([]Unspent, error) {
+	key, ready, err := c.watch(address, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		return nil, fmt.Errorf("p2p.address[%v].scanning", address)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var unspents []Unspent
+	for txid, ptx := range c.txs {
+		for i := range ptx.tx.Outputs {
+			outpoint := fmt.Sprintf("%v:%v", txid, i)
+			out, ok := c.outputs[outpoint]
+			if !ok || out.script != key {
+				continue
+			}
+			if _, spent := c.spents[outpoint]; spent {
+				continue
+			}
+			unspents = append(unspents, Unspent{
+				Txid:         txid,
+				Vout:         uint32(i),
+				Value:        out.value,
+				Confirmed:    ptx.height > 0,
+				BlockTime:    uint32(ptx.time),
+				BlockHeight:  uint32(ptx.height),
+				Scriptpubkey: key,
+			})
+		}
+	}
+	return unspents, nil
+}
+
+// GetTxs -- used to get transactions by address.
+func (c *P2PChain) GetTxs(address string) ([]Tx, error) {
+	key, ready, err := c.watch(address, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		return nil, fmt.Errorf("p2p.address[%v].scanning", address)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var txs []Tx
+	for txid, ptx := range c.txs {
+		var data string
+		var related bool
+		var sentValue int64
+		var receivedValue int64
+		var totalIn, totalOut uint64
+		var known = true
+
+		for _, in := range ptx.tx.Inputs {
+			out, ok := c.outputs[fmt.Sprintf("%v:%v", in.Txid, in.Vout)]
+			if !ok {
+				known = false
+				continue
+			}
+			totalIn += out.value
+			if out.script == key {
+				related = true
+				sentValue += int64(out.value)
+			}
+		}
+		for i, out := range ptx.tx.Outputs {
+			totalOut += out.Value
+			if o, ok := c.outputs[fmt.Sprintf("%v:%v", txid, i)]; ok && o.script == key {
+				related = true
+				receivedValue += int64(out.Value)
+			}
+			if d, ok := opReturnData(out.Script); ok {
+				data = d
+			}
+		}
+		if !related {
+			continue
+		}
+
+		// The fee is known only if all the inputs are ours.
+		var fee int64
+		if known && totalIn >= totalOut {
+			fee = int64(totalIn - totalOut)
+		}
+		txs = append(txs, Tx{
+			Txid:        txid,
+			Fee:         fee,
+			VSize:       ptx.tx.vsize(),
+			Data:        data,
+			Value:       receivedValue - sentValue,
+			Confirmed:   ptx.height > 0,
+			BlockTime:   ptx.time,
+			BlockHeight: ptx.height,
+		})
+	}
+	return txs, nil
+}
+
+// GetFees -- the p2p network has no fee estimation, returns the configured fees.
+func (c *P2PChain) GetFees() (map[string]float32, error) {
+	fees := p2pDefaultFees
+	if c.conf.P2P != nil && len(c.conf.P2P.Fees) > 0 {
+		fees = c.conf.P2P.Fees
+	}
+	ret := make(map[string]float32)
+	for k, v := range fees {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// GetTxLink -- get the tx web link.
+func (c *P2PChain) GetTxLink() string {
+	var link string
+	if c.conf.P2P != nil {
+		link = c.conf.P2P.TxLink
+	}
+	return txLink(c.conf.ChainNet, link)
+}
+
+// PushTx -- used to broadcast the tx to the connected peer, the tx is recorded once the peer accepted it.
+func (c *P2PChain) PushTx(hexstr string) (string, error) {
+	log := c.log
+
+	data, err := hex.DecodeString(hexstr)
+	if err != nil {
+		return "", err
+	}
+	tx, err := parseRawTx(data)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	connected := c.peer != nil
+	c.mu.Unlock()
+	if !connected {
+		return "", fmt.Errorf("p2p.pushtx.no.peer.connected")
+	}
+
+	log.Info("chain.p2p.pushtx.txid:%v", tx.Txid)
+	push := &p2pPush{tx: tx, data: data, result: make(chan error, 1)}
+	timeout := time.After(p2pDialTimeout)
+	select {
+	case c.pushCh <- push:
+	case <-timeout:
+		return "", fmt.Errorf("p2p.pushtx[%v].timeout", tx.Txid)
+	}
+	select {
+	case err := <-push.result:
+		if err != nil {
+			return "", err
+		}
+	case <-timeout:
+		return "", fmt.Errorf("p2p.pushtx[%v].timeout", tx.Txid)
+	}
+	return tx.Txid, nil
+}
+
+// parseRawBlock -- decodes the block, verifies the merkle root of the txs.
+func parseRawBlock(data []byte) (*xprotocol.BlockHeader, []*rawTx, error) {
+	if len(data) < p2pHeaderSize {
+		return nil, nil, fmt.Errorf("p2p.block.size[%v].too.small", len(data))
+	}
+	header, err := decodeBlockHeader(data[:p2pHeaderSize])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buffer := xbase.NewBufferReader(data[p2pHeaderSize:])
+	count, err := buffer.ReadVarInt()
+	if err != nil {
+		return nil, nil, err
+	}
+	offset := p2pHeaderSize + buffer.Seek()
+
+	var txs []*rawTx
+	var hashes [][]byte
+	for i := uint64(0); i < count; i++ {
+		tx, size, err := decodeRawTx(data[offset:])
+		if err != nil {
+			return nil, nil, err
+		}
+		offset += size
+		txs = append(txs, tx)
+		hashes = append(hashes, tx.Hash)
+	}
+	if offset != len(data) {
+		return nil, nil, fmt.Errorf("p2p.block.trailing.bytes[%v]", len(data)-offset)
+	}
+	if root := p2pMerkleRoot(hashes); !bytes.Equal(root, header.MerkleRoot) {
+		return nil, nil, fmt.Errorf("p2p.block.merkle.root.mismatch")
+	}
+	return header, txs, nil
+}
+
+// p2pMerkleRoot -- the merkle root of the tx hashes.
+func p2pMerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return make([]byte, 32)
+	}
+	for len(hashes) > 1 {
+		if len(hashes)%2 == 1 {
+			hashes = append(hashes, hashes[len(hashes)-1])
+		}
+		var next [][]byte
+		for i := 0; i < len(hashes); i += 2 {
+			next = append(next, xcrypto.DoubleSha256(append(append([]byte{}, hashes[i]...), hashes[i+1]...)))
+		}
+		hashes = next
+	}
+	return hashes[0]
+}
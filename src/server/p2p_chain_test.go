@@ -0,0 +1,456 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"xlog"
+
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xcore"
+	"github.com/keyfuse/tokucore/xprotocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockP2PBlock -- the mined block with the raw data.
+type mockP2PBlock struct {
+	header *xprotocol.BlockHeader
+	data   []byte
+}
+
+// newMockP2PBlock -- mines a block with the hex txs on the prev header.
+func newMockP2PBlock(t *testing.T, prev *xprotocol.BlockHeader, txs ...string) *mockP2PBlock {
+	var hashes [][]byte
+	var body bytes.Buffer
+	for _, txhex := range txs {
+		tx, err := parseRawTxHex(txhex)
+		assert.Nil(t, err)
+		hashes = append(hashes, tx.Hash)
+		data, _ := hex.DecodeString(txhex)
+		body.Write(data)
+	}
+	header := &xprotocol.BlockHeader{
+		Version:    1,
+		PrevBlock:  prev.BlockHash(),
+		MerkleRoot: p2pMerkleRoot(hashes),
+		Timestamp:  prev.Timestamp + 600,
+		Bits:       prev.Bits,
+	}
+	mockP2PMine(header)
+
+	buffer := xbase.NewBuffer()
+	buffer.WriteBytes(encodeBlockHeader(header))
+	buffer.WriteVarInt(uint64(len(txs)))
+	buffer.WriteBytes(body.Bytes())
+	return &mockP2PBlock{header: header, data: buffer.Bytes()}
+}
+
+// mockP2PNode -- the scripted bitcoin node serves the headers and blocks of its chain.
+type mockP2PNode struct {
+	mu     sync.Mutex
+	ln     net.Listener
+	params *p2pParams
+	chain  []*mockP2PBlock
+	blocks map[string]*mockP2PBlock
+	peers  []*p2pPeer
+	txCh   chan []byte
+	reject string
+}
+
+func newMockP2PNode(params *p2pParams) *mockP2PNode {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	node := &mockP2PNode{
+		ln:     ln,
+		params: params,
+		chain:  []*mockP2PBlock{{header: params.genesis}},
+		blocks: make(map[string]*mockP2PBlock),
+		txCh:   make(chan []byte, 16),
+	}
+	go node.serve()
+	return node
+}
+
+func (n *mockP2PNode) addr() string {
+	return n.ln.Addr().String()
+}
+
+func (n *mockP2PNode) close() {
+	n.ln.Close()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, peer := range n.peers {
+		peer.close()
+	}
+}
+
+// tip -- the best block header.
+func (n *mockP2PNode) tip() *xprotocol.BlockHeader {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.chain[len(n.chain)-1].header
+}
+
+// at -- the block header at the height.
+func (n *mockP2PNode) at(height int) *xprotocol.BlockHeader {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.chain[height].header
+}
+
+// mine -- sets the blocks from the height, and announces the tip to the peers.
+func (n *mockP2PNode) mine(height int, blocks ...*mockP2PBlock) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.chain = append(n.chain[:height], blocks...)
+	for _, block := range blocks {
+		n.blocks[string(block.header.BlockHash())] = block
+	}
+
+	inv := xprotocol.NewMsgInv()
+	inv.AddInvVect(xprotocol.NewInvVect(xprotocol.InvTypeBlock, n.chain[len(n.chain)-1].header.BlockHash()))
+	for _, peer := range n.peers {
+		peer.send(inv)
+	}
+}
+
+func (n *mockP2PNode) serve() {
+	for {
+		conn, err := n.ln.Accept()
+		if err != nil {
+			return
+		}
+		peer := &p2pPeer{
+			addr:   conn.RemoteAddr().String(),
+			conn:   conn,
+			magic:  n.params.net.Magic,
+			reader: bufio.NewReader(conn),
+			stream: xprotocol.NewStream(conn, n.params.net.Magic),
+		}
+		go n.handle(peer)
+	}
+}
+
+func (n *mockP2PNode) handle(peer *p2pPeer) {
+	defer peer.close()
+	for {
+		command, data, err := peer.readMessage(time.Now().Add(time.Minute))
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		switch command {
+		case xprotocol.CommandVersion:
+			version := xprotocol.NewMsgVersion(n.params.net)
+			version.Services = p2pNodeNetwork
+			version.LastBlock = uint32(len(n.chain) - 1)
+			peer.send(version)
+			peer.send(xprotocol.NewMsgVerAck())
+			n.peers = append(n.peers, peer)
+		case xprotocol.CommandGetHeaders:
+			// The getheaders can't be decoded outside, the locator is unexported.
+			buffer := xbase.NewBufferReader(data)
+			buffer.ReadU32()
+			count, _ := buffer.ReadVarInt()
+			from := 0
+		locator:
+			for i := uint64(0); i < count; i++ {
+				hash, _ := buffer.ReadBytes(32)
+				for height, block := range n.chain {
+					if bytes.Equal(block.header.BlockHash(), hash) {
+						from = height + 1
+						break locator
+					}
+				}
+			}
+			headers := xprotocol.NewMsgHeaders()
+			for height := from; height < len(n.chain) && len(headers.Headers) < xprotocol.MaxBlockHeadersPerMsg; height++ {
+				headers.AddBlockHeader(n.chain[height].header)
+			}
+			peer.send(headers)
+		case xprotocol.CommandGetData:
+			getdata := &xprotocol.MsgGetData{}
+			getdata.Decode(data)
+			for _, iv := range getdata.InvList {
+				if block, ok := n.blocks[string(iv.Hash)]; ok && iv.Type == xprotocol.InvTypeBlock {
+					peer.send(&p2pMessage{command: xprotocol.CommandBlock, data: block.data})
+				}
+			}
+		case xprotocol.CommandPing:
+			ping := &xprotocol.MsgPing{}
+			ping.Decode(data)
+			peer.send(xprotocol.NewMsgPong(ping.Nonce))
+		case xprotocol.CommandTx:
+			if n.reject == "" {
+				n.txCh <- data
+				break
+			}
+			tx, _ := parseRawTx(data)
+			reject := xprotocol.NewMsgReject(xprotocol.CommandTx, xprotocol.RejectInsufficientFee, n.reject)
+			reject.Hash, _ = xbase.NewIDFromString(tx.Txid)
+			peer.send(reject)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// mockP2PWait -- waits the condition to be true.
+func mockP2PWait(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(20 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			assert.FailNow(t, "p2p.wait.timeout")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func mockP2PChain(params *p2pParams, peers []string, headersFile string) *P2PChain {
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+	conf := MockConfig()
+	conf.SpvProvider = "p2p"
+	conf.P2P = &P2PConfig{Peers: peers, ScanHeight: 2, HeadersFile: headersFile}
+	return newP2PChain(log, conf, params)
+}
+
+func TestP2PChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thresh-wallet-p2p")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	headersFile := filepath.Join(dir, "headers")
+
+	params := mockP2PParams(time.Now().Unix() - 24*60*60)
+	node := newMockP2PNode(params)
+	defer node.close()
+
+	script, _ := hex.DecodeString(mockBitcoindScript)
+	other, _ := hex.DecodeString("76a914000000000000000000000000000000000000000088ac")
+	zeroHex, zeroID := mockElectrumTx(t, "1111111111111111111111111111111111111111111111111111111111111111", 0, xcore.NewTxOut(100000, other))
+	fundHex, fundID := mockElectrumTx(t, zeroID, 0, xcore.NewTxOut(93266, script), xcore.NewTxOut(5000, other))
+	spendHex, spendID := mockElectrumTx(t, fundID, 0, xcore.NewTxOut(92266, other))
+	fund2Hex, fund2ID := mockElectrumTx(t, zeroID, 1, xcore.NewTxOut(50000, script))
+	pushHex, pushID := mockElectrumTx(t, fund2ID, 0, xcore.NewTxOut(49000, other))
+	preHex, _ := mockElectrumTx(t, zeroID, 2, xcore.NewTxOut(7000, script))
+
+	// Height 1 is below the scan height, height 2 funds, height 3 spends, height 4 funds again.
+	blocks := make([]*mockP2PBlock, 5)
+	blocks[0] = newMockP2PBlock(t, params.genesis, preHex)
+	blocks[1] = newMockP2PBlock(t, blocks[0].header, zeroHex, fundHex)
+	blocks[2] = newMockP2PBlock(t, blocks[1].header, spendHex)
+	blocks[3] = newMockP2PBlock(t, blocks[2].header, fund2Hex)
+	blocks[4] = newMockP2PBlock(t, blocks[3].header)
+	node.mine(1, blocks...)
+
+	chain := mockP2PChain(params, []string{node.addr()}, headersFile)
+
+	// Scanning.
+	{
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.NotNil(t, err)
+		assert.Equal(t, "p2p.address[mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU].scanning", err.Error())
+	}
+
+	// UTXO.
+	{
+		var utxos []Unspent
+		mockP2PWait(t, func() bool {
+			utxos, err = chain.GetUTXO(mockBitcoindAddress)
+			return err == nil
+		})
+		assert.Equal(t, 1, len(utxos))
+		assert.Equal(t, fund2ID, utxos[0].Txid)
+		assert.Equal(t, uint64(50000), utxos[0].Value)
+		assert.Equal(t, uint32(4), utxos[0].BlockHeight)
+		assert.Equal(t, blocks[3].header.Timestamp, utxos[0].BlockTime)
+		assert.True(t, utxos[0].Confirmed)
+		assert.Equal(t, mockBitcoindScript, utxos[0].Scriptpubkey)
+	}
+
+	// Txs.
+	{
+		txs, err := chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(txs))
+		values := make(map[string]Tx)
+		for _, tx := range txs {
+			values[tx.Txid] = tx
+		}
+		assert.Equal(t, int64(93266), values[fundID].Value)
+		assert.Equal(t, int64(2), values[fundID].BlockHeight)
+		assert.Equal(t, int64(-93266), values[spendID].Value)
+		assert.Equal(t, int64(1000), values[spendID].Fee)
+		assert.Equal(t, int64(50000), values[fund2ID].Value)
+	}
+
+	// The birth height.
+	{
+		chain.mu.Lock()
+		assert.Equal(t, int64(2), chain.birthHeight(0))
+		assert.Equal(t, int64(4), chain.birthHeight(int64(blocks[3].header.Timestamp)+p2pBirthWindow))
+		assert.Equal(t, int64(6), chain.birthHeight(time.Now().Unix()))
+		chain.mu.Unlock()
+	}
+
+	// The new address is scanned from its creation, the history before isn't.
+	{
+		fresh := "mfWxJ45yp2SFn7UciZyNpvDKrzbhyfKrY8"
+		assert.Nil(t, chain.WatchAddress(fresh, time.Now().Unix()))
+		var txs []Tx
+		mockP2PWait(t, func() bool {
+			txs, err = chain.GetTxs(fresh)
+			return err == nil
+		})
+		assert.Equal(t, 0, len(txs))
+		chain.mu.Lock()
+		assert.Equal(t, int64(6), chain.scripts[hex.EncodeToString(other)].from)
+		chain.mu.Unlock()
+	}
+
+	// Push.
+	{
+		txid, err := chain.PushTx(pushHex)
+		assert.Nil(t, err)
+		assert.Equal(t, pushID, txid)
+
+		select {
+		case data := <-node.txCh:
+			assert.Equal(t, pushHex, hex.EncodeToString(data))
+		case <-time.After(10 * time.Second):
+			assert.FailNow(t, "p2p.push.timeout")
+		}
+
+		utxos, err := chain.GetUTXO(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(utxos))
+		txs, err := chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(txs))
+	}
+
+	// Reorg, the fund2 moves from height 4 to 6.
+	{
+		fork4 := newMockP2PBlock(t, blocks[2].header)
+		fork5 := newMockP2PBlock(t, fork4.header)
+		fork6 := newMockP2PBlock(t, fork5.header, fund2Hex, pushHex)
+		node.mine(4, fork4, fork5, fork6)
+
+		mockP2PWait(t, func() bool {
+			txs, err := chain.GetTxs(mockBitcoindAddress)
+			assert.Nil(t, err)
+			for _, tx := range txs {
+				if tx.Txid == pushID {
+					return tx.Confirmed && tx.BlockHeight == 6
+				}
+			}
+			return false
+		})
+		txs, err := chain.GetTxs(mockBitcoindAddress)
+		assert.Nil(t, err)
+		for _, tx := range txs {
+			if tx.Txid == fund2ID {
+				assert.Equal(t, int64(6), tx.BlockHeight)
+			}
+		}
+		assert.Equal(t, int64(6), chain.tipHeight())
+	}
+	chain.Close()
+
+	// The headers are reloaded from the file.
+	{
+		chain := mockP2PChain(params, nil, headersFile)
+		defer chain.Close()
+		assert.Equal(t, int64(6), chain.tipHeight())
+		assert.Equal(t, node.tip().BlockHash(), chain.headers.tip().hash)
+		assert.Equal(t, node.at(3).BlockHash(), chain.headers.at(3).hash)
+
+		// No peers.
+		_, err := chain.PushTx(pushHex)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestP2PChainBadBlock(t *testing.T) {
+	params := mockP2PParams(time.Now().Unix() - 24*60*60)
+	node := newMockP2PNode(params)
+	defer node.close()
+
+	script, _ := hex.DecodeString(mockBitcoindScript)
+	fundHex, _ := mockElectrumTx(t, "1111111111111111111111111111111111111111111111111111111111111111", 0, xcore.NewTxOut(1000, script))
+	block1 := newMockP2PBlock(t, params.genesis)
+	block2 := newMockP2PBlock(t, block1.header, fundHex)
+
+	// Replace the tx, the merkle root mismatch.
+	otherHex, _ := mockElectrumTx(t, "1111111111111111111111111111111111111111111111111111111111111111", 0, xcore.NewTxOut(2000, script))
+	other, _ := hex.DecodeString(otherHex)
+	block2.data = append(block2.data[:81], other...)
+	node.mine(1, block1, block2)
+
+	_, _, err := parseRawBlock(block2.data)
+	assert.NotNil(t, err)
+	assert.Equal(t, "p2p.block.merkle.root.mismatch", err.Error())
+
+	p2pReconnectDelay = 100 * time.Millisecond
+	defer func() { p2pReconnectDelay = 5 * time.Second }()
+	chain := mockP2PChain(params, []string{node.addr()}, "")
+	defer chain.Close()
+
+	// The bad block is never scanned.
+	mockP2PWait(t, func() bool {
+		chain.GetUTXO(mockBitcoindAddress)
+		return chain.tipHeight() == 2
+	})
+	time.Sleep(500 * time.Millisecond)
+	_, err = chain.GetUTXO(mockBitcoindAddress)
+	assert.NotNil(t, err)
+}
+
+func TestP2PChainPushRejected(t *testing.T) {
+	params := mockP2PParams(time.Now().Unix() - 24*60*60)
+	node := newMockP2PNode(params)
+	node.reject = "min relay fee not met"
+	defer node.close()
+
+	script, _ := hex.DecodeString(mockBitcoindScript)
+	other, _ := hex.DecodeString("76a914000000000000000000000000000000000000000088ac")
+	fundHex, fundID := mockElectrumTx(t, "1111111111111111111111111111111111111111111111111111111111111111", 0, xcore.NewTxOut(1000, script))
+	spendHex, _ := mockElectrumTx(t, fundID, 0, xcore.NewTxOut(999, other))
+	block1 := newMockP2PBlock(t, params.genesis)
+	block2 := newMockP2PBlock(t, block1.header, fundHex)
+	node.mine(1, block1, block2)
+
+	chain := mockP2PChain(params, []string{node.addr()}, "")
+	defer chain.Close()
+	mockP2PWait(t, func() bool {
+		_, err := chain.GetUTXO(mockBitcoindAddress)
+		return err == nil
+	})
+
+	// The rejected tx isn't recorded.
+	_, err := chain.PushTx(spendHex)
+	rerr, ok := err.(*TxRejectError)
+	assert.True(t, ok)
+	assert.Equal(t, "min relay fee not met", rerr.Reason)
+	utxos, err := chain.GetUTXO(mockBitcoindAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(utxos))
+	txs, err := chain.GetTxs(mockBitcoindAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(txs))
+}
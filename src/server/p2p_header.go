@@ -0,0 +1,374 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xprotocol"
+)
+
+const (
+	p2pHeaderSize       = 80
+	p2pRetargetInterval = 2016
+	p2pTargetTimespan   = 14 * 24 * 60 * 60
+	p2pTargetSpacing    = 10 * 60
+	p2pMedianTimeBlocks = 11
+	p2pMaxFutureTime    = 2 * 60 * 60
+)
+
+// p2pParams -- the consensus parameters for the header chain validation.
+type p2pParams struct {
+	net           *network.Network
+	genesis       *xprotocol.BlockHeader
+	powLimitBits  uint32
+	noRetarget    bool // The difficulty never changes, like the regtest.
+	minDiffBlocks bool // The testnet allows the min-difficulty block after 20 minutes.
+}
+
+// p2pParamsByNet -- returns the consensus parameters of the chain net.
+func p2pParamsByNet(chainnet string) *p2pParams {
+	merkle, _ := xbase.NewIDFromString("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+	genesis := &xprotocol.BlockHeader{
+		Version:    1,
+		PrevBlock:  make([]byte, 32),
+		MerkleRoot: merkle,
+		Bits:       0x1d00ffff,
+	}
+
+	switch chainnet {
+	case mainnet:
+		genesis.Timestamp = 1231006505
+		genesis.Nonce = 2083236893
+		return &p2pParams{
+			net:          network.MainNet,
+			genesis:      genesis,
+			powLimitBits: 0x1d00ffff,
+		}
+	default:
+		genesis.Timestamp = 1296688602
+		genesis.Nonce = 414098458
+		return &p2pParams{
+			net:           network.TestNet,
+			genesis:       genesis,
+			powLimitBits:  0x1d00ffff,
+			minDiffBlocks: true,
+		}
+	}
+}
+
+// p2pHeaderNode -- the header in the chain with its height and cumulative work.
+type p2pHeaderNode struct {
+	hash   []byte
+	header *xprotocol.BlockHeader
+	height int64
+	work   *big.Int
+}
+
+// p2pHeaders -- the validated header chain from the genesis.
+// Not thread-safe, the P2PChain guards it.
+type p2pHeaders struct {
+	params *p2pParams
+	nodes  []*p2pHeaderNode
+	index  map[string]int64
+}
+
+func newP2PHeaders(params *p2pParams) *p2pHeaders {
+	genesis := params.genesis
+	node := &p2pHeaderNode{
+		hash:   genesis.BlockHash(),
+		header: genesis,
+		height: 0,
+		work:   p2pWork(genesis.Bits),
+	}
+	return &p2pHeaders{
+		params: params,
+		nodes:  []*p2pHeaderNode{node},
+		index:  map[string]int64{string(node.hash): 0},
+	}
+}
+
+// tip -- returns the best header.
+func (hc *p2pHeaders) tip() *p2pHeaderNode {
+	return hc.nodes[len(hc.nodes)-1]
+}
+
+// at -- returns the header at the height of the best chain, nil if not exists.
+func (hc *p2pHeaders) at(height int64) *p2pHeaderNode {
+	if height < 0 || height >= int64(len(hc.nodes)) {
+		return nil
+	}
+	return hc.nodes[height]
+}
+
+// locator -- the block locator hashes, dense for the recent 10 then exponentially back to the genesis.
+func (hc *p2pHeaders) locator() [][]byte {
+	var hashes [][]byte
+
+	step := int64(1)
+	for height := hc.tip().height; height > 0; height -= step {
+		hashes = append(hashes, hc.nodes[height].hash)
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+	}
+	return append(hashes, hc.nodes[0].hash)
+}
+
+// connect -- validates and connects the headers, reorganizes if the new branch has more work.
+// Returns the fork height if the best chain reorganized, otherwise -1.
+func (hc *p2pHeaders) connect(headers []*xprotocol.BlockHeader, now int64) (int64, error) {
+	if len(headers) == 0 {
+		return -1, nil
+	}
+
+	parent, ok := hc.index[string(headers[0].PrevBlock)]
+	if !ok {
+		return -1, fmt.Errorf("p2p.headers.prev[%v].cant.found", xbase.NewIDToString(headers[0].PrevBlock))
+	}
+
+	// Skip the headers we already have.
+	for len(headers) > 0 {
+		next := hc.at(parent + 1)
+		if next == nil || !bytes.Equal(next.hash, headers[0].BlockHash()) {
+			break
+		}
+		parent++
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return -1, nil
+	}
+
+	// Extending the tip appends to the nodes, the existing are never overwritten.
+	branch := hc.nodes[:parent+1]
+	if parent < hc.tip().height {
+		branch = make([]*p2pHeaderNode, parent+1, parent+1+int64(len(headers)))
+		copy(branch, hc.nodes[:parent+1])
+	}
+	for _, header := range headers {
+		prev := branch[len(branch)-1]
+		if !bytes.Equal(header.PrevBlock, prev.hash) {
+			return -1, fmt.Errorf("p2p.headers.not.continuous.at[%v]", prev.height+1)
+		}
+		node := &p2pHeaderNode{
+			hash:   header.BlockHash(),
+			header: header,
+			height: prev.height + 1,
+			work:   new(big.Int).Add(prev.work, p2pWork(header.Bits)),
+		}
+		if err := hc.check(branch, node, now); err != nil {
+			return -1, err
+		}
+		branch = append(branch, node)
+	}
+
+	// Less or equal work, keep the first seen.
+	if branch[len(branch)-1].work.Cmp(hc.tip().work) <= 0 {
+		return -1, nil
+	}
+
+	fork := int64(-1)
+	if parent < hc.tip().height {
+		fork = parent
+	}
+	for _, node := range hc.nodes[parent+1:] {
+		delete(hc.index, string(node.hash))
+	}
+	for _, node := range branch[parent+1:] {
+		hc.index[string(node.hash)] = node.height
+	}
+	hc.nodes = branch
+	return fork, nil
+}
+
+// check -- checks the proof of work, difficulty and timestamp of the header on the branch.
+func (hc *p2pHeaders) check(branch []*p2pHeaderNode, node *p2pHeaderNode, now int64) error {
+	header := node.header
+	params := hc.params
+
+	target := p2pCompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(p2pCompactToBig(params.powLimitBits)) > 0 {
+		return fmt.Errorf("p2p.header[%v].target.out.of.range", node.height)
+	}
+	if p2pHashToBig(node.hash).Cmp(target) > 0 {
+		return fmt.Errorf("p2p.header[%v].proof.of.work.invalid", node.height)
+	}
+	if bits := hc.requiredBits(branch, header); header.Bits != bits {
+		return fmt.Errorf("p2p.header[%v].bits[%x].want[%x]", node.height, header.Bits, bits)
+	}
+
+	// Median time past.
+	var times []int64
+	for i := len(branch) - 1; i >= 0 && len(times) < p2pMedianTimeBlocks; i-- {
+		times = append(times, int64(branch[i].header.Timestamp))
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	if median := times[len(times)/2]; int64(header.Timestamp) <= median {
+		return fmt.Errorf("p2p.header[%v].time[%v].before.median[%v]", node.height, header.Timestamp, median)
+	}
+	if int64(header.Timestamp) > now+p2pMaxFutureTime {
+		return fmt.Errorf("p2p.header[%v].time[%v].too.far.in.future", node.height, header.Timestamp)
+	}
+	return nil
+}
+
+// requiredBits -- the difficulty of the next header on the branch.
+func (hc *p2pHeaders) requiredBits(branch []*p2pHeaderNode, header *xprotocol.BlockHeader) uint32 {
+	params := hc.params
+	prev := branch[len(branch)-1]
+	height := prev.height + 1
+
+	if params.noRetarget {
+		return prev.header.Bits
+	}
+	if height%p2pRetargetInterval != 0 {
+		if !params.minDiffBlocks {
+			return prev.header.Bits
+		}
+		if int64(header.Timestamp) > int64(prev.header.Timestamp)+2*p2pTargetSpacing {
+			return params.powLimitBits
+		}
+		// The last non-special-min-difficulty block.
+		i := prev.height
+		for i > 0 && i%p2pRetargetInterval != 0 && branch[i].header.Bits == params.powLimitBits {
+			i--
+		}
+		return branch[i].header.Bits
+	}
+
+	first := branch[height-p2pRetargetInterval]
+	timespan := int64(prev.header.Timestamp) - int64(first.header.Timestamp)
+	if timespan < p2pTargetTimespan/4 {
+		timespan = p2pTargetTimespan / 4
+	}
+	if timespan > p2pTargetTimespan*4 {
+		timespan = p2pTargetTimespan * 4
+	}
+	target := p2pCompactToBig(prev.header.Bits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(p2pTargetTimespan))
+	if limit := p2pCompactToBig(params.powLimitBits); target.Cmp(limit) > 0 {
+		target = limit
+	}
+	return p2pBigToCompact(target)
+}
+
+// encodeBlockHeader -- the 80 bytes serialization of the header.
+func encodeBlockHeader(header *xprotocol.BlockHeader) []byte {
+	buffer := xbase.NewBuffer()
+	buffer.WriteU32(header.Version)
+	buffer.WriteBytes(header.PrevBlock)
+	buffer.WriteBytes(header.MerkleRoot)
+	buffer.WriteU32(header.Timestamp)
+	buffer.WriteU32(header.Bits)
+	buffer.WriteU32(header.Nonce)
+	return buffer.Bytes()
+}
+
+// decodeBlockHeader -- decodes the 80 bytes header.
+func decodeBlockHeader(data []byte) (*xprotocol.BlockHeader, error) {
+	var err error
+	header := &xprotocol.BlockHeader{}
+	buffer := xbase.NewBufferReader(data)
+
+	if header.Version, err = buffer.ReadU32(); err != nil {
+		return nil, err
+	}
+	if header.PrevBlock, err = buffer.ReadBytes(32); err != nil {
+		return nil, err
+	}
+	if header.MerkleRoot, err = buffer.ReadBytes(32); err != nil {
+		return nil, err
+	}
+	if header.Timestamp, err = buffer.ReadU32(); err != nil {
+		return nil, err
+	}
+	if header.Bits, err = buffer.ReadU32(); err != nil {
+		return nil, err
+	}
+	if header.Nonce, err = buffer.ReadU32(); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// p2pNow -- the current unix time.
+func p2pNow() int64 {
+	return time.Now().Unix()
+}
+
+// p2pHashToBig -- the hash is little-endian.
+func p2pHashToBig(hash []byte) *big.Int {
+	buf := make([]byte, len(hash))
+	for i := range hash {
+		buf[i] = hash[len(hash)-1-i]
+	}
+	return new(big.Int).SetBytes(buf)
+}
+
+// p2pWork -- the work of the header, 2^256 / (target+1).
+func p2pWork(bits uint32) *big.Int {
+	target := p2pCompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// p2pCompactToBig -- converts the compact bits to the target.
+func p2pCompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	negative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+	if negative {
+		bn = bn.Neg(bn)
+	}
+	return bn
+}
+
+// p2pBigToCompact -- converts the target to the compact bits.
+func p2pBigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
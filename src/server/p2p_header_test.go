@@ -0,0 +1,249 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xprotocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockP2PParams -- the regtest-like params, the min difficulty never changes.
+func mockP2PParams(base int64) *p2pParams {
+	genesis := &xprotocol.BlockHeader{
+		Version:    1,
+		PrevBlock:  make([]byte, 32),
+		MerkleRoot: make([]byte, 32),
+		Timestamp:  uint32(base),
+		Bits:       0x207fffff,
+	}
+	mockP2PMine(genesis)
+	return &p2pParams{
+		net:          network.TestNet,
+		genesis:      genesis,
+		powLimitBits: 0x207fffff,
+		noRetarget:   true,
+	}
+}
+
+// mockP2PMine -- finds the nonce meets the target of the header bits.
+func mockP2PMine(header *xprotocol.BlockHeader) {
+	target := p2pCompactToBig(header.Bits)
+	for header.Nonce = 0; p2pHashToBig(header.BlockHash()).Cmp(target) > 0; header.Nonce++ {
+	}
+}
+
+// mockP2PHeaders -- mines the headers on the prev header, one per 10 minutes.
+func mockP2PHeaders(prev *xprotocol.BlockHeader, n int) []*xprotocol.BlockHeader {
+	var headers []*xprotocol.BlockHeader
+	for i := 0; i < n; i++ {
+		header := &xprotocol.BlockHeader{
+			Version:    1,
+			PrevBlock:  prev.BlockHash(),
+			MerkleRoot: make([]byte, 32),
+			Timestamp:  prev.Timestamp + 600,
+			Bits:       prev.Bits,
+		}
+		mockP2PMine(header)
+		headers = append(headers, header)
+		prev = header
+	}
+	return headers
+}
+
+func TestP2PHeadersGenesis(t *testing.T) {
+	tests := []struct {
+		chainnet string
+		hash     string
+	}{
+		{mainnet, "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"},
+		{testnet, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"},
+	}
+	for _, test := range tests {
+		headers := newP2PHeaders(p2pParamsByNet(test.chainnet))
+		assert.Equal(t, test.hash, xbase.NewIDToString(headers.tip().hash))
+		assert.Equal(t, int64(0), headers.tip().height)
+	}
+
+	// The mainnet block 1.
+	{
+		headers := newP2PHeaders(p2pParamsByNet(mainnet))
+		prev, _ := xbase.NewIDFromString("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+		merkle, _ := xbase.NewIDFromString("0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098")
+		block1 := &xprotocol.BlockHeader{
+			Version:    1,
+			PrevBlock:  prev,
+			MerkleRoot: merkle,
+			Timestamp:  1231469665,
+			Bits:       0x1d00ffff,
+			Nonce:      2573394689,
+		}
+		fork, err := headers.connect([]*xprotocol.BlockHeader{block1}, p2pNow())
+		assert.Nil(t, err)
+		assert.Equal(t, int64(-1), fork)
+		assert.Equal(t, "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048", xbase.NewIDToString(headers.tip().hash))
+
+		// Encode and decode.
+		header, err := decodeBlockHeader(encodeBlockHeader(block1))
+		assert.Nil(t, err)
+		assert.Equal(t, block1.BlockHash(), header.BlockHash())
+	}
+}
+
+func TestP2PHeadersCompact(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x207fffff, 0x1b0404cb, 0x170e1b7b} {
+		assert.Equal(t, bits, p2pBigToCompact(p2pCompactToBig(bits)))
+	}
+}
+
+func TestP2PHeadersConnect(t *testing.T) {
+	now := time.Now().Unix()
+	params := mockP2PParams(now - 24*60*60)
+	headers := newP2PHeaders(params)
+
+	main := mockP2PHeaders(params.genesis, 5)
+	fork, err := headers.connect(main, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), fork)
+	assert.Equal(t, int64(5), headers.tip().height)
+	assert.Equal(t, main[4].BlockHash(), headers.tip().hash)
+
+	// Connect again.
+	fork, err = headers.connect(main[2:], now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), fork)
+	assert.Equal(t, int64(5), headers.tip().height)
+
+	// Less work branch is ignored.
+	side := mockP2PHeaders(main[1], 2)
+	side[0].Timestamp++
+	mockP2PMine(side[0])
+	side = append(side[:1], mockP2PHeaders(side[0], 1)...)
+	fork, err = headers.connect(side, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), fork)
+	assert.Equal(t, main[4].BlockHash(), headers.tip().hash)
+
+	// More work branch reorganizes.
+	side = append(side, mockP2PHeaders(side[1], 2)...)
+	fork, err = headers.connect(side, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), fork)
+	assert.Equal(t, int64(6), headers.tip().height)
+	assert.Equal(t, side[3].BlockHash(), headers.tip().hash)
+	assert.Equal(t, main[1].BlockHash(), headers.at(2).hash)
+
+	// Locator.
+	locator := headers.locator()
+	assert.Equal(t, headers.tip().hash, locator[0])
+	assert.Equal(t, params.genesis.BlockHash(), locator[len(locator)-1])
+}
+
+func TestP2PHeadersError(t *testing.T) {
+	now := time.Now().Unix()
+	params := mockP2PParams(now - 24*60*60)
+	tip := mockP2PHeaders(params.genesis, 11)
+
+	tests := []struct {
+		name   string
+		header func() *xprotocol.BlockHeader
+		err    string
+	}{
+		{
+			name: "unknown.prev",
+			header: func() *xprotocol.BlockHeader {
+				h := mockP2PHeaders(tip[10], 1)[0]
+				h.PrevBlock = make([]byte, 32)
+				return h
+			},
+			err: "p2p.headers.prev[0000000000000000000000000000000000000000000000000000000000000000].cant.found",
+		},
+		{
+			name: "proof.of.work",
+			header: func() *xprotocol.BlockHeader {
+				h := mockP2PHeaders(tip[10], 1)[0]
+				target := p2pCompactToBig(h.Bits)
+				for h.Nonce++; p2pHashToBig(h.BlockHash()).Cmp(target) <= 0; h.Nonce++ {
+				}
+				return h
+			},
+			err: "p2p.header[12].proof.of.work.invalid",
+		},
+		{
+			name: "bits",
+			header: func() *xprotocol.BlockHeader {
+				h := mockP2PHeaders(tip[10], 1)[0]
+				h.Bits = 0x2000ffff
+				mockP2PMine(h)
+				return h
+			},
+			err: "p2p.header[12].bits[2000ffff].want[207fffff]",
+		},
+		{
+			name: "target",
+			header: func() *xprotocol.BlockHeader {
+				h := mockP2PHeaders(tip[10], 1)[0]
+				h.Bits = 0x21010000
+				return h
+			},
+			err: "p2p.header[12].target.out.of.range",
+		},
+		{
+			name: "median.time",
+			header: func() *xprotocol.BlockHeader {
+				h := mockP2PHeaders(tip[10], 1)[0]
+				h.Timestamp = tip[5].Timestamp
+				mockP2PMine(h)
+				return h
+			},
+			err: "p2p.header[12].time",
+		},
+		{
+			name: "future.time",
+			header: func() *xprotocol.BlockHeader {
+				h := mockP2PHeaders(tip[10], 1)[0]
+				h.Timestamp = uint32(now + 3*60*60)
+				mockP2PMine(h)
+				return h
+			},
+			err: "too.far.in.future",
+		},
+	}
+	for _, test := range tests {
+		headers := newP2PHeaders(params)
+		_, err := headers.connect(tip, now)
+		assert.Nil(t, err)
+
+		_, err = headers.connect([]*xprotocol.BlockHeader{test.header()}, now)
+		assert.NotNil(t, err, test.name)
+		assert.Contains(t, err.Error(), test.err, test.name)
+		assert.Equal(t, int64(11), headers.tip().height, test.name)
+	}
+}
+
+func TestP2PHeadersRetarget(t *testing.T) {
+	params := p2pParamsByNet(mainnet)
+	headers := newP2PHeaders(params)
+
+	// Blocks twice as fast, the target halves.
+	var branch []*p2pHeaderNode
+	for i := 0; i < p2pRetargetInterval; i++ {
+		branch = append(branch, &p2pHeaderNode{
+			height: int64(i),
+			header: &xprotocol.BlockHeader{Bits: 0x1d00ffff, Timestamp: uint32(1231006505 + i*300)},
+		})
+	}
+	bits := headers.requiredBits(branch, &xprotocol.BlockHeader{})
+	assert.Equal(t, uint32(0x1c7fef3f), bits)
+
+	// Not at the retarget height.
+	assert.Equal(t, uint32(0x1d00ffff), headers.requiredBits(branch[:100], &xprotocol.BlockHeader{}))
+}
@@ -0,0 +1,157 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xprotocol"
+)
+
+const (
+	p2pDialTimeout = 10 * time.Second
+	p2pIdleTimeout = 5 * time.Minute
+	p2pMaxPayload  = 32 * 1024 * 1024
+	p2pNodeNetwork = 1
+)
+
+// p2pMessage -- the raw message for the commands which xprotocol can't decode, such as block.
+type p2pMessage struct {
+	command string
+	data    []byte
+}
+
+// Encode -- the implementation method for xprotocol.Message.
+func (m *p2pMessage) Encode() []byte {
+	return m.data
+}
+
+// Decode -- the implementation method for xprotocol.Message.
+func (m *p2pMessage) Decode(data []byte) error {
+	m.data = data
+	return nil
+}
+
+// Size -- the implementation method for xprotocol.Message.
+func (m *p2pMessage) Size() int {
+	return len(m.data)
+}
+
+// Command -- the implementation method for xprotocol.Message.
+func (m *p2pMessage) Command() string {
+	return m.command
+}
+
+// p2pPeer -- the connection to a bitcoin node.
+// The xprotocol.Stream drops the payload of the unknown commands, so the peer reads the frames itself.
+type p2pPeer struct {
+	addr    string
+	conn    net.Conn
+	magic   []byte
+	reader  *bufio.Reader
+	stream  *xprotocol.Stream
+	version *xprotocol.MsgVersion
+}
+
+// dialP2PPeer -- connects to the node and does the version handshake.
+func dialP2PPeer(addr string, netwrk *network.Network, height int64) (*p2pPeer, error) {
+	conn, err := net.DialTimeout("tcp", addr, p2pDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	peer := &p2pPeer{
+		addr:   addr,
+		conn:   conn,
+		magic:  netwrk.Magic,
+		reader: bufio.NewReader(conn),
+		stream: xprotocol.NewStream(conn, netwrk.Magic),
+	}
+
+	version := xprotocol.NewMsgVersion(netwrk)
+	version.Nonce = rand.Uint64()
+	version.LastBlock = uint32(height)
+	version.Relay = 1
+	if err := peer.send(version); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var verack bool
+	deadline := time.Now().Add(p2pDialTimeout)
+	for peer.version == nil || !verack {
+		command, data, err := peer.readMessage(deadline)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		switch command {
+		case xprotocol.CommandVersion:
+			msg := &xprotocol.MsgVersion{}
+			if err := msg.Decode(data); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			if msg.Services&p2pNodeNetwork == 0 {
+				conn.Close()
+				return nil, fmt.Errorf("p2p.peer[%v].services[%x].not.network.node", addr, msg.Services)
+			}
+			peer.version = msg
+			if err := peer.send(xprotocol.NewMsgVerAck()); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		case xprotocol.CommandVersionAck:
+			verack = true
+		}
+	}
+	return peer, nil
+}
+
+// readMessage -- reads the next message frame, returns the command and payload.
+func (p *p2pPeer) readMessage(deadline time.Time) (string, []byte, error) {
+	p.conn.SetReadDeadline(deadline)
+
+	head := make([]byte, 24)
+	if _, err := io.ReadFull(p.reader, head); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(head[:4], p.magic) {
+		return "", nil, fmt.Errorf("p2p.peer[%v].magic[%x].mismatch", p.addr, head[:4])
+	}
+	command := string(bytes.TrimRight(head[4:16], "\x00"))
+	size := binary.LittleEndian.Uint32(head[16:20])
+	if size > p2pMaxPayload {
+		return "", nil, fmt.Errorf("p2p.peer[%v].%v.payload[%v].too.large", p.addr, command, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(p.reader, data); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(head[20:24], xcrypto.DoubleSha256(data)[:4]) {
+		return "", nil, fmt.Errorf("p2p.peer[%v].%v.checksum.mismatch", p.addr, command)
+	}
+	return command, data, nil
+}
+
+// send -- writes the message.
+func (p *p2pPeer) send(msg xprotocol.Message) error {
+	return p.stream.WriteMessage(msg)
+}
+
+// close -- closes the connection.
+func (p *p2pPeer) close() {
+	p.conn.Close()
+}
@@ -0,0 +1,203 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keyfuse/tokucore/xcore"
+)
+
+const (
+	policyDay  = 24 * 60 * 60
+	policyWeek = 7 * policyDay
+)
+
+// Policy rules, used as the PolicyError.Rule.
+const (
+	PolicyDailyLimit      = "daily_limit"
+	PolicyWeeklyLimit     = "weekly_limit"
+	PolicyMaxTxValue      = "max_tx_value"
+	PolicyMinSendInterval = "min_send_interval"
+	PolicyMaxFeesPerKB    = "max_fees_per_kb"
+	PolicyWhitelist       = "whitelist"
+	PolicyResign          = "resign"
+)
+
+// Policy -- the spending rules which the co-signer enforces before releasing its share.
+// Zero value of the rule means no limit.
+type Policy struct {
+	DailyLimit      uint64 `json:"daily_limit"`
+	WeeklyLimit     uint64 `json:"weekly_limit"`
+	MaxTxValue      uint64 `json:"max_tx_value"`
+	MinSendInterval int64  `json:"min_send_interval"`
+	MaxFeesPerKB    uint64 `json:"max_fees_per_kb"`
+	// AllowResign -- the sighash co-signed can be co-signed again, refused by default.
+	AllowResign bool `json:"allow_resign"`
+}
+
+// PolicyError -- the error of the policy violation.
+type PolicyError struct {
+	Rule    string
+	Limit   uint64
+	Value   uint64
+	Address string
+}
+
+// Error -- the implementation method for error interface.
+func (e *PolicyError) Error() string {
+	if e.Rule == PolicyResign {
+		return "policy.resign.violated.sighash.cosigned"
+	}
+	if e.Address != "" {
+		return fmt.Sprintf("policy.%v.violated.address[%v]", e.Rule, e.Address)
+	}
+	return fmt.Sprintf("policy.%v.violated.limit[%v].value[%v]", e.Rule, e.Limit, e.Value)
+}
+
+// Spend -- the outbound tx which the co-signer has signed.
+type Spend struct {
+	Time      int64    `json:"time"`
+	Value     uint64   `json:"value"`
+	Outpoints []string `json:"outpoints"`
+}
+
+// PolicyState -- the policy in effect and the pending loosening one, the pending takes effect at the PendingAt.
+type PolicyState struct {
+	Policy    Policy
+	Pending   *Policy
+	PendingAt int64
+}
+
+// allowResign -- the nil policy refuses the replays.
+func (p *Policy) allowResign() bool {
+	return p != nil && p.AllowResign
+}
+
+// looser -- returns true if the policy loosens any rule of the current, the nil current has no limit.
+func (p *Policy) looser(current *Policy) bool {
+	if current == nil {
+		current = &Policy{}
+	}
+	looser := func(value uint64, limit uint64) bool {
+		return limit > 0 && (value == 0 || value > limit)
+	}
+	return looser(p.DailyLimit, current.DailyLimit) ||
+		looser(p.WeeklyLimit, current.WeeklyLimit) ||
+		looser(p.MaxTxValue, current.MaxTxValue) ||
+		looser(p.MaxFeesPerKB, current.MaxFeesPerKB) ||
+		p.MinSendInterval < current.MinSendInterval ||
+		(p.AllowResign && !current.AllowResign)
+}
+
+// overlaps -- returns true if the spend has the same outpoint.
+func (s *Spend) overlaps(outpoints []string) bool {
+	for _, a := range s.Outpoints {
+		for _, b := range outpoints {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SpendStat -- the outbound stat of the wallet.
+type SpendStat struct {
+	DailyValue  uint64
+	WeeklyValue uint64
+	LastTime    int64
+}
+
+// check -- checks the outbound value and fees of the tx with the stat.
+func (p *Policy) check(stat *SpendStat, value uint64, fees uint64, ins int, outs int, now int64) error {
+	if p.MaxTxValue > 0 && value > p.MaxTxValue {
+		return &PolicyError{Rule: PolicyMaxTxValue, Limit: p.MaxTxValue, Value: value}
+	}
+	if p.DailyLimit > 0 && (stat.DailyValue+value) > p.DailyLimit {
+		return &PolicyError{Rule: PolicyDailyLimit, Limit: p.DailyLimit, Value: stat.DailyValue + value}
+	}
+	if p.WeeklyLimit > 0 && (stat.WeeklyValue+value) > p.WeeklyLimit {
+		return &PolicyError{Rule: PolicyWeeklyLimit, Limit: p.WeeklyLimit, Value: stat.WeeklyValue + value}
+	}
+	if p.MinSendInterval > 0 && stat.LastTime > 0 {
+		if elapsed := now - stat.LastTime; elapsed < p.MinSendInterval {
+			return &PolicyError{Rule: PolicyMinSendInterval, Limit: uint64(p.MinSendInterval), Value: uint64(elapsed)}
+		}
+	}
+	if p.MaxFeesPerKB > 0 {
+		estsize := xcore.EstimateNormalSize(ins, outs)
+		feesPerKB := fees * 1000 / uint64(estsize)
+		if feesPerKB > p.MaxFeesPerKB {
+			return &PolicyError{Rule: PolicyMaxFeesPerKB, Limit: p.MaxFeesPerKB, Value: feesPerKB}
+		}
+	}
+	return nil
+}
+
+// policy -- returns the policy in effect at the time, the pending one if it's due,
+// then the wallet own policy, otherwise the defaults.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) policy(defaults *Policy, now int64) *Policy {
+	if w.PendingPolicy != nil && now >= w.PolicyAt {
+		return w.PendingPolicy
+	}
+	if w.Policy != nil {
+		return w.Policy
+	}
+	return defaults
+}
+
+// SetPolicy -- sets the wallet own policy, the tightening takes effect immediately and cancels the pending,
+// the loosening takes effect after the delay seconds.
+func (w *Wallet) SetPolicy(policy Policy, defaults *Policy, delay int64) (PolicyState, error) {
+	if policy.MinSendInterval < 0 {
+		return PolicyState{}, fmt.Errorf("wallet.policy.min.send.interval[%v].negative", policy.MinSendInterval)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now().Unix()
+	if w.PendingPolicy != nil && now >= w.PolicyAt {
+		w.Policy = w.PendingPolicy
+		w.PendingPolicy = nil
+		w.PolicyAt = 0
+	}
+	if policy.looser(w.policy(defaults, now)) {
+		w.PendingPolicy = &policy
+		w.PolicyAt = now + delay
+	} else {
+		w.Policy = &policy
+		w.PendingPolicy = nil
+		w.PolicyAt = 0
+	}
+	return w.policyState(defaults, now), nil
+}
+
+// GetPolicy -- returns the policy in effect and the pending one.
+func (w *Wallet) GetPolicy(defaults *Policy) PolicyState {
+	w.Lock()
+	defer w.Unlock()
+	return w.policyState(defaults, time.Now().Unix())
+}
+
+// policyState -- returns the copy of the policy state at the time.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) policyState(defaults *Policy, now int64) PolicyState {
+	var state PolicyState
+	if policy := w.policy(defaults, now); policy != nil {
+		state.Policy = *policy
+	}
+	if w.PendingPolicy != nil && now < w.PolicyAt {
+		pending := *w.PendingPolicy
+		state.Pending = &pending
+		state.PendingAt = w.PolicyAt
+	}
+	return state
+}
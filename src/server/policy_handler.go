@@ -0,0 +1,128 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"proto"
+)
+
+func (h *Handler) walletPolicy(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletPolicy", r)
+	if err != nil {
+		log.Error("api.wallet.policy.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletPolicyRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet.policy[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].policy.req:%+v", uid, req)
+
+	state, err := wdb.Policy(uid)
+	if err != nil {
+		log.Error("api.wallet.policy[%v].wdb.policy.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	rsp := policyResponse(state)
+	log.Info("api.wallet.policy.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+func (h *Handler) walletSetPolicy(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletSetPolicy", r)
+	if err != nil {
+		log.Error("api.wallet.set.policy.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletSetPolicyRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet.set.policy[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].set.policy.req:%+v", uid, req)
+
+	entry := &AuditEntry{Event: auditWalletPolicy, UID: uid, Detail: fmt.Sprintf("%+v", req.WalletPolicy)}
+	state, err := wdb.SetPolicy(uid, Policy{
+		DailyLimit:      req.DailyLimit,
+		WeeklyLimit:     req.WeeklyLimit,
+		MaxTxValue:      req.MaxTxValue,
+		MinSendInterval: req.MinSendInterval,
+		MaxFeesPerKB:    req.MaxFeesPerKB,
+		AllowResign:     req.AllowResign,
+	})
+	if err != nil {
+		log.Error("api.wallet.set.policy[%v].wdb.set.policy.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+	if err := h.auditEvent(r, entry, nil); err != nil {
+		resp.writeError(err)
+		return
+	}
+
+	// Notify.
+	if state.PendingAt > 0 {
+		pendingAt := time.Unix(state.PendingAt, 0).UTC()
+		h.notify(uid, "KeyFuse Labs Wallet Policy Loosening",
+			fmt.Sprintf("The loosened spending policy of your wallet will take effect at %v.", pendingAt))
+	}
+
+	rsp := policyResponse(state)
+	log.Info("api.wallet.set.policy.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+func protoPolicy(policy *Policy) proto.WalletPolicy {
+	return proto.WalletPolicy{
+		DailyLimit:      policy.DailyLimit,
+		WeeklyLimit:     policy.WeeklyLimit,
+		MaxTxValue:      policy.MaxTxValue,
+		MinSendInterval: policy.MinSendInterval,
+		MaxFeesPerKB:    policy.MaxFeesPerKB,
+		AllowResign:     policy.AllowResign,
+	}
+}
+
+func policyResponse(state *PolicyState) *proto.WalletPolicyResponse {
+	rsp := &proto.WalletPolicyResponse{
+		Policy:    protoPolicy(&state.Policy),
+		PendingAt: state.PendingAt,
+	}
+	if state.Pending != nil {
+		pending := protoPolicy(state.Pending)
+		rsp.Pending = &pending
+	}
+	return rsp
+}
@@ -0,0 +1,313 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"proto"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockPolicyWallet(t *testing.T) *Wallet {
+	wallet := NewWallet()
+	err := json.Unmarshal([]byte(mock13888888888Json), wallet)
+	assert.Nil(t, err)
+	wallet.net = network.TestNet
+	return wallet
+}
+
+func TestPolicyCheck(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		policy Policy
+		stat   SpendStat
+		value  uint64
+		fees   uint64
+		rule   string
+	}{
+		{"nolimit", Policy{}, SpendStat{DailyValue: 1e8, WeeklyValue: 1e8, LastTime: now}, 1e8, 1e6, ""},
+		{"maxtx", Policy{MaxTxValue: 1000}, SpendStat{}, 1001, 0, PolicyMaxTxValue},
+		{"daily", Policy{DailyLimit: 1000}, SpendStat{DailyValue: 500}, 501, 0, PolicyDailyLimit},
+		{"daily.ok", Policy{DailyLimit: 1000}, SpendStat{DailyValue: 500}, 500, 0, ""},
+		{"weekly", Policy{DailyLimit: 1000, WeeklyLimit: 2000}, SpendStat{WeeklyValue: 1800}, 500, 0, PolicyWeeklyLimit},
+		{"interval", Policy{MinSendInterval: 60}, SpendStat{LastTime: now - 30}, 1, 0, PolicyMinSendInterval},
+		{"interval.ok", Policy{MinSendInterval: 60}, SpendStat{LastTime: now - 61}, 1, 0, ""},
+		{"feerate", Policy{MaxFeesPerKB: 10000}, SpendStat{}, 1, 100000, PolicyMaxFeesPerKB},
+		{"feerate.ok", Policy{MaxFeesPerKB: 10000}, SpendStat{}, 1, 1000, ""},
+	}
+
+	for _, test := range tests {
+		err := test.policy.check(&test.stat, test.value, test.fees, 1, 2, now)
+		if test.rule == "" {
+			assert.Nil(t, err, test.name)
+			continue
+		}
+		perr, ok := err.(*PolicyError)
+		assert.True(t, ok, test.name)
+		assert.Equal(t, test.rule, perr.Rule, test.name)
+	}
+}
+
+func TestWalletPolicy(t *testing.T) {
+	wallet := mockPolicyWallet(t)
+	tx := mockSignTx()
+	hash, err := tx.SignatureHash(0)
+	assert.Nil(t, err)
+
+	// Outbound, the output pays to the wallet address.
+	{
+		assert.Equal(t, uint64(3266), wallet.outbound(tx))
+	}
+
+	// Max tx value.
+	{
+		policy := &Policy{MaxTxValue: 3000}
+		err := wallet.CheckSignTx(tx, 0, 2, hash, policy)
+		assert.Equal(t, PolicyMaxTxValue, err.(*PolicyError).Rule)
+
+		// Wallet policy overrides the defaults.
+		wallet.Policy = &Policy{MaxTxValue: 5000}
+		err = wallet.CheckSignTx(tx, 0, 2, hash, policy)
+		assert.Nil(t, err)
+		wallet.Policy = nil
+	}
+
+	// Min send interval.
+	{
+		policy := &Policy{MinSendInterval: 3600}
+		wallet.RecordSpend(tx)
+		assert.Equal(t, 1, len(wallet.Spends))
+
+		// Same tx is ok for the other inputs signing.
+		err := wallet.CheckSignTx(tx, 0, 2, hash, policy)
+		assert.Nil(t, err)
+
+		// Others.
+		other := &proto.Tx{
+			Version: 1,
+			Inputs: []proto.TxIn{
+				{
+					Pos:          2,
+					Txid:         "2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a",
+					Vout:         1,
+					Value:        10000,
+					Sequence:     proto.DefaultSequence,
+					Scriptpubkey: "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac",
+				},
+			},
+			Outputs: []proto.TxOut{
+				{
+					Value:  9000,
+					Script: "76a914000000000000000000000000000000000000000088ac",
+				},
+			},
+		}
+		otherHash, err := other.SignatureHash(0)
+		assert.Nil(t, err)
+		err = wallet.CheckSignTx(other, 0, 2, otherHash, policy)
+		assert.Equal(t, PolicyMinSendInterval, err.(*PolicyError).Rule)
+
+		// Daily limit with the pending spend.
+		err = wallet.CheckSignTx(other, 0, 2, otherHash, &Policy{DailyLimit: 13000})
+		assert.Equal(t, PolicyDailyLimit, err.(*PolicyError).Rule)
+		err = wallet.CheckSignTx(other, 0, 2, otherHash, &Policy{DailyLimit: 13266})
+		assert.Nil(t, err)
+
+		// Re-sign replaces the spend.
+		wallet.RecordSpend(tx)
+		assert.Equal(t, 1, len(wallet.Spends))
+	}
+
+	// History outbound.
+	{
+		wallet.Spends = nil
+		now := time.Now().Unix()
+		wallet.UpdateTxs("mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU", []Tx{
+			{Txid: "a1", Value: -5000, Confirmed: true, BlockTime: now - 60},
+			{Txid: "a2", Value: -7000, Confirmed: true, BlockTime: now - 3*policyDay},
+			{Txid: "a3", Value: -1000},
+		})
+		wallet.UpdateTxs("msV128vgApMNEFbTUy5wto12ucZNFdtKTA", []Tx{
+			{Txid: "a1", Value: 4000, Confirmed: true, BlockTime: now - 60},
+		})
+		wallet.Lock()
+		stat := wallet.spendStat(nil, now)
+		wallet.Unlock()
+		assert.Equal(t, uint64(2000), stat.DailyValue)
+		assert.Equal(t, uint64(9000), stat.WeeklyValue)
+		assert.Equal(t, now-60, stat.LastTime)
+	}
+}
+
+func TestPolicyLooser(t *testing.T) {
+	current := &Policy{DailyLimit: 1000, MinSendInterval: 60}
+	tests := []struct {
+		name   string
+		policy Policy
+		looser bool
+	}{
+		{"same", Policy{DailyLimit: 1000, MinSendInterval: 60}, false},
+		{"tighter", Policy{DailyLimit: 500, WeeklyLimit: 2000, MinSendInterval: 120}, false},
+		{"daily.raise", Policy{DailyLimit: 1001, MinSendInterval: 60}, true},
+		{"daily.remove", Policy{MinSendInterval: 60}, true},
+		{"interval.lower", Policy{DailyLimit: 1000, MinSendInterval: 30}, true},
+		{"resign", Policy{DailyLimit: 1000, MinSendInterval: 60, AllowResign: true}, true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.looser, test.policy.looser(current), test.name)
+	}
+
+	// The nil current has no limit.
+	assert.False(t, (&Policy{}).looser(nil))
+	assert.True(t, (&Policy{AllowResign: true}).looser(nil))
+}
+
+func TestWalletSetPolicy(t *testing.T) {
+	wallet := mockPolicyWallet(t)
+	tx := mockSignTx()
+	hash, err := tx.SignatureHash(0)
+	assert.Nil(t, err)
+	defaults := &Policy{MaxTxValue: 5000}
+
+	// The defaults without own policy.
+	{
+		state := wallet.GetPolicy(defaults)
+		assert.Equal(t, uint64(5000), state.Policy.MaxTxValue)
+		assert.Nil(t, state.Pending)
+	}
+
+	// Tightening is immediate.
+	{
+		state, err := wallet.SetPolicy(Policy{MaxTxValue: 3000}, defaults, 3600)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(3000), state.Policy.MaxTxValue)
+		assert.Nil(t, state.Pending)
+		err = wallet.CheckSignTx(tx, 0, 2, hash, defaults)
+		assert.Equal(t, PolicyMaxTxValue, err.(*PolicyError).Rule)
+	}
+
+	// Loosening is delayed.
+	{
+		state, err := wallet.SetPolicy(Policy{MaxTxValue: 10000}, defaults, 3600)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(3000), state.Policy.MaxTxValue)
+		assert.Equal(t, uint64(10000), state.Pending.MaxTxValue)
+		assert.True(t, state.PendingAt > time.Now().Unix())
+		err = wallet.CheckSignTx(tx, 0, 2, hash, defaults)
+		assert.Equal(t, PolicyMaxTxValue, err.(*PolicyError).Rule)
+	}
+
+	// Tightening cancels the pending.
+	{
+		state, err := wallet.SetPolicy(Policy{MaxTxValue: 2000}, defaults, 3600)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(2000), state.Policy.MaxTxValue)
+		assert.Nil(t, state.Pending)
+		assert.Equal(t, int64(0), state.PendingAt)
+	}
+
+	// The pending takes effect once it's due.
+	{
+		_, err := wallet.SetPolicy(Policy{MaxTxValue: 10000}, defaults, 3600)
+		assert.Nil(t, err)
+		wallet.PolicyAt = time.Now().Unix() - 1
+		err = wallet.CheckSignTx(tx, 0, 2, hash, defaults)
+		assert.Nil(t, err)
+		state := wallet.GetPolicy(defaults)
+		assert.Equal(t, uint64(10000), state.Policy.MaxTxValue)
+		assert.Nil(t, state.Pending)
+	}
+
+	// Invalid.
+	{
+		_, err := wallet.SetPolicy(Policy{MinSendInterval: -1}, defaults, 3600)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestWalletPolicyHandler(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	// Set, tightening.
+	{
+		req := &proto.WalletSetPolicyRequest{WalletPolicy: proto.WalletPolicy{DailyLimit: 100000}}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/policy/set", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		rsp := &proto.WalletPolicyResponse{}
+		err = httpRsp.Json(rsp)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(100000), rsp.Policy.DailyLimit)
+		assert.Nil(t, rsp.Pending)
+	}
+
+	// Set, loosening.
+	{
+		req := &proto.WalletSetPolicyRequest{}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/policy/set", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+	}
+
+	// Get.
+	{
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/policy", &proto.WalletPolicyRequest{})
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		rsp := &proto.WalletPolicyResponse{}
+		err = httpRsp.Json(rsp)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(100000), rsp.Policy.DailyLimit)
+		assert.Equal(t, uint64(0), rsp.Pending.DailyLimit)
+		assert.True(t, rsp.PendingAt > 0)
+	}
+
+	// Invalid.
+	{
+		req := &proto.WalletSetPolicyRequest{WalletPolicy: proto.WalletPolicy{MinSendInterval: -1}}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/policy/set", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+}
+
+func TestEcdsaPolicyHandler(t *testing.T) {
+	conf := MockConfig()
+	conf.Policy = &Policy{MaxTxValue: 1000}
+	ts, cleanup := MockServerWithConfig(conf)
+	defer cleanup()
+
+	tx := mockSignTx()
+	tx.Outputs[0].Script = "76a914000000000000000000000000000000000000000088ac"
+	hash, err := tx.SignatureHash(0)
+	assert.Nil(t, err)
+
+	req := &proto.EcdsaR2Request{
+		Pos:  2,
+		Tx:   tx,
+		Hash: hash,
+	}
+	httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/ecdsa/r2", req)
+	assert.Nil(t, err)
+	assert.Equal(t, 403, httpRsp.StatusCode())
+
+	violation := &proto.PolicyViolation{}
+	err = json.Unmarshal([]byte(httpRsp.Body()), violation)
+	assert.Nil(t, err)
+	assert.Equal(t, PolicyMaxTxValue, violation.Rule)
+	assert.Equal(t, uint64(1000), violation.Limit)
+	assert.Equal(t, uint64(93266), violation.Value)
+}
@@ -0,0 +1,373 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"proto"
+	"xlog"
+)
+
+const (
+	blockchainInfo = "blockchain.info"
+	coinbase       = "coinbase"
+	bitstamp       = "bitstamp"
+	static         = "static"
+
+	defaultFiatCode    = "CNY"
+	defaultPriceMaxAge = 60 * 60
+)
+
+var (
+	// fiatSymbols -- the symbols of the fiat codes, the feeds only report these codes.
+	fiatSymbols = map[string]string{
+		"USD": "$",
+		"EUR": "€",
+		"GBP": "£",
+		"JPY": "¥",
+		"CNY": "¥",
+		"KRW": "₩",
+		"RUB": "₽",
+		"INR": "₹",
+		"AUD": "$",
+		"CAD": "$",
+		"HKD": "$",
+		"SGD": "$",
+		"CHF": "CHF",
+	}
+
+	// bitstampCodes -- the fiat codes traded on the bitstamp.
+	bitstampCodes = []string{"USD", "EUR", "GBP"}
+)
+
+// Ticker -- the ticker of the blockchain.info.
+type Ticker struct {
+	One5M  float64 `json:"15m"`
+	Last   float64 `json:"last"`
+	Buy    float64 `json:"buy"`
+	Sell   float64 `json:"sell"`
+	Symbol string  `json:"symbol"`
+}
+
+// Price -- the BTC price in the fiat, the time is when the price updated.
+type Price struct {
+	Code   string
+	Symbol string
+	Last   float64
+	Source string
+	Time   int64
+}
+
+// PriceFeed -- the BTC price provider.
+type PriceFeed interface {
+	Name() string
+	GetPrices() (map[string]Price, error)
+}
+
+// NewPriceFeed -- creates the median feed of the configured feeds, default is the blockchain.info.
+func NewPriceFeed(log *xlog.Log, conf *Config) PriceFeed {
+	pconf := conf.PriceFeed
+	if pconf == nil {
+		pconf = &PriceFeedConfig{}
+	}
+
+	var feeds []PriceFeed
+	for _, name := range pconf.Feeds {
+		switch name {
+		case blockchainInfo:
+			feeds = append(feeds, NewBlockchainInfoFeed())
+		case coinbase:
+			feeds = append(feeds, NewCoinbaseFeed())
+		case bitstamp:
+			feeds = append(feeds, NewBitstampFeed())
+		case static:
+			feeds = append(feeds, NewStaticFeed(pconf.Static))
+		default:
+			log.Error("pricefeed[%v].unknown.skipped", name)
+		}
+	}
+	if len(feeds) == 0 {
+		feeds = append(feeds, NewBlockchainInfoFeed())
+	}
+	return NewMedianFeed(log, pconf.MinFeeds, feeds...)
+}
+
+// priceCode -- returns the code, the configured default code if empty.
+func priceCode(conf *Config, code string) string {
+	if code == "" && conf.PriceFeed != nil {
+		code = conf.PriceFeed.FiatCode
+	}
+	if code == "" {
+		code = defaultFiatCode
+	}
+	return code
+}
+
+// priceMaxAge -- returns the max age of the price in seconds.
+func priceMaxAge(conf *Config) int64 {
+	if conf.PriceFeed != nil && conf.PriceFeed.MaxAge > 0 {
+		return conf.PriceFeed.MaxAge
+	}
+	return defaultPriceMaxAge
+}
+
+// fiatSymbol -- returns the symbol of the code, the code itself if unknown.
+func fiatSymbol(code string) string {
+	if symbol, ok := fiatSymbols[code]; ok {
+		return symbol
+	}
+	return code
+}
+
+// BlockchainInfoFeed -- the prices from the blockchain.info ticker.
+type BlockchainInfoFeed struct {
+	url string
+}
+
+// NewBlockchainInfoFeed -- creates new BlockchainInfoFeed.
+func NewBlockchainInfoFeed() *BlockchainInfoFeed {
+	return &BlockchainInfoFeed{url: "https://blockchain.info/ticker"}
+}
+
+// Name -- the feed name.
+func (f *BlockchainInfoFeed) Name() string {
+	return blockchainInfo
+}
+
+// GetPrices -- used to get the prices.
+func (f *BlockchainInfoFeed) GetPrices() (map[string]Price, error) {
+	httpRsp, err := proto.NewRequest().Get(f.url)
+	if err != nil {
+		return nil, err
+	}
+	if httpRsp.StatusCode() != 200 {
+		return nil, fmt.Errorf("pricefeed[%v].rsp.error:%v", f.Name(), httpRsp.StatusCode())
+	}
+
+	tickers := make(map[string]Ticker)
+	if err := httpRsp.Json(&tickers); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	prices := make(map[string]Price)
+	for code, ticker := range tickers {
+		prices[code] = Price{Code: code, Symbol: ticker.Symbol, Last: ticker.Last, Source: f.Name(), Time: now}
+	}
+	return prices, nil
+}
+
+// CoinbaseRates -- the exchange rates of the coinbase.
+type CoinbaseRates struct {
+	Data struct {
+		Currency string            `json:"currency"`
+		Rates    map[string]string `json:"rates"`
+	} `json:"data"`
+}
+
+// CoinbaseFeed -- the prices from the coinbase exchange rates, only the fiat codes are reported.
+type CoinbaseFeed struct {
+	url string
+}
+
+// NewCoinbaseFeed -- creates new CoinbaseFeed.
+func NewCoinbaseFeed() *CoinbaseFeed {
+	return &CoinbaseFeed{url: "https://api.coinbase.com/v2/exchange-rates?currency=BTC"}
+}
+
+// Name -- the feed name.
+func (f *CoinbaseFeed) Name() string {
+	return coinbase
+}
+
+// GetPrices -- used to get the prices.
+func (f *CoinbaseFeed) GetPrices() (map[string]Price, error) {
+	httpRsp, err := proto.NewRequest().Get(f.url)
+	if err != nil {
+		return nil, err
+	}
+	if httpRsp.StatusCode() != 200 {
+		return nil, fmt.Errorf("pricefeed[%v].rsp.error:%v", f.Name(), httpRsp.StatusCode())
+	}
+
+	rates := &CoinbaseRates{}
+	if err := httpRsp.Json(rates); err != nil {
+		return nil, err
+	}
+	if rates.Data.Currency != "BTC" {
+		return nil, fmt.Errorf("pricefeed[%v].currency[%v].not.btc", f.Name(), rates.Data.Currency)
+	}
+	now := time.Now().Unix()
+	prices := make(map[string]Price)
+	for code, rate := range rates.Data.Rates {
+		if _, ok := fiatSymbols[code]; !ok {
+			continue
+		}
+		last, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, err
+		}
+		prices[code] = Price{Code: code, Symbol: fiatSymbol(code), Last: last, Source: f.Name(), Time: now}
+	}
+	return prices, nil
+}
+
+// BitstampTicker -- the ticker of the bitstamp.
+type BitstampTicker struct {
+	Last      string `json:"last"`
+	Timestamp string `json:"timestamp"`
+}
+
+// BitstampFeed -- the prices from the bitstamp tickers.
+type BitstampFeed struct {
+	url string
+}
+
+// NewBitstampFeed -- creates new BitstampFeed.
+func NewBitstampFeed() *BitstampFeed {
+	return &BitstampFeed{url: "https://www.bitstamp.net/api/v2/ticker"}
+}
+
+// Name -- the feed name.
+func (f *BitstampFeed) Name() string {
+	return bitstamp
+}
+
+// GetPrices -- used to get the prices, one request per code.
+func (f *BitstampFeed) GetPrices() (map[string]Price, error) {
+	prices := make(map[string]Price)
+	for _, code := range bitstampCodes {
+		httpRsp, err := proto.NewRequest().Get(fmt.Sprintf("%s/btc%s/", f.url, strings.ToLower(code)))
+		if err != nil {
+			return nil, err
+		}
+		if httpRsp.StatusCode() != 200 {
+			return nil, fmt.Errorf("pricefeed[%v].code[%v].rsp.error:%v", f.Name(), code, httpRsp.StatusCode())
+		}
+
+		ticker := &BitstampTicker{}
+		if err := httpRsp.Json(ticker); err != nil {
+			return nil, err
+		}
+		last, err := strconv.ParseFloat(ticker.Last, 64)
+		if err != nil {
+			return nil, err
+		}
+		timestamp, err := strconv.ParseInt(ticker.Timestamp, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		prices[code] = Price{Code: code, Symbol: fiatSymbol(code), Last: last, Source: f.Name(), Time: timestamp}
+	}
+	return prices, nil
+}
+
+// StaticFeed -- the prices from the config, they are never stale.
+type StaticFeed struct {
+	prices map[string]float64
+}
+
+// NewStaticFeed -- creates new StaticFeed.
+func NewStaticFeed(prices map[string]float64) *StaticFeed {
+	return &StaticFeed{prices: prices}
+}
+
+// Name -- the feed name.
+func (f *StaticFeed) Name() string {
+	return static
+}
+
+// GetPrices -- used to get the prices.
+func (f *StaticFeed) GetPrices() (map[string]Price, error) {
+	if len(f.prices) == 0 {
+		return nil, fmt.Errorf("pricefeed[%v].prices.empty", f.Name())
+	}
+	now := time.Now().Unix()
+	prices := make(map[string]Price)
+	for code, last := range f.prices {
+		prices[code] = Price{Code: code, Symbol: fiatSymbol(code), Last: last, Source: f.Name(), Time: now}
+	}
+	return prices, nil
+}
+
+// MedianFeed -- the median price of the feeds.
+// The code reported by less than min feeds is dropped, the time is the oldest of the feeds.
+type MedianFeed struct {
+	log      *xlog.Log
+	feeds    []PriceFeed
+	minFeeds int
+}
+
+// NewMedianFeed -- creates new MedianFeed.
+func NewMedianFeed(log *xlog.Log, minFeeds int, feeds ...PriceFeed) *MedianFeed {
+	if minFeeds < 1 {
+		minFeeds = 1
+	}
+	return &MedianFeed{log: log, feeds: feeds, minFeeds: minFeeds}
+}
+
+// Name -- the feed name.
+func (f *MedianFeed) Name() string {
+	var names []string
+	for _, feed := range f.feeds {
+		names = append(names, feed.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// GetPrices -- used to get the median prices, errors only if all the feeds failed.
+func (f *MedianFeed) GetPrices() (map[string]Price, error) {
+	log := f.log
+
+	var errs []string
+	all := make(map[string][]Price)
+	for _, feed := range f.feeds {
+		prices, err := feed.GetPrices()
+		if err != nil {
+			log.Error("pricefeed[%v].get.prices.error:%v", feed.Name(), err)
+			errs = append(errs, fmt.Sprintf("%v:%v", feed.Name(), err))
+			continue
+		}
+		for code, price := range prices {
+			all[code] = append(all[code], price)
+		}
+	}
+	if len(errs) == len(f.feeds) {
+		return nil, fmt.Errorf("pricefeed.all.feeds.failed[%v]", strings.Join(errs, ", "))
+	}
+
+	prices := make(map[string]Price)
+	for code, list := range all {
+		if len(list) < f.minFeeds {
+			log.Warning("pricefeed.code[%v].feeds[%v].less.than.min[%v]", code, len(list), f.minFeeds)
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Last < list[j].Last })
+
+		var sources []string
+		median := list[0]
+		for _, price := range list {
+			sources = append(sources, price.Source)
+			if price.Time < median.Time {
+				median.Time = price.Time
+			}
+		}
+		if n := len(list); n%2 == 0 {
+			median.Last = (list[n/2-1].Last + list[n/2].Last) / 2
+		} else {
+			median.Last = list[n/2].Last
+		}
+		sort.Strings(sources)
+		median.Source = strings.Join(sources, ",")
+		prices[code] = median
+	}
+	return prices, nil
+}
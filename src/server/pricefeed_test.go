@@ -0,0 +1,229 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"xlog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPriceFeed -- the scripted feed.
+type mockPriceFeed struct {
+	mu     sync.Mutex
+	name   string
+	prices map[string]Price
+	err    error
+}
+
+func (f *mockPriceFeed) Name() string {
+	return f.name
+}
+
+func (f *mockPriceFeed) GetPrices() (map[string]Price, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.prices, f.err
+}
+
+func (f *mockPriceFeed) setError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func mockPriceFeedServer(bodies map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func TestPriceFeeds(t *testing.T) {
+	ts := mockPriceFeedServer(map[string]string{
+		"/ticker":                `{"USD":{"15m":10721.13,"last":10721.13,"buy":10721.13,"sell":10721.13,"symbol":"$"},"CNY":{"15m":73711.13,"last":73711.13,"buy":73711.13,"sell":73711.13,"symbol":"¥"}}`,
+		"/v2/exchange-rates":     `{"data":{"currency":"BTC","rates":{"USD":"10731.5","EUR":"9650.01","ETH":"55.1"}}}`,
+		"/api/v2/ticker/btcusd/": `{"last":"10711.00","timestamp":"1565000000"}`,
+		"/api/v2/ticker/btceur/": `{"last":"9640.00","timestamp":"1565000000"}`,
+		"/api/v2/ticker/btcgbp/": `{"last":"8840.00","timestamp":"1565000000"}`,
+	})
+	defer ts.Close()
+
+	// blockchain.info.
+	{
+		feed := &BlockchainInfoFeed{url: ts.URL + "/ticker"}
+		prices, err := feed.GetPrices()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(prices))
+		assert.Equal(t, 73711.13, prices["CNY"].Last)
+		assert.Equal(t, "¥", prices["CNY"].Symbol)
+		assert.Equal(t, "blockchain.info", prices["CNY"].Source)
+	}
+
+	// coinbase, the crypto code is dropped.
+	{
+		feed := &CoinbaseFeed{url: ts.URL + "/v2/exchange-rates?currency=BTC"}
+		prices, err := feed.GetPrices()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(prices))
+		assert.Equal(t, 10731.5, prices["USD"].Last)
+		assert.Equal(t, "€", prices["EUR"].Symbol)
+	}
+
+	// bitstamp.
+	{
+		feed := &BitstampFeed{url: ts.URL + "/api/v2/ticker"}
+		prices, err := feed.GetPrices()
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(prices))
+		assert.Equal(t, 10711.0, prices["USD"].Last)
+		assert.Equal(t, int64(1565000000), prices["GBP"].Time)
+	}
+
+	// static.
+	{
+		feed := NewStaticFeed(map[string]float64{"USD": 10000})
+		prices, err := feed.GetPrices()
+		assert.Nil(t, err)
+		assert.Equal(t, "$", prices["USD"].Symbol)
+		assert.Equal(t, "static", prices["USD"].Source)
+
+		_, err = NewStaticFeed(nil).GetPrices()
+		assert.NotNil(t, err)
+	}
+
+	// Error.
+	{
+		feed := &BlockchainInfoFeed{url: ts.URL + "/404"}
+		_, err := feed.GetPrices()
+		assert.NotNil(t, err)
+		assert.Equal(t, "pricefeed[blockchain.info].rsp.error:404", err.Error())
+	}
+}
+
+func TestMedianFeed(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	now := time.Now().Unix()
+	a := &mockPriceFeed{name: "a", prices: map[string]Price{
+		"USD": {Code: "USD", Symbol: "$", Last: 100, Source: "a", Time: now},
+		"CNY": {Code: "CNY", Symbol: "¥", Last: 700, Source: "a", Time: now},
+	}}
+	b := &mockPriceFeed{name: "b", prices: map[string]Price{
+		"USD": {Code: "USD", Symbol: "$", Last: 104, Source: "b", Time: now - 10},
+	}}
+	c := &mockPriceFeed{name: "c", prices: map[string]Price{
+		"USD": {Code: "USD", Symbol: "$", Last: 500, Source: "c", Time: now},
+	}}
+
+	// Odd, the outlier is ignored.
+	{
+		feed := NewMedianFeed(log, 0, a, b, c)
+		assert.Equal(t, "a,b,c", feed.Name())
+		prices, err := feed.GetPrices()
+		assert.Nil(t, err)
+		assert.Equal(t, 104.0, prices["USD"].Last)
+		assert.Equal(t, "a,b,c", prices["USD"].Source)
+		assert.Equal(t, now-10, prices["USD"].Time)
+		assert.Equal(t, 700.0, prices["CNY"].Last)
+		assert.Equal(t, "a", prices["CNY"].Source)
+	}
+
+	// Even, c failed.
+	{
+		c.setError(errors.New("mock.c.error"))
+		feed := NewMedianFeed(log, 0, a, b, c)
+		prices, err := feed.GetPrices()
+		assert.Nil(t, err)
+		assert.Equal(t, 102.0, prices["USD"].Last)
+		assert.Equal(t, "a,b", prices["USD"].Source)
+	}
+
+	// Min feeds.
+	{
+		feed := NewMedianFeed(log, 2, a, b, c)
+		prices, err := feed.GetPrices()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(prices))
+		_, ok := prices["CNY"]
+		assert.False(t, ok)
+	}
+
+	// All failed.
+	{
+		feed := NewMedianFeed(log, 0, c)
+		_, err := feed.GetPrices()
+		assert.NotNil(t, err)
+		assert.Equal(t, "pricefeed.all.feeds.failed[c:mock.c.error]", err.Error())
+	}
+
+	// Config.
+	{
+		conf := MockConfig()
+		conf.PriceFeed = &PriceFeedConfig{Feeds: []string{"blockchain.info", "coinbase", "bitstamp", "static", "unknown"}}
+		assert.Equal(t, "blockchain.info,coinbase,bitstamp,static", NewPriceFeed(log, conf).Name())
+		conf.PriceFeed = nil
+		assert.Equal(t, "blockchain.info", NewPriceFeed(log, conf).Name())
+	}
+}
+
+func TestWalletDBPrice(t *testing.T) {
+	conf := MockConfig()
+	conf.PriceFeed.MaxAge = 60
+	conf.PriceFeed.FiatCode = "USD"
+	log := xlog.NewStdLog(xlog.Level(xlog.INFO))
+	wdb := NewWalletDB(log, conf)
+	defer wdb.Close()
+
+	now := time.Now().Unix()
+	feed := &mockPriceFeed{name: "mock", prices: map[string]Price{
+		"USD": {Code: "USD", Symbol: "$", Last: 100, Source: "mock", Time: now},
+		"EUR": {Code: "EUR", Symbol: "€", Last: 90, Source: "mock", Time: now - 120},
+	}}
+	wdb.setChain(newMockChain(log))
+	wdb.setPriceFeed(feed)
+
+	dir := "/tmp/tss"
+	os.RemoveAll(dir)
+	err := wdb.Open(dir)
+	assert.Nil(t, err)
+
+	// Default code.
+	{
+		price, err := wdb.GetPrice("")
+		assert.Nil(t, err)
+		assert.Equal(t, 100.0, price.Last)
+		assert.Equal(t, "mock", price.Source)
+	}
+
+	// Stale.
+	{
+		_, err := wdb.GetPrice("EUR")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "wallet.price[EUR].source[mock].stale")
+	}
+
+	// The feed failed, the last price is kept until stale.
+	{
+		feed.setError(errors.New("mock.error"))
+		wdb.syncer.Sync()
+		price, err := wdb.GetPrice("USD")
+		assert.Nil(t, err)
+		assert.Equal(t, 100.0, price.Last)
+	}
+}
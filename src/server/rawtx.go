@@ -0,0 +1,172 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/keyfuse/tokucore/xbase"
+	"github.com/keyfuse/tokucore/xcrypto"
+)
+
+// rawTxIn -- the outpoint and the sequence of the input.
+type rawTxIn struct {
+	Txid     string
+	Vout     uint32
+	Sequence uint32
+}
+
+// rawTxOut -- the output.
+type rawTxOut struct {
+	Value  uint64
+	Script []byte
+}
+
+// rawTx -- the decoded raw transaction, the xcore.Transaction doesn't export the inputs and outputs.
+// The weight is the size without the witness*3 plus the size(BIP141).
+type rawTx struct {
+	Hash     []byte
+	Txid     string
+	Weight   int64
+	Version  uint32
+	LockTime uint32
+	Inputs   []rawTxIn
+	Outputs  []rawTxOut
+}
+
+// vsize -- the virtual size of the tx, the weight/4 rounded up.
+func (tx *rawTx) vsize() int64 {
+	return (tx.Weight + 3) / 4
+}
+
+// parseRawTx -- decodes the serialized transaction with or without the witness.
+func parseRawTx(data []byte) (*rawTx, error) {
+	tx, size, err := decodeRawTx(data)
+	if err != nil {
+		return nil, err
+	}
+	if size != len(data) {
+		return nil, fmt.Errorf("rawtx.trailing.bytes[%v]", len(data)-size)
+	}
+	return tx, nil
+}
+
+// decodeRawTx -- decodes the transaction at the beginning of the data, returns the size it takes.
+func decodeRawTx(data []byte) (*rawTx, int, error) {
+	var err error
+	var witness bool
+	tx := &rawTx{}
+	buffer := xbase.NewBufferReader(data)
+
+	// Version.
+	if tx.Version, err = buffer.ReadU32(); err != nil {
+		return nil, 0, err
+	}
+
+	// Witness marker and flag.
+	if len(data) > 6 && data[4] == 0x00 && data[5] != 0x00 {
+		witness = true
+		if _, err = buffer.ReadBytes(2); err != nil {
+			return nil, 0, err
+		}
+	}
+	start := buffer.Seek()
+
+	// Inputs.
+	ins, err := buffer.ReadVarInt()
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := uint64(0); i < ins; i++ {
+		hash, err := buffer.ReadBytes(32)
+		if err != nil {
+			return nil, 0, err
+		}
+		vout, err := buffer.ReadU32()
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := buffer.ReadVarBytes(); err != nil {
+			return nil, 0, err
+		}
+		sequence, err := buffer.ReadU32()
+		if err != nil {
+			return nil, 0, err
+		}
+		tx.Inputs = append(tx.Inputs, rawTxIn{Txid: xbase.NewIDToString(hash), Vout: vout, Sequence: sequence})
+	}
+
+	// Outputs.
+	outs, err := buffer.ReadVarInt()
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := uint64(0); i < outs; i++ {
+		value, err := buffer.ReadU64()
+		if err != nil {
+			return nil, 0, err
+		}
+		script, err := buffer.ReadVarBytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		tx.Outputs = append(tx.Outputs, rawTxOut{Value: value, Script: script})
+	}
+	end := buffer.Seek()
+
+	// Witness.
+	if witness {
+		for i := uint64(0); i < ins; i++ {
+			items, err := buffer.ReadVarInt()
+			if err != nil {
+				return nil, 0, err
+			}
+			for j := uint64(0); j < items; j++ {
+				if _, err := buffer.ReadVarBytes(); err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+	}
+
+	// Lock time.
+	if tx.LockTime, err = buffer.ReadU32(); err != nil {
+		return nil, 0, err
+	}
+	size := buffer.Seek()
+
+	// Txid is the hash of the serialization without witness.
+	ser := make([]byte, 0, 8+end-start)
+	ser = append(ser, data[:4]...)
+	ser = append(ser, data[start:end]...)
+	ser = append(ser, data[size-4:size]...)
+	tx.Hash = xcrypto.DoubleSha256(ser)
+	tx.Txid = xbase.NewIDToString(tx.Hash)
+	tx.Weight = int64(len(ser)*3 + size)
+	return tx, size, nil
+}
+
+// parseRawTxHex -- decodes the hex serialized transaction.
+func parseRawTxHex(hexstr string) (*rawTx, error) {
+	data, err := hex.DecodeString(hexstr)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawTx(data)
+}
+
+// opReturnData -- returns the data of the OP_RETURN script.
+func opReturnData(script []byte) (string, bool) {
+	if len(script) == 0 || script[0] != 0x6a {
+		return "", false
+	}
+	if len(script) > 2 {
+		return string(script[2:]), true
+	}
+	return "", true
+}
@@ -0,0 +1,226 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"proto"
+)
+
+const (
+	// rbfIncrementalFeesPerKB -- the replacement pays the fees of its own size at this rate more than the original(BIP125).
+	rbfIncrementalFeesPerKB = 1000
+
+	// sentMaxAge -- the sent txs are kept for the mempool expiry, 2 weeks.
+	sentMaxAge = 2 * policyWeek
+)
+
+// SentTx -- the tx pushed by the wallet with the prevouts of its inputs, it's for the fee bumping.
+// The ReplacedBy is the txid of the replacement which spends the same inputs.
+type SentTx struct {
+	Txid       string   `json:"txid"`
+	Time       int64    `json:"time"`
+	VSize      int64    `json:"vsize"`
+	Fees       uint64   `json:"fees"`
+	RBF        bool     `json:"rbf"`
+	Tx         proto.Tx `json:"tx"`
+	ReplacedBy string   `json:"replaced_by,omitempty"`
+}
+
+// BumpFeeOutput -- the output of the tx to bump, the change is back to the wallet.
+type BumpFeeOutput struct {
+	proto.TxOut
+	Change bool
+}
+
+// BumpFee -- the original inputs and outputs of the tx, and the fees of the target rate for the replacement.
+type BumpFee struct {
+	Txid       string
+	Inputs     []UTXO
+	Outputs    []BumpFeeOutput
+	VSize      int64
+	Fees       uint64
+	FeesPerKB  int
+	TargetFees uint64
+}
+
+// RecordSent -- records the pushed tx if all its inputs are of the wallet, the pending txs which spend the same
+// inputs are marked as replaced by it.
+func (w *Wallet) RecordSent(raw *rawTx) error {
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now().Unix()
+	sent := &SentTx{
+		Txid: raw.Txid,
+		Time: now,
+		Tx:   proto.Tx{Version: 1},
+	}
+	sent.VSize = raw.vsize()
+
+	var totalIn, totalOut uint64
+	for i, in := range raw.Inputs {
+		txin := w.sentInput(in.Txid, in.Vout)
+		if addr, unspent := w.unspent(in.Txid, in.Vout); unspent != nil {
+			txin = &proto.TxIn{
+				Pos:          addr.Pos,
+				Txid:         unspent.Txid,
+				Vout:         unspent.Vout,
+				Value:        unspent.Value,
+				Scriptpubkey: unspent.Scriptpubkey,
+			}
+		}
+		if txin == nil {
+			return fmt.Errorf("wallet.sent.tx[%v].input[%v].outpoint[%v:%v].not.of.wallet", raw.Txid, i, in.Txid, in.Vout)
+		}
+		txin.Sequence = in.Sequence
+		if proto.IsRBFSequence(in.Sequence) {
+			sent.RBF = true
+		}
+		sent.Tx.Inputs = append(sent.Tx.Inputs, *txin)
+		totalIn += txin.Value
+	}
+	for _, out := range raw.Outputs {
+		sent.Tx.Outputs = append(sent.Tx.Outputs, proto.TxOut{Value: out.Value, Script: hex.EncodeToString(out.Script)})
+		totalOut += out.Value
+	}
+	if totalOut > totalIn {
+		return fmt.Errorf("wallet.sent.tx[%v].outputs[%v].larger.than.inputs[%v]", raw.Txid, totalOut, totalIn)
+	}
+	sent.Fees = totalIn - totalOut
+
+	if w.Sent == nil {
+		w.Sent = make(map[string]*SentTx)
+	}
+	ops := outpoints(&sent.Tx)
+	for txid, old := range w.Sent {
+		if (now - old.Time) >= sentMaxAge {
+			delete(w.Sent, txid)
+			continue
+		}
+		if txid != sent.Txid && old.ReplacedBy == "" && !w.txConfirmed(txid) && old.overlaps(ops) {
+			old.ReplacedBy = sent.Txid
+		}
+	}
+	w.Sent[sent.Txid] = sent
+	return nil
+}
+
+// BumpFee -- returns the inputs and outputs of the sent tx to replace, the target fees are of the feesPerKB.
+// The tx must be pending and signal the replace-by-fee.
+func (w *Wallet) BumpFee(txid string, feesPerKB int) (*BumpFee, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	sent, ok := w.Sent[txid]
+	if !ok {
+		return nil, fmt.Errorf("wallet.bumpfee.tx[%v].cant.found", txid)
+	}
+	if sent.ReplacedBy != "" {
+		return nil, fmt.Errorf("wallet.bumpfee.tx[%v].replaced.by[%v]", txid, sent.ReplacedBy)
+	}
+	if w.txConfirmed(txid) {
+		return nil, fmt.Errorf("wallet.bumpfee.tx[%v].confirmed", txid)
+	}
+	if !sent.RBF {
+		return nil, fmt.Errorf("wallet.bumpfee.tx[%v].not.signal.rbf", txid)
+	}
+
+	share, err := proto.ParseKeyShare(w.svrMasterKey())
+	if err != nil {
+		return nil, err
+	}
+	bump := &BumpFee{
+		Txid:      txid,
+		VSize:     sent.VSize,
+		Fees:      sent.Fees,
+		FeesPerKB: feesPerKB,
+	}
+	for _, in := range sent.Tx.Inputs {
+		svrchild, err := share.Derive(in.Pos)
+		if err != nil {
+			return nil, err
+		}
+		address, _ := scriptAddress(in.Scriptpubkey, w.net)
+		bump.Inputs = append(bump.Inputs, UTXO{
+			Pos:          in.Pos,
+			Txid:         in.Txid,
+			Vout:         in.Vout,
+			Value:        in.Value,
+			Address:      address,
+			SvrPubKey:    svrchild.HDPublicKey().ToString(w.net),
+			Scriptpubkey: in.Scriptpubkey,
+		})
+	}
+	for _, out := range sent.Tx.Outputs {
+		bump.Outputs = append(bump.Outputs, BumpFeeOutput{TxOut: out, Change: w.isOwnScript(out.Script)})
+	}
+
+	bump.TargetFees = uint64((sent.VSize*int64(feesPerKB) + 999) / 1000)
+	if min := sent.Fees + uint64((sent.VSize*rbfIncrementalFeesPerKB+999)/1000); bump.TargetFees < min {
+		bump.TargetFees = min
+	}
+	return bump, nil
+}
+
+// sentInput -- returns the input of the outpoint which is spent by the pending replaceable sent tx, nil if not found.
+// The input is still spendable by the replacement although it's not unspent.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) sentInput(txid string, vout uint32) *proto.TxIn {
+	for _, sent := range w.Sent {
+		if !sent.RBF || sent.ReplacedBy != "" || w.txConfirmed(sent.Txid) {
+			continue
+		}
+		for _, in := range sent.Tx.Inputs {
+			if in.Txid == txid && in.Vout == vout {
+				txin := in
+				return &txin
+			}
+		}
+	}
+	return nil
+}
+
+// replacedTxids -- returns the sent txids which are replaced, or to be replaced by the tx of the outpoints.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) replacedTxids(ops []string) map[string]bool {
+	txids := make(map[string]bool)
+	for txid, sent := range w.Sent {
+		if sent.ReplacedBy != "" || (!w.txConfirmed(txid) && sent.overlaps(ops)) {
+			txids[txid] = true
+		}
+	}
+	return txids
+}
+
+// txConfirmed -- returns true if the tx is confirmed in the history.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) txConfirmed(txid string) bool {
+	for _, addr := range w.Address {
+		for _, tx := range addr.Txs {
+			if tx.Txid == txid && (tx.Confirmed || tx.BlockHeight > 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// overlaps -- returns true if the sent tx spends one of the outpoints.
+func (s *SentTx) overlaps(ops []string) bool {
+	for _, a := range outpoints(&s.Tx) {
+		for _, b := range ops {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
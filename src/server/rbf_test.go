@@ -0,0 +1,142 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockRBFRawTx(t *testing.T, tx *proto.Tx) *rawTx {
+	xtx, err := tx.Transaction()
+	assert.Nil(t, err)
+	raw, err := parseRawTxHex(hex.EncodeToString(xtx.Serialize()))
+	assert.Nil(t, err)
+	return raw
+}
+
+func mockRBFTx(sequence uint32, change uint64) *proto.Tx {
+	return &proto.Tx{
+		Version: 1,
+		Inputs: []proto.TxIn{
+			{
+				Pos:          2,
+				Txid:         "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df",
+				Vout:         0,
+				Value:        93266,
+				Sequence:     sequence,
+				Scriptpubkey: "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac",
+			},
+		},
+		Outputs: []proto.TxOut{
+			{
+				Value:  50000,
+				Script: "76a914000000000000000000000000000000000000000088ac",
+			},
+			{
+				Value:  change,
+				Script: "76a914490e0eebcc5d462221ea38d00a6aee1238db2a5788ac",
+			},
+		},
+	}
+}
+
+func TestWalletBumpFee(t *testing.T) {
+	addr := "mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU"
+	wallet := mockPolicyWallet(t)
+	unspents, err := newMockChain(nil).GetUTXO(addr)
+	assert.Nil(t, err)
+	wallet.UpdateUnspents(addr, unspents)
+
+	sendtx := mockRBFTx(proto.RBFSequence, 42266)
+	raw := mockRBFRawTx(t, sendtx)
+	vsize := raw.vsize()
+
+	// Record.
+	{
+		assert.Nil(t, wallet.RecordSent(raw))
+		sent := wallet.Sent[raw.Txid]
+		assert.True(t, sent.RBF)
+		assert.Equal(t, uint64(1000), sent.Fees)
+		assert.Equal(t, vsize, sent.VSize)
+
+		// The input isn't of the wallet.
+		foreign := mockRBFTx(proto.RBFSequence, 42266)
+		foreign.Inputs[0].Vout = 9
+		assert.NotNil(t, wallet.RecordSent(mockRBFRawTx(t, foreign)))
+	}
+
+	// Bump.
+	{
+		bump, err := wallet.BumpFee(raw.Txid, 20000)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64((vsize*20000+999)/1000), bump.TargetFees)
+		assert.Equal(t, 1, len(bump.Inputs))
+		assert.Equal(t, addr, bump.Inputs[0].Address)
+		assert.NotEqual(t, "", bump.Inputs[0].SvrPubKey)
+		assert.False(t, bump.Outputs[0].Change)
+		assert.True(t, bump.Outputs[1].Change)
+
+		// The incremental relay fees at least.
+		bump, err = wallet.BumpFee(raw.Txid, 1000)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1000+vsize), bump.TargetFees)
+
+		_, err = wallet.BumpFee("xx", 5000)
+		assert.NotNil(t, err)
+	}
+
+	// The replacement input isn't unspent after the broadcast.
+	replacement := mockRBFTx(proto.RBFSequence, 41266)
+	{
+		wallet.UpdateUnspents(addr, unspents[1:])
+		hash, err := replacement.SignatureHash(0)
+		assert.Nil(t, err)
+		assert.Nil(t, wallet.CheckSignTx(replacement, 0, 2, hash, &Policy{}))
+	}
+
+	// Replaced.
+	{
+		rraw := mockRBFRawTx(t, replacement)
+		assert.Nil(t, wallet.RecordSent(rraw))
+		assert.Equal(t, rraw.Txid, wallet.Sent[raw.Txid].ReplacedBy)
+
+		_, err := wallet.BumpFee(raw.Txid, 5000)
+		assert.NotNil(t, err)
+		_, err = wallet.BumpFee(rraw.Txid, 5000)
+		assert.Nil(t, err)
+
+		wallet.UpdateTxs(addr, append(wallet.Address[addr].Txs, Tx{Txid: raw.Txid}))
+		var replaced int
+		for _, tx := range wallet.Txs(0, 100) {
+			if tx.Txid == raw.Txid {
+				assert.Equal(t, rraw.Txid, tx.ReplacedBy)
+				replaced++
+			}
+		}
+		assert.Equal(t, 1, replaced)
+	}
+
+	// Not signal the rbf.
+	{
+		final := mockRBFTx(proto.DefaultSequence, 42266)
+		final.Inputs[0].Txid = "2335b1b00d149907e0ce9eb349da87234d2c9bd0dfcc216cb251c3b21d63054a"
+		final.Inputs[0].Vout = 1
+		final.Inputs[0].Value = 10000
+		final.Outputs = final.Outputs[:1]
+		final.Outputs[0].Value = 9000
+		fraw := mockRBFRawTx(t, final)
+		assert.Nil(t, wallet.RecordSent(fraw))
+
+		_, err := wallet.BumpFee(fraw.Txid, 5000)
+		assert.NotNil(t, err)
+	}
+}
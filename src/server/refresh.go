@@ -0,0 +1,195 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"proto"
+
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+const (
+	refreshExpired = 10 * 60
+)
+
+// KeyRefresh -- the last key refresh committed of the wallet.
+// The shares of the pos before the cutover and the change pos before the change cutover are refreshed,
+// the others are derived from the new master keys.
+type KeyRefresh struct {
+	ID            string `json:"id"`
+	Time          int64  `json:"time"`
+	Cutover       uint32 `json:"cutover"`
+	ChangeCutover uint32 `json:"change_cutover,omitempty"`
+}
+
+// pendingRefresh -- the key refresh prepared and not committed.
+type pendingRefresh struct {
+	id              string
+	cutover         uint32
+	changeCutover   uint32
+	cliMasterPubKey string
+	svrPubKey       string
+	expired         int64
+}
+
+// RefreshPrepared -- the result of the refresh prepared.
+type RefreshPrepared struct {
+	ID            string
+	Factor        []byte
+	Cutover       uint32
+	ChangeCutover uint32
+	SvrPubKey     string
+	NewSvrPubKey  string
+	Expired       int64
+}
+
+// PrepareRefresh -- prepares the key refresh of the wallet by the client factor and the new client master public key.
+// The refresh factor is the product of the client and server factors, the server share is refreshed by the inverse.
+// The wallet keeps the old shares until committed, the prepared one is replaced by the next.
+func (wdb *WalletDB) PrepareRefresh(uid string, cliFactor []byte, cliMasterPubKey string) (*RefreshPrepared, error) {
+	net := wdb.net
+	store := wdb.store
+	n := secp256k1.SECP256K1().Params().N
+
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.refresh.uid[%v].cant.found", uid)
+	}
+	rc := new(big.Int).SetBytes(cliFactor)
+	if rc.Sign() <= 0 || rc.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("wdb.refresh.uid[%v].factor.invalid", uid)
+	}
+	rs, err := randScalar()
+	if err != nil {
+		return nil, err
+	}
+	r := new(big.Int).Mul(rc, rs)
+	r.Mod(r, n)
+	rinv := new(big.Int).ModInverse(r, n)
+
+	// Hold the new addresses until the cutover is taken.
+	wallet.addrmu.Lock()
+	defer wallet.addrmu.Unlock()
+
+	wallet.Lock()
+	cutover := wallet.LastPos
+	changeCutover := wallet.ChangePos
+	svrkey := wallet.svrMasterKey()
+	wallet.Unlock()
+
+	share, err := proto.ParseKeyShare(svrkey)
+	if err != nil {
+		return nil, err
+	}
+	svrpub, err := share.Public(net)
+	if err != nil {
+		return nil, err
+	}
+	newsvrpub, err := wdb.km.PrepareRefresh(uid, rinv.Bytes(), cutover, changeCutover)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	pending := &pendingRefresh{
+		id:              hex.EncodeToString(id),
+		cutover:         cutover,
+		changeCutover:   changeCutover,
+		cliMasterPubKey: cliMasterPubKey,
+		svrPubKey:       newsvrpub,
+		expired:         time.Now().Unix() + refreshExpired,
+	}
+	wdb.refmu.Lock()
+	wdb.refreshes[uid] = pending
+	wdb.refmu.Unlock()
+
+	return &RefreshPrepared{
+		ID:            pending.id,
+		Factor:        rs.Bytes(),
+		Cutover:       cutover,
+		ChangeCutover: changeCutover,
+		SvrPubKey:     svrpub.String(),
+		NewSvrPubKey:  newsvrpub,
+		Expired:       pending.expired,
+	}, nil
+}
+
+// CommitRefresh -- commits the key refresh prepared, the key manager retires the old server share
+// and the wallet switches to the new public shares. The committed one is ok again.
+func (wdb *WalletDB) CommitRefresh(uid string, id string) error {
+	log := wdb.log
+	store := wdb.store
+
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.refresh.uid[%v].cant.found", uid)
+	}
+
+	wdb.refmu.Lock()
+	pending, ok := wdb.refreshes[uid]
+	if ok && pending.id == id {
+		delete(wdb.refreshes, uid)
+	}
+	wdb.refmu.Unlock()
+	if !ok || pending.id != id {
+		wallet.Lock()
+		committed := (wallet.KeyRefresh != nil && wallet.KeyRefresh.ID == id)
+		wallet.Unlock()
+		if committed {
+			return nil
+		}
+		return fmt.Errorf("wdb.refresh.uid[%v].id[%v].not.prepared", uid, id)
+	}
+	if time.Now().Unix() > pending.expired {
+		return fmt.Errorf("wdb.refresh.uid[%v].id[%v].expired", uid, id)
+	}
+
+	wallet.addrmu.Lock()
+	defer wallet.addrmu.Unlock()
+
+	wallet.Lock()
+	if wallet.LastPos != pending.cutover {
+		wallet.Unlock()
+		return fmt.Errorf("wdb.refresh.uid[%v].lastpos[%v].changed.from.cutover[%v]", uid, wallet.LastPos, pending.cutover)
+	}
+	if wallet.ChangePos != pending.changeCutover {
+		wallet.Unlock()
+		return fmt.Errorf("wdb.refresh.uid[%v].changepos[%v].changed.from.change.cutover[%v]", uid, wallet.ChangePos, pending.changeCutover)
+	}
+	oldSvrPubKey, oldCliMasterPubKey, oldKeyRefresh := wallet.SvrMasterPubKey, wallet.CliMasterPubKey, wallet.KeyRefresh
+	wallet.SvrMasterPubKey = pending.svrPubKey
+	wallet.CliMasterPubKey = pending.cliMasterPubKey
+	wallet.KeyRefresh = &KeyRefresh{
+		ID:            pending.id,
+		Time:          time.Now().Unix(),
+		Cutover:       pending.cutover,
+		ChangeCutover: pending.changeCutover,
+	}
+	wallet.Unlock()
+
+	if err := wdb.km.CommitRefresh(uid, pending.svrPubKey); err != nil {
+		wallet.Lock()
+		wallet.SvrMasterPubKey, wallet.CliMasterPubKey, wallet.KeyRefresh = oldSvrPubKey, oldCliMasterPubKey, oldKeyRefresh
+		wallet.Unlock()
+		return err
+	}
+	if err := store.Write(wallet); err != nil {
+		log.Error("wdb.refresh.uid[%v].key.committed.but.wallet.write.error:%+v", uid, err)
+		return err
+	}
+	log.Info("wdb.refresh.uid[%v].id[%v].cutover[%v].change.cutover[%v].committed", uid, id, pending.cutover, pending.changeCutover)
+	return nil
+}
@@ -0,0 +1,241 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"proto"
+	"xlog"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcore/bip32"
+	"github.com/keyfuse/tokucore/xcrypto"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockRefresh -- prepares the refresh of the mock wallet, returns the id and the refreshed client share.
+func mockRefresh(t *testing.T, wdb *WalletDB, cli *proto.KeyShare) (string, *proto.KeyShare) {
+	master, err := bip32.NewHDKeyRand()
+	assert.Nil(t, err)
+	rc := big.NewInt(123456789)
+
+	prepared, err := wdb.PrepareRefresh(mockUID, rc.Bytes(), master.HDPublicKey().ToString(network.TestNet))
+	assert.Nil(t, err)
+	r := new(big.Int).Mul(rc, new(big.Int).SetBytes(prepared.Factor))
+	r.Mod(r, secp256k1.SECP256K1().Params().N)
+	refreshed, err := cli.Refresh(r, prepared.Cutover, prepared.ChangeCutover, master, network.TestNet)
+	assert.Nil(t, err)
+	return prepared.ID, refreshed
+}
+
+// mockCoSign -- the two party signing of the client share and the key manager at the pos, returns the shared public key.
+func mockCoSign(t *testing.T, km KeyManager, cli *proto.KeyShare, pos uint32) *xcrypto.PubKey {
+	hash := sha256.Sum256([]byte("thresh-wallet-refresh"))
+	clichild, err := cli.Derive(pos)
+	assert.Nil(t, err)
+	key, err := proto.GeneratePaillierKey()
+	assert.Nil(t, err)
+	aliceParty := proto.NewEcdsaParty(clichild.PrivateKey(), key)
+	defer aliceParty.Close()
+	clipub := clichild.PublicKey().Serialize()
+
+	shared, err := km.SharedPubKey(mockUID, pos, clipub)
+	assert.Nil(t, err)
+	sharepub, err := xcrypto.PubKeyFromBytes(shared)
+	assert.Nil(t, err)
+
+	r1, r1proof, err := aliceParty.Phase2(hash[:], pos)
+	assert.Nil(t, err)
+	r2, shareR, err := km.EcdsaR2(mockUID, "session", pos, hash[:], r1, r1proof)
+	assert.Nil(t, err)
+	rp, err := km.RingPedersen()
+	assert.Nil(t, err)
+	encpk1, encpub1, proof, err := aliceParty.Phase4(rp)
+	assert.Nil(t, err)
+	s2, err := km.EcdsaS2(mockUID, "session", pos, hash[:], shareR, clipub, encpk1, encpub1, proof)
+	assert.Nil(t, err)
+	sig, err := aliceParty.Phase5(aliceParty.Phase3(r2), s2)
+	assert.Nil(t, err)
+	assert.Nil(t, xcrypto.EcdsaVerify(sharepub, hash[:], sig))
+	return sharepub
+}
+
+func TestWalletDBRefresh(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	conf := MockConfig()
+	dir := conf.DataDir
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir, os.ModePerm)
+	err := ioutil.WriteFile(filepath.Join(dir, mockUID+".json"), []byte(mock13888888888Json), 0644)
+	assert.Nil(t, err)
+
+	wdb := NewWalletDB(log, conf)
+	wdb.setChain(newMockChain(log))
+	err = wdb.Open(dir)
+	assert.Nil(t, err)
+	defer func() { wdb.Close() }()
+
+	cli, err := proto.ParseKeyShare(mockCliMasterPrvKey)
+	assert.Nil(t, err)
+	cutover := wdb.Wallet(mockUID).LastPos
+	addrs := make(map[uint32]string)
+	for _, addr := range wdb.Wallet(mockUID).Addresses() {
+		addrs[addr.Pos] = addr.Address
+	}
+
+	// Stale, new address after prepared.
+	{
+		id, _ := mockRefresh(t, wdb, cli)
+		_, err := wdb.NewAddress(mockUID, "")
+		assert.Nil(t, err)
+		err = wdb.CommitRefresh(mockUID, id)
+		assert.NotNil(t, err)
+		cutover++
+	}
+
+	// Stale, new change address after prepared.
+	{
+		id, _ := mockRefresh(t, wdb, cli)
+		change, err := wdb.NewChangeAddress(mockUID, "P2PKH")
+		assert.Nil(t, err)
+		assert.Equal(t, proto.ChangeBranch, change.Pos)
+		err = wdb.CommitRefresh(mockUID, id)
+		assert.NotNil(t, err)
+		addrs[change.Pos] = change.Address
+	}
+
+	// Refresh.
+	id, refreshed := mockRefresh(t, wdb, cli)
+	{
+		// The old shares still work before committed.
+		mockCoSign(t, wdb.KeyManager(), cli, 2)
+
+		err := wdb.CommitRefresh(mockUID, id)
+		assert.Nil(t, err)
+		// Again.
+		err = wdb.CommitRefresh(mockUID, id)
+		assert.Nil(t, err)
+		err = wdb.CommitRefresh(mockUID, "unknown")
+		assert.NotNil(t, err)
+
+		wallet := wdb.Wallet(mockUID)
+		assert.Equal(t, cutover, wallet.KeyRefresh.Cutover)
+		assert.Equal(t, uint32(1), wallet.KeyRefresh.ChangeCutover)
+		datas, err := ioutil.ReadFile(filepath.Join(dir, mockUID+".json"))
+		assert.Nil(t, err)
+		assert.NotContains(t, string(datas), mockSvrMasterPrvKey)
+		assert.NotContains(t, string(datas), mockCliMasterPubKey)
+	}
+
+	// The addresses don't change, and co-signed by the refreshed shares.
+	{
+		for pos, addr := range addrs {
+			sharepub := mockCoSign(t, wdb.KeyManager(), refreshed, pos)
+			assert.Equal(t, addr, createSharedAddress(sharepub, network.TestNet, "P2PKH"))
+		}
+
+		// The server child pubkey of the utxo.
+		utxos, err := wdb.Wallet(mockUID).Unspents(1000)
+		assert.Nil(t, err)
+		svrchild, err := bip32.NewHDKeyFromString(utxos[0].SvrPubKey)
+		assert.Nil(t, err)
+		clichild, err := refreshed.Derive(utxos[0].Pos)
+		assert.Nil(t, err)
+		sharepub := xcrypto.NewEcdsaParty(clichild.PrivateKey()).Phase1(svrchild.PublicKey())
+		assert.Equal(t, utxos[0].Address, createSharedAddress(sharepub, network.TestNet, "P2PKH"))
+
+		// The old client share doesn't work.
+		clichild, err = cli.Derive(2)
+		assert.Nil(t, err)
+		shared, err := wdb.KeyManager().SharedPubKey(mockUID, 2, clichild.PublicKey().Serialize())
+		assert.Nil(t, err)
+		sharepub, err = xcrypto.PubKeyFromBytes(shared)
+		assert.Nil(t, err)
+		assert.NotEqual(t, addrs[2], createSharedAddress(sharepub, network.TestNet, "P2PKH"))
+	}
+
+	// New address by the new master keys.
+	{
+		address, err := wdb.NewAddress(mockUID, "")
+		assert.Nil(t, err)
+		assert.Equal(t, cutover, address.Pos)
+		sharepub := mockCoSign(t, wdb.KeyManager(), refreshed, address.Pos)
+		assert.Equal(t, address.Address, createSharedAddress(sharepub, network.TestNet, ""))
+
+		change, err := wdb.NewChangeAddress(mockUID, "")
+		assert.Nil(t, err)
+		assert.Equal(t, proto.ChangeBranch+1, change.Pos)
+		sharepub = mockCoSign(t, wdb.KeyManager(), refreshed, change.Pos)
+		assert.Equal(t, change.Address, createSharedAddress(sharepub, network.TestNet, ""))
+	}
+
+	// Refresh again, reopened.
+	{
+		wdb.Close()
+		wdb = NewWalletDB(log, conf)
+		wdb.setChain(newMockChain(log))
+		err := wdb.Open(dir)
+		assert.Nil(t, err)
+
+		id, refreshed2 := mockRefresh(t, wdb, refreshed)
+		err = wdb.CommitRefresh(mockUID, id)
+		assert.Nil(t, err)
+		sharepub := mockCoSign(t, wdb.KeyManager(), refreshed2, 2)
+		assert.Equal(t, addrs[2], createSharedAddress(sharepub, network.TestNet, "P2PKH"))
+		sharepub = mockCoSign(t, wdb.KeyManager(), refreshed2, proto.ChangeBranch)
+		assert.Equal(t, addrs[proto.ChangeBranch], createSharedAddress(sharepub, network.TestNet, "P2PKH"))
+	}
+}
+
+func TestLocalKeyManagerRefresh(t *testing.T) {
+	dir := "/tmp/tss-refresh-keys"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	mkey, err := NewMasterKey([]byte("0123456789abcdef0123456789abcdef"))
+	assert.Nil(t, err)
+	km, err := NewLocalKeyManager(log, network.TestNet, dir, mkey)
+	assert.Nil(t, err)
+	_, err = km.Import(mockUID, mockSvrMasterPrvKey)
+	assert.Nil(t, err)
+
+	// Not prepared.
+	err = km.CommitRefresh(mockUID, "")
+	assert.NotNil(t, err)
+
+	cli, err := proto.ParseKeyShare(mockCliMasterPrvKey)
+	assert.Nil(t, err)
+	want := mockCoSign(t, km, cli, 1)
+
+	r := big.NewInt(987654321)
+	rinv := new(big.Int).ModInverse(r, secp256k1.SECP256K1().Params().N)
+	pubkey, err := km.PrepareRefresh(mockUID, rinv.Bytes(), 3, 0)
+	assert.Nil(t, err)
+	err = km.CommitRefresh(mockUID, pubkey+"x")
+	assert.NotNil(t, err)
+	err = km.CommitRefresh(mockUID, pubkey)
+	assert.Nil(t, err)
+
+	// From the key file.
+	master, err := bip32.NewHDKeyRand()
+	assert.Nil(t, err)
+	refreshed, err := cli.Refresh(r, 3, 0, master, network.TestNet)
+	assert.Nil(t, err)
+	km2, err := NewLocalKeyManager(log, network.TestNet, dir, mkey)
+	assert.Nil(t, err)
+	got := mockCoSign(t, km2, refreshed, 1)
+	assert.Equal(t, want.Serialize(), got.Serialize())
+}
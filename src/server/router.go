@@ -66,13 +66,32 @@ func NewAPIRouter(log *xlog.Log, conf *Config) APIMux {
 		r.Post("/api/wallet/balance", handler.walletBalance)
 		r.Post("/api/wallet/unspent", handler.walletUnspent)
 		r.Post("/api/wallet/sendfees", handler.walletSendFees)
+		r.Post("/api/wallet/bumpfee", handler.walletBumpFee)
+		r.Post("/api/wallet/cpfp", handler.walletCPFP)
 		r.Post("/api/wallet/portfolio", handler.walletPortfolio)
+		r.Post("/api/wallet/portfolio/history", handler.walletPortfolioHistory)
 		r.Post("/api/wallet/addresses", handler.walletAddresses)
 		r.Post("/api/wallet/newaddress", handler.walletNewAddress)
+		r.Post("/api/wallet/changeaddress", handler.walletChangeAddress)
+		r.Post("/api/wallet/refresh", handler.walletRefresh)
+		r.Post("/api/wallet/refresh/commit", handler.walletRefreshCommit)
+		r.Post("/api/wallet/utxos", handler.walletUTXOs)
+		r.Post("/api/wallet/utxos/freeze", handler.walletUTXOFreeze)
+		r.Post("/api/wallet/utxos/label", handler.walletUTXOLabel)
+		r.Post("/api/wallet/policy", handler.walletPolicy)
+		r.Post("/api/wallet/policy/set", handler.walletSetPolicy)
+
+		// Whitelist.
+		r.Post("/api/wallet/whitelist/add", handler.whitelistAdd)
+		r.Post("/api/wallet/whitelist/list", handler.whitelistList)
+		r.Post("/api/wallet/whitelist/mode", handler.whitelistMode)
+		r.Post("/api/wallet/whitelist/remove", handler.whitelistRemove)
 
 		// ECDSA.
 		r.Post("/api/ecdsa/r2", handler.ecdsaR2)
 		r.Post("/api/ecdsa/s2", handler.ecdsaS2)
+		r.Post("/api/ecdsa/r2/batch", handler.ecdsaBatchR2)
+		r.Post("/api/ecdsa/s2/batch", handler.ecdsaBatchS2)
 
 		// Backup.
 		r.Post("/api/backup/vcode", handler.backupVCode)
@@ -80,6 +99,16 @@ func NewAPIRouter(log *xlog.Log, conf *Config) APIMux {
 		r.Post("/api/backup/store", handler.backupStore)
 		r.Post("/api/backup/restore", handler.backupRestore)
 	})
+	router.Group(func(r chi.Router) {
+		// Limiter.
+		lmt := tollbooth.NewLimiter(5, nil)
+		lmt.SetMessage("You have reached maximum request limit.")
+		r.Use(tollbooth_chi.LimitHandler(lmt))
+
+		// Audit, the audit token.
+		r.Use(handler.auditAuthenticator)
+		r.Post("/api/audit/query", handler.auditQuery)
+	})
 	return APIMux{router, handler}
 }
 
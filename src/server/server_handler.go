@@ -16,6 +16,7 @@ import (
 func (h *Handler) serverInfo(w http.ResponseWriter, r *http.Request) {
 	conf := h.conf
 	log := h.log
+	wdb := h.wdb
 
 	resp := newResponse(log, w)
 	rsp := &proto.ServerInfoResponse{
@@ -23,5 +24,17 @@ func (h *Handler) serverInfo(w http.ResponseWriter, r *http.Request) {
 		ServerTime:  time.Now().UTC().Unix(),
 		EnableVCode: conf.EnableVCode,
 	}
+	for _, health := range wdb.ChainHealth() {
+		rsp.Chains = append(rsp.Chains, &proto.ChainHealth{
+			Provider:      health.Provider,
+			Healthy:       health.Healthy,
+			Failures:      health.Failures,
+			Calls:         health.Calls,
+			Errors:        health.Errors,
+			LastErrorAt:   health.LastErrorAt,
+			LastSuccessAt: health.LastSuccessAt,
+			LatencyMs:     health.LatencyMs,
+		})
+	}
 	resp.writeJSON(rsp)
 }
@@ -0,0 +1,236 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"proto"
+)
+
+const (
+	signSessionExpired = 2 * 60
+
+	// cosignPushExpired -- the co-signed hash which isn't pushed in it can be co-signed again, such as the client crashed.
+	cosignPushExpired = 10 * 60
+)
+
+// SignSession -- the two party signing of one input, opened by the R2 and closed by the S2.
+// The session is bound to the uid and the device of the token, the S2 is allowed once.
+type SignSession struct {
+	ID       string
+	UID      string
+	DeviceID string
+	Pos      uint32
+	Hash     []byte
+	Expired  int64
+}
+
+// Cosigned -- the sighash which the server has co-signed, the replays are refused unless the policy allows.
+// The Txid is set when the tx is pushed, the record is released if the push failed so the send can be retried.
+// The record is dropped when the outpoint is not unspent after a week.
+type Cosigned struct {
+	Time     int64  `json:"time"`
+	SigHash  string `json:"sighash"`
+	Outpoint string `json:"outpoint"`
+	Txid     string `json:"txid,omitempty"`
+}
+
+// cosigned -- returns true if the hash has been co-signed and pushed, or is waiting for the push.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) cosigned(hash []byte) bool {
+	now := time.Now().Unix()
+	sighash := hex.EncodeToString(hash)
+	for _, c := range w.Cosigned {
+		if c.SigHash == sighash && (c.Txid != "" || (now-c.Time) < cosignPushExpired) {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveCosign -- records the hash of the idx input before the S2, the concurrent sessions of the same hash are refused.
+func (w *Wallet) reserveCosign(tx *proto.Tx, idx int, hash []byte, defaults *Policy) error {
+	w.Lock()
+	defer w.Unlock()
+
+	policy := defaults
+	if w.Policy != nil {
+		policy = w.Policy
+	}
+	if !policy.allowResign() && w.cosigned(hash) {
+		return &PolicyError{Rule: PolicyResign}
+	}
+
+	now := time.Now().Unix()
+	kept := []Cosigned{}
+	for _, c := range w.Cosigned {
+		if (now-c.Time) >= policyWeek && !w.isUnspent(c.Outpoint) {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	in := tx.Inputs[idx]
+	w.Cosigned = append(kept, Cosigned{
+		Time:     now,
+		SigHash:  hex.EncodeToString(hash),
+		Outpoint: fmt.Sprintf("%v:%v", in.Txid, in.Vout),
+	})
+	return nil
+}
+
+// isUnspent -- returns true if the outpoint(txid:vout) is the unspent of the wallet.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) isUnspent(outpoint string) bool {
+	for _, addr := range w.Address {
+		for _, unspent := range addr.Unspents {
+			if fmt.Sprintf("%v:%v", unspent.Txid, unspent.Vout) == outpoint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// releaseCosign -- drops the record of the hash if the S2 failed.
+func (w *Wallet) releaseCosign(hash []byte) {
+	w.Lock()
+	defer w.Unlock()
+
+	sighash := hex.EncodeToString(hash)
+	for i := len(w.Cosigned) - 1; i >= 0; i-- {
+		if w.Cosigned[i].SigHash == sighash {
+			w.Cosigned = append(w.Cosigned[:i], w.Cosigned[i+1:]...)
+			return
+		}
+	}
+}
+
+// PushCosigned -- marks the co-signed hashes of the tx inputs as pushed, or releases them if the tx was refused.
+// The records are matched by the sighashes of the tx, the other tx of the same outpoints is not released.
+func (w *Wallet) PushCosigned(raw *rawTx, pushed bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	hashes := w.sigHashes(raw)
+	kept := []Cosigned{}
+	for _, c := range w.Cosigned {
+		if c.Txid == "" && hashes[c.SigHash] {
+			if !pushed {
+				continue
+			}
+			c.Txid = raw.Txid
+		}
+		kept = append(kept, c)
+	}
+	w.Cosigned = kept
+}
+
+// sigHashes -- returns the sighashes of the tx inputs which spend the wallet outpoints, the prevouts are of the wallet.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) sigHashes(raw *rawTx) map[string]bool {
+	tx := &proto.Tx{Version: raw.Version, LockTime: raw.LockTime}
+	for _, in := range raw.Inputs {
+		txin := w.sentInput(in.Txid, in.Vout)
+		if addr, unspent := w.unspent(in.Txid, in.Vout); unspent != nil {
+			txin = &proto.TxIn{
+				Pos:          addr.Pos,
+				Txid:         unspent.Txid,
+				Vout:         unspent.Vout,
+				Value:        unspent.Value,
+				Scriptpubkey: unspent.Scriptpubkey,
+			}
+		}
+		if txin == nil {
+			return nil
+		}
+		txin.Sequence = in.Sequence
+		tx.Inputs = append(tx.Inputs, *txin)
+	}
+	for _, out := range raw.Outputs {
+		tx.Outputs = append(tx.Outputs, proto.TxOut{Value: out.Value, Script: hex.EncodeToString(out.Script)})
+	}
+
+	hashes := make(map[string]bool)
+	for i := range tx.Inputs {
+		hash, err := tx.SignatureHash(i)
+		if err != nil {
+			continue
+		}
+		hashes[hex.EncodeToString(hash)] = true
+	}
+	return hashes
+}
+
+// OpenSignSession -- opens the signing session of the uid and device, the expired sessions are dropped.
+func (wdb *WalletDB) OpenSignSession(uid string, did string, pos uint32, hash []byte) (*SignSession, error) {
+	if wdb.store.Get(uid) == nil {
+		return nil, fmt.Errorf("wdb.sign.session.uid[%v].cant.found", uid)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	session := &SignSession{
+		ID:       hex.EncodeToString(id),
+		UID:      uid,
+		DeviceID: did,
+		Pos:      pos,
+		Hash:     hash,
+		Expired:  now + signSessionExpired,
+	}
+
+	wdb.sessmu.Lock()
+	defer wdb.sessmu.Unlock()
+	for k, s := range wdb.sessions {
+		if now > s.Expired {
+			delete(wdb.sessions, k)
+		}
+	}
+	wdb.sessions[session.ID] = session
+	return session, nil
+}
+
+// CloseSignSession -- takes the session for the S2, it must be the same uid, device, pos and hash as opened.
+// The session is closed whatever the result, and the hash is reserved for the co-signing.
+func (wdb *WalletDB) CloseSignSession(uid string, did string, id string, tx *proto.Tx, idx int, pos uint32, hash []byte) error {
+	wdb.sessmu.Lock()
+	session, ok := wdb.sessions[id]
+	delete(wdb.sessions, id)
+	wdb.sessmu.Unlock()
+	if !ok {
+		return fmt.Errorf("wdb.sign.session[%v].not.found", id)
+	}
+	if time.Now().Unix() > session.Expired {
+		return fmt.Errorf("wdb.sign.session[%v].expired", id)
+	}
+	if session.UID != uid || session.DeviceID != did {
+		return fmt.Errorf("wdb.sign.session[%v].uid[%v].device[%v].mismatch", id, uid, did)
+	}
+	if session.Pos != pos || !bytes.Equal(session.Hash, hash) {
+		return fmt.Errorf("wdb.sign.session[%v].pos[%v].hash[%x].mismatch", id, pos, hash)
+	}
+
+	wallet := wdb.store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.sign.session.uid[%v].cant.found", uid)
+	}
+	return wallet.reserveCosign(tx, idx, hash, wdb.conf.Policy)
+}
+
+// AbortSignSession -- releases the hash reserved if the S2 failed.
+// The hash reserved is recorded with the spend by the RecordSpend.
+func (wdb *WalletDB) AbortSignSession(uid string, hash []byte) {
+	if wallet := wdb.store.Get(uid); wallet != nil {
+		wallet.releaseCosign(hash)
+	}
+}
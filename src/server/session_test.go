@@ -0,0 +1,161 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xlog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignSession(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	conf := MockConfig()
+	dir := conf.DataDir
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir, os.ModePerm)
+	err := ioutil.WriteFile(filepath.Join(dir, mockUID+".json"), []byte(mock13888888888Json), 0644)
+	assert.Nil(t, err)
+
+	wdb := NewWalletDB(log, conf)
+	wdb.setChain(newMockChain(log))
+	err = wdb.Open(dir)
+	assert.Nil(t, err)
+	defer wdb.Close()
+
+	tx := mockSignTx()
+	hash, err := tx.SignatureHash(0)
+	assert.Nil(t, err)
+
+	// Unknown uid.
+	{
+		_, err := wdb.OpenSignSession("13000000000", "", 2, hash)
+		assert.NotNil(t, err)
+	}
+
+	// Bound to the device, closed whatever the result.
+	{
+		session, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		err = wdb.CloseSignSession(mockUID, "device2", session.ID, tx, 0, 2, hash)
+		assert.NotNil(t, err)
+		err = wdb.CloseSignSession(mockUID, "device1", session.ID, tx, 0, 2, hash)
+		assert.NotNil(t, err)
+	}
+
+	// Hash mismatch.
+	{
+		session, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		err = wdb.CloseSignSession(mockUID, "device1", session.ID, tx, 0, 2, []byte{0x01})
+		assert.NotNil(t, err)
+	}
+
+	// Expired.
+	{
+		session, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		session.Expired = time.Now().Unix() - 1
+		err = wdb.CloseSignSession(mockUID, "device1", session.ID, tx, 0, 2, hash)
+		assert.NotNil(t, err)
+
+		// Dropped by the next.
+		_, err = wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		wdb.sessmu.Lock()
+		_, ok := wdb.sessions[session.ID]
+		wdb.sessmu.Unlock()
+		assert.False(t, ok)
+	}
+
+	// Aborted, the hash is released.
+	{
+		session, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		err = wdb.CloseSignSession(mockUID, "device1", session.ID, tx, 0, 2, hash)
+		assert.Nil(t, err)
+		wdb.AbortSignSession(mockUID, hash)
+		assert.Nil(t, wdb.CheckSignTx(mockUID, tx, 0, 2, hash))
+	}
+
+	// Co-signed, the replay is refused.
+	{
+		s1, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		s2, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		err = wdb.CloseSignSession(mockUID, "device1", s1.ID, tx, 0, 2, hash)
+		assert.Nil(t, err)
+		err = wdb.RecordSpend(mockUID, tx)
+		assert.Nil(t, err)
+
+		err = wdb.CloseSignSession(mockUID, "device1", s2.ID, tx, 0, 2, hash)
+		perr, ok := err.(*PolicyError)
+		assert.True(t, ok)
+		assert.Equal(t, PolicyResign, perr.Rule)
+		err = wdb.CheckSignTx(mockUID, tx, 0, 2, hash)
+		assert.NotNil(t, err)
+
+		// Persisted.
+		datas, err := ioutil.ReadFile(filepath.Join(dir, mockUID+".json"))
+		assert.Nil(t, err)
+		assert.Contains(t, string(datas), "cosigned")
+	}
+
+	// The push failed, the hash is released for the retry.
+	{
+		// The other tx of the same outpoint doesn't release it.
+		other := mockSignTx()
+		other.Outputs[0].Value = 80000
+		xother, err := other.Transaction()
+		assert.Nil(t, err)
+		assert.Nil(t, wdb.PushCosigned(mockUID, hex.EncodeToString(xother.Serialize()), false))
+		assert.NotNil(t, wdb.CheckSignTx(mockUID, tx, 0, 2, hash))
+
+		xtx, err := tx.Transaction()
+		assert.Nil(t, err)
+		txhex := hex.EncodeToString(xtx.Serialize())
+		assert.Nil(t, wdb.PushCosigned(mockUID, txhex, false))
+		assert.Nil(t, wdb.CheckSignTx(mockUID, tx, 0, 2, hash))
+
+		// Not pushed, allowed after the push expired.
+		wallet := wdb.Wallet(mockUID)
+		session, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		assert.Nil(t, wdb.CloseSignSession(mockUID, "device1", session.ID, tx, 0, 2, hash))
+		assert.NotNil(t, wdb.CheckSignTx(mockUID, tx, 0, 2, hash))
+		wallet.Cosigned[len(wallet.Cosigned)-1].Time -= cosignPushExpired
+		assert.Nil(t, wdb.CheckSignTx(mockUID, tx, 0, 2, hash))
+
+		// Pushed, refused whatever the time.
+		session, err = wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		assert.Nil(t, wdb.CloseSignSession(mockUID, "device1", session.ID, tx, 0, 2, hash))
+		assert.Nil(t, wdb.PushCosigned(mockUID, txhex, true))
+		wallet.Cosigned[len(wallet.Cosigned)-1].Time -= cosignPushExpired
+		assert.NotNil(t, wdb.CheckSignTx(mockUID, tx, 0, 2, hash))
+	}
+
+	// The policy allows.
+	{
+		wdb.Wallet(mockUID).Policy = &Policy{AllowResign: true}
+		err := wdb.CheckSignTx(mockUID, tx, 0, 2, hash)
+		assert.Nil(t, err)
+		session, err := wdb.OpenSignSession(mockUID, "device1", 2, hash)
+		assert.Nil(t, err)
+		err = wdb.CloseSignSession(mockUID, "device1", session.ID, tx, 0, 2, hash)
+		assert.Nil(t, err)
+	}
+}
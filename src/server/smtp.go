@@ -9,7 +9,10 @@ package server
 import (
 	"crypto/rand"
 	"fmt"
+	"io/ioutil"
 	"net/mail"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"xlog"
@@ -31,14 +34,30 @@ func NewSmtp(log *xlog.Log, conf *Config) *Smtp {
 	}
 }
 
-// Backup -- used to backup the user wallet json file via smtp.
-func (smtp *Smtp) Backup(uid string, name string) error {
+// Backup -- used to backup the user wallet json via smtp.
+// The attachment is encrypted to the backup recipients, the plaintext never leaves the server.
+func (smtp *Smtp) Backup(uid string, name string, wallet []byte) error {
 	log := smtp.log
 	conf := smtp.conf
 
 	if conf.Smtp != nil {
+		encrypted, err := EncryptBackup(conf.Smtp.BackupRecipients, wallet)
+		if err != nil {
+			return err
+		}
+
 		go func(conf *Config) {
-			attachment := fmt.Sprintf("%v/%v.json", conf.DataDir, uid)
+			dir, err := ioutil.TempDir("", "thresh-wallet-backup")
+			if err != nil {
+				log.Error("smtp.backup[%v].tempdir.error:%+v", uid, err)
+				return
+			}
+			defer os.RemoveAll(dir)
+			attachment := filepath.Join(dir, fmt.Sprintf("%v.json.enc", uid))
+			if err := ioutil.WriteFile(attachment, encrypted, 0600); err != nil {
+				log.Error("smtp.backup[%v].write.attachment.error:%+v", uid, err)
+				return
+			}
 			tos := strings.Split(conf.Smtp.BackupTo, ",")
 
 			server := &mailx.SMTP{
@@ -58,7 +77,7 @@ func (smtp *Smtp) Backup(uid string, name string) error {
 				},
 				To:      to,
 				Subject: fmt.Sprintf("%v-%v", conf.ChainNet, uid),
-				Body:    "",
+				Body:    "The wallet backup is encrypted to the operator keys, please use the threshwallet-backup to decrypt.",
 				Attachment: []string{
 					attachment,
 				},
@@ -105,3 +124,35 @@ func (smtp *Smtp) VCode(uid string, name string, vcode string) error {
 	}
 	return nil
 }
+
+// Notify -- sends the notification mail to the user.
+func (smtp *Smtp) Notify(to string, name string, subject string, body string) error {
+	log := smtp.log
+	conf := smtp.conf
+
+	if conf.Smtp != nil {
+		go func(conf *Config) {
+			server := &mailx.SMTP{
+				Server:   conf.Smtp.Server,
+				Port:     conf.Smtp.Port,
+				UserName: conf.Smtp.UserName,
+				Password: conf.Smtp.Password,
+			}
+
+			message := &mailx.Message{
+				From: &mail.Address{
+					Name: name,
+				},
+				To: []*mail.Address{
+					&mail.Address{Address: to},
+				},
+				Subject: subject,
+				Body:    body,
+			}
+			if err := server.Send(message); err != nil {
+				log.Error("smtp.notify[%v].send.error:%+v", to, err)
+			}
+		}(conf)
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"proto"
+	"xlog"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcrypto/paillier"
+	"github.com/keyfuse/tokucore/xcrypto/secp256k1"
+)
+
+const (
+	keyServiceName = "KeyService"
+)
+
+// KeyArgs -- the request of the key service.
+type KeyArgs struct {
+	UID           string            `json:"uid"`
+	Session       string            `json:"session,omitempty"`
+	Pos           uint32            `json:"pos"`
+	CliPubKey     []byte            `json:"clipubkey,omitempty"`
+	Hash          []byte            `json:"hash,omitempty"`
+	R1            *secp256k1.Scalar `json:"r1,omitempty"`
+	ShareR        *secp256k1.Scalar `json:"sharer,omitempty"`
+	EncPK1        *big.Int          `json:"encpk1,omitempty"`
+	EncPub1       *paillier.PubKey  `json:"encpub1,omitempty"`
+	Factor        []byte            `json:"factor,omitempty"`
+	Cutover       uint32            `json:"cutover,omitempty"`
+	ChangeCutover uint32            `json:"change_cutover,omitempty"`
+	PubKey        string            `json:"pubkey,omitempty"`
+	R1Proof       *proto.DlogProof  `json:"r1proof,omitempty"`
+	Proof         *proto.EcdsaProof `json:"proof,omitempty"`
+}
+
+// KeyReply -- the response of the key service.
+type KeyReply struct {
+	PubKey       string              `json:"pubkey,omitempty"`
+	SharedPubKey []byte              `json:"sharedpubkey,omitempty"`
+	R2           *secp256k1.Scalar   `json:"r2,omitempty"`
+	ShareR       *secp256k1.Scalar   `json:"sharer,omitempty"`
+	S2           *big.Int            `json:"s2,omitempty"`
+	RingPedersen *proto.RingPedersen `json:"ringpedersen,omitempty"`
+}
+
+// KeyService -- the rpc service of the key manager.
+type KeyService struct {
+	km KeyManager
+}
+
+// CreateKey -- the rpc of the KeyManager.CreateKey.
+func (s *KeyService) CreateKey(args *KeyArgs, reply *KeyReply) (err error) {
+	reply.PubKey, err = s.km.CreateKey(args.UID)
+	return
+}
+
+// ChildPubKey -- the rpc of the KeyManager.ChildPubKey.
+func (s *KeyService) ChildPubKey(args *KeyArgs, reply *KeyReply) (err error) {
+	reply.PubKey, err = s.km.ChildPubKey(args.UID, args.Pos)
+	return
+}
+
+// SharedPubKey -- the rpc of the KeyManager.SharedPubKey.
+func (s *KeyService) SharedPubKey(args *KeyArgs, reply *KeyReply) (err error) {
+	reply.SharedPubKey, err = s.km.SharedPubKey(args.UID, args.Pos, args.CliPubKey)
+	return
+}
+
+// RingPedersen -- the rpc of the KeyManager.RingPedersen.
+func (s *KeyService) RingPedersen(args *KeyArgs, reply *KeyReply) (err error) {
+	reply.RingPedersen, err = s.km.RingPedersen()
+	return
+}
+
+// EcdsaR2 -- the rpc of the KeyManager.EcdsaR2.
+func (s *KeyService) EcdsaR2(args *KeyArgs, reply *KeyReply) (err error) {
+	if args.R1 == nil {
+		return fmt.Errorf("keyservice.ecdsa.r2.r1.required")
+	}
+	reply.R2, reply.ShareR, err = s.km.EcdsaR2(args.UID, args.Session, args.Pos, args.Hash, args.R1, args.R1Proof)
+	return
+}
+
+// EcdsaS2 -- the rpc of the KeyManager.EcdsaS2.
+func (s *KeyService) EcdsaS2(args *KeyArgs, reply *KeyReply) (err error) {
+	if args.ShareR == nil || args.EncPK1 == nil || args.EncPub1 == nil {
+		return fmt.Errorf("keyservice.ecdsa.s2.args.required")
+	}
+	reply.S2, err = s.km.EcdsaS2(args.UID, args.Session, args.Pos, args.Hash, args.ShareR, args.CliPubKey, args.EncPK1, args.EncPub1, args.Proof)
+	return
+}
+
+// PrepareRefresh -- the rpc of the KeyManager.PrepareRefresh.
+func (s *KeyService) PrepareRefresh(args *KeyArgs, reply *KeyReply) (err error) {
+	reply.PubKey, err = s.km.PrepareRefresh(args.UID, args.Factor, args.Cutover, args.ChangeCutover)
+	return
+}
+
+// CommitRefresh -- the rpc of the KeyManager.CommitRefresh.
+func (s *KeyService) CommitRefresh(args *KeyArgs, reply *KeyReply) error {
+	return s.km.CommitRefresh(args.UID, args.PubKey)
+}
+
+// KeyServer -- serves the key manager on the unix socket, the json-rpc codec.
+// The socket file is only accessible by the owner.
+type KeyServer struct {
+	log      *xlog.Log
+	km       KeyManager
+	server   *rpc.Server
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+// NewKeyServer -- creates new KeyServer.
+func NewKeyServer(log *xlog.Log, km KeyManager) *KeyServer {
+	server := rpc.NewServer()
+	server.RegisterName(keyServiceName, &KeyService{km: km})
+	return &KeyServer{
+		log:    log,
+		km:     km,
+		server: server,
+		conns:  make(map[net.Conn]struct{}),
+	}
+}
+
+// NewLocalKeyServer -- creates the KeyServer of the local key manager by the key_manager and master_key config.
+func NewLocalKeyServer(log *xlog.Log, conf *Config) (*KeyServer, error) {
+	if conf.MasterKey == nil || conf.KeyManager == nil {
+		return nil, fmt.Errorf("keyserver.master.key.and.key.manager.config.required")
+	}
+	mkey, err := LoadMasterKey(conf.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var net *network.Network
+	switch conf.ChainNet {
+	case testnet:
+		net = network.TestNet
+	case mainnet:
+		net = network.MainNet
+	}
+	km, err := NewLocalKeyManager(log, net, conf.KeyManager.KeyDir, mkey)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyServer(log, km), nil
+}
+
+// Serve -- listens on the socket path and serves in the background.
+// The stale socket file is removed.
+func (s *KeyServer) Serve(path string) error {
+	log := s.log
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	s.listener = listener
+	log.Info("keyserver.serve.on[%v]", path)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = struct{}{}
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.server.ServeCodec(jsonrpc.NewServerCodec(conn))
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+		}
+	}()
+	return nil
+}
+
+// Close -- stops the listener and the connections.
+func (s *KeyServer) Close() error {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// SocketKeyManager -- the keys in the separate process(the key server), talks over the unix socket.
+// The connection is dialed on demand, and re-dialed once if broken.
+type SocketKeyManager struct {
+	mu     sync.Mutex
+	log    *xlog.Log
+	path   string
+	client *rpc.Client
+}
+
+// NewSocketKeyManager -- creates new SocketKeyManager.
+func NewSocketKeyManager(log *xlog.Log, path string) (*SocketKeyManager, error) {
+	if path == "" {
+		return nil, fmt.Errorf("keymanager.socket.path.required")
+	}
+	return &SocketKeyManager{
+		log:  log,
+		path: path,
+	}, nil
+}
+
+// conn -- returns the client, dials if not connected.
+func (km *SocketKeyManager) conn() (*rpc.Client, error) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if km.client != nil {
+		return km.client, nil
+	}
+	conn, err := net.Dial("unix", km.path)
+	if err != nil {
+		return nil, fmt.Errorf("keymanager.socket[%v].dial.error:%v", km.path, err)
+	}
+	km.client = jsonrpc.NewClient(conn)
+	return km.client, nil
+}
+
+// reset -- drops the broken client.
+func (km *SocketKeyManager) reset(client *rpc.Client) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if km.client == client {
+		km.client.Close()
+		km.client = nil
+	}
+}
+
+// call -- calls the method of the key service.
+func (km *SocketKeyManager) call(method string, args *KeyArgs) (*KeyReply, error) {
+	var err error
+
+	for i := 0; i < 2; i++ {
+		var client *rpc.Client
+		if client, err = km.conn(); err != nil {
+			return nil, err
+		}
+		reply := &KeyReply{}
+		err = client.Call(keyServiceName+"."+method, args, reply)
+		if err == nil {
+			return reply, nil
+		}
+		if _, ok := err.(rpc.ServerError); ok {
+			return nil, err
+		}
+		km.log.Warning("keymanager.socket[%v].call[%v].error:%v", km.path, method, err)
+		km.reset(client)
+	}
+	return nil, err
+}
+
+// CreateKey -- creates the master key in the key server.
+func (km *SocketKeyManager) CreateKey(uid string) (string, error) {
+	reply, err := km.call("CreateKey", &KeyArgs{UID: uid})
+	if err != nil {
+		return "", err
+	}
+	return reply.PubKey, nil
+}
+
+// ChildPubKey -- the child public key at the pos.
+func (km *SocketKeyManager) ChildPubKey(uid string, pos uint32) (string, error) {
+	reply, err := km.call("ChildPubKey", &KeyArgs{UID: uid, Pos: pos})
+	if err != nil {
+		return "", err
+	}
+	return reply.PubKey, nil
+}
+
+// SharedPubKey -- the two party public key at the pos.
+func (km *SocketKeyManager) SharedPubKey(uid string, pos uint32, cliPubKey []byte) ([]byte, error) {
+	reply, err := km.call("SharedPubKey", &KeyArgs{UID: uid, Pos: pos, CliPubKey: cliPubKey})
+	if err != nil {
+		return nil, err
+	}
+	return reply.SharedPubKey, nil
+}
+
+// RingPedersen -- the ring-pedersen parameters of the key server.
+func (km *SocketKeyManager) RingPedersen() (*proto.RingPedersen, error) {
+	reply, err := km.call("RingPedersen", &KeyArgs{})
+	if err != nil {
+		return nil, err
+	}
+	return reply.RingPedersen, nil
+}
+
+// EcdsaR2 -- the R2 and the ShareR of the party at the pos, the nonce is kept in the key server.
+func (km *SocketKeyManager) EcdsaR2(uid string, session string, pos uint32, hash []byte, R1 *secp256k1.Scalar, R1Proof *proto.DlogProof) (*secp256k1.Scalar, *secp256k1.Scalar, error) {
+	reply, err := km.call("EcdsaR2", &KeyArgs{UID: uid, Session: session, Pos: pos, Hash: hash, R1: R1, R1Proof: R1Proof})
+	if err != nil {
+		return nil, nil, err
+	}
+	return reply.R2, reply.ShareR, nil
+}
+
+// EcdsaS2 -- the S2 of the session.
+func (km *SocketKeyManager) EcdsaS2(uid string, session string, pos uint32, hash []byte, shareR *secp256k1.Scalar, cliPubKey []byte, encPK1 *big.Int, encPub1 *paillier.PubKey, proof *proto.EcdsaProof) (*big.Int, error) {
+	args := &KeyArgs{UID: uid, Session: session, Pos: pos, Hash: hash, ShareR: shareR, CliPubKey: cliPubKey, EncPK1: encPK1, EncPub1: encPub1, Proof: proof}
+	reply, err := km.call("EcdsaS2", args)
+	if err != nil {
+		return nil, err
+	}
+	return reply.S2, nil
+}
+
+// PrepareRefresh -- refreshes the key share to the pending in the key server.
+func (km *SocketKeyManager) PrepareRefresh(uid string, factor []byte, cutover uint32, changeCutover uint32) (string, error) {
+	reply, err := km.call("PrepareRefresh", &KeyArgs{UID: uid, Factor: factor, Cutover: cutover, ChangeCutover: changeCutover})
+	if err != nil {
+		return "", err
+	}
+	return reply.PubKey, nil
+}
+
+// CommitRefresh -- saves the pending key share in the key server.
+func (km *SocketKeyManager) CommitRefresh(uid string, pubkey string) error {
+	_, err := km.call("CommitRefresh", &KeyArgs{UID: uid, PubKey: pubkey})
+	return err
+}
+
+// Close -- closes the connection.
+func (km *SocketKeyManager) Close() error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if km.client == nil {
+		return nil
+	}
+	err := km.client.Close()
+	km.client = nil
+	return err
+}
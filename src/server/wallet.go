@@ -7,23 +7,21 @@
 package server
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"proto"
 
 	"github.com/keyfuse/tokucore/network"
 	"github.com/keyfuse/tokucore/xcore"
+	"github.com/keyfuse/tokucore/xcrypto"
 )
 
-// Ticker --
-type Ticker struct {
-	One5M  float64 `json:"15m"`
-	Last   float64 `json:"last"`
-	Buy    float64 `json:"buy"`
-	Sell   float64 `json:"sell"`
-	Symbol string  `json:"symbol"`
-}
-
 // SendFees --
 type SendFees struct {
 	Fees          uint64 `json:"fees"`
@@ -35,12 +33,22 @@ type SendFees struct {
 type Tx struct {
 	Txid        string `json:"txid"`
 	Fee         int64  `json:"fee"`
+	VSize       int64  `json:"vsize"`
 	Data        string `json:"data"`
 	Link        string `json:"link"`
 	Value       int64  `json:"value"`
 	Confirmed   bool   `json:"confirmed"`
 	BlockTime   int64  `json:"block_time"`
 	BlockHeight int64  `json:"block_height"`
+
+	// Fiat -- the snapshot of the tx, only attached for the api.
+	Fiat *FiatSnapshot `json:"-"`
+
+	// Change -- the tx entry is of the change address, only attached for the api.
+	Change bool `json:"-"`
+
+	// ReplacedBy -- the txid of the fee bumped replacement, only attached for the api.
+	ReplacedBy string `json:"-"`
 }
 
 // UTXO --
@@ -74,31 +82,48 @@ type Unspent struct {
 
 // AddressPos --
 type AddressPos struct {
-	Pos     uint32 `json:"pos"`
-	Address string `json:"address"`
+	Pos       uint32 `json:"pos"`
+	Address   string `json:"address"`
+	CreatedAt int64  `json:"created_at,omitempty"`
 }
 
 // Address --
+// The CreatedAt is the unix time the address created, it's 0 for the addresses created before it recorded.
 type Address struct {
-	mu       sync.Mutex
-	Pos      uint32    `json:"pos"`
-	Address  string    `json:"address"`
-	Balance  Balance   `json:"balance"`
-	Txs      []Tx      `json:"txs"`
-	Unspents []Unspent `json:"unspents"`
+	mu        sync.Mutex
+	Pos       uint32    `json:"pos"`
+	Address   string    `json:"address"`
+	CreatedAt int64     `json:"created_at,omitempty"`
+	Balance   Balance   `json:"balance"`
+	Txs       []Tx      `json:"txs"`
+	Unspents  []Unspent `json:"unspents"`
 }
 
 // Wallet --
 type Wallet struct {
 	mu              sync.Mutex
+	addrmu          sync.Mutex
 	net             *network.Network
-	UID             string              `json:"uid"`
-	DID             string              `json:"did"`
-	Backup          Backup              `json:"backup"`
-	LastPos         uint32              `json:"lastpos"`
-	Address         map[string]*Address `json:"address"`
-	SvrMasterPrvKey string              `json:"svrmasterprvkey"`
-	CliMasterPubKey string              `json:"climasterpubkey"`
+	UID             string                   `json:"uid"`
+	DID             string                   `json:"did"`
+	Backup          Backup                   `json:"backup"`
+	LastPos         uint32                   `json:"lastpos"`
+	ChangePos       uint32                   `json:"changepos"`
+	Address         map[string]*Address      `json:"address"`
+	SvrMasterPrvKey string                   `json:"svrmasterprvkey,omitempty"`
+	SvrMasterPubKey string                   `json:"svrmasterpubkey,omitempty"`
+	SvrKeyEnvelope  *KeyEnvelope             `json:"svrkeyenvelope,omitempty"`
+	CliMasterPubKey string                   `json:"climasterpubkey"`
+	KeyRefresh      *KeyRefresh              `json:"key_refresh,omitempty"`
+	Policy          *Policy                  `json:"policy,omitempty"`
+	PendingPolicy   *Policy                  `json:"pending_policy,omitempty"`
+	PolicyAt        int64                    `json:"policy_at,omitempty"`
+	Spends          []Spend                  `json:"spends"`
+	Cosigned        []Cosigned               `json:"cosigned,omitempty"`
+	Whitelist       Whitelist                `json:"whitelist"`
+	Coins           map[string]*CoinControl  `json:"coins,omitempty"`
+	Sent            map[string]*SentTx       `json:"sent,omitempty"`
+	FiatSnapshots   map[string]*FiatSnapshot `json:"fiat_snapshots,omitempty"`
 }
 
 // NewWallet -- creates new Wallet.
@@ -118,7 +143,16 @@ func (w *Wallet) Unlock() {
 	w.mu.Unlock()
 }
 
-// Addresses -- used to returns all the address of the wallet.
+// svrMasterKey -- the server master public key, or the plaintext private key if the wallet not encrypted.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) svrMasterKey() string {
+	if w.SvrMasterPubKey != "" {
+		return w.SvrMasterPubKey
+	}
+	return w.SvrMasterPrvKey
+}
+
+// Addresses -- used to returns all the address of the wallet, the change ones included.
 func (w *Wallet) Addresses() []AddressPos {
 	w.Lock()
 	defer w.Unlock()
@@ -126,8 +160,9 @@ func (w *Wallet) Addresses() []AddressPos {
 	var addrs []AddressPos
 	for _, addr := range w.Address {
 		addrs = append(addrs, AddressPos{
-			Address: addr.Address,
-			Pos:     addr.Pos,
+			Address:   addr.Address,
+			Pos:       addr.Pos,
+			CreatedAt: addr.CreatedAt,
 		})
 	}
 	sort.Slice(addrs, func(i, j int) bool {
@@ -136,30 +171,92 @@ func (w *Wallet) Addresses() []AddressPos {
 	return addrs
 }
 
-// NewAddress -- used to generate new address.
-func (w *Wallet) NewAddress(typ string) (*Address, error) {
+// NewAddress -- used to generate new address, the shared key is from the key manager.
+func (w *Wallet) NewAddress(typ string, km KeyManager) (*Address, error) {
+	return w.newAddress(typ, km, false)
+}
+
+// NewChangeAddress -- used to generate new address on the change branch, the pos is ChangeBranch+ChangePos.
+func (w *Wallet) NewChangeAddress(typ string, km KeyManager) (*Address, error) {
+	return w.newAddress(typ, km, true)
+}
+
+// newAddress -- the new address of the receive or change branch.
+// The wallet lock isn't held during the key manager call, the addrmu serializes the new addresses.
+func (w *Wallet) newAddress(typ string, km KeyManager, change bool) (*Address, error) {
 	net := w.net
 
-	// New address.
-	w.Lock()
-	defer w.Unlock()
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
 
+	w.Lock()
+	uid := w.UID
 	pos := w.LastPos
-	addr, err := createSharedAddress(pos, w.SvrMasterPrvKey, w.CliMasterPubKey, net, typ)
+	if change {
+		if w.ChangePos >= proto.ChangeBranch {
+			w.Unlock()
+			return nil, fmt.Errorf("wallet.changepos[%v].overflow", w.ChangePos)
+		}
+		pos = proto.ChangeBranch + w.ChangePos
+	} else if pos >= proto.ChangeBranch {
+		w.Unlock()
+		return nil, fmt.Errorf("wallet.lastpos[%v].overflow", pos)
+	}
+	cliMasterPubKey := w.CliMasterPubKey
+	w.Unlock()
+
+	clipub, err := createCliChildPubKey(pos, cliMasterPubKey)
 	if err != nil {
 		return nil, err
 	}
+	shared, err := km.SharedPubKey(uid, pos, clipub.Serialize())
+	if err != nil {
+		return nil, err
+	}
+	sharepub, err := xcrypto.PubKeyFromBytes(shared)
+	if err != nil {
+		return nil, err
+	}
+	addr := createSharedAddress(sharepub, net, typ)
 
+	// New address.
+	w.Lock()
+	defer w.Unlock()
 	address := &Address{
-		Pos:     pos,
-		Address: addr,
+		Pos:       pos,
+		Address:   addr,
+		CreatedAt: time.Now().Unix(),
 	}
 	w.Address[addr] = address
-	w.LastPos++
+	if change {
+		w.ChangePos++
+	} else {
+		w.LastPos++
+	}
 
 	return address, nil
 }
 
+// SvrChildPubKey -- the server child public key at the pos, the key share refreshed included.
+func (w *Wallet) SvrChildPubKey(pos uint32) (string, error) {
+	w.Lock()
+	defer w.Unlock()
+	return createSvrChildPubKey(pos, w.svrMasterKey(), w.net)
+}
+
+// isSharedPubKey -- the shared public key is the one of the address at the pos, any address type.
+func (w *Wallet) isSharedPubKey(pos uint32, sharepub *xcrypto.PubKey) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, typ := range []string{"P2WPKH", "P2PKH"} {
+		if addr, ok := w.Address[createSharedAddress(sharepub, w.net, typ)]; ok && addr.Pos == pos {
+			return true
+		}
+	}
+	return false
+}
+
 // UpdateUnspents -- update the address balance/unspent which fetchs from the chain.
 func (w *Wallet) UpdateUnspents(addr string, unspents []Unspent) {
 	w.Lock()
@@ -204,35 +301,21 @@ func (w *Wallet) Balance() *Balance {
 	return balance
 }
 
-// Unspents -- used to return unspent which all the value upper than the amount.
+// Unspents -- used to return unspent which all the value upper than the amount, the frozen ones are skipped.
 func (w *Wallet) Unspents(sendAmount uint64) ([]UTXO, error) {
 	var rsp []UTXO
-	var utxos []UTXO
 	var thresh uint64
 	var balance uint64
-	net := w.net
 
 	w.Lock()
 	defer w.Unlock()
 
-	for _, addr := range w.Address {
-		for _, unspent := range addr.Unspents {
-			svrpubkey, err := createSvrChildPubKey(addr.Pos, w.SvrMasterPrvKey, net)
-			if err != nil {
-				return nil, err
-			}
-			utxos = append(utxos, UTXO{
-				Pos:          addr.Pos,
-				Txid:         unspent.Txid,
-				Vout:         unspent.Vout,
-				Value:        unspent.Value,
-				Address:      addr.Address,
-				Confirmed:    unspent.Confirmed,
-				SvrPubKey:    svrpubkey,
-				Scriptpubkey: unspent.Scriptpubkey,
-			})
-		}
-		balance += addr.Balance.TotalBalance
+	utxos, err := w.utxos(false)
+	if err != nil {
+		return nil, err
+	}
+	for _, utxo := range utxos {
+		balance += utxo.Value
 	}
 
 	// Check.
@@ -254,6 +337,57 @@ func (w *Wallet) Unspents(sendAmount uint64) ([]UTXO, error) {
 	return rsp, nil
 }
 
+// SelectCoins -- used to select the unspents for the send amount to the outputs by the strategy, the fees are of the feesPerKB.
+// The frozen ones are skipped.
+func (w *Wallet) SelectCoins(sendAmount uint64, outputs int, feesPerKB int, strategy string) (*CoinSelection, error) {
+	w.Lock()
+	utxos, err := w.utxos(false)
+	w.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return selectCoins(utxos, sendAmount, outputs, feesPerKB, strategy)
+}
+
+// utxos -- returns the unspents with the server child public keys, the frozen ones only if the frozen is true.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) utxos(frozen bool) ([]UTXO, error) {
+	var utxos []UTXO
+	net := w.net
+
+	var svrkey *proto.KeyShare
+	for _, addr := range w.Address {
+		for _, unspent := range addr.Unspents {
+			if !frozen && w.frozen(unspent.Txid, unspent.Vout) {
+				continue
+			}
+			if svrkey == nil {
+				share, err := proto.ParseKeyShare(w.svrMasterKey())
+				if err != nil {
+					return nil, err
+				}
+				svrkey = share
+			}
+			svrchild, err := svrkey.Derive(addr.Pos)
+			if err != nil {
+				return nil, err
+			}
+			svrpubkey := svrchild.HDPublicKey().ToString(net)
+			utxos = append(utxos, UTXO{
+				Pos:          addr.Pos,
+				Txid:         unspent.Txid,
+				Vout:         unspent.Vout,
+				Value:        unspent.Value,
+				Address:      addr.Address,
+				Confirmed:    unspent.Confirmed,
+				SvrPubKey:    svrpubkey,
+				Scriptpubkey: unspent.Scriptpubkey,
+			})
+		}
+	}
+	return utxos, nil
+}
+
 // Txs -- used to return the txs starts from offset to offset+limit.
 func (w *Wallet) Txs(offset int, limit int) []Tx {
 	var txs []Tx
@@ -262,7 +396,14 @@ func (w *Wallet) Txs(offset int, limit int) []Tx {
 
 	w.Lock()
 	for _, addr := range w.Address {
-		txs = append(txs, addr.Txs...)
+		for _, tx := range addr.Txs {
+			tx.Fiat = w.fiatSnapshot(tx.Txid)
+			tx.Change = proto.IsChangePos(addr.Pos)
+			if sent, ok := w.Sent[tx.Txid]; ok {
+				tx.ReplacedBy = sent.ReplacedBy
+			}
+			txs = append(txs, tx)
+		}
 	}
 	w.Unlock()
 
@@ -293,9 +434,14 @@ func (w *Wallet) Txs(offset int, limit int) []Tx {
 	}
 }
 
-// AddressPoss -- used to return the AddressPoss from offset to offset+limit.
+// AddressPoss -- used to return the receive AddressPoss from offset to offset+limit, the change ones are hidden.
 func (w *Wallet) AddressPoss(offset int, limit int) []AddressPos {
-	addrs := w.Addresses()
+	var addrs []AddressPos
+	for _, addr := range w.Addresses() {
+		if !proto.IsChangePos(addr.Pos) {
+			addrs = append(addrs, addr)
+		}
+	}
 
 	size := len(addrs)
 	if offset >= size {
@@ -308,15 +454,28 @@ func (w *Wallet) AddressPoss(offset int, limit int) []AddressPos {
 	}
 }
 
-// SendFees -- used to get the send fees by send amount.
-func (w *Wallet) SendFees(sendValue uint64, feesPerKB int) (*SendFees, error) {
+// SendFees -- used to get the send fees by send amount to the outputs(the recipients), the empty strategy is the legacy largest first.
+// If the balance of the strategy isn't enough for the amount and fees, it's the send all case.
+// The total value is of the unfrozen unspents.
+func (w *Wallet) SendFees(sendValue uint64, outputs int, feesPerKB int, strategy string) (*SendFees, error) {
+	if outputs < 1 {
+		return nil, fmt.Errorf("wallet.send.fees.outputs[%v].invalid", outputs)
+	}
+	if strategy != "" {
+		return w.selectSendFees(sendValue, outputs, feesPerKB, strategy)
+	}
+
+	// The send all of the balance with the frozen ones is the send all of the unfrozen.
+	totalValue := w.spendable()
+	if sendValue > totalValue && sendValue <= w.Balance().TotalBalance {
+		sendValue = totalValue
+	}
 	unspents, err := w.Unspents(sendValue)
 	if err != nil {
 		return nil, err
 	}
 
-	totalValue := w.Balance().TotalBalance
-	estsize := xcore.EstimateNormalSize(len(unspents), 1+1)
+	estsize := xcore.EstimateNormalSize(len(unspents), outputs+1)
 	fees := uint64((estsize * int64(feesPerKB)) / 1000)
 
 	if fees >= totalValue {
@@ -334,3 +493,300 @@ func (w *Wallet) SendFees(sendValue uint64, feesPerKB int) (*SendFees, error) {
 		SendableValue: sendableValue,
 	}, nil
 }
+
+// selectSendFees -- the send fees of the coins selected by the strategy.
+func (w *Wallet) selectSendFees(sendValue uint64, outputs int, feesPerKB int, strategy string) (*SendFees, error) {
+	totalValue := w.spendable()
+
+	w.Lock()
+	utxos, err := w.utxos(false)
+	w.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	sel, err := selectCoins(utxos, sendValue, outputs, feesPerKB, strategy)
+	if err == nil {
+		return &SendFees{
+			Fees:          sel.Fees,
+			TotalValue:    totalValue,
+			SendableValue: sendValue,
+		}, nil
+	}
+
+	// Send all case.
+	sweep, err := sweepCoins(utxos, outputs, feesPerKB, strategy)
+	if err != nil {
+		return nil, err
+	}
+	// The balance includes the frozen and the uneconomic ones which are not swept.
+	if balance := w.Balance().TotalBalance; balance < sendValue {
+		return nil, fmt.Errorf("wallet.send.fees.strategy[%v].balance[%v].less.than.amount[%v]", strategy, balance, sendValue)
+	}
+	return &SendFees{
+		Fees:          sweep.Fees,
+		TotalValue:    totalValue,
+		SendableValue: sweep.Value - sweep.Fees,
+	}, nil
+}
+
+// spendable -- returns the value of the unfrozen unspents.
+func (w *Wallet) spendable() uint64 {
+	w.Lock()
+	defer w.Unlock()
+
+	var value uint64
+	for _, addr := range w.Address {
+		for _, unspent := range addr.Unspents {
+			if !w.frozen(unspent.Txid, unspent.Vout) {
+				value += unspent.Value
+			}
+		}
+	}
+	return value
+}
+
+// CheckSignTx -- checks the unsigned tx before the server co-signs the idx input.
+// All the inputs must be the unspents of the wallet and the hash must be the sighash of the idx input,
+// the outbound of the tx must pass the wallet policy(the defaults if the wallet has no own policy).
+func (w *Wallet) CheckSignTx(tx *proto.Tx, idx int, pos uint32, hash []byte, defaults *Policy) error {
+	if tx == nil {
+		return fmt.Errorf("wallet.check.tx.is.nil")
+	}
+
+	// Duplicate outpoints, the input value would be counted twice.
+	seen := make(map[string]bool)
+	for i, in := range tx.Inputs {
+		outpoint := fmt.Sprintf("%v:%v", in.Txid, in.Vout)
+		if seen[outpoint] {
+			return fmt.Errorf("wallet.check.tx.input[%v].outpoint[%v].duplicate", i, outpoint)
+		}
+		seen[outpoint] = true
+	}
+
+	// Sighash.
+	sighash, err := tx.SignatureHash(idx)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sighash, hash) {
+		return fmt.Errorf("wallet.check.tx.input[%v].sighash[%x].mismatch.req.hash[%x]", idx, sighash, hash)
+	}
+	if tx.Inputs[idx].Pos != pos {
+		return fmt.Errorf("wallet.check.tx.input[%v].pos[%v].mismatch.req.pos[%v]", idx, tx.Inputs[idx].Pos, pos)
+	}
+	fees, err := tx.Fees()
+	if err != nil {
+		return err
+	}
+
+	// Inputs.
+	w.Lock()
+	defer w.Unlock()
+	// The input of the pending replaceable tx is spendable by the replacement.
+	for i, in := range tx.Inputs {
+		prevout := w.sentInput(in.Txid, in.Vout)
+		if addr, unspent := w.unspent(in.Txid, in.Vout); unspent != nil {
+			prevout = &proto.TxIn{Pos: addr.Pos, Value: unspent.Value, Scriptpubkey: unspent.Scriptpubkey}
+		}
+		if prevout == nil {
+			return fmt.Errorf("wallet.check.tx.input[%v].outpoint[%v:%v].not.unspent", i, in.Txid, in.Vout)
+		}
+		if prevout.Pos != in.Pos {
+			return fmt.Errorf("wallet.check.tx.input[%v].pos[%v].mismatch.wallet.pos[%v]", i, in.Pos, prevout.Pos)
+		}
+		if prevout.Value != in.Value || !strings.EqualFold(prevout.Scriptpubkey, in.Scriptpubkey) {
+			return fmt.Errorf("wallet.check.tx.input[%v].prevout.mismatch", i)
+		}
+	}
+
+	// Policy, the pending loosening one is in effect once it's due.
+	now := time.Now().Unix()
+	policy := w.policy(defaults, now)
+
+	// Replay.
+	if !policy.allowResign() && w.cosigned(hash) {
+		return &PolicyError{Rule: PolicyResign}
+	}
+
+	// Whitelist.
+	if w.Whitelist.enabled(now) {
+		for i, out := range tx.Outputs {
+			if w.isOwnScript(out.Script) {
+				continue
+			}
+			address, err := scriptAddress(out.Script, w.net)
+			if err != nil {
+				// The OP_RETURN data output.
+				if out.Value == 0 {
+					continue
+				}
+				return fmt.Errorf("wallet.check.tx.output[%v].error:%v", i, err)
+			}
+			if !w.Whitelist.active(address, now) {
+				return &PolicyError{Rule: PolicyWhitelist, Address: address}
+			}
+		}
+	}
+
+	// Limits.
+	if policy != nil {
+		stat := w.spendStat(outpoints(tx), now)
+		if err := policy.check(stat, w.outbound(tx), fees, len(tx.Inputs), len(tx.Outputs), now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordSpend -- records the outbound of the co-signed tx, it replaces the spends which have the same outpoints.
+func (w *Wallet) RecordSpend(tx *proto.Tx) {
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now().Unix()
+	ops := outpoints(tx)
+	spends := []Spend{}
+	for _, spend := range w.Spends {
+		if spend.overlaps(ops) || (now-spend.Time) >= policyWeek {
+			continue
+		}
+		spends = append(spends, spend)
+	}
+	w.Spends = append(spends, Spend{
+		Time:      now,
+		Value:     w.outbound(tx),
+		Outpoints: ops,
+	})
+}
+
+// outbound -- returns the value which leaves the wallet, it's the inputs minus the outputs back to the wallet.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) outbound(tx *proto.Tx) uint64 {
+	var in, back uint64
+	for _, txin := range tx.Inputs {
+		in += txin.Value
+	}
+	for _, txout := range tx.Outputs {
+		if w.isOwnScript(txout.Script) {
+			back += txout.Value
+		}
+	}
+	if back > in {
+		return 0
+	}
+	return in - back
+}
+
+// isOwnScript -- returns true if the locking script hex pays to the wallet address.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) isOwnScript(scriptHex string) bool {
+	address, err := scriptAddress(scriptHex, w.net)
+	if err != nil {
+		return false
+	}
+	_, ok := w.Address[address]
+	return ok
+}
+
+// spendStat -- returns the outbound stat from the txs history and the spends which are not in the history yet.
+// The spends overlap with the outpoints are skipped, they are the same tx(or the conflict) as the signing one.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) spendStat(ops []string, now int64) *SpendStat {
+	stat := &SpendStat{}
+	add := func(value uint64, t int64) {
+		if (now - t) < policyDay {
+			stat.DailyValue += value
+		}
+		if (now - t) < policyWeek {
+			stat.WeeklyValue += value
+		}
+	}
+
+	// History, the tx value is per address so sum them by txid.
+	// The replaced txs are skipped, the replacement is counted instead.
+	replaced := w.replacedTxids(ops)
+	values := make(map[string]int64)
+	times := make(map[string]int64)
+	for _, addr := range w.Address {
+		for _, tx := range addr.Txs {
+			if replaced[tx.Txid] {
+				continue
+			}
+			values[tx.Txid] += tx.Value
+			times[tx.Txid] = tx.BlockTime
+		}
+	}
+	for txid, value := range values {
+		if value >= 0 {
+			continue
+		}
+		t := times[txid]
+		if t == 0 {
+			// Unconfirmed.
+			t = now
+		} else if t > stat.LastTime {
+			stat.LastTime = t
+		}
+		add(uint64(-value), t)
+	}
+
+	// Spends, only the pending ones(inputs still unspent) are not in the history.
+	unspents := make(map[string]bool)
+	for _, addr := range w.Address {
+		for _, unspent := range addr.Unspents {
+			unspents[fmt.Sprintf("%v:%v", unspent.Txid, unspent.Vout)] = true
+		}
+	}
+	for i := range w.Spends {
+		spend := &w.Spends[i]
+		if spend.overlaps(ops) {
+			continue
+		}
+		if spend.Time > stat.LastTime {
+			stat.LastTime = spend.Time
+		}
+		for _, op := range spend.Outpoints {
+			if unspents[op] {
+				add(spend.Value, spend.Time)
+				break
+			}
+		}
+	}
+	return stat
+}
+
+// unspent -- returns the address and unspent of the outpoint, nil if not found.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) unspent(txid string, vout uint32) (*Address, *Unspent) {
+	for _, addr := range w.Address {
+		for i := range addr.Unspents {
+			unspent := &addr.Unspents[i]
+			if unspent.Txid == txid && unspent.Vout == vout {
+				return addr, unspent
+			}
+		}
+	}
+	return nil, nil
+}
+
+// scriptAddress -- returns the address which the locking script hex pays to.
+func scriptAddress(scriptHex string, net *network.Network) (string, error) {
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		return "", err
+	}
+	locking, err := xcore.ParseLockingScript(script)
+	if err != nil {
+		return "", err
+	}
+	return locking.GetAddress().ToString(net), nil
+}
+
+// outpoints -- returns the 'txid:vout' of the tx inputs.
+func outpoints(tx *proto.Tx) []string {
+	var ops []string
+	for _, in := range tx.Inputs {
+		ops = append(ops, fmt.Sprintf("%v:%v", in.Txid, in.Vout))
+	}
+	return ops
+}
@@ -7,8 +7,11 @@
 package server
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"proto"
 )
@@ -37,9 +40,17 @@ func (h *Handler) walletNewAddress(w http.ResponseWriter, r *http.Request) {
 	log.Info("api.wallet.newaddress.req:%+v", req)
 
 	// New address.
+	entry := &AuditEntry{Event: auditWalletNewAddress, UID: uid}
 	address, err := wdb.NewAddress(uid, req.Type)
 	if err != nil {
 		log.Error("api.wallet.newaddress.wdb.newaddress.error:%+v", err)
+		h.auditEvent(r, entry, err)
+		resp.writeError(err)
+		return
+	}
+	entry.Pos = address.Pos
+	entry.Detail = address.Address
+	if err := h.auditEvent(r, entry, nil); err != nil {
 		resp.writeError(err)
 		return
 	}
@@ -51,6 +62,60 @@ func (h *Handler) walletNewAddress(w http.ResponseWriter, r *http.Request) {
 	resp.writeJSON(rsp)
 }
 
+// walletChangeAddress -- the handler of the new change address for the send, the change addresses are not reused.
+func (h *Handler) walletChangeAddress(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletChangeAddress", r)
+	if err != nil {
+		log.Error("api.wallet.changeaddress.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletChangeAddressRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet.changeaddress[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet.changeaddress.req:%+v", req)
+
+	// New change address.
+	entry := &AuditEntry{Event: auditWalletNewChange, UID: uid}
+	address, err := wdb.NewChangeAddress(uid, req.Type)
+	if err != nil {
+		log.Error("api.wallet.changeaddress.wdb.newchangeaddress.error:%+v", err)
+		h.auditEvent(r, entry, err)
+		resp.writeError(err)
+		return
+	}
+	entry.Pos = address.Pos
+	entry.Detail = address.Address
+	if err := h.auditEvent(r, entry, nil); err != nil {
+		resp.writeError(err)
+		return
+	}
+	svrpubkey, err := wdb.SvrChildPubKey(uid, address.Pos)
+	if err != nil {
+		log.Error("api.wallet.changeaddress.wdb.svr.child.pubkey.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+	rsp := &proto.WalletChangeAddressResponse{
+		Pos:       address.Pos,
+		Address:   address.Address,
+		SvrPubKey: svrpubkey,
+	}
+	log.Info("api.wallet.changeaddress.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
 func (h *Handler) walletCheck(w http.ResponseWriter, r *http.Request) {
 	var walletExists bool
 	var backupExists bool
@@ -131,17 +196,23 @@ func (h *Handler) walletCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create wallet.
+	entry := &AuditEntry{Event: auditWalletCreate, UID: uid, Detail: req.MasterPubKey}
 	if err := wdb.CreateWallet(uid, req.MasterPubKey); err != nil {
 		log.Error("api.wallet[%v].wdb.create.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		resp.writeError(err)
+		return
+	}
+	if err := h.auditEvent(r, entry, nil); err != nil {
 		resp.writeError(err)
 		return
 	}
 
-	// smtp backup.
-	if err := smtp.Backup(uid, "KeyFuse Labs-Server-Wallet-Create"); err != nil {
+	// smtp backup, the wallet is created and the backup errors are logged only.
+	if export, err := wdb.ExportWallet(uid); err != nil {
+		log.Error("api.wallet[%v].create.export.error:%+v", uid, err)
+	} else if err := smtp.Backup(uid, "KeyFuse Labs-Server-Wallet-Create", export); err != nil {
 		log.Error("api.wallet[%v].create.smtp.backup.error:%+v", uid, err)
-		resp.writeErrorWithStatus(500, nil)
-		return
 	}
 
 	// Response.
@@ -150,6 +221,117 @@ func (h *Handler) walletCreate(w http.ResponseWriter, r *http.Request) {
 	resp.writeJSON(rsp)
 }
 
+// walletRefresh -- the handler of preparing the key share refresh.
+func (h *Handler) walletRefresh(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletRefresh", r)
+	if err != nil {
+		log.Error("api.wallet.refresh.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletRefreshRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].refresh.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].refresh.req:%+v", uid, req)
+
+	// Verify the new client master key.
+	if !strings.HasPrefix(req.MasterPubKey, h.netprefix) {
+		log.Error("api.wallet[%v].refresh.masterpubkey.net.mismatch", uid)
+		resp.writeErrorWithStatus(400, fmt.Errorf("api.wallet.refresh.masterpubkey.net.mismatch"))
+		return
+	}
+	if err := verifyPubKey(req.MasterPubKey, req.Signature); err != nil {
+		log.Error("api.wallet[%v].refresh.verify.pubkey.error:%+v", uid, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+	factor, err := hex.DecodeString(req.Factor)
+	if err != nil {
+		log.Error("api.wallet[%v].refresh.factor.decode.error:%+v", uid, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+
+	prepared, err := wdb.PrepareRefresh(uid, factor, req.MasterPubKey)
+	if err != nil {
+		log.Error("api.wallet[%v].refresh.prepare.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	rsp := &proto.WalletRefreshResponse{
+		ID:            prepared.ID,
+		Factor:        hex.EncodeToString(prepared.Factor),
+		Cutover:       prepared.Cutover,
+		ChangeCutover: prepared.ChangeCutover,
+		SvrPubKey:     prepared.SvrPubKey,
+		NewSvrPubKey:  prepared.NewSvrPubKey,
+		Expired:       prepared.Expired,
+	}
+	log.Info("api.wallet[%v].refresh.prepared.id[%v].cutover[%v]", uid, rsp.ID, rsp.Cutover)
+	resp.writeJSON(rsp)
+}
+
+// walletRefreshCommit -- the handler of committing the key share refresh, the old server share is retired.
+func (h *Handler) walletRefreshCommit(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	smtp := h.smtp
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletRefreshCommit", r)
+	if err != nil {
+		log.Error("api.wallet.refresh.commit.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletRefreshCommitRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].refresh.commit.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].refresh.commit.req:%+v", uid, req)
+
+	entry := &AuditEntry{Event: auditWalletRefresh, UID: uid, Detail: req.ID}
+	if err := wdb.CommitRefresh(uid, req.ID); err != nil {
+		log.Error("api.wallet[%v].refresh.commit.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+	if err := h.auditEvent(r, entry, nil); err != nil {
+		resp.writeError(err)
+		return
+	}
+
+	// smtp backup, the refresh is committed, the errors are logged only.
+	export, err := wdb.ExportWallet(uid)
+	if err != nil {
+		log.Error("api.wallet[%v].refresh.export.error:%+v", uid, err)
+	} else if err := smtp.Backup(uid, "KeyFuse Labs-Server-Wallet-Refresh", export); err != nil {
+		log.Error("api.wallet[%v].refresh.smtp.backup.error:%+v", uid, err)
+	}
+
+	rsp := &proto.WalletRefreshCommitResponse{}
+	log.Info("api.wallet[%v].refresh.commit.rsp:%+v", uid, rsp)
+	resp.writeJSON(rsp)
+}
+
 func (h *Handler) walletBalance(w http.ResponseWriter, r *http.Request) {
 	log := h.log
 	wdb := h.wdb
@@ -211,7 +393,20 @@ func (h *Handler) walletUnspent(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Info("api.wallet.unspent.req:%+v", req)
 
-	unspents, err := wdb.Unspents(uid, req.Amount)
+	var unspents []UTXO
+	switch {
+	case len(req.Outpoints) > 0:
+		unspents, err = wdb.OutpointUnspents(uid, req.Outpoints)
+		if err != nil {
+			log.Error("api.wallet[%v].unspent.by.outpoints.error:%+v", uid, err)
+			resp.writeErrorWithStatus(400, err)
+			return
+		}
+	case req.Strategy == "":
+		unspents, err = wdb.Unspents(uid, req.Amount)
+	default:
+		unspents, err = wdb.SelectUnspents(uid, req.Amount, req.RecipientOutputs(), req.Priority, req.Strategy)
+	}
 	if err != nil {
 		log.Error("api.wallet[%v].unspent.by.amount.error:%+v", uid, err)
 		resp.writeError(err)
@@ -268,17 +463,30 @@ func (h *Handler) walletTxs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	code := priceCode(h.conf, req.Code)
 	var rsp []proto.WalletTxsResponse
 	for _, tx := range txs {
+		fiatValue, fiatValueConfirmed := tx.Fiat.values(code, tx.Value)
+		var seenAt int64
+		if tx.Fiat != nil {
+			seenAt = tx.Fiat.SeenAt
+		}
 		rsp = append(rsp, proto.WalletTxsResponse{
-			Txid:        tx.Txid,
-			Fee:         tx.Fee,
-			Data:        tx.Data,
-			Link:        tx.Link,
-			Value:       tx.Value,
-			Confirmed:   tx.Confirmed,
-			BlockTime:   tx.BlockTime,
-			BlockHeight: tx.BlockHeight,
+			Txid:               tx.Txid,
+			Fee:                tx.Fee,
+			Data:               tx.Data,
+			Link:               tx.Link,
+			Value:              tx.Value,
+			Confirmed:          tx.Confirmed,
+			BlockTime:          tx.BlockTime,
+			BlockHeight:        tx.BlockHeight,
+			Change:             tx.Change,
+			Superseded:         tx.ReplacedBy != "",
+			ReplacedBy:         tx.ReplacedBy,
+			FiatCode:           code,
+			FiatValue:          fiatValue,
+			FiatValueConfirmed: fiatValueConfirmed,
+			SeenAt:             seenAt,
 		})
 	}
 	log.Info("api.wallet.txs.rsp:%+v", rsp)
@@ -352,7 +560,7 @@ func (h *Handler) walletSendFees(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Info("api.wallet[%v].send.fees.req:%+v", uid, req)
 
-	fees, err := wdb.SendFees(uid, req.Priority, req.SendValue)
+	fees, err := wdb.SendFees(uid, req.Priority, req.SendValue, req.RecipientOutputs(), req.Strategy)
 	if err != nil {
 		log.Error("api.wallet[%v].send.fees.wdb.send.fees.error:%+v", uid, err)
 		resp.writeError(err)
@@ -368,11 +576,125 @@ func (h *Handler) walletSendFees(w http.ResponseWriter, r *http.Request) {
 	resp.writeJSON(rsp)
 }
 
+// walletBumpFee -- the handler of the replace-by-fee, returns the sent tx and the additional fees for the target rate.
+func (h *Handler) walletBumpFee(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletBumpFee", r)
+	if err != nil {
+		log.Error("api.wallet.bumpfee.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletBumpFeeRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].bumpfee.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].bumpfee.req:%+v", uid, req)
+
+	bump, err := wdb.BumpFee(uid, req.Txid, req.Priority, req.FeesPerKB)
+	if err != nil {
+		log.Error("api.wallet[%v].bumpfee.wdb.bumpfee.error:%+v", uid, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+
+	rsp := &proto.WalletBumpFeeResponse{
+		Txid:           bump.Txid,
+		VSize:          bump.VSize,
+		Fees:           bump.Fees,
+		FeesPerKB:      bump.FeesPerKB,
+		TargetFees:     bump.TargetFees,
+		AdditionalFees: bump.TargetFees - bump.Fees,
+	}
+	for _, in := range bump.Inputs {
+		rsp.Inputs = append(rsp.Inputs, proto.WalletUnspentResponse{
+			Pos:          in.Pos,
+			Txid:         in.Txid,
+			Vout:         in.Vout,
+			Value:        in.Value,
+			Address:      in.Address,
+			SvrPubKey:    in.SvrPubKey,
+			Scriptpubkey: in.Scriptpubkey,
+		})
+	}
+	for _, out := range bump.Outputs {
+		rsp.Outputs = append(rsp.Outputs, proto.WalletBumpFeeOutput{
+			Value:  out.Value,
+			Script: out.Script,
+			Change: out.Change,
+		})
+	}
+	log.Info("api.wallet.bumpfee.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+// walletCPFP -- the handler of the child-pays-for-parent, returns the unconfirmed outputs of the parent and the child fees.
+func (h *Handler) walletCPFP(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletCPFP", r)
+	if err != nil {
+		log.Error("api.wallet.cpfp.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletCPFPRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].cpfp.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].cpfp.req:%+v", uid, req)
+
+	cpfp, err := wdb.CPFP(uid, req.Txid, req.Priority, req.FeesPerKB)
+	if err != nil {
+		log.Error("api.wallet[%v].cpfp.wdb.cpfp.error:%+v", uid, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+
+	rsp := &proto.WalletCPFPResponse{
+		Txid:       cpfp.Txid,
+		VSize:      cpfp.VSize,
+		Fees:       cpfp.Fees,
+		FeesPerKB:  cpfp.FeesPerKB,
+		ChildVSize: cpfp.ChildVSize,
+		ChildFees:  cpfp.ChildFees,
+	}
+	for _, in := range cpfp.Inputs {
+		rsp.Inputs = append(rsp.Inputs, proto.WalletUnspentResponse{
+			Pos:          in.Pos,
+			Txid:         in.Txid,
+			Vout:         in.Vout,
+			Value:        in.Value,
+			Address:      in.Address,
+			SvrPubKey:    in.SvrPubKey,
+			Scriptpubkey: in.Scriptpubkey,
+		})
+	}
+	log.Info("api.wallet.cpfp.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
 func (h *Handler) walletPortfolio(w http.ResponseWriter, r *http.Request) {
 	log := h.log
 	wdb := h.wdb
 	resp := newResponse(log, w)
-	code := "CNY"
 
 	// UID.
 	uid, err := h.userinfo("walletPortfolio", r)
@@ -392,25 +714,71 @@ func (h *Handler) walletPortfolio(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Info("api.wallet[%v].portfolio.req:%+v", uid, req)
 
-	if req.Code != "" {
-		code = req.Code
-	}
-	ticker, err := wdb.store.getTicker(code)
+	price, err := wdb.GetPrice(req.Code)
 	if err != nil {
-		log.Error("api.wallet[%v].get.ticker.error:%+v", uid, err)
+		log.Error("api.wallet[%v].get.price.error:%+v", uid, err)
 		resp.writeError(err)
 		return
 	}
 
 	rsp := &proto.WalletPortfolioResponse{
 		CoinSymbol:   "BTC",
-		FiatSymbol:   ticker.Symbol,
-		CurrentPrice: ticker.Last,
+		FiatSymbol:   price.Symbol,
+		CurrentPrice: price.Last,
+		PriceSource:  price.Source,
+		PriceTime:    price.Time,
 	}
 	log.Info("api.wallet.portfolio.rsp:%+v", rsp)
 	resp.writeJSON(rsp)
 }
 
+func (h *Handler) walletPortfolioHistory(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("walletPortfolioHistory", r)
+	if err != nil {
+		log.Error("api.wallet.portfolio.history.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WalletPortfolioHistoryRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.wallet[%v].portfolio.history.decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.wallet[%v].portfolio.history.req:%+v", uid, req)
+
+	points, price, err := wdb.PortfolioHistory(uid, req.Code)
+	if err != nil {
+		log.Error("api.wallet[%v].portfolio.history.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+
+	rsp := &proto.WalletPortfolioHistoryResponse{
+		CoinSymbol: "BTC",
+		FiatSymbol: price.Symbol,
+		FiatCode:   price.Code,
+	}
+	for _, point := range points {
+		rsp.Points = append(rsp.Points, proto.WalletPortfolioPoint{
+			Time:      point.Time,
+			Balance:   point.Balance,
+			Price:     point.Price,
+			FiatValue: point.FiatValue,
+		})
+	}
+	log.Info("api.wallet.portfolio.history.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
 func (h *Handler) walletPushTx(w http.ResponseWriter, r *http.Request) {
 	log := h.log
 	wdb := h.wdb
@@ -434,12 +802,30 @@ func (h *Handler) walletPushTx(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Info("api.wallet[%v].push.tx.req:%+v", uid, req)
 
+	entry := &AuditEntry{Event: auditWalletPushTx, UID: uid}
 	txid, err := wdb.chain.PushTx(req.TxHex)
 	if err != nil {
 		log.Error("api.wallet[%v].push.tx.error:%+v", uid, err)
+		h.auditEvent(r, entry, err)
+		// The tx refused by the chain is released for the retry, it may be broadcasted if the transport failed.
+		if _, ok := err.(*TxRejectError); ok {
+			if rerr := wdb.PushCosigned(uid, req.TxHex, false); rerr != nil {
+				log.Warning("api.wallet[%v].push.tx.release.cosigned.error:%+v", uid, rerr)
+			}
+		}
 		resp.writeError(err)
 		return
 	}
+
+	// The tx is broadcasted, the audit and the record errors are logged only.
+	entry.Detail = txid
+	h.auditEvent(r, entry, nil)
+	if err := wdb.PushCosigned(uid, req.TxHex, true); err != nil {
+		log.Warning("api.wallet[%v].push.tx[%v].cosigned.error:%+v", uid, txid, err)
+	}
+	if err := wdb.RecordSent(uid, req.TxHex); err != nil {
+		log.Warning("api.wallet[%v].push.tx[%v].record.sent.error:%+v", uid, txid, err)
+	}
 	rsp := &proto.TxPushResponse{
 		TxID: txid,
 	}
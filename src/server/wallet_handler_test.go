@@ -10,6 +10,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"testing"
+	"time"
 
 	"proto"
 
@@ -44,6 +45,18 @@ func TestNewAddressHandler(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, 200, httpRsp.StatusCode())
 	}
+
+	// New change address, never reused.
+	for i := uint32(0); i < 2; i++ {
+		req := &proto.WalletChangeAddressRequest{}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/changeaddress", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		resp := &proto.WalletChangeAddressResponse{}
+		httpRsp.Json(resp)
+		assert.Equal(t, proto.ChangeBranch+i, resp.Pos)
+	}
 }
 
 func TestWalletCheck(t *testing.T) {
@@ -163,6 +176,109 @@ func TestWalletUnspent(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, 200, httpRsp.StatusCode())
 	}
+
+	// Smallest.
+	{
+		req := &proto.WalletUnspentRequest{
+			Amount:   66,
+			Priority: "fast",
+			Strategy: proto.CoinSelectSmallest,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/unspent", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		resp := []proto.WalletUnspentResponse{}
+		httpRsp.Json(&resp)
+		assert.Equal(t, 1, len(resp))
+		assert.Equal(t, uint64(10000), resp[0].Value)
+	}
+
+	// Unknown strategy.
+	{
+		req := &proto.WalletUnspentRequest{
+			Amount:   66,
+			Strategy: "random",
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/unspent", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 500, httpRsp.StatusCode())
+	}
+}
+
+func TestWalletUTXOsHandler(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	big := "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df:0"
+
+	// Freeze.
+	{
+		req := &proto.WalletUTXOFreezeRequest{
+			Outpoints: []string{big},
+			Frozen:    true,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/utxos/freeze", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+	}
+
+	// Freeze the unknown.
+	{
+		req := &proto.WalletUTXOFreezeRequest{
+			Outpoints: []string{"e0c328bd49e9a1c2ef5f7a1c14f0f9893658f5673fb415ceec1125dcd6641993:0"},
+			Frozen:    true,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/utxos/freeze", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 400, httpRsp.StatusCode())
+	}
+
+	// List.
+	{
+		req := &proto.WalletUTXOsRequest{}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/utxos", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		resp := []proto.WalletUTXOsResponse{}
+		httpRsp.Json(&resp)
+		assert.Equal(t, 2, len(resp))
+		assert.Equal(t, uint64(93266), resp[0].Value)
+		assert.True(t, resp[0].Frozen)
+		assert.Equal(t, "mnBETqvxTqcFRSLnR3w2Tpe9Qu58EasQgU", resp[0].Address)
+		assert.True(t, resp[0].Confirmations > 0)
+	}
+
+	// The automatic selection skips the frozen.
+	{
+		req := &proto.WalletUnspentRequest{
+			Amount: 66,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/unspent", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		resp := []proto.WalletUnspentResponse{}
+		httpRsp.Json(&resp)
+		assert.Equal(t, 1, len(resp))
+		assert.Equal(t, uint64(10000), resp[0].Value)
+	}
+
+	// The explicit outpoints.
+	{
+		req := &proto.WalletUnspentRequest{
+			Outpoints: []string{big},
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/unspent", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		resp := []proto.WalletUnspentResponse{}
+		httpRsp.Json(&resp)
+		assert.Equal(t, 1, len(resp))
+		assert.Equal(t, uint64(93266), resp[0].Value)
+	}
 }
 
 func TestWalletTxs(t *testing.T) {
@@ -229,6 +345,86 @@ func TestWalletTxs(t *testing.T) {
 	}
 }
 
+func TestWalletTxsFiat(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	// Default code, only the unconfirmed tx is seen in the price max age.
+	{
+		req := &proto.WalletTxsRequest{
+			Offset: 0,
+			Limit:  3,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/txs", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		resp := []proto.WalletTxsResponse{}
+		httpRsp.Json(&resp)
+		assert.Equal(t, 3, len(resp))
+		assert.Equal(t, "0f8c5cdf448acb82969193452ac4bb7010c0890ceb96fa5e8c332378654459df", resp[0].Txid)
+		assert.Equal(t, "CNY", resp[0].FiatCode)
+		assert.Equal(t, 3.37, resp[0].FiatValue)
+		assert.Equal(t, 0.0, resp[0].FiatValueConfirmed)
+		assert.True(t, resp[0].SeenAt > 0)
+		assert.Equal(t, 0.0, resp[1].FiatValue)
+		assert.Equal(t, resp[1].BlockTime, resp[1].SeenAt)
+	}
+
+	// USD.
+	{
+		req := &proto.WalletTxsRequest{
+			Offset: 0,
+			Limit:  1,
+			Code:   "USD",
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/txs", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		resp := []proto.WalletTxsResponse{}
+		httpRsp.Json(&resp)
+		assert.Equal(t, 1, len(resp))
+		assert.Equal(t, "USD", resp[0].FiatCode)
+		assert.Equal(t, 0.49, resp[0].FiatValue)
+	}
+}
+
+func TestWalletPortfolioHistory(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	{
+		req := &proto.WalletPortfolioHistoryRequest{Code: "USD"}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/portfolio/history", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		rsp := &proto.WalletPortfolioHistoryResponse{}
+		httpRsp.Json(rsp)
+		assert.Equal(t, "BTC", rsp.CoinSymbol)
+		assert.Equal(t, "$", rsp.FiatSymbol)
+		assert.Equal(t, "USD", rsp.FiatCode)
+		assert.Equal(t, 4, len(rsp.Points))
+		assert.Equal(t, int64(1234), rsp.Points[0].Balance)
+		assert.Equal(t, int64(-92032), rsp.Points[1].Balance)
+		assert.Equal(t, int64(-87463), rsp.Points[2].Balance)
+		assert.Equal(t, 10721.13, rsp.Points[2].Price)
+		last := rsp.Points[3]
+		assert.Equal(t, int64(-87463), last.Balance)
+		assert.Equal(t, 10721.13, last.Price)
+		assert.Equal(t, -9.38, last.FiatValue)
+	}
+
+	// Code not found.
+	{
+		req := &proto.WalletPortfolioHistoryRequest{Code: "XXX"}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/portfolio/history", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 500, httpRsp.StatusCode())
+	}
+}
+
 func TestWalletAddresses(t *testing.T) {
 	ts, cleanup := MockServer()
 	defer cleanup()
@@ -358,6 +554,72 @@ func TestWalletSendFees(t *testing.T) {
 	}
 }
 
+func TestWalletSendFeesStrategy(t *testing.T) {
+	ts, cleanup := MockServer()
+	defer cleanup()
+
+	// One input and the change.
+	{
+		req := &proto.WalletSendFeesRequest{
+			Priority:  "fast",
+			SendValue: 1000,
+			Strategy:  proto.CoinSelectSmallest,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/sendfees", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		got := &proto.WalletSendFeesResponse{}
+		httpRsp.Json(got)
+
+		want := &proto.WalletSendFeesResponse{
+			Fees:          uint64(223),
+			TotalValue:    uint64(103266),
+			SendableValue: uint64(1000),
+		}
+		assert.Equal(t, want, got)
+	}
+
+	// The batch of 3 outputs, 2 more P2PKH outputs of 34 vbytes.
+	{
+		req := &proto.WalletSendFeesRequest{
+			Priority:  "fast",
+			SendValue: 1000,
+			Strategy:  proto.CoinSelectSmallest,
+			Outputs:   3,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/sendfees", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		got := &proto.WalletSendFeesResponse{}
+		httpRsp.Json(got)
+		assert.Equal(t, uint64(223+68), got.Fees)
+	}
+
+	// Send all.
+	{
+		req := &proto.WalletSendFeesRequest{
+			Priority:  "fast",
+			SendValue: 103266,
+			Strategy:  proto.CoinSelectBnB,
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/sendfees", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, httpRsp.StatusCode())
+
+		got := &proto.WalletSendFeesResponse{}
+		httpRsp.Json(got)
+
+		want := &proto.WalletSendFeesResponse{
+			Fees:          uint64(340),
+			TotalValue:    uint64(103266),
+			SendableValue: uint64(102926),
+		}
+		assert.Equal(t, want, got)
+	}
+}
+
 func TestWalletPortfolio(t *testing.T) {
 	ts, cleanup := MockServer()
 	defer cleanup()
@@ -376,8 +638,11 @@ func TestWalletPortfolio(t *testing.T) {
 			CoinSymbol:   "BTC",
 			FiatSymbol:   "¥",
 			CurrentPrice: 73711.13,
+			PriceSource:  "static",
+			PriceTime:    got.PriceTime,
 		}
 		assert.Equal(t, want, got)
+		assert.True(t, time.Now().Unix()-got.PriceTime < 60)
 	}
 
 	// Code USD.
@@ -396,9 +661,21 @@ func TestWalletPortfolio(t *testing.T) {
 			CoinSymbol:   "BTC",
 			FiatSymbol:   "$",
 			CurrentPrice: 10721.13,
+			PriceSource:  "static",
+			PriceTime:    got.PriceTime,
 		}
 		assert.Equal(t, want, got)
 	}
+
+	// Code not found.
+	{
+		req := &proto.WalletPortfolioRequest{
+			Code: "XXX",
+		}
+		httpRsp, err := proto.NewRequest().SetHeaders("Authorization", mockToken).Post(ts.URL+"/api/wallet/portfolio", req)
+		assert.Nil(t, err)
+		assert.Equal(t, 500, httpRsp.StatusCode())
+	}
 }
 
 func TestWalletPushTx(t *testing.T) {
@@ -7,14 +7,16 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
 
+	"proto"
 	"xlog"
 
 	"github.com/keyfuse/tokucore/network"
-	"github.com/keyfuse/tokucore/xcore/bip32"
+	"github.com/keyfuse/tokucore/xcrypto"
 )
 
 // WalletDB --
@@ -24,8 +26,19 @@ type WalletDB struct {
 	conf   *Config
 	net    *network.Network
 	chain  Chain
+	feed   PriceFeed
 	store  *WalletStore
 	syncer *WalletSyncer
+	mkey   *MasterKey
+	km     KeyManager
+
+	// The key refreshes prepared, by uid.
+	refmu     sync.Mutex
+	refreshes map[string]*pendingRefresh
+
+	// The signing sessions opened, by id.
+	sessmu   sync.Mutex
+	sessions map[string]*SignSession
 }
 
 // NewWalletDB -- creates new WalletDB.
@@ -39,15 +52,20 @@ func NewWalletDB(log *xlog.Log, conf *Config) *WalletDB {
 	}
 
 	chain := NewChainProxy(log, conf)
+	feed := NewPriceFeed(log, conf)
 	store := NewWalletStore(log, conf)
-	syncer := NewWalletSyncer(log, conf, chain, store)
+	syncer := NewWalletSyncer(log, conf, chain, feed, store)
 	return &WalletDB{
 		log:    log,
 		net:    net,
 		conf:   conf,
 		chain:  chain,
+		feed:   feed,
 		store:  store,
 		syncer: syncer,
+
+		refreshes: make(map[string]*pendingRefresh),
+		sessions:  make(map[string]*SignSession),
 	}
 }
 
@@ -63,20 +81,62 @@ func (wdb *WalletDB) setChain(chain Chain) {
 	syncer.Stop()
 
 	// Set new syncer.
-	newsyncer := NewWalletSyncer(log, conf, chain, store)
+	newsyncer := NewWalletSyncer(log, conf, chain, wdb.feed, store)
 	wdb.syncer = newsyncer
 	newsyncer.Start()
 	wdb.chain = chain
 }
 
+func (wdb *WalletDB) setPriceFeed(feed PriceFeed) {
+	wdb.mu.Lock()
+	defer wdb.mu.Unlock()
+
+	log := wdb.log
+	conf := wdb.conf
+	store := wdb.store
+
+	syncer := wdb.syncer
+	syncer.Stop()
+
+	// Set new syncer.
+	newsyncer := NewWalletSyncer(log, conf, wdb.chain, feed, store)
+	wdb.syncer = newsyncer
+	newsyncer.Start()
+	wdb.feed = feed
+}
+
 // Open -- used to load all the wallets who in the disk to the cache.
 func (wdb *WalletDB) Open(dir string) error {
 	wdb.mu.Lock()
 	defer wdb.mu.Unlock()
 
+	log := wdb.log
+	conf := wdb.conf
+
+	// The master key of the server shares.
+	if conf.MasterKey != nil {
+		mkey, err := LoadMasterKey(conf.MasterKey)
+		if err != nil {
+			return err
+		}
+		wdb.mkey = mkey
+	}
+
+	km, err := NewKeyManager(log, conf, wdb.store, wdb.mkey)
+	if err != nil {
+		return err
+	}
+	wdb.km = km
+
 	if err := wdb.store.Open(dir); err != nil {
 		return err
 	}
+	for _, uid := range wdb.store.AllUID() {
+		wallet := wdb.store.Get(uid)
+		if wdb.mkey != nil && wallet.SvrMasterPrvKey != "" {
+			log.Warning("wdb.wallet[%v].svrkey.plaintext.please.migrate", uid)
+		}
+	}
 	wdb.syncer.Start()
 	return nil
 }
@@ -86,31 +146,78 @@ func (wdb *WalletDB) Close() {
 	wdb.mu.Lock()
 	defer wdb.mu.Unlock()
 	wdb.syncer.Stop()
+	if err := wdb.store.Close(); err != nil {
+		wdb.log.Error("wdb.store.close.error:%v", err)
+	}
+	if wdb.km != nil {
+		if err := wdb.km.Close(); err != nil {
+			wdb.log.Error("wdb.keymanager.close.error:%v", err)
+		}
+	}
+
+	// The chain keeps the connections, such as the p2p.
+	if closer, ok := wdb.chain.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// ChainHealth -- returns the health of the chain providers, nil if the chain doesn't track it.
+func (wdb *WalletDB) ChainHealth() []ChainHealth {
+	wdb.mu.Lock()
+	chain := wdb.chain
+	wdb.mu.Unlock()
+
+	if healther, ok := chain.(interface{ Health() []ChainHealth }); ok {
+		return healther.Health()
+	}
+	return nil
+}
+
+// GetPrice -- returns the price of the fiat code, default code is from the config.
+// Errors if the price is older than the max age.
+func (wdb *WalletDB) GetPrice(code string) (*Price, error) {
+	conf := wdb.conf
+	store := wdb.store
+
+	code = priceCode(conf, code)
+	price, err := store.getPrice(code)
+	if err != nil {
+		return nil, err
+	}
+	if age := time.Now().Unix() - price.Time; age > priceMaxAge(conf) {
+		return nil, fmt.Errorf("wallet.price[%v].source[%v].stale.updated.at[%v]", code, price.Source, price.Time)
+	}
+	return &price, nil
 }
 
 // CreateWallet -- used to create a wallet file.
+// The server master key is created by the key manager, the wallet keeps the master public key.
 func (wdb *WalletDB) CreateWallet(uid string, cliMasterPubKey string) error {
 	net := wdb.net
 	store := wdb.store
 
 	wallet := store.Get(uid)
-	if wallet == nil {
-		masterKey, err := bip32.NewHDKeyRand()
-		if err != nil {
-			return err
-		}
-		svrMasterPrvKey := masterKey.ToString(net)
-		wallet = &Wallet{
-			net:             net,
-			UID:             uid,
-			Address:         make(map[string]*Address),
-			CliMasterPubKey: cliMasterPubKey,
-			SvrMasterPrvKey: svrMasterPrvKey,
-		}
-		return store.Write(wallet)
-	} else {
+	if wallet != nil {
 		return fmt.Errorf("wdb.wallet[%v, %v].create.error:wallet.exists", uid, cliMasterPubKey)
 	}
+	wallet = &Wallet{
+		net:             net,
+		UID:             uid,
+		Address:         make(map[string]*Address),
+		CliMasterPubKey: cliMasterPubKey,
+	}
+	if err := store.Write(wallet); err != nil {
+		return err
+	}
+
+	pubkey, err := wdb.km.CreateKey(uid)
+	if err != nil {
+		return err
+	}
+	wallet.Lock()
+	wallet.SvrMasterPubKey = pubkey
+	wallet.Unlock()
+	return store.Write(wallet)
 }
 
 // NewAddress -- used to generate new address of this uid.
@@ -123,7 +230,7 @@ func (wdb *WalletDB) NewAddress(uid string, typ string) (*Address, error) {
 		return nil, fmt.Errorf("wdb.newaddress.uid[%v].cant.found", uid)
 	}
 
-	address, err := wallet.NewAddress(typ)
+	address, err := wallet.NewAddress(typ, wdb.km)
 	if err != nil {
 		return nil, err
 	}
@@ -135,16 +242,92 @@ func (wdb *WalletDB) NewAddress(uid string, typ string) (*Address, error) {
 	return address, nil
 }
 
-func (wdb *WalletDB) MasterPrvKey(uid string) (string, error) {
+// NewChangeAddress -- used to generate new change address of this uid, it's on the change branch.
+func (wdb *WalletDB) NewChangeAddress(uid string, typ string) (*Address, error) {
 	store := wdb.store
 
 	// Get wallet.
 	wallet := store.Get(uid)
 	if wallet == nil {
-		return "", fmt.Errorf("wdb.master.prvkey.uid[%v].cant.found", uid)
+		return nil, fmt.Errorf("wdb.newchangeaddress.uid[%v].cant.found", uid)
 	}
 
-	return wallet.SvrMasterPrvKey, nil
+	address, err := wallet.NewChangeAddress(typ, wdb.km)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to db.
+	if err := store.Write(wallet); err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// SvrChildPubKey -- used to get the server child public key of the wallet at the pos.
+func (wdb *WalletDB) SvrChildPubKey(uid string, pos uint32) (string, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return "", fmt.Errorf("wdb.svr.child.pubkey.uid[%v].cant.found", uid)
+	}
+	return wallet.SvrChildPubKey(pos)
+}
+
+// KeyManager -- returns the key manager of the server master keys.
+func (wdb *WalletDB) KeyManager() KeyManager {
+	return wdb.km
+}
+
+// CheckSignTx -- used to check the tx before co-signing the idx input.
+func (wdb *WalletDB) CheckSignTx(uid string, tx *proto.Tx, idx int, pos uint32, hash []byte) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.check.sign.tx.uid[%v].cant.found", uid)
+	}
+	return wallet.CheckSignTx(tx, idx, pos, hash, wdb.conf.Policy)
+}
+
+// CheckCliPubKey -- used to check the client child public key(serialized) of the S2 proofs,
+// the shared public key of it must be the wallet address at the pos.
+func (wdb *WalletDB) CheckCliPubKey(uid string, pos uint32, cliPubKey []byte) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.check.cli.pubkey.uid[%v].cant.found", uid)
+	}
+	shared, err := wdb.km.SharedPubKey(uid, pos, cliPubKey)
+	if err != nil {
+		return err
+	}
+	sharepub, err := xcrypto.PubKeyFromBytes(shared)
+	if err != nil {
+		return err
+	}
+	if !wallet.isSharedPubKey(pos, sharepub) {
+		return fmt.Errorf("wdb.check.cli.pubkey.uid[%v].pos[%v].not.the.address", uid, pos)
+	}
+	return nil
+}
+
+// RecordSpend -- used to record the outbound of the co-signed tx for the policy.
+func (wdb *WalletDB) RecordSpend(uid string, tx *proto.Tx) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.record.spend.uid[%v].cant.found", uid)
+	}
+	wallet.RecordSpend(tx)
+	return store.Write(wallet)
 }
 
 // Wallet -- used to get the wallet.
@@ -153,6 +336,20 @@ func (wdb *WalletDB) Wallet(uid string) *Wallet {
 	return store.Get(uid)
 }
 
+// ExportWallet -- returns the wallet json for the backup.
+func (wdb *WalletDB) ExportWallet(uid string) ([]byte, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.export.wallet.uid[%v].cant.found", uid)
+	}
+	wallet.Lock()
+	defer wallet.Unlock()
+	return json.MarshalIndent(wallet, "", " ")
+}
+
 // Balance --used to return balance of the wallet.
 func (wdb *WalletDB) Balance(uid string) (*Balance, error) {
 	store := wdb.store
@@ -177,6 +374,157 @@ func (wdb *WalletDB) Unspents(uid string, amount uint64) ([]UTXO, error) {
 	return wallet.Unspents(amount)
 }
 
+// SelectUnspents -- used to return the unspents selected by the strategy for the send amount to the outputs,
+// the fees are of the priority.
+func (wdb *WalletDB) SelectUnspents(uid string, amount uint64, outputs int, priority string, strategy string) ([]UTXO, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.select.unspents.uid[%v].cant.found", uid)
+	}
+
+	feesperkb := store.FeesPerKB(priority)
+	sel, err := wallet.SelectCoins(amount, outputs, feesperkb, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return sel.UTXOs, nil
+}
+
+// OutpointUnspents -- used to return the unspents of the outpoints, the coin control of the send.
+func (wdb *WalletDB) OutpointUnspents(uid string, outpoints []string) ([]UTXO, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.outpoint.unspents.uid[%v].cant.found", uid)
+	}
+	return wallet.OutpointUnspents(outpoints)
+}
+
+// CoinUTXOs -- used to return the unspents with the confirmations and the coin control.
+// The confirmations are of the chain tip if the chain tracks it, otherwise the best height seen by the wallet.
+func (wdb *WalletDB) CoinUTXOs(uid string) ([]CoinUTXO, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.coin.utxos.uid[%v].cant.found", uid)
+	}
+
+	wdb.mu.Lock()
+	chain := wdb.chain
+	wdb.mu.Unlock()
+
+	var tip int64
+	if tipper, ok := chain.(interface{ tipHeight() int64 }); ok {
+		tip = tipper.tipHeight()
+	}
+	return wallet.CoinUTXOs(tip), nil
+}
+
+// FreezeCoins -- used to freeze or unfreeze the unspents of the outpoints.
+func (wdb *WalletDB) FreezeCoins(uid string, outpoints []string, frozen bool) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.freeze.coins.uid[%v].cant.found", uid)
+	}
+	if err := wallet.FreezeCoins(outpoints, frozen); err != nil {
+		return err
+	}
+	return store.Write(wallet)
+}
+
+// LabelCoin -- used to label the unspent of the outpoint.
+func (wdb *WalletDB) LabelCoin(uid string, outpoint string, label string) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.label.coin.uid[%v].cant.found", uid)
+	}
+	if err := wallet.LabelCoin(outpoint, label); err != nil {
+		return err
+	}
+	return store.Write(wallet)
+}
+
+// PushCosigned -- used to mark the co-signed hashes of the tx as pushed, or release them for the retry if the push failed.
+func (wdb *WalletDB) PushCosigned(uid string, txhex string, pushed bool) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.push.cosigned.uid[%v].cant.found", uid)
+	}
+	raw, err := parseRawTxHex(txhex)
+	if err != nil {
+		return err
+	}
+	wallet.PushCosigned(raw, pushed)
+	return store.Write(wallet)
+}
+
+// RecordSent -- used to record the pushed tx of the wallet for the fee bumping.
+func (wdb *WalletDB) RecordSent(uid string, txhex string) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.record.sent.uid[%v].cant.found", uid)
+	}
+	raw, err := parseRawTxHex(txhex)
+	if err != nil {
+		return err
+	}
+	if err := wallet.RecordSent(raw); err != nil {
+		return err
+	}
+	return store.Write(wallet)
+}
+
+// BumpFee -- used to return the sent tx to replace and the fees of the target rate, the rate is of the priority
+// if the feesPerKB is 0.
+func (wdb *WalletDB) BumpFee(uid string, txid string, priority string, feesPerKB int) (*BumpFee, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.bumpfee.uid[%v].cant.found", uid)
+	}
+	if feesPerKB <= 0 {
+		feesPerKB = store.FeesPerKB(priority)
+	}
+	return wallet.BumpFee(txid, feesPerKB)
+}
+
+// CPFP -- used to return the unconfirmed outputs of the parent tx and the child fees of the target rate, the rate is of
+// the priority if the feesPerKB is 0.
+func (wdb *WalletDB) CPFP(uid string, txid string, priority string, feesPerKB int) (*CPFP, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.cpfp.uid[%v].cant.found", uid)
+	}
+	if feesPerKB <= 0 {
+		feesPerKB = store.FeesPerKB(priority)
+	}
+	return wallet.CPFP(txid, feesPerKB)
+}
+
 // Txs -- used to returns tx list.
 func (wdb *WalletDB) Txs(uid string, offset int, limit int) ([]Tx, error) {
 	var ret []Tx
@@ -196,6 +544,22 @@ func (wdb *WalletDB) Txs(uid string, offset int, limit int) ([]Tx, error) {
 	return ret, nil
 }
 
+// PortfolioHistory -- used to return the balance history valued in the fiat code at the current price.
+func (wdb *WalletDB) PortfolioHistory(uid string, code string) ([]PortfolioPoint, *Price, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, nil, fmt.Errorf("wdb.portfolio.history.uid[%v].cant.found", uid)
+	}
+	price, err := wdb.GetPrice(code)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wallet.PortfolioHistory(price.Code, price.Last, time.Now().Unix()), price, nil
+}
+
 // Addresses -- used to get address list.
 func (wdb *WalletDB) Addresses(uid string, offset int, limit int) ([]AddressPos, error) {
 	store := wdb.store
@@ -209,8 +573,8 @@ func (wdb *WalletDB) Addresses(uid string, offset int, limit int) ([]AddressPos,
 	return ret, nil
 }
 
-// SendFees -- returns the fee info for this send.
-func (wdb *WalletDB) SendFees(uid string, priority string, sendAmount uint64) (*SendFees, error) {
+// SendFees -- returns the fee info for this send to the outputs, the coins are selected by the strategy.
+func (wdb *WalletDB) SendFees(uid string, priority string, sendAmount uint64, outputs int, strategy string) (*SendFees, error) {
 	store := wdb.store
 
 	// Get wallet.
@@ -220,7 +584,7 @@ func (wdb *WalletDB) SendFees(uid string, priority string, sendAmount uint64) (*
 	}
 
 	feesperkb := store.FeesPerKB(priority)
-	return wallet.SendFees(sendAmount, feesperkb)
+	return wallet.SendFees(sendAmount, outputs, feesperkb, strategy)
 }
 
 func (wdb *WalletDB) StoreBackup(uid string, email string, did string, cloudService string, encryptedPrvKey string, encryptionPubKey string) error {
@@ -252,3 +616,102 @@ func (wdb *WalletDB) GetBackup(uid string) (Backup, error) {
 	}
 	return wallet.Backup, nil
 }
+
+// WhitelistAdd -- used to add the address to the wallet whitelist, active after the conf delay.
+func (wdb *WalletDB) WhitelistAdd(uid string, address string, label string) (*WhitelistAddress, error) {
+	conf := wdb.conf
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.whitelist.add.uid[%v].cant.found", uid)
+	}
+	wladdr, err := wallet.WhitelistAdd(address, label, conf.WhitelistDelay)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Write(wallet); err != nil {
+		return nil, err
+	}
+	return wladdr, nil
+}
+
+// WhitelistRemove -- used to remove the address from the wallet whitelist.
+func (wdb *WalletDB) WhitelistRemove(uid string, address string) error {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return fmt.Errorf("wdb.whitelist.remove.uid[%v].cant.found", uid)
+	}
+	if err := wallet.WhitelistRemove(address); err != nil {
+		return err
+	}
+	return store.Write(wallet)
+}
+
+// WhitelistMode -- used to turn on/off the wallet whitelist mode, the off is delayed by the conf.
+func (wdb *WalletDB) WhitelistMode(uid string, enable bool) (*Whitelist, error) {
+	conf := wdb.conf
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.whitelist.mode.uid[%v].cant.found", uid)
+	}
+	wl := wallet.WhitelistMode(enable, conf.WhitelistDelay)
+	if err := store.Write(wallet); err != nil {
+		return nil, err
+	}
+	return &wl, nil
+}
+
+// Whitelist -- used to get the wallet whitelist.
+func (wdb *WalletDB) Whitelist(uid string) (*Whitelist, error) {
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.whitelist.uid[%v].cant.found", uid)
+	}
+	wl := wallet.GetWhitelist()
+	return &wl, nil
+}
+
+// SetPolicy -- used to set the wallet own policy, the loosening is delayed by the conf.
+func (wdb *WalletDB) SetPolicy(uid string, policy Policy) (*PolicyState, error) {
+	conf := wdb.conf
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.set.policy.uid[%v].cant.found", uid)
+	}
+	state, err := wallet.SetPolicy(policy, conf.Policy, conf.PolicyDelay)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Write(wallet); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Policy -- used to get the wallet policy in effect and the pending one.
+func (wdb *WalletDB) Policy(uid string) (*PolicyState, error) {
+	conf := wdb.conf
+	store := wdb.store
+
+	// Get wallet.
+	wallet := store.Get(uid)
+	if wallet == nil {
+		return nil, fmt.Errorf("wdb.policy.uid[%v].cant.found", uid)
+	}
+	state := wallet.GetPolicy(conf.Policy)
+	return &state, nil
+}
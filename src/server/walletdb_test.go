@@ -11,6 +11,7 @@ import (
 	"sync"
 	"testing"
 
+	"proto"
 	"xlog"
 
 	"github.com/fortytw2/leaktest"
@@ -69,4 +70,32 @@ func TestWalletDB(t *testing.T) {
 		}
 		wg.Wait()
 	}
+
+	// Change address.
+	{
+		change, err := wdb.NewChangeAddress(mockUID, "")
+		assert.Nil(t, err)
+		assert.Equal(t, proto.ChangeBranch, change.Pos)
+		wallet := wdb.Wallet(mockUID)
+		assert.Equal(t, uint32(40), wallet.LastPos)
+		assert.Equal(t, uint32(1), wallet.ChangePos)
+
+		// Hidden in the address list.
+		addrs, err := wdb.Addresses(mockUID, 0, 100)
+		assert.Nil(t, err)
+		assert.Equal(t, 40, len(addrs))
+		for _, addr := range addrs {
+			assert.NotEqual(t, change.Address, addr.Address)
+		}
+
+		// Flagged in the txs, the wallet out of the syncer.
+		w := NewWallet()
+		w.Address["receive"] = &Address{Pos: 1, Txs: []Tx{{Txid: "receive", BlockHeight: 1}}}
+		w.Address["change"] = &Address{Pos: change.Pos, Txs: []Tx{{Txid: "change"}}}
+		txs := w.Txs(0, 10)
+		assert.Equal(t, 2, len(txs))
+		assert.Equal(t, "change", txs[0].Txid)
+		assert.True(t, txs[0].Change)
+		assert.False(t, txs[1].Change)
+	}
 }
@@ -7,12 +7,10 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"xlog"
 
@@ -28,7 +26,8 @@ type WalletStore struct {
 	net     *network.Network
 	fees    map[string]float32
 	wallets map[string]*Wallet
-	tickers map[string]Ticker
+	prices  map[string]Price
+	backend WalletBackend
 }
 
 // NewWalletStore -- creates new WalletStore.
@@ -46,7 +45,8 @@ func NewWalletStore(log *xlog.Log, conf *Config) *WalletStore {
 		net:     net,
 		fees:    make(map[string]float32),
 		wallets: make(map[string]*Wallet),
-		tickers: make(map[string]Ticker),
+		prices:  make(map[string]Price),
+		backend: NewWalletBackend(log, conf),
 	}
 }
 
@@ -57,77 +57,54 @@ func (s *WalletStore) Open(dir string) error {
 
 	log := s.log
 	s.dir = dir
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		if x := os.MkdirAll(dir, os.ModePerm); x != nil {
-			return x
-		}
-		return nil
+	if err := s.backend.Open(dir); err != nil {
+		return err
 	}
-
-	files, err := ioutil.ReadDir(dir)
+	wallets, err := s.backend.Load()
 	if err != nil {
 		return err
 	}
-
-	for _, file := range files {
-		path := fmt.Sprintf("%s/%s", dir, file.Name())
-		wallet, err := s.Read(path)
-		if err != nil {
-			return err
-		}
+	for _, wallet := range wallets {
+		wallet.net = s.net
 		s.wallets[wallet.UID] = wallet
 		log.Info("wallet.store.load[%s/%v]", dir, wallet.UID)
 	}
 	return nil
 }
 
-// Write -- write wallet to the file.
+// Close -- used to close the backend.
+func (s *WalletStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend.Close()
+}
+
+// Write -- write wallet to the backend.
 // Thread-Safe.
 func (s *WalletStore) Write(wallet *Wallet) error {
-	dir := s.dir
 	uid := wallet.UID
 
 	s.mu.Lock()
 	w, ok := s.wallets[uid]
 	if !ok {
 		s.wallets[uid] = wallet
-	} else if (w.CliMasterPubKey != wallet.CliMasterPubKey) || (w.SvrMasterPrvKey != wallet.SvrMasterPrvKey) {
+	} else if (w.CliMasterPubKey != wallet.CliMasterPubKey) || (w.SvrMasterPrvKey != wallet.SvrMasterPrvKey) || (w.SvrKeyEnvelope != wallet.SvrKeyEnvelope) {
+		s.mu.Unlock()
 		return fmt.Errorf("storage.write.data.race.uid[%v]", uid)
 	}
 	s.mu.Unlock()
 
 	wallet.Lock()
 	defer wallet.Unlock()
-	file := fmt.Sprintf("%s/%s.json", dir, uid)
-	datas, err := json.MarshalIndent(wallet, "", " ")
-	if err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile(file, datas, os.ModePerm); err != nil {
-		return err
-	}
-	return nil
+	return s.backend.Save(wallet)
 }
 
-// Read -- reads a wallet from the file.
+// Read -- reads a wallet from the json file.
 func (s *WalletStore) Read(path string) (*Wallet, error) {
-	wallet := NewWallet()
-
-	file, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		return nil, err
-	}
-
-	buf, err := ioutil.ReadAll(file)
+	wallet, err := readWalletFile(path)
 	if err != nil {
 		return nil, err
 	}
-	if err := json.Unmarshal(buf, wallet); err != nil {
-		return nil, err
-	}
-	if wallet.Address == nil {
-		wallet.Address = make(map[string]*Address)
-	}
 	wallet.net = s.net
 	return wallet, nil
 }
@@ -163,20 +140,38 @@ func (s *WalletStore) updateFees(fees map[string]float32) {
 	s.fees = fees
 }
 
-func (s *WalletStore) updateTickers(tickers map[string]Ticker) {
+// updatePrices -- merges the prices, the code missing in the update keeps the old price until it's stale.
+func (s *WalletStore) updatePrices(prices map[string]Price) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.tickers = tickers
+	for code, price := range prices {
+		s.prices[code] = price
+	}
 }
 
-func (s *WalletStore) getTicker(code string) (Ticker, error) {
+func (s *WalletStore) getPrice(code string) (Price, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	ticker, ok := s.tickers[code]
+	price, ok := s.prices[code]
 	if !ok {
-		return ticker, fmt.Errorf("wallet.store.get.ticker.code[%v].cant.found", code)
+		return price, fmt.Errorf("wallet.store.get.price.code[%v].cant.found", code)
+	}
+	return price, nil
+}
+
+// freshPrices -- returns the last prices updated in the max age seconds.
+func (s *WalletStore) freshPrices(maxAge int64) map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	prices := make(map[string]float64)
+	for code, price := range s.prices {
+		if now-price.Time <= maxAge {
+			prices[code] = price.Last
+		}
 	}
-	return ticker, nil
+	return prices
 }
 
 // FeesPerKB -- used to return the fees.
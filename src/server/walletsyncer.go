@@ -22,17 +22,19 @@ type WalletSyncer struct {
 	done   chan bool
 	store  *WalletStore
 	chain  Chain
+	feed   PriceFeed
 	ticker *time.Ticker
 }
 
 // NewWalletSyncer -- creates new WalletSyncer.
-func NewWalletSyncer(log *xlog.Log, conf *Config, chain Chain, store *WalletStore) *WalletSyncer {
+func NewWalletSyncer(log *xlog.Log, conf *Config, chain Chain, feed PriceFeed, store *WalletStore) *WalletSyncer {
 	return &WalletSyncer{
 		log:    log,
 		store:  store,
 		conf:   conf,
 		done:   make(chan bool),
 		chain:  chain,
+		feed:   feed,
 		ticker: time.NewTicker(time.Duration(time.Millisecond * time.Duration(conf.WalletSyncIntervalMs))),
 	}
 }
@@ -42,6 +44,7 @@ func (ws *WalletSyncer) Start() {
 	log := ws.log
 	store := ws.store
 	chain := ws.chain
+	feed := ws.feed
 
 	// Update fees.
 	fees, err := chain.GetFees()
@@ -51,12 +54,12 @@ func (ws *WalletSyncer) Start() {
 		store.updateFees(fees)
 	}
 
-	// Update tickers.
-	tickers, err := chain.GetTickers()
+	// Update prices.
+	prices, err := feed.GetPrices()
 	if err != nil {
-		log.Error("walletsyncer.get.tickers.error:%v", err)
+		log.Error("walletsyncer.get.prices.error:%v", err)
 	} else {
-		store.updateTickers(tickers)
+		store.updatePrices(prices)
 	}
 
 	ws.wg.Add(1)
@@ -80,6 +83,7 @@ func (ws *WalletSyncer) Sync() {
 	log := ws.log
 	store := ws.store
 	chain := ws.chain
+	feed := ws.feed
 
 	ws.mu.Lock()
 	defer ws.mu.Unlock()
@@ -92,20 +96,33 @@ func (ws *WalletSyncer) Sync() {
 		store.updateFees(fees)
 	}
 
-	// Update tickers.
-	tickers, err := chain.GetTickers()
+	// Update prices.
+	prices, err := feed.GetPrices()
 	if err != nil {
-		log.Error("walletsyncer.get.tickers.error:%v", err)
+		log.Error("walletsyncer.get.prices.error:%v", err)
 	} else {
-		store.updateTickers(tickers)
+		store.updatePrices(prices)
 	}
 
+	// The fresh prices for the tx snapshots.
+	now := time.Now().Unix()
+	maxAge := priceMaxAge(ws.conf)
+	fresh := store.freshPrices(maxAge)
+
 	uids := store.AllUID()
 	for _, uid := range uids {
 		wallet := store.Get(uid)
 		if wallet != nil {
 			addresses := wallet.Addresses()
 			for _, addr := range addresses {
+				// The chain which tracks the addresses scans the history from the address creation.
+				if watcher, ok := chain.(interface{ WatchAddress(string, int64) error }); ok {
+					if err := watcher.WatchAddress(addr.Address, addr.CreatedAt); err != nil {
+						log.Error("walletsyncer.address[%v].watch.error:%v", addr, err)
+						continue
+					}
+				}
+
 				// Unspents.
 				unspents, err := chain.GetUTXO(addr.Address)
 				if err != nil {
@@ -122,6 +139,9 @@ func (ws *WalletSyncer) Sync() {
 				}
 				wallet.UpdateTxs(addr.Address, txs)
 			}
+			if n := wallet.SnapshotFiat(fresh, now, maxAge); n > 0 {
+				log.Info("walletsyncer.wallet[%v].fiat.snapshots[%v]", wallet.UID, n)
+			}
 			if err := store.Write(wallet); err != nil {
 				log.Error("walletsyncer.wallet[%v].store.write.error:%v", wallet.UID, err)
 			}
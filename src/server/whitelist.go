@@ -0,0 +1,128 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keyfuse/tokucore/xcore"
+)
+
+// WhitelistAddress -- the trusted destination address, it's active after the ActiveAt.
+type WhitelistAddress struct {
+	Address  string `json:"address"`
+	Label    string `json:"label"`
+	AddedAt  int64  `json:"added_at"`
+	ActiveAt int64  `json:"active_at"`
+}
+
+// Whitelist -- the trusted destination addresses of the wallet.
+// If enabled, the server refuses to co-sign the payments to the non-whitelisted outputs.
+// Disabling is delayed as adding address, the DisableAt is the time when the mode off.
+type Whitelist struct {
+	Enabled   bool               `json:"enabled"`
+	DisableAt int64              `json:"disable_at"`
+	Addresses []WhitelistAddress `json:"addresses"`
+}
+
+// enabled -- returns true if the whitelist mode is on at the time.
+func (wl *Whitelist) enabled(now int64) bool {
+	if !wl.Enabled {
+		return false
+	}
+	return wl.DisableAt == 0 || now < wl.DisableAt
+}
+
+// active -- returns true if the address is whitelisted and active at the time.
+func (wl *Whitelist) active(address string, now int64) bool {
+	for _, addr := range wl.Addresses {
+		if addr.Address == address && now >= addr.ActiveAt {
+			return true
+		}
+	}
+	return false
+}
+
+// WhitelistAdd -- adds the address to the whitelist, it becomes active after the delay seconds.
+func (w *Wallet) WhitelistAdd(address string, label string, delay int64) (*WhitelistAddress, error) {
+	addr, err := xcore.DecodeAddress(address, w.net)
+	if err != nil {
+		return nil, err
+	}
+	address = addr.ToString(w.net)
+
+	w.Lock()
+	defer w.Unlock()
+	for _, wladdr := range w.Whitelist.Addresses {
+		if wladdr.Address == address {
+			return nil, fmt.Errorf("wallet.whitelist.address[%v].exists", address)
+		}
+	}
+
+	now := time.Now().Unix()
+	wladdr := WhitelistAddress{
+		Address:  address,
+		Label:    label,
+		AddedAt:  now,
+		ActiveAt: now + delay,
+	}
+	w.Whitelist.Addresses = append(w.Whitelist.Addresses, wladdr)
+	return &wladdr, nil
+}
+
+// WhitelistRemove -- removes the address from the whitelist.
+func (w *Wallet) WhitelistRemove(address string) error {
+	addr, err := xcore.DecodeAddress(address, w.net)
+	if err != nil {
+		return err
+	}
+	address = addr.ToString(w.net)
+
+	w.Lock()
+	defer w.Unlock()
+
+	for i, wladdr := range w.Whitelist.Addresses {
+		if wladdr.Address == address {
+			w.Whitelist.Addresses = append(w.Whitelist.Addresses[:i], w.Whitelist.Addresses[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("wallet.whitelist.address[%v].cant.found", address)
+}
+
+// WhitelistMode -- turns the whitelist mode on immediately, or off after the delay seconds.
+func (w *Wallet) WhitelistMode(enable bool, delay int64) Whitelist {
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now().Unix()
+	wl := &w.Whitelist
+	switch {
+	case enable:
+		wl.Enabled = true
+		wl.DisableAt = 0
+	case wl.enabled(now) && wl.DisableAt == 0:
+		wl.DisableAt = now + delay
+	}
+	return w.whitelist()
+}
+
+// GetWhitelist -- returns the copy of the whitelist.
+func (w *Wallet) GetWhitelist() Whitelist {
+	w.Lock()
+	defer w.Unlock()
+	return w.whitelist()
+}
+
+// whitelist -- returns the copy of the whitelist.
+// Not thread-safe, the caller must hold the wallet lock.
+func (w *Wallet) whitelist() Whitelist {
+	wl := w.Whitelist
+	wl.Addresses = append([]WhitelistAddress(nil), w.Whitelist.Addresses...)
+	return wl
+}
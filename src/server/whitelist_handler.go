@@ -0,0 +1,190 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"proto"
+)
+
+func (h *Handler) whitelistAdd(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("whitelistAdd", r)
+	if err != nil {
+		log.Error("api.whitelist.add.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WhitelistAddRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.whitelist.add[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.whitelist[%v].add.req:%+v", uid, req)
+
+	wladdr, err := wdb.WhitelistAdd(uid, req.Address, req.Label)
+	if err != nil {
+		log.Error("api.whitelist.add[%v].wdb.add.error:%+v", uid, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+
+	// Notify.
+	activeAt := time.Unix(wladdr.ActiveAt, 0).UTC()
+	h.notify(uid, "KeyFuse Labs Whitelist Address Added",
+		fmt.Sprintf("The address <b>%v</b>(%v) is added to your wallet whitelist, it will be active at %v.", wladdr.Address, wladdr.Label, activeAt))
+
+	rsp := &proto.WhitelistAddResponse{
+		WhitelistAddress: proto.WhitelistAddress{
+			Address:  wladdr.Address,
+			Label:    wladdr.Label,
+			AddedAt:  wladdr.AddedAt,
+			ActiveAt: wladdr.ActiveAt,
+		},
+	}
+	log.Info("api.whitelist.add.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+func (h *Handler) whitelistRemove(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("whitelistRemove", r)
+	if err != nil {
+		log.Error("api.whitelist.remove.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WhitelistRemoveRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.whitelist.remove[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.whitelist[%v].remove.req:%+v", uid, req)
+
+	if err := wdb.WhitelistRemove(uid, req.Address); err != nil {
+		log.Error("api.whitelist.remove[%v].wdb.remove.error:%+v", uid, err)
+		resp.writeErrorWithStatus(400, err)
+		return
+	}
+	rsp := &proto.WhitelistRemoveResponse{}
+	log.Info("api.whitelist.remove.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+func (h *Handler) whitelistMode(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("whitelistMode", r)
+	if err != nil {
+		log.Error("api.whitelist.mode.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WhitelistModeRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.whitelist.mode[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.whitelist[%v].mode.req:%+v", uid, req)
+
+	wl, err := wdb.WhitelistMode(uid, req.Enable)
+	if err != nil {
+		log.Error("api.whitelist.mode[%v].wdb.mode.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+
+	// Notify.
+	if wl.DisableAt > 0 {
+		disableAt := time.Unix(wl.DisableAt, 0).UTC()
+		h.notify(uid, "KeyFuse Labs Whitelist Mode Disabling",
+			fmt.Sprintf("The whitelist mode of your wallet will be disabled at %v.", disableAt))
+	}
+
+	rsp := whitelistResponse(wl)
+	log.Info("api.whitelist.mode.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+func (h *Handler) whitelistList(w http.ResponseWriter, r *http.Request) {
+	log := h.log
+	wdb := h.wdb
+	resp := newResponse(log, w)
+
+	// UID.
+	uid, err := h.userinfo("whitelistList", r)
+	if err != nil {
+		log.Error("api.whitelist.list.uid.error:%+v", err)
+		resp.writeError(err)
+		return
+	}
+
+	// Request.
+	req := &proto.WhitelistListRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		log.Error("api.whitelist.list[%v].decode.body.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	log.Info("api.whitelist[%v].list.req:%+v", uid, req)
+
+	wl, err := wdb.Whitelist(uid)
+	if err != nil {
+		log.Error("api.whitelist.list[%v].wdb.whitelist.error:%+v", uid, err)
+		resp.writeError(err)
+		return
+	}
+	rsp := whitelistResponse(wl)
+	log.Info("api.whitelist.list.rsp:%+v", rsp)
+	resp.writeJSON(rsp)
+}
+
+func whitelistResponse(wl *Whitelist) *proto.WhitelistResponse {
+	now := time.Now().Unix()
+	rsp := &proto.WhitelistResponse{
+		Enabled:   wl.enabled(now),
+		DisableAt: wl.DisableAt,
+	}
+	for _, addr := range wl.Addresses {
+		rsp.Addresses = append(rsp.Addresses, proto.WhitelistAddress{
+			Address:  addr.Address,
+			Label:    addr.Label,
+			AddedAt:  addr.AddedAt,
+			ActiveAt: addr.ActiveAt,
+			Active:   wl.active(addr.Address, now),
+		})
+	}
+	return rsp
+}
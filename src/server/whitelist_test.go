@@ -0,0 +1,110 @@
+// thresh-wallet
+//
+// Copyright 2019 by KeyFuse Labs
+//
+// GPLv3 License
+
+package server
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"proto"
+
+	"github.com/keyfuse/tokucore/network"
+	"github.com/keyfuse/tokucore/xcore"
+	"github.com/keyfuse/tokucore/xvm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletWhitelist(t *testing.T) {
+	to := "mfcHP2WMCVLsVZA8yrovmhMgxNFW9r98xw"
+	addr, err := xcore.DecodeAddress(to, network.TestNet)
+	assert.Nil(t, err)
+	script, err := addr.LockingScript()
+	assert.Nil(t, err)
+
+	wallet := mockPolicyWallet(t)
+	tx := mockSignTx()
+	tx.Outputs[0].Value = 80000
+	tx.Outputs = append(tx.Outputs, proto.TxOut{Value: 10000, Script: hex.EncodeToString(script)})
+	hash, err := tx.SignatureHash(0)
+	assert.Nil(t, err)
+
+	// Mode off.
+	{
+		err := wallet.CheckSignTx(tx, 0, 2, hash, nil)
+		assert.Nil(t, err)
+	}
+
+	// Mode on, the address is not whitelisted.
+	{
+		wl := wallet.WhitelistMode(true, 3600)
+		assert.True(t, wl.Enabled)
+		err := wallet.CheckSignTx(tx, 0, 2, hash, nil)
+		perr := err.(*PolicyError)
+		assert.Equal(t, PolicyWhitelist, perr.Rule)
+		assert.Equal(t, to, perr.Address)
+	}
+
+	// Whitelisted but not active yet.
+	{
+		_, err := wallet.WhitelistAdd(to, "cold", 3600)
+		assert.Nil(t, err)
+		_, err = wallet.WhitelistAdd(to, "cold", 3600)
+		assert.NotNil(t, err)
+		err = wallet.CheckSignTx(tx, 0, 2, hash, nil)
+		assert.Equal(t, PolicyWhitelist, err.(*PolicyError).Rule)
+	}
+
+	// Active.
+	{
+		wallet.Whitelist.Addresses[0].ActiveAt = time.Now().Unix() - 1
+		err := wallet.CheckSignTx(tx, 0, 2, hash, nil)
+		assert.Nil(t, err)
+	}
+
+	// OP_RETURN output is skipped.
+	{
+		data, err := xvm.NewScriptBuilder().AddOp(xvm.OP_RETURN).AddData([]byte("memo")).Script()
+		assert.Nil(t, err)
+		optx := mockSignTx()
+		optx.Outputs = append(optx.Outputs, proto.TxOut{Value: 0, Script: hex.EncodeToString(data)})
+		ophash, err := optx.SignatureHash(0)
+		assert.Nil(t, err)
+		err = wallet.CheckSignTx(optx, 0, 2, ophash, nil)
+		assert.Nil(t, err)
+	}
+
+	// Remove takes effect immediately.
+	{
+		err := wallet.WhitelistRemove(to)
+		assert.Nil(t, err)
+		err = wallet.WhitelistRemove(to)
+		assert.NotNil(t, err)
+		err = wallet.CheckSignTx(tx, 0, 2, hash, nil)
+		assert.Equal(t, PolicyWhitelist, err.(*PolicyError).Rule)
+
+		// The address is decoded as the add.
+		_, err = wallet.WhitelistAdd("tb1qfy8qa67vt4rzyg02xrgq56hwzgudk2jhp8hx3x", "", 0)
+		assert.Nil(t, err)
+		err = wallet.WhitelistRemove("tb1qfy8qa67vt4rzyg02xrgq56hwzgudk2jhp8hx3x")
+		assert.Nil(t, err)
+		err = wallet.WhitelistRemove("xx")
+		assert.Contains(t, err.Error(), "unknown.format")
+	}
+
+	// Mode off is delayed.
+	{
+		wl := wallet.WhitelistMode(false, 3600)
+		assert.True(t, wl.DisableAt > 0)
+		err := wallet.CheckSignTx(tx, 0, 2, hash, nil)
+		assert.Equal(t, PolicyWhitelist, err.(*PolicyError).Rule)
+
+		wallet.Whitelist.DisableAt = time.Now().Unix() - 1
+		err = wallet.CheckSignTx(tx, 0, 2, hash, nil)
+		assert.Nil(t, err)
+	}
+}